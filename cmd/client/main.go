@@ -26,7 +26,7 @@ func main() {
 		panic(err)
 	}
 
-	if err := client.RunClient(cfg); err != nil {
+	if err := client.RunClient(cfg, BuildVersion); err != nil {
 		panic(err)
 	}
 }
@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"os"
+
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/config"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/server"
 )
@@ -15,17 +17,35 @@ var (
 	BuildCommit = "N/A"
 )
 
+// rotateKeysCommand re-wraps user keys with the active master key instead of starting the server.
+// Usage: server rotate-keys [flags]
+const rotateKeysCommand = "rotate-keys"
+
 func main() {
 	// print server build info
 	fmt.Printf("Build version: %s\n", BuildVersion)
 	fmt.Printf("Build date: %s\n", BuildDate)
 	fmt.Printf("Build commit: %s\n", BuildCommit)
 
+	// command goes before flags, remove it to parse flags
+	command := ""
+	if len(os.Args) > 1 && os.Args[1] == rotateKeysCommand {
+		command = os.Args[1]
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
 	cfg, err := config.ReadConfig()
 	if err != nil {
 		panic(err)
 	}
 
+	if command == rotateKeysCommand {
+		if err := server.RunKeyRotation(cfg); err != nil {
+			panic(err)
+		}
+		return
+	}
+
 	if err := server.RunServer(cfg); err != nil {
 		panic(err)
 	}
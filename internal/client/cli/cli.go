@@ -7,34 +7,104 @@ import (
 	"github.com/c-bata/go-prompt"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/client/local"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/client/service"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+	pb "github.com/vstebletsov89/go-developer-course-gophkeeper/internal/proto"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/secure"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/service/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"os"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 )
 
+// ErrorDataNotLoaded defines an error for edit of private data which was not loaded by get-data.
+var ErrorDataNotLoaded = errors.New("data is not loaded, use get-data first")
+
+// ErrorDataChanged defines an error for edit of private data which was changed on another device.
+var ErrorDataChanged = errors.New("data was changed on another device, use get-data to reload it")
+
+// watchRetryDelay defines delay before the watch stream is reopened after the server was not available.
+const watchRetryDelay = 5 * time.Second
+
 // CLI represents a structure for cli communication with user.
+// Revisions of the loaded private data are kept to detect concurrent changes on edit.
+// Records synchronized with the server are kept with cursor of the last sync, they are updated in background
+// while changes are watched. Records, changes made offline and their conflicts are saved to local vault.
 type CLI struct {
 	authClient   *service.AuthClient
 	secretClient *service.SecretClient
+	mu           sync.Mutex
+	syncMu       sync.Mutex
+	revisions    map[string]int64
+	records      map[string]models.Data
+	cursor       int64
+	stopWatch    context.CancelFunc
+	localPath    string
+	local        *local.Vault
+	pending      []local.Operation
+	conflicts    []local.Conflict
+	syncedAt     time.Time
+	offline      bool
+	offlineLogin bool
 }
 
 // NewCLI returns an instance of CLI.
 func NewCLI(authClient *service.AuthClient, secretClient *service.SecretClient) *CLI {
-	return &CLI{authClient: authClient, secretClient: secretClient}
+	return &CLI{
+		authClient:   authClient,
+		secretClient: secretClient,
+		revisions:    make(map[string]int64),
+		records:      make(map[string]models.Data),
+	}
 }
 
 // Completer is a menu items for the Gophkeeper UI.
 func (c *CLI) Completer(d prompt.Document) []prompt.Suggest {
 	s := []prompt.Suggest{
 		{Text: "register", Description: "Register new user for gophkeeper application. Example: register <user> <password>"},
-		{Text: "login", Description: "Sign-in into gophkeeper application. Example: Login <user> <password>"},
-		{Text: "add-text", Description: "Add new private text data. Example: add-text <description> <text>"},
-		{Text: "add-card", Description: "Add new private card data. Example: add-card <description> <name> <number> <date> <cvv>"},
-		{Text: "add-binary", Description: "Add new private binary data. Example: add-binary <description> <value>"},
-		{Text: "add-credentials", Description: "Add new private credentials data. Example: add-credentials <user> <password>"},
-		{Text: "get-data", Description: "Get all private data for the user. Example: get-data"},
-		{Text: "delete-data", Description: "Delete private data. Example: delete-data <data_id>"},
+		{Text: "login", Description: "Sign-in into gophkeeper application. Example: login <user> <password> [--legacy] (--legacy for password login of legacy users)"},
+		{Text: "logout", Description: "Sign-out from gophkeeper application. Example: logout [--all] (--all for all devices)"},
+		{Text: "change-password", Description: "Change password of the current user. Example: change-password <old_password> <new_password>"},
+		{Text: "delete-account", Description: "Delete current user with all private data. Example: delete-account <password> [2fa_code]"},
+		{Text: "2fa-enable", Description: "Enable two-factor authentication (TOTP). Example: 2fa-enable"},
+		{Text: "2fa-confirm", Description: "Confirm two-factor authentication with code from authenticator app. Example: 2fa-confirm <code>"},
+		{Text: "2fa-verify", Description: "Complete login with TOTP code or recovery code. Example: 2fa-verify <code>"},
+		{Text: "2fa-disable", Description: "Disable two-factor authentication. Example: 2fa-disable <code>"},
+		{Text: "sessions", Description: "List active sessions of the current user. Example: sessions"},
+		{Text: "revoke-session", Description: "Sign-out session on another device. Example: revoke-session <session_id>"},
+		{Text: "register-device", Description: "Issue client certificate for this device (mTLS). Example: register-device <name> <cert_file> <key_file>"},
+		{Text: "revoke-device", Description: "Revoke client certificate of the device. Example: revoke-device <device_id>"},
+		{Text: "token-create", Description: "Create personal access token for scripts. Example: token-create <name> <read|read-write> [--expires 720h] [--types text,card] [--records id1,id2]"}, //nolint:lll
+		{Text: "tokens", Description: "List personal access tokens of the current user. Example: tokens"},
+		{Text: "token-revoke", Description: "Revoke personal access token. Example: token-revoke <token_id>"},
+		{Text: "add-text", Description: "Add new private text data. Example: add-text <description> <text> " + metadataUsage},
+		{Text: "add-card", Description: "Add new private card data. Example: add-card <description> <name> <number> <date> <cvv> " + metadataUsage},
+		{Text: "add-binary", Description: "Add new private binary data. Example: add-binary <description> <value> " + metadataUsage},
+		{Text: "add-credentials", Description: "Add new private credentials data. Example: add-credentials <description> <user> <password> " + metadataUsage},
+		{Text: "edit-text", Description: "Edit private text data. Example: edit-text <data_id> <description> <text> " + metadataUsage},
+		{Text: "edit-card", Description: "Edit private card data. Example: edit-card <data_id> <description> <name> <number> <date> <cvv> " + metadataUsage},
+		{Text: "edit-binary", Description: "Edit private binary data. Example: edit-binary <data_id> <description> <value> " + metadataUsage},
+		{Text: "edit-credentials", Description: "Edit private credentials data. Example: edit-credentials <data_id> <description> <user> <password> " + metadataUsage},
+		{Text: "get-data", Description: "Get all private data for the user, optionally with the tag. Example: get-data [--tag <tag>]"},
+		{Text: "history", Description: "List previous revisions of private data. Example: history <data_id>"},
+		{Text: "restore", Description: "Restore previous revision of private data. Example: restore <data_id> <revision>"},
+		{Text: "sync", Description: "Synchronize private data changed on other devices since the last sync. Example: sync"},
+		{Text: "status", Description: "Show connection, local vault and changes waiting for sync. Example: status"},
+		{Text: "conflicts", Description: "List changes made offline which conflict with changes on another device. Example: conflicts"},
+		{Text: "show-conflict", Description: "Show private data of both versions of the conflicting record. Example: show-conflict <data_id>"},
+		{Text: "resolve", Description: "Resolve conflict of the record. Example: resolve <data_id> --keep <local|remote|both>"},
+		{Text: "watch", Description: "Synchronize private data in background when it is changed on other devices. Example: watch"},
+		{Text: "unwatch", Description: "Stop background synchronization of private data. Example: unwatch"},
+		{Text: "delete-data", Description: "Move private data to trash. Example: delete-data <data_id>"},
+		{Text: "trash", Description: "List deleted private data kept in trash. Example: trash"},
+		{Text: "trash-restore", Description: "Restore deleted private data from trash. Example: trash-restore <data_id>"},
+		{Text: "trash-purge", Description: "Delete private data from trash permanently. Example: trash-purge <data_id>"},
+		{Text: "migrate-data", Description: "Re-encrypt legacy private data on the client side. Example: migrate-data"},
 		{Text: "exit", Description: "Exit from gophkeeper application. Example: exit"},
 	}
 	return prompt.FilterContains(s, d.CurrentLine(), true)
@@ -46,7 +116,13 @@ func (c *CLI) Register(ctx context.Context, args []string) error {
 		return errors.New("invalid arguments")
 	}
 	c.setCurrentUser(args)
-	return c.authClient.Register(ctx)
+
+	// vault key is derived from the password and never leaves the client
+	vault, _, err := secure.NewVault(args[1])
+	if err != nil {
+		return err
+	}
+	return c.authClient.Register(ctx, vault)
 }
 
 func (c *CLI) setCurrentUser(args []string) {
@@ -56,48 +132,843 @@ func (c *CLI) setCurrentUser(args []string) {
 		Password: args[1],
 	}
 	c.authClient.SetUser(user)
+	c.resetRecords()
 }
 
-// Login sign-in into gophkeeper application.
+// resetRecords removes private data of the previous user, the next sync loads all records.
+// Changes of the previous user are not watched anymore.
+func (c *CLI) resetRecords() {
+	c.StopWatch()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.revisions = make(map[string]int64)
+	c.records = make(map[string]models.Data)
+	c.cursor = 0
+	c.local = nil
+	c.pending = nil
+	c.conflicts = nil
+	c.syncedAt = time.Time{}
+	c.offline = false
+	c.offlineLogin = false
+}
+
+// Login sign-in into gophkeeper application. Password is sent to the server only with --legacy flag,
+// it is used once by legacy users without SRP verifier to upgrade to SRP login.
 func (c *CLI) Login(ctx context.Context, args []string) error {
+	passwordLogin, args := parseFlag(args, "--legacy")
 	if len(args) != 2 {
 		return errors.New("invalid arguments")
 	}
 
 	c.setCurrentUser(args)
+	c.authClient.SetPasswordLogin(passwordLogin)
 
 	token, err := c.authClient.Login(ctx)
+	if errors.Is(err, service.ErrorTwoFactorRequired) {
+		// login is completed by 2fa-verify command
+		return err
+	}
+	if isUnavailable(err) && c.localPath != "" {
+		// private data of the local vault is available without the server
+		if offlineErr := c.loginOffline(args[0], args[1]); offlineErr != nil {
+			log.Error().Msgf("Failed to Login offline: %v", offlineErr)
+			return err
+		}
+		return nil
+	}
 	if err != nil {
 		log.Error().Msgf("Failed to Login: %v", err)
 		return err
 	}
 
+	if err := c.completeLogin(ctx, token, args[1]); err != nil {
+		return err
+	}
+	c.flushPending(ctx)
+	return nil
+}
+
+// VerifyTwoFactor completes login with TOTP code from authenticator app or recovery code.
+func (c *CLI) VerifyTwoFactor(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("invalid arguments")
+	}
+
+	token, err := c.authClient.VerifyTwoFactor(ctx, args[0])
+	if err != nil {
+		log.Error().Msgf("Failed to verify second factor: %v", err)
+		return err
+	}
+
+	if err := c.completeLogin(ctx, token, c.authClient.User().Password); err != nil {
+		return err
+	}
+	c.flushPending(ctx)
+	return nil
+}
+
+// completeLogin sets access token, unlocks vault of the logged in user and loads local vault.
+func (c *CLI) completeLogin(ctx context.Context, token string, password string) error {
 	// set jwt token
 	c.authClient.SetAccessToken(token)
+
+	vaultKey, err := c.unlockVault(ctx, password)
+	if err != nil {
+		log.Error().Msgf("Failed to unlock vault: %v", err)
+		return err
+	}
+	c.secretClient.SetVaultKey(vaultKey)
+	if err := c.openLocal(vaultKey); err != nil {
+		// private data is available online without local vault
+		log.Error().Msgf("Failed to open local vault: %v", err)
+	}
+	return nil
+}
+
+// EnableTwoFactor starts enrollment of the second factor and prints secret with recovery codes.
+func (c *CLI) EnableTwoFactor(ctx context.Context) error {
+	response, err := c.authClient.EnableTwoFactor(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msgf("Add secret to authenticator app: %s", response.GetSecret())
+	log.Info().Msgf("Provisioning URI: %s", response.GetProvisioningUri())
+	log.Info().Msg("Recovery codes (each code can be used once instead of TOTP code, keep them safe):")
+	for _, code := range response.GetRecoveryCodes() {
+		log.Info().Msg(code)
+	}
 	return nil
 }
 
-// DeleteData deletes private data from storage.
+// ConfirmTwoFactor enables second factor with the first TOTP code from authenticator app.
+func (c *CLI) ConfirmTwoFactor(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("invalid arguments")
+	}
+
+	return c.authClient.ConfirmTwoFactor(ctx, args[0])
+}
+
+// DisableTwoFactor disables second factor with TOTP code or recovery code.
+func (c *CLI) DisableTwoFactor(ctx context.Context, args []string) error {
+	if len(args) > 1 {
+		return errors.New("invalid arguments")
+	}
+
+	code := ""
+	if len(args) == 1 {
+		code = args[0]
+	}
+	return c.authClient.DisableTwoFactor(ctx, code)
+}
+
+// Logout sign-out from gophkeeper application. Option --all revokes sessions on all devices.
+func (c *CLI) Logout(ctx context.Context, args []string) error {
+	allDevices, args := parseFlag(args, "--all")
+	if len(args) != 0 {
+		return errors.New("invalid arguments")
+	}
+
+	// vault key is removed even if server is not available, local vault is kept for offline login
+	c.mu.Lock()
+	offlineLogin := c.offlineLogin
+	c.mu.Unlock()
+	c.secretClient.SetVaultKey(nil)
+	c.resetRecords()
+	if offlineLogin {
+		return nil
+	}
+	return c.authClient.Logout(ctx, allDevices)
+}
+
+// ChangePassword changes password of the current user. Vault key is re-wrapped with the new password,
+// so private data is not re-encrypted.
+func (c *CLI) ChangePassword(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return errors.New("invalid arguments")
+	}
+
+	var vault *models.Vault
+	if current := c.authClient.Vault(); current != nil {
+		rewrapped, err := secure.RewrapVault(args[0], args[1], *current)
+		if err != nil {
+			return err
+		}
+		vault = &rewrapped
+	}
+
+	token, err := c.authClient.ChangePassword(ctx, args[0], args[1], vault)
+	if err != nil {
+		return err
+	}
+
+	// other sessions are revoked, current session continues with new token
+	c.authClient.SetAccessToken(token)
+
+	// local vault is unlocked with the new password
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.local != nil && c.authClient.Vault() != nil {
+		c.local.SetVault(*c.authClient.Vault())
+		return c.saveLocal()
+	}
+	return nil
+}
+
+// DeleteAccount deletes the current user with all private data. Code of the second factor is required
+// if two-factor authentication is enabled.
+func (c *CLI) DeleteAccount(ctx context.Context, args []string) error {
+	if len(args) != 1 && len(args) != 2 {
+		return errors.New("invalid arguments")
+	}
+
+	code := ""
+	if len(args) == 2 {
+		code = args[1]
+	}
+	err := c.authClient.DeleteAccount(ctx, args[0], code)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	localVault := c.local
+	c.mu.Unlock()
+	if localVault != nil {
+		if err := localVault.Remove(); err != nil {
+			log.Error().Msgf("Failed to remove local vault: %v", err)
+		}
+	}
+
+	c.secretClient.SetVaultKey(nil)
+	c.resetRecords()
+	return nil
+}
+
+// ListSessions prints active sessions of the current user.
+func (c *CLI) ListSessions(ctx context.Context) error {
+	sessions, err := c.authClient.ListSessions(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		current := ""
+		if session.GetCurrent() {
+			current = " (current)"
+		}
+		log.Info().Msgf("%s%s: device '%s', client %s, address %s, signed in %s, last seen %s",
+			session.GetId(), current, session.GetDeviceName(), session.GetClientVersion(), session.GetAddress(),
+			time.Unix(session.GetCreatedAt(), 0).Format(time.RFC3339),
+			time.Unix(session.GetLastSeenAt(), 0).Format(time.RFC3339))
+	}
+	return nil
+}
+
+// RevokeSession signs out session of the current user.
+func (c *CLI) RevokeSession(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("invalid arguments")
+	}
+
+	return c.authClient.RevokeSession(ctx, args[0])
+}
+
+// RegisterDevice generates private key of the device and saves client certificate signed by the server.
+// Both files are used for authentication with client certificate instead of access token.
+func (c *CLI) RegisterDevice(ctx context.Context, args []string) (string, error) {
+	if len(args) != 3 {
+		return "", errors.New("invalid arguments")
+	}
+
+	keyPEM, csrPEM, err := auth.NewDeviceKey(args[0])
+	if err != nil {
+		return "", err
+	}
+
+	response, err := c.authClient.RegisterDevice(ctx, args[0], csrPEM)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.WriteFile(args[1], response.GetCertificate(), 0o600); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(args[2], keyPEM, 0o600); err != nil {
+		return "", err
+	}
+	return response.GetDeviceId(), nil
+}
+
+// RevokeDevice revokes client certificate of the device.
+func (c *CLI) RevokeDevice(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("invalid arguments")
+	}
+
+	return c.authClient.RevokeDevice(ctx, args[0])
+}
+
+// CreateAccessToken creates personal access token of the current user. Vault key is wrapped with the key
+// which is a part of the returned token, so the token is able to decrypt private data without password.
+func (c *CLI) CreateAccessToken(ctx context.Context, args []string) (string, error) {
+	expires, args, err := parseOption(args, "--expires")
+	if err != nil {
+		return "", err
+	}
+	types, args, err := parseOption(args, "--types")
+	if err != nil {
+		return "", err
+	}
+	records, args, err := parseOption(args, "--records")
+	if err != nil {
+		return "", err
+	}
+	if len(args) != 2 || (args[1] != "read" && args[1] != "read-write") {
+		return "", errors.New("invalid arguments")
+	}
+
+	request := &pb.CreateAccessTokenRequest{
+		Name:      args[0],
+		ReadOnly:  args[1] == "read",
+		DataIds:   splitList(records),
+		DataTypes: splitList(types),
+	}
+	if expires != "" {
+		expiresIn, err := time.ParseDuration(expires)
+		if err != nil || expiresIn < time.Second {
+			return "", errors.New("invalid token expiration")
+		}
+		request.ExpiresIn = int64(expiresIn / time.Second)
+	}
+
+	var tokenKey []byte
+	if vaultKey := c.secretClient.VaultKey(); vaultKey != nil {
+		tokenKey, request.WrappedKey, err = secure.WrapVaultKeyForToken(vaultKey)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	response, err := c.authClient.CreateAccessToken(ctx, request)
+	if err != nil {
+		return "", err
+	}
+	return auth.JoinPersonalToken(response.GetToken(), tokenKey), nil
+}
+
+// ListAccessTokens prints personal access tokens of the current user.
+func (c *CLI) ListAccessTokens(ctx context.Context) error {
+	tokens, err := c.authClient.ListAccessTokens(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		mode := "read-write"
+		if token.GetReadOnly() {
+			mode = "read"
+		}
+		expires := "never"
+		if token.GetExpiresAt() != 0 {
+			expires = time.Unix(token.GetExpiresAt(), 0).Format(time.RFC3339)
+		}
+		lastUsed := "never"
+		if token.GetLastUsedAt() != 0 {
+			lastUsed = time.Unix(token.GetLastUsedAt(), 0).Format(time.RFC3339)
+		}
+		log.Info().Msgf("%s: '%s' %s, types [%s], records [%s], expires %s, last used %s",
+			token.GetId(), token.GetName(), mode, strings.Join(token.GetDataTypes(), ","),
+			strings.Join(token.GetDataIds(), ","), expires, lastUsed)
+	}
+	return nil
+}
+
+// RevokeAccessToken revokes personal access token of the current user.
+func (c *CLI) RevokeAccessToken(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("invalid arguments")
+	}
+
+	return c.authClient.RevokeAccessToken(ctx, args[0])
+}
+
+// UseAccessToken authenticates the client with personal access token instead of login.
+// Vault is unlocked with the key of the token if the token has it.
+func (c *CLI) UseAccessToken(ctx context.Context, value string) error {
+	token, tokenKey, err := auth.SplitPersonalToken(value)
+	if err != nil {
+		return err
+	}
+	c.authClient.SetAccessToken(token)
+	if tokenKey == nil {
+		return nil
+	}
+
+	vault, err := c.authClient.GetVault(ctx)
+	if err != nil {
+		return err
+	}
+	vaultKey, err := secure.UnwrapVaultKeyWithToken(tokenKey, vault.GetWrappedKey())
+	if err != nil {
+		return err
+	}
+	c.secretClient.SetVaultKey(vaultKey)
+	return nil
+}
+
+// parseFlag removes boolean flag from arguments and reports whether it was present.
+func parseFlag(args []string, flag string) (bool, []string) {
+	found := false
+	rest := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg == flag {
+			found = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	return found, rest
+}
+
+// parseOption removes option with value from arguments and returns the value (empty if option is absent).
+func parseOption(args []string, option string) (string, []string, error) {
+	value := ""
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		if args[i] != option {
+			rest = append(rest, args[i])
+			continue
+		}
+		if i+1 == len(args) {
+			return "", nil, errors.New("missing value of " + option)
+		}
+		i++
+		value = args[i]
+	}
+	return value, rest, nil
+}
+
+// splitList splits comma separated list, empty string is an empty list.
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+// unlockVault returns vault key of the current user. Vault is created for legacy users without vault.
+func (c *CLI) unlockVault(ctx context.Context, password string) ([]byte, error) {
+	vault := c.authClient.Vault()
+	if vault != nil {
+		return secure.UnlockVault(password, *vault)
+	}
+
+	newVault, vaultKey, err := secure.NewVault(password)
+	if err != nil {
+		return nil, err
+	}
+	err = c.authClient.SetVault(ctx, newVault)
+	if err != nil {
+		return nil, err
+	}
+	return vaultKey, nil
+}
+
+// MigrateData re-encrypts legacy private data (encrypted on the server side) with the vault key.
+// Previous revisions of migrated records are removed from history by the server. Returns count of migrated records.
+func (c *CLI) MigrateData(ctx context.Context) (int, error) {
+	data, err := c.secretClient.GetData(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, secret := range data {
+		c.setRevision(secret.ID, secret.Revision)
+		if secret.ClientEncrypted {
+			continue
+		}
+
+		// record is replaced with client encrypted data, so ID of the record is not changed
+		revision, err := c.secretClient.UpdateData(ctx, secret)
+		if err != nil {
+			return migrated, err
+		}
+		c.setRevision(secret.ID, revision)
+		migrated++
+	}
+	return migrated, nil
+}
+
+// DeleteData deletes private data from storage. Data loaded by get-data is not deleted if it was changed
+// on another device after it was loaded (ErrorDataChanged).
 func (c *CLI) DeleteData(ctx context.Context, args []string) error {
 	if len(args) != 1 {
 		return errors.New("invalid arguments")
 	}
 
-	return c.secretClient.DeleteData(ctx, args[0])
+	id := args[0]
+	c.mu.Lock()
+	revision := c.revisions[id]
+	c.mu.Unlock()
+	return c.deleteData(ctx, models.Data{ID: id, Revision: revision})
 }
 
-// GetData gets all private data from the storage.
+// deleteData deletes private data with the expected revision (zero for any revision).
+// Deletion is kept in local vault if the server is not available.
+func (c *CLI) deleteData(ctx context.Context, data models.Data) error {
+	if c.useLocal() {
+		return c.queueDelete(data)
+	}
+
+	err := c.secretClient.DeleteData(ctx, data.ID, data.Revision)
+	if c.fallbackToLocal(err) {
+		return c.queueDelete(data)
+	}
+	if status.Code(err) == codes.Aborted {
+		return ErrorDataChanged
+	}
+	if err != nil {
+		return err
+	}
+	c.removeRecord(data.ID)
+	return nil
+}
+
+// queueDelete keeps deletion of private data loaded from local vault.
+func (c *CLI) queueDelete(data models.Data) error {
+	c.mu.Lock()
+	_, ok := c.records[data.ID]
+	c.mu.Unlock()
+	if !ok {
+		return ErrorDataNotLoaded
+	}
+	return c.queue(local.OperationDelete, data)
+}
+
+// addData adds private data to storage. Data is kept in local vault if the server is not available.
+func (c *CLI) addData(ctx context.Context, data models.Data) error {
+	if c.useLocal() {
+		return c.queue(local.OperationAdd, data)
+	}
+
+	err := c.secretClient.AddData(ctx, data)
+	if c.fallbackToLocal(err) {
+		return c.queue(local.OperationAdd, data)
+	}
+	if err != nil {
+		return err
+	}
+	data.Revision = 1
+	data.UpdatedAt = time.Now()
+	c.setRecord(data)
+	return nil
+}
+
+// GetData gets all private data from the storage. Records of local vault are returned if the server
+// is not available or changes made offline are not sent yet.
 func (c *CLI) GetData(ctx context.Context) ([]models.Data, error) {
+	if c.useLocal() {
+		return c.Records(), nil
+	}
+
 	data, err := c.secretClient.GetData(ctx)
+	if c.fallbackToLocal(err) {
+		return c.Records(), nil
+	}
 	if err != nil {
 		log.Error().Msgf("Failed to get private data: %v", err)
 		return nil, err
 	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.revisions = make(map[string]int64, len(data))
+	for _, secret := range data {
+		c.revisions[secret.ID] = secret.Revision
+	}
 	return data, nil
 }
 
+// setRevision saves revision of the loaded private data.
+func (c *CLI) setRevision(id string, revision int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.revisions[id] = revision
+}
+
+// Sync loads private data changed since the previous sync and applies changes to the local records.
+// User logged in offline is signed in and changes made offline are sent to the server before.
+func (c *CLI) Sync(ctx context.Context) (models.DataChanges, error) {
+	c.mu.Lock()
+	offlineLogin := c.offlineLogin
+	c.mu.Unlock()
+	if offlineLogin {
+		if err := c.reconnect(ctx); err != nil {
+			return models.DataChanges{}, err
+		}
+	}
+
+	// changes of concurrent syncs are applied in order of cursors
+	c.syncMu.Lock()
+	defer c.syncMu.Unlock()
+
+	if err := c.replayPending(ctx); err != nil {
+		return models.DataChanges{}, err
+	}
+
+	changes, err := c.secretClient.Sync(ctx, c.Cursor())
+	if err != nil {
+		if isUnavailable(err) {
+			c.mu.Lock()
+			c.offline = true
+			c.mu.Unlock()
+		}
+		return models.DataChanges{}, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if changes.Full {
+		c.records = make(map[string]models.Data, len(changes.Data))
+	}
+	for _, secret := range changes.Data {
+		c.records[secret.ID] = secret
+		c.revisions[secret.ID] = secret.Revision
+	}
+	for _, tombstone := range changes.Tombstones {
+		delete(c.records, tombstone.DataID)
+		delete(c.revisions, tombstone.DataID)
+	}
+	c.cursor = changes.Cursor
+	c.dropSettledConflicts()
+	c.syncedAt = time.Now()
+	c.offline = false
+	if err := c.saveLocal(); err != nil {
+		return models.DataChanges{}, err
+	}
+	return changes, nil
+}
+
+// Cursor returns cursor of the last sync.
+func (c *CLI) Cursor() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cursor
+}
+
+// Watch synchronizes local records on every notification about changed private data until the context is done.
+// Applied changes are passed to notify. Stream is reopened with refreshed access token when the token expires
+// and after the server was not available.
+func (c *CLI) Watch(ctx context.Context, notify func(models.DataChanges)) error {
+	if _, err := c.Sync(ctx); err != nil {
+		return err
+	}
+
+	for {
+		accessToken := c.authClient.AccessToken()
+		err := c.secretClient.Watch(ctx, c.Cursor(), func(event models.ChangeEvent) {
+			if event.Cursor != 0 && event.Cursor <= c.Cursor() {
+				// change was already loaded
+				return
+			}
+			changes, err := c.Sync(ctx)
+			if err != nil {
+				log.Error().Msgf("Failed to sync data: %v", err)
+				return
+			}
+			if notify != nil {
+				notify(changes)
+			}
+		})
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		switch status.Code(err) {
+		case codes.Unauthenticated:
+			if c.authClient.RefreshToken() == "" {
+				return err
+			}
+			if err := c.authClient.RefreshExpired(ctx, accessToken); err != nil {
+				return err
+			}
+			// changes made while the stream was closed are reported on open
+			continue
+		case codes.Unavailable:
+			log.Warn().Msgf("Watch stream closed, reconnecting: %v", err)
+		default:
+			return err
+		}
+
+		select {
+		case <-time.After(watchRetryDelay):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// StartWatch starts watching changes of private data in background. Changes are printed when they are applied.
+func (c *CLI) StartWatch() {
+	c.StopWatch()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.mu.Lock()
+	c.stopWatch = cancel
+	c.mu.Unlock()
+
+	go func() {
+		err := c.Watch(ctx, func(changes models.DataChanges) {
+			log.Info().Msgf("Data was changed on another device: %d changed, %d deleted record(s).",
+				len(changes.Data), len(changes.Tombstones))
+		})
+		if err != nil {
+			log.Error().Msgf("Failed to watch data: %v", err)
+		}
+	}()
+}
+
+// StopWatch stops watching changes of private data.
+func (c *CLI) StopWatch() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopWatch != nil {
+		c.stopWatch()
+		c.stopWatch = nil
+	}
+}
+
+// Records returns local records synchronized with the server (with changes made offline) ordered by ID.
+func (c *CLI) Records() []models.Data {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	records := make([]models.Data, 0, len(c.records))
+	for _, secret := range c.records {
+		records = append(records, secret)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].ID < records[j].ID
+	})
+	return records
+}
+
+// editData replaces private data loaded by get-data. Data changed on another device after it was loaded
+// is not overwritten (ErrorDataChanged). Metadata of the record is kept unless metadata options are set.
+func (c *CLI) editData(ctx context.Context, id string, secret models.PrivateData, metadata models.Metadata, set bool) error {
+	c.mu.Lock()
+	revision, ok := c.revisions[id]
+	c.mu.Unlock()
+	if !ok {
+		return ErrorDataNotLoaded
+	}
+
+	binary, err := secret.GetJSON()
+	if err != nil {
+		return err
+	}
+	if !set {
+		metadata, err = c.recordMetadata(ctx, id, revision)
+		if err != nil {
+			return err
+		}
+	}
+
+	data := models.Data{
+		ID:         id,
+		UserID:     "",
+		DataType:   secret.GetType(),
+		DataBinary: binary,
+		Revision:   revision,
+		Metadata:   metadata,
+	}
+	return c.updateData(ctx, data)
+}
+
+// updateData replaces private data with the expected revision. Change is kept in local vault
+// if the server is not available.
+func (c *CLI) updateData(ctx context.Context, data models.Data) error {
+	if c.useLocal() {
+		return c.queue(local.OperationUpdate, data)
+	}
+
+	revision, err := c.secretClient.UpdateData(ctx, data)
+	if c.fallbackToLocal(err) {
+		return c.queue(local.OperationUpdate, data)
+	}
+	if status.Code(err) == codes.Aborted {
+		return ErrorDataChanged
+	}
+	if err != nil {
+		return err
+	}
+	data.Revision = revision
+	data.UpdatedAt = time.Now()
+	c.setRecord(data)
+	return nil
+}
+
+// EditBinary replaces binary data in the storage.
+func (c *CLI) EditBinary(ctx context.Context, args []string) error {
+	metadata, set, args, err := parseMetadata(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 3 {
+		return errors.New("invalid arguments")
+	}
+
+	return c.editData(ctx, args[0], models.NewBinary(args[1], []byte(args[2])), metadata, set)
+}
+
+// EditCredentials replaces credentials data in the storage.
+func (c *CLI) EditCredentials(ctx context.Context, args []string) error {
+	metadata, set, args, err := parseMetadata(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 4 {
+		return errors.New("invalid arguments")
+	}
+
+	return c.editData(ctx, args[0], models.NewCredentials(args[1], args[2], args[3]), metadata, set)
+}
+
+// EditText replaces text data in the storage.
+func (c *CLI) EditText(ctx context.Context, args []string) error {
+	metadata, set, args, err := parseMetadata(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 3 {
+		return errors.New("invalid arguments")
+	}
+
+	return c.editData(ctx, args[0], models.NewText(args[1], args[2]), metadata, set)
+}
+
+// EditCard replaces card data in the storage.
+func (c *CLI) EditCard(ctx context.Context, args []string) error {
+	metadata, set, args, err := parseMetadata(args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 6 {
+		return errors.New("invalid arguments")
+	}
+
+	return c.editData(ctx, args[0], models.NewCard(args[1], args[2], args[3], args[4], args[5]), metadata, set)
+}
+
 // AddBinary add binary data to the storage.
 func (c *CLI) AddBinary(ctx context.Context, args []string) error {
+	metadata, _, args, err := parseMetadata(args)
+	if err != nil {
+		return err
+	}
 	if len(args) != 2 {
 		return errors.New("invalid arguments")
 	}
@@ -114,13 +985,18 @@ func (c *CLI) AddBinary(ctx context.Context, args []string) error {
 		UserID:     "",
 		DataType:   secret.GetType(),
 		DataBinary: binary,
+		Metadata:   metadata,
 	}
 
-	return c.secretClient.AddData(ctx, data)
+	return c.addData(ctx, data)
 }
 
 // AddCredentials add credentials data to the storage.
 func (c *CLI) AddCredentials(ctx context.Context, args []string) error {
+	metadata, _, args, err := parseMetadata(args)
+	if err != nil {
+		return err
+	}
 	if len(args) != 3 {
 		return errors.New("invalid arguments")
 	}
@@ -137,13 +1013,18 @@ func (c *CLI) AddCredentials(ctx context.Context, args []string) error {
 		UserID:     "",
 		DataType:   secret.GetType(),
 		DataBinary: binary,
+		Metadata:   metadata,
 	}
 
-	return c.secretClient.AddData(ctx, data)
+	return c.addData(ctx, data)
 }
 
 // AddText add text data to the storage.
 func (c *CLI) AddText(ctx context.Context, args []string) error {
+	metadata, _, args, err := parseMetadata(args)
+	if err != nil {
+		return err
+	}
 	if len(args) != 2 {
 		return errors.New("invalid arguments")
 	}
@@ -160,13 +1041,18 @@ func (c *CLI) AddText(ctx context.Context, args []string) error {
 		UserID:     "",
 		DataType:   secret.GetType(),
 		DataBinary: binary,
+		Metadata:   metadata,
 	}
 
-	return c.secretClient.AddData(ctx, data)
+	return c.addData(ctx, data)
 }
 
 // AddCard add card data to the storage.
 func (c *CLI) AddCard(ctx context.Context, args []string) error {
+	metadata, _, args, err := parseMetadata(args)
+	if err != nil {
+		return err
+	}
 	if len(args) != 5 {
 		return errors.New("invalid arguments")
 	}
@@ -183,9 +1069,10 @@ func (c *CLI) AddCard(ctx context.Context, args []string) error {
 		UserID:     "",
 		DataType:   secret.GetType(),
 		DataBinary: binary,
+		Metadata:   metadata,
 	}
 
-	return c.secretClient.AddData(ctx, data)
+	return c.addData(ctx, data)
 }
 
 // Executor runs actions for option items.
@@ -208,11 +1095,111 @@ func (c *CLI) Executor(input string) {
 		log.Info().Msg("User was registered. Use login command to sign-in.")
 	case "login":
 		err := c.Login(ctx, args[1:])
+		if errors.Is(err, service.ErrorTwoFactorRequired) {
+			log.Info().Msg("Second factor required. Use 2fa-verify command to complete login.")
+			return
+		}
 		if err != nil {
 			log.Error().Msgf("Failed to login: %v", err)
 			return
 		}
 		log.Info().Msg("UI login done.")
+	case "logout":
+		err := c.Logout(ctx, args[1:])
+		if err != nil {
+			log.Error().Msgf("Failed to logout: %v", err)
+			return
+		}
+		log.Info().Msg("User was logged out.")
+	case "change-password":
+		err := c.ChangePassword(ctx, args[1:])
+		if err != nil {
+			log.Error().Msgf("Failed to change password: %v", err)
+			return
+		}
+		log.Info().Msg("Password was changed. Other sessions were signed out.")
+	case "delete-account":
+		err := c.DeleteAccount(ctx, args[1:])
+		if err != nil {
+			log.Error().Msgf("Failed to delete account: %v", err)
+			return
+		}
+		log.Info().Msg("Account was deleted.")
+	case "2fa-enable":
+		err := c.EnableTwoFactor(ctx)
+		if err != nil {
+			log.Error().Msgf("Failed to enable two-factor authentication: %v", err)
+			return
+		}
+		log.Info().Msg("Use 2fa-confirm command with code from authenticator app to finish setup.")
+	case "2fa-confirm":
+		err := c.ConfirmTwoFactor(ctx, args[1:])
+		if err != nil {
+			log.Error().Msgf("Failed to confirm two-factor authentication: %v", err)
+			return
+		}
+		log.Info().Msg("Two-factor authentication was enabled.")
+	case "2fa-verify":
+		err := c.VerifyTwoFactor(ctx, args[1:])
+		if err != nil {
+			log.Error().Msgf("Failed to verify second factor: %v", err)
+			return
+		}
+		log.Info().Msg("UI login done.")
+	case "2fa-disable":
+		err := c.DisableTwoFactor(ctx, args[1:])
+		if err != nil {
+			log.Error().Msgf("Failed to disable two-factor authentication: %v", err)
+			return
+		}
+		log.Info().Msg("Two-factor authentication was disabled.")
+	case "sessions":
+		err := c.ListSessions(ctx)
+		if err != nil {
+			log.Error().Msgf("Failed to list sessions: %v", err)
+			return
+		}
+	case "revoke-session":
+		err := c.RevokeSession(ctx, args[1:])
+		if err != nil {
+			log.Error().Msgf("Failed to revoke session: %v", err)
+			return
+		}
+		log.Info().Msg("Session was revoked.")
+	case "register-device":
+		deviceID, err := c.RegisterDevice(ctx, args[1:])
+		if err != nil {
+			log.Error().Msgf("Failed to register device: %v", err)
+			return
+		}
+		log.Info().Msgf("Device %s was registered. Set DEVICE_CERT_FILE and DEVICE_KEY_FILE to use client certificate.", deviceID)
+	case "revoke-device":
+		err := c.RevokeDevice(ctx, args[1:])
+		if err != nil {
+			log.Error().Msgf("Failed to revoke device: %v", err)
+			return
+		}
+		log.Info().Msg("Device was revoked.")
+	case "token-create":
+		token, err := c.CreateAccessToken(ctx, args[1:])
+		if err != nil {
+			log.Error().Msgf("Failed to create access token: %v", err)
+			return
+		}
+		log.Info().Msgf("Personal access token (it is shown once, set PERSONAL_ACCESS_TOKEN to use it): %s", token)
+	case "tokens":
+		err := c.ListAccessTokens(ctx)
+		if err != nil {
+			log.Error().Msgf("Failed to list access tokens: %v", err)
+			return
+		}
+	case "token-revoke":
+		err := c.RevokeAccessToken(ctx, args[1:])
+		if err != nil {
+			log.Error().Msgf("Failed to revoke access token: %v", err)
+			return
+		}
+		log.Info().Msg("Access token was revoked.")
 	case "add-text":
 		err := c.AddText(ctx, args[1:])
 		if err != nil {
@@ -241,21 +1228,129 @@ func (c *CLI) Executor(input string) {
 			return
 		}
 		log.Info().Msg("Credentials data was added.")
+	case "edit-text":
+		err := c.EditText(ctx, args[1:])
+		if err != nil {
+			log.Error().Msgf("Failed to edit text data: %v", err)
+			return
+		}
+		log.Info().Msg("Text data was updated.")
+	case "edit-card":
+		err := c.EditCard(ctx, args[1:])
+		if err != nil {
+			log.Error().Msgf("Failed to edit card data: %v", err)
+			return
+		}
+		log.Info().Msg("Card data was updated.")
+	case "edit-binary":
+		err := c.EditBinary(ctx, args[1:])
+		if err != nil {
+			log.Error().Msgf("Failed to edit binary data: %v", err)
+			return
+		}
+		log.Info().Msg("Binary data was updated.")
+	case "edit-credentials":
+		err := c.EditCredentials(ctx, args[1:])
+		if err != nil {
+			log.Error().Msgf("Failed to edit credentials data: %v", err)
+			return
+		}
+		log.Info().Msg("Credentials data was updated.")
 	case "get-data":
+		tag, _, err := parseOption(args[1:], optionTag)
+		if err != nil {
+			log.Error().Msgf("Failed to get data: %v", err)
+			return
+		}
 		data, err := c.GetData(ctx)
 		if err != nil {
 			log.Error().Msgf("Failed to get data: %v", err)
 			return
 		}
-		c.LogData(data)
+		c.LogData(FilterByTag(data, tag))
 		log.Info().Msg("All user data was received.")
+	case "history":
+		revisions, err := c.History(ctx, args[1:])
+		if err != nil {
+			log.Error().Msgf("Failed to get history: %v", err)
+			return
+		}
+		c.LogRevisions(revisions)
+	case "restore":
+		err := c.Restore(ctx, args[1:])
+		if err != nil {
+			log.Error().Msgf("Failed to restore revision: %v", err)
+			return
+		}
+		log.Info().Msg("Revision was restored.")
+	case "sync":
+		changes, err := c.Sync(ctx)
+		if err != nil {
+			log.Error().Msgf("Failed to sync data: %v", err)
+			return
+		}
+		c.LogData(c.Records())
+		log.Info().Msgf("Data was synchronized: %d changed, %d deleted record(s).",
+			len(changes.Data), len(changes.Tombstones))
+	case "status":
+		c.LogStatus()
+	case "conflicts":
+		c.LogConflicts()
+	case "show-conflict":
+		conflict, err := c.ShowConflict(args[1:])
+		if err != nil {
+			log.Error().Msgf("Failed to show conflict: %v", err)
+			return
+		}
+		c.LogConflict(conflict)
+	case "resolve":
+		err := c.Resolve(ctx, args[1:])
+		if err != nil {
+			log.Error().Msgf("Failed to resolve conflict: %v", err)
+			return
+		}
+		log.Info().Msg("Conflict was resolved.")
+	case "watch":
+		c.StartWatch()
+		log.Info().Msg("Changes of data are watched.")
+	case "unwatch":
+		c.StopWatch()
+		log.Info().Msg("Changes of data are not watched anymore.")
 	case "delete-data":
 		err := c.DeleteData(ctx, args[1:])
 		if err != nil {
 			log.Error().Msgf("Failed to delete data: %v", err)
 			return
 		}
-		log.Info().Msg("Data was deleted.")
+		log.Info().Msg("Data was moved to trash.")
+	case "trash":
+		trash, err := c.Trash(ctx)
+		if err != nil {
+			log.Error().Msgf("Failed to get trash: %v", err)
+			return
+		}
+		c.LogTrash(trash)
+	case "trash-restore":
+		err := c.RestoreData(ctx, args[1:])
+		if err != nil {
+			log.Error().Msgf("Failed to restore data: %v", err)
+			return
+		}
+		log.Info().Msg("Data was restored from trash.")
+	case "trash-purge":
+		err := c.PurgeData(ctx, args[1:])
+		if err != nil {
+			log.Error().Msgf("Failed to purge data: %v", err)
+			return
+		}
+		log.Info().Msg("Data was deleted permanently.")
+	case "migrate-data":
+		migrated, err := c.MigrateData(ctx)
+		if err != nil {
+			log.Error().Msgf("Failed to migrate data: %v", err)
+			return
+		}
+		log.Info().Msgf("Legacy data was migrated: %d record(s).", migrated)
 	case "exit":
 		log.Debug().Msg("Client shutdown.")
 		os.Exit(0)
@@ -270,17 +1365,17 @@ func (c *CLI) LogData(data []models.Data) {
 	for _, secret := range data {
 		switch secret.DataType {
 		case models.CredentialsType:
-			log.Info().Msgf("ID: %s type: CREDENTIALS data: %s",
-				secret.ID, string(secret.DataBinary))
+			log.Info().Msgf("ID: %s revision: %d type: CREDENTIALS data: %s%s",
+				secret.ID, secret.Revision, string(secret.DataBinary), describeMetadata(secret.Metadata))
 		case models.TextType:
-			log.Info().Msgf("ID: %s type: TEXT data: %s",
-				secret.ID, string(secret.DataBinary))
+			log.Info().Msgf("ID: %s revision: %d type: TEXT data: %s%s",
+				secret.ID, secret.Revision, string(secret.DataBinary), describeMetadata(secret.Metadata))
 		case models.BinaryType:
-			log.Info().Msgf("ID: %s type: BINARY data: %s",
-				secret.ID, string(secret.DataBinary))
+			log.Info().Msgf("ID: %s revision: %d type: BINARY data: %s%s",
+				secret.ID, secret.Revision, string(secret.DataBinary), describeMetadata(secret.Metadata))
 		case models.CardType:
-			log.Info().Msgf("ID: %s type: CARD data: %s",
-				secret.ID, string(secret.DataBinary))
+			log.Info().Msgf("ID: %s revision: %d type: CARD data: %s%s",
+				secret.ID, secret.Revision, string(secret.DataBinary), describeMetadata(secret.Metadata))
 		}
 	}
 }
@@ -0,0 +1,225 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/client/local"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+)
+
+// ErrorConflictNotFound defines an error for resolve of the record without conflict.
+var ErrorConflictNotFound = errors.New("conflict not found, use conflicts to list them")
+
+// Versions of the record kept on resolve of the conflict.
+const (
+	keepLocal  = "local"
+	keepRemote = "remote"
+	keepBoth   = "both"
+)
+
+// Conflict represents a structure for conflicting versions of the record: local version changed offline
+// and remote version changed on another device. Version is nil if the record was deleted.
+type Conflict struct {
+	ID         string
+	Local      *models.Data
+	Remote     *models.Data
+	DetectedAt time.Time
+}
+
+// Conflicts returns conflicts of changes made offline in order of detection.
+func (c *CLI) Conflicts() []Conflict {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	conflicts := make([]Conflict, 0, len(c.conflicts))
+	for _, conflict := range c.conflicts {
+		conflicts = append(conflicts, c.newConflict(conflict))
+	}
+	return conflicts
+}
+
+// LogConflicts prints conflicts of changes made offline without private data, use show-conflict to print it.
+func (c *CLI) LogConflicts() {
+	conflicts := c.Conflicts()
+	if len(conflicts) == 0 {
+		log.Info().Msg("No conflicts.")
+		return
+	}
+
+	log.Info().Msg("Conflicts of changes made offline:")
+	for _, conflict := range conflicts {
+		log.Info().Msgf("ID: %s detected: %s local: %s remote: %s", conflict.ID,
+			conflict.DetectedAt.Format(time.RFC3339), describeVersion(conflict.Local), describeVersion(conflict.Remote))
+	}
+}
+
+// describeVersion formats version of the conflicting record without private data.
+func describeVersion(data *models.Data) string {
+	if data == nil {
+		return "deleted"
+	}
+	description := fmt.Sprintf("type %s revision %d", data.DataType, data.Revision)
+	if data.Metadata.Title != "" {
+		description += fmt.Sprintf(" title %q", data.Metadata.Title)
+	}
+	return description
+}
+
+// ShowConflict returns conflict of the record with private data of both versions.
+func (c *CLI) ShowConflict(args []string) (Conflict, error) {
+	if len(args) != 1 {
+		return Conflict{}, errors.New("invalid arguments")
+	}
+	conflict, ok := c.findConflict(args[0])
+	if !ok {
+		return Conflict{}, ErrorConflictNotFound
+	}
+	return conflict, nil
+}
+
+// LogConflict prints both versions of the conflicting record with private data.
+func (c *CLI) LogConflict(conflict Conflict) {
+	log.Info().Msgf("Conflict of the record %s detected: %s", conflict.ID, conflict.DetectedAt.Format(time.RFC3339))
+	for _, version := range []struct {
+		name string
+		data *models.Data
+	}{{name: keepLocal, data: conflict.Local}, {name: keepRemote, data: conflict.Remote}} {
+		if version.data == nil {
+			log.Info().Msgf("%s: deleted", version.name)
+			continue
+		}
+		log.Info().Msgf("%s: %s data: %s%s", version.name, describeVersion(version.data),
+			string(version.data.DataBinary), describeMetadata(version.data.Metadata))
+	}
+}
+
+// findConflict returns conflict of the record with the ID.
+func (c *CLI) findConflict(id string) (Conflict, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, pending := range c.conflicts {
+		if pending.Data.ID == id {
+			return c.newConflict(pending), true
+		}
+	}
+	return Conflict{}, false
+}
+
+// Resolve resolves conflict of the record. Local version replaces remote version (--keep local), remote version
+// is kept (--keep remote) or local version is added as a new record (--keep both).
+func (c *CLI) Resolve(ctx context.Context, args []string) error {
+	keep, args, err := parseOption(args, "--keep")
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("invalid arguments")
+	}
+
+	conflict, ok := c.findConflict(args[0])
+	if !ok {
+		return ErrorConflictNotFound
+	}
+
+	switch keep {
+	case keepLocal:
+		err = c.keepLocal(ctx, conflict)
+	case keepRemote:
+	case keepBoth:
+		if conflict.Local != nil {
+			data := *conflict.Local
+			data.ID = uuid.NewString()
+			data.Revision = 0
+			err = c.addData(ctx, data)
+		}
+	default:
+		return fmt.Errorf("invalid value of --keep %q, use local, remote or both", keep)
+	}
+	if err != nil {
+		return err
+	}
+	return c.removeConflict(conflict.ID)
+}
+
+// keepLocal replaces remote version of the record with local version.
+func (c *CLI) keepLocal(ctx context.Context, conflict Conflict) error {
+	switch {
+	case conflict.Local == nil && conflict.Remote == nil:
+		return nil
+	case conflict.Local == nil:
+		return c.deleteData(ctx, models.Data{ID: conflict.ID, Revision: conflict.Remote.Revision})
+	case conflict.Remote == nil:
+		data := *conflict.Local
+		data.Revision = 0
+		return c.addData(ctx, data)
+	}
+
+	data := *conflict.Local
+	data.Revision = conflict.Remote.Revision
+	return c.updateData(ctx, data)
+}
+
+// newConflict returns conflict with remote version of the record from the last sync. Caller must hold the lock.
+func (c *CLI) newConflict(conflict local.Conflict) Conflict {
+	result := Conflict{ID: conflict.Data.ID, DetectedAt: conflict.DetectedAt}
+	if conflict.Type != local.OperationDelete {
+		data := conflict.Data
+		result.Local = &data
+	}
+	if remote, ok := c.records[conflict.Data.ID]; ok {
+		result.Remote = &remote
+	}
+	return result
+}
+
+// addConflict keeps change made offline which was rejected by the server, previous conflict of the record
+// is replaced. Caller must hold the lock.
+func (c *CLI) addConflict(operation local.Operation) {
+	conflict := local.Conflict{Type: operation.Type, Data: operation.Data, DetectedAt: time.Now()}
+	for i := range c.conflicts {
+		if c.conflicts[i].Data.ID == operation.Data.ID {
+			c.conflicts[i] = conflict
+			return
+		}
+	}
+	c.conflicts = append(c.conflicts, conflict)
+}
+
+// removeConflict removes resolved conflict of the record.
+func (c *CLI) removeConflict(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for i := range c.conflicts {
+		if c.conflicts[i].Data.ID == id {
+			c.conflicts = append(c.conflicts[:i], c.conflicts[i+1:]...)
+			break
+		}
+	}
+	return c.saveLocal()
+}
+
+// dropSettledConflicts removes conflicts of changes which are already applied on the server (e.g. change was sent
+// again after the response was lost). Caller must hold the lock.
+func (c *CLI) dropSettledConflicts() {
+	conflicts := make([]local.Conflict, 0, len(c.conflicts))
+	for _, conflict := range c.conflicts {
+		remote, ok := c.records[conflict.Data.ID]
+		switch {
+		case conflict.Type == local.OperationDelete && !ok:
+		case conflict.Type != local.OperationDelete && ok && remote.DataType == conflict.Data.DataType &&
+			bytes.Equal(remote.DataBinary, conflict.Data.DataBinary) &&
+			remote.Metadata.Equal(conflict.Data.Metadata):
+		default:
+			conflicts = append(conflicts, conflict)
+		}
+	}
+	c.conflicts = conflicts
+}
@@ -0,0 +1,214 @@
+package cli
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/client/local"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+)
+
+func TestCLI_Resolve(t *testing.T) {
+	remote := models.Data{ID: "id", DataType: models.TextType, DataBinary: []byte("remote"), Revision: 3}
+	changed := local.Conflict{
+		Type:       local.OperationUpdate,
+		Data:       models.Data{ID: "id", DataType: models.TextType, DataBinary: []byte("local"), Revision: 2},
+		DetectedAt: time.Now(),
+	}
+	deleted := local.Conflict{Type: local.OperationDelete, Data: models.Data{ID: "id", Revision: 2}, DetectedAt: time.Now()}
+
+	tests := []struct {
+		name        string
+		conflict    local.Conflict
+		args        []string
+		wantErr     bool
+		wantAdded   int
+		wantUpdated int64
+		wantDeleted int64
+		wantRecord  []byte
+	}{
+		{
+			name:        "positive test (keep local)",
+			conflict:    changed,
+			args:        []string{"id", "--keep", "local"},
+			wantUpdated: remote.Revision,
+			wantRecord:  []byte("local"),
+		},
+		{
+			name:       "positive test (keep remote)",
+			conflict:   changed,
+			args:       []string{"id", "--keep", "remote"},
+			wantRecord: []byte("remote"),
+		},
+		{
+			name:       "positive test (keep both)",
+			conflict:   changed,
+			args:       []string{"--keep", "both", "id"},
+			wantAdded:  1,
+			wantRecord: []byte("remote"),
+		},
+		{
+			name:        "positive test (keep local deletion)",
+			conflict:    deleted,
+			args:        []string{"id", "--keep", "local"},
+			wantDeleted: remote.Revision,
+		},
+		{
+			name:     "negative test (conflict not found)",
+			conflict: changed,
+			args:     []string{"another", "--keep", "local"},
+			wantErr:  true,
+		},
+		{
+			name:     "negative test (invalid keep)",
+			conflict: changed,
+			args:     []string{"id", "--keep", "all"},
+			wantErr:  true,
+		},
+		{
+			name:     "negative test (invalid arguments)",
+			conflict: changed,
+			args:     []string{"--keep", "local"},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeService{}
+			c := newTestCLI(fake)
+			c.records[remote.ID] = remote
+			c.revisions[remote.ID] = remote.Revision
+			c.conflicts = []local.Conflict{tt.conflict}
+
+			err := c.Resolve(context.Background(), tt.args)
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Len(t, c.Conflicts(), 1)
+				assert.Empty(t, fake.added)
+				assert.Empty(t, fake.updated)
+				assert.Empty(t, fake.deleted)
+				return
+			}
+			require.NoError(t, err)
+			assert.Empty(t, c.Conflicts())
+
+			if assert.Len(t, fake.added, tt.wantAdded) && tt.wantAdded != 0 {
+				assert.NotEqual(t, remote.ID, fake.added[0].GetDataId())
+				assert.Len(t, c.records, 2)
+			}
+			if tt.wantUpdated != 0 && assert.Len(t, fake.updated, 1) {
+				assert.Equal(t, tt.wantUpdated, fake.updated[0].GetRevision())
+			}
+			if tt.wantDeleted != 0 && assert.Len(t, fake.deleted, 1) {
+				assert.Equal(t, tt.wantDeleted, fake.deleted[0].GetRevision())
+			}
+
+			record, ok := c.records[remote.ID]
+			assert.Equal(t, tt.wantRecord != nil, ok)
+			if ok {
+				assert.Equal(t, tt.wantRecord, record.DataBinary)
+			}
+		})
+	}
+}
+
+func TestCLI_dropSettledConflicts(t *testing.T) {
+	metadata := models.Metadata{Title: "title", Tags: []string{"tag"}}
+	remote := models.Data{ID: "id", DataType: models.TextType, DataBinary: []byte("text"), Revision: 3, Metadata: metadata}
+
+	tests := []struct {
+		name     string
+		conflict local.Conflict
+		settled  bool
+	}{
+		{
+			name: "positive test (change is applied)",
+			conflict: local.Conflict{Type: local.OperationUpdate,
+				Data: models.Data{ID: "id", DataType: models.TextType, DataBinary: []byte("text"), Metadata: metadata}},
+			settled: true,
+		},
+		{
+			name: "positive test (another data)",
+			conflict: local.Conflict{Type: local.OperationUpdate,
+				Data: models.Data{ID: "id", DataType: models.TextType, DataBinary: []byte("local"), Metadata: metadata}},
+		},
+		{
+			name: "positive test (another metadata)",
+			conflict: local.Conflict{Type: local.OperationUpdate,
+				Data: models.Data{ID: "id", DataType: models.TextType, DataBinary: []byte("text"),
+					Metadata: models.Metadata{Title: "local"}}},
+		},
+		{
+			name:     "positive test (record is not deleted)",
+			conflict: local.Conflict{Type: local.OperationDelete, Data: models.Data{ID: "id"}},
+		},
+		{
+			name:     "positive test (record is deleted)",
+			conflict: local.Conflict{Type: local.OperationDelete, Data: models.Data{ID: "deleted"}},
+			settled:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCLI(&fakeService{})
+			c.records[remote.ID] = remote
+			c.conflicts = []local.Conflict{tt.conflict}
+
+			c.dropSettledConflicts()
+
+			assert.Equal(t, tt.settled, len(c.conflicts) == 0)
+		})
+	}
+}
+
+func Test_describeVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		data *models.Data
+		want string
+	}{
+		{
+			name: "positive test (with title)",
+			data: &models.Data{ID: "id", DataType: models.CredentialsType, DataBinary: []byte("secret"), Revision: 2,
+				Metadata: models.Metadata{Title: "mail", Notes: "notes"}},
+			want: `type credentials revision 2 title "mail"`,
+		},
+		{
+			name: "positive test (without title)",
+			data: &models.Data{ID: "id", DataType: models.TextType, DataBinary: []byte("secret"), Revision: 1},
+			want: "type text revision 1",
+		},
+		{
+			name: "positive test (deleted)",
+			want: "deleted",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, describeVersion(tt.data))
+		})
+	}
+}
+
+func TestCLI_ShowConflict(t *testing.T) {
+	remote := models.Data{ID: "id", DataType: models.TextType, DataBinary: []byte("remote"), Revision: 3}
+	c := newTestCLI(&fakeService{})
+	c.records[remote.ID] = remote
+	c.conflicts = []local.Conflict{{Type: local.OperationUpdate,
+		Data: models.Data{ID: "id", DataType: models.TextType, DataBinary: []byte("local"), Revision: 2}}}
+
+	conflict, err := c.ShowConflict([]string{"id"})
+	require.NoError(t, err)
+	if assert.NotNil(t, conflict.Local) && assert.NotNil(t, conflict.Remote) {
+		assert.Equal(t, []byte("local"), conflict.Local.DataBinary)
+		assert.Equal(t, []byte("remote"), conflict.Remote.DataBinary)
+	}
+
+	_, err = c.ShowConflict([]string{"another"})
+	assert.ErrorIs(t, err, ErrorConflictNotFound)
+	_, err = c.ShowConflict(nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,48 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+)
+
+// History returns previous revisions of the record kept on the server, the latest revision first.
+func (c *CLI) History(ctx context.Context, args []string) ([]models.Data, error) {
+	if len(args) != 1 {
+		return nil, errors.New("invalid arguments")
+	}
+
+	return c.secretClient.ListRevisions(ctx, args[0])
+}
+
+// Restore replaces the record with the previous revision from history. Restored record gets new revision.
+func (c *CLI) Restore(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return errors.New("invalid arguments")
+	}
+
+	revision, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || revision <= 0 {
+		return errors.New("invalid revision")
+	}
+
+	restored, err := c.secretClient.RestoreRevision(ctx, args[0], revision)
+	if err != nil {
+		return err
+	}
+	c.setRecord(restored)
+	return nil
+}
+
+// LogRevisions prints previous revisions of the record.
+func (c *CLI) LogRevisions(revisions []models.Data) {
+	log.Info().Msg("Previous revisions of the record:")
+	for _, secret := range revisions {
+		log.Info().Msgf("Revision: %d updated: %s data: %s",
+			secret.Revision, secret.UpdatedAt.Format(time.RFC3339), string(secret.DataBinary))
+	}
+}
@@ -0,0 +1,140 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+)
+
+// Options of the commands for metadata of the record.
+const (
+	optionTitle = "--title"
+	optionTags  = "--tags"
+	optionNotes = "--notes"
+	optionMeta  = "--meta"
+	optionTag   = "--tag"
+)
+
+// metadataUsage describes metadata options of the commands.
+const metadataUsage = "[--title <title>] [--tags <tag1,tag2>] [--notes <notes>] [--meta <key=value>]..."
+
+// parseMetadata removes metadata options from arguments and returns metadata of the record.
+// Custom metadata is set by --meta key=value, the option can be repeated. Set is false if no options are found.
+func parseMetadata(args []string) (metadata models.Metadata, set bool, rest []string, err error) {
+	metadata.Title, rest, err = parseOption(args, optionTitle)
+	if err != nil {
+		return models.Metadata{}, false, nil, err
+	}
+	tags, rest, err := parseOption(rest, optionTags)
+	if err != nil {
+		return models.Metadata{}, false, nil, err
+	}
+	metadata.Tags = splitList(tags)
+	metadata.Notes, rest, err = parseOption(rest, optionNotes)
+	if err != nil {
+		return models.Metadata{}, false, nil, err
+	}
+
+	fields, rest, err := parseOptionValues(rest, optionMeta)
+	if err != nil {
+		return models.Metadata{}, false, nil, err
+	}
+	for _, field := range fields {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok || key == "" {
+			return models.Metadata{}, false, nil, fmt.Errorf("invalid value of %s %q, use key=value", optionMeta, field)
+		}
+		if metadata.Fields == nil {
+			metadata.Fields = make(map[string]string)
+		}
+		metadata.Fields[key] = value
+	}
+
+	return metadata, len(rest) != len(args), rest, nil
+}
+
+// parseOptionValues removes repeated option with values from arguments and returns all values.
+func parseOptionValues(args []string, option string) ([]string, []string, error) {
+	var values []string
+	rest := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		if args[i] != option {
+			rest = append(rest, args[i])
+			continue
+		}
+		if i+1 == len(args) {
+			return nil, nil, errors.New("missing value of " + option)
+		}
+		i++
+		values = append(values, args[i])
+	}
+	return values, rest, nil
+}
+
+// recordMetadata returns metadata of the record loaded with the revision. Metadata of the record changed
+// after the last sync is loaded from the server.
+func (c *CLI) recordMetadata(ctx context.Context, id string, revision int64) (models.Metadata, error) {
+	c.mu.Lock()
+	record, ok := c.records[id]
+	c.mu.Unlock()
+	if (ok && record.Revision == revision) || c.useLocal() {
+		return record.Metadata, nil
+	}
+
+	data, err := c.secretClient.GetData(ctx)
+	if c.fallbackToLocal(err) {
+		return record.Metadata, nil
+	}
+	if err != nil {
+		return models.Metadata{}, err
+	}
+	for _, secret := range data {
+		if secret.ID == id {
+			return secret.Metadata, nil
+		}
+	}
+	return models.Metadata{}, nil
+}
+
+// FilterByTag returns private data with the tag, all data is returned for empty tag.
+func FilterByTag(data []models.Data, tag string) []models.Data {
+	if tag == "" {
+		return data
+	}
+
+	filtered := make([]models.Data, 0, len(data))
+	for _, secret := range data {
+		if secret.Metadata.HasTag(tag) {
+			filtered = append(filtered, secret)
+		}
+	}
+	return filtered
+}
+
+// describeMetadata formats metadata of the record for output, empty metadata is an empty string.
+func describeMetadata(metadata models.Metadata) string {
+	var b strings.Builder
+	if metadata.Title != "" {
+		fmt.Fprintf(&b, " title: %s", metadata.Title)
+	}
+	if len(metadata.Tags) != 0 {
+		fmt.Fprintf(&b, " tags: %s", strings.Join(metadata.Tags, ","))
+	}
+	if metadata.Notes != "" {
+		fmt.Fprintf(&b, " notes: %s", metadata.Notes)
+	}
+
+	keys := make([]string, 0, len(metadata.Fields))
+	for key := range metadata.Fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&b, " %s=%s", key, metadata.Fields[key])
+	}
+	return b.String()
+}
@@ -0,0 +1,349 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/client/local"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Status represents a structure for state of the client: connection to the server, local vault,
+// changes made offline which are not sent to the server yet and conflicts of the changes.
+type Status struct {
+	Login      string
+	Online     bool
+	LocalVault string
+	Records    int
+	Pending    int
+	Conflicts  int
+	Cursor     int64
+	SyncedAt   time.Time
+}
+
+// SetLocalVault sets path of local vault file. Local vault is not used with empty path.
+func (c *CLI) SetLocalVault(path string) {
+	c.localPath = path
+}
+
+// Status returns state of the client.
+func (c *CLI) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	state := Status{
+		Login:     c.authClient.User().Login,
+		Online:    !c.offline,
+		Records:   len(c.records),
+		Pending:   len(c.pending),
+		Conflicts: len(c.conflicts),
+		Cursor:    c.cursor,
+		SyncedAt:  c.syncedAt,
+	}
+	if c.local != nil {
+		state.LocalVault = c.local.Path()
+	}
+	return state
+}
+
+// LogStatus prints state of the client.
+func (c *CLI) LogStatus() {
+	state := c.Status()
+	connection := "online"
+	if !state.Online {
+		connection = "offline"
+	}
+	localVault := state.LocalVault
+	if localVault == "" {
+		localVault = "not used"
+	}
+	syncedAt := "never"
+	if !state.SyncedAt.IsZero() {
+		syncedAt = state.SyncedAt.Format(time.RFC3339)
+	}
+
+	log.Info().Msgf("User: %s, connection: %s", state.Login, connection)
+	log.Info().Msgf("Local vault: %s", localVault)
+	log.Info().Msgf("Records: %d, cursor: %d, last sync: %s", state.Records, state.Cursor, syncedAt)
+	log.Info().Msgf("Changes waiting for sync: %d, conflicts: %d", state.Pending, state.Conflicts)
+}
+
+// isUnavailable checks that request failed because the server cannot be reached.
+func isUnavailable(err error) bool {
+	return status.Code(err) == codes.Unavailable
+}
+
+// openLocal loads local vault of the logged in user, so changes made offline are kept. Local vault
+// of another user is replaced. State in memory is kept on reconnect after offline login.
+func (c *CLI) openLocal(vaultKey []byte) error {
+	vault := c.authClient.Vault()
+	if c.localPath == "" || vault == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.offline = false
+	if c.local != nil && c.offlineLogin {
+		c.offlineLogin = false
+		c.local.SetVault(*vault)
+		return c.saveLocal()
+	}
+
+	localVault := local.New(c.localPath, c.authClient.User().Login, *vault, vaultKey)
+	state, err := localVault.Load()
+	switch {
+	case errors.Is(err, local.ErrorVaultNotFound):
+	case errors.Is(err, local.ErrorVaultMismatch):
+		log.Warn().Msgf("Local vault %s of another user is replaced", c.localPath)
+	case err != nil:
+		return err
+	}
+
+	c.local = localVault
+	c.applyState(state)
+	if err := c.saveLocal(); err != nil {
+		c.local = nil
+		return err
+	}
+	return nil
+}
+
+// loginOffline unlocks local vault with the password when the server is not available. Records of the local
+// vault are used and changes are kept until the next sync.
+func (c *CLI) loginOffline(login string, password string) error {
+	localVault, state, err := local.Open(c.localPath, login, password)
+	if err != nil {
+		return err
+	}
+	c.secretClient.SetVaultKey(localVault.VaultKey())
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.local = localVault
+	c.applyState(state)
+	c.offlineLogin = true
+	c.offline = true
+
+	log.Warn().Msg("Server is not available, records of the local vault are used (offline mode)")
+	return nil
+}
+
+// reconnect signs in the user who logged in offline. Login with second factor is completed by 2fa-verify.
+func (c *CLI) reconnect(ctx context.Context) error {
+	user := c.authClient.User()
+	token, err := c.authClient.Login(ctx)
+	if err != nil {
+		if isUnavailable(err) {
+			return fmt.Errorf("server is not available: %w", err)
+		}
+		return err
+	}
+	return c.completeLogin(ctx, token, user.Password)
+}
+
+// flushPending sends changes made offline after login of the user.
+func (c *CLI) flushPending(ctx context.Context) {
+	if !c.hasPending() {
+		return
+	}
+	if _, err := c.Sync(ctx); err != nil {
+		log.Warn().Msgf("Changes made offline were not sent: %v", err)
+	}
+}
+
+// applyState replaces records in memory with the state of local vault. Caller must hold the lock.
+func (c *CLI) applyState(state local.State) {
+	c.records = make(map[string]models.Data, len(state.Records))
+	c.revisions = make(map[string]int64, len(state.Records))
+	for _, secret := range state.Records {
+		c.records[secret.ID] = secret
+		c.revisions[secret.ID] = secret.Revision
+	}
+	c.cursor = state.Cursor
+	c.pending = state.Pending
+	c.conflicts = state.Conflicts
+	c.syncedAt = state.SyncedAt
+}
+
+// saveLocal writes records in memory to local vault. Caller must hold the lock.
+func (c *CLI) saveLocal() error {
+	if c.local == nil {
+		return nil
+	}
+
+	state := local.State{
+		Records:   make([]models.Data, 0, len(c.records)),
+		Cursor:    c.cursor,
+		Pending:   c.pending,
+		Conflicts: c.conflicts,
+		SyncedAt:  c.syncedAt,
+	}
+	for _, secret := range c.records {
+		state.Records = append(state.Records, secret)
+	}
+	if err := c.local.Save(state); err != nil {
+		log.Error().Msgf("Failed to save local vault: %v", err)
+		return err
+	}
+	return nil
+}
+
+// hasPending checks that changes made offline are not sent to the server yet.
+func (c *CLI) hasPending() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending) != 0
+}
+
+// useLocal checks that changes are kept in local vault instead of the server: the user logged in offline
+// or previous changes are not sent yet (changes are sent in order).
+func (c *CLI) useLocal() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.local != nil && (c.offlineLogin || len(c.pending) != 0)
+}
+
+// fallbackToLocal checks that failed request is replaced with local vault because the server cannot be reached.
+func (c *CLI) fallbackToLocal(err error) bool {
+	if !isUnavailable(err) {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.local == nil {
+		return false
+	}
+	c.offline = true
+	log.Warn().Msgf("Server is not available, local vault is used: %v", err)
+	return true
+}
+
+// queue keeps change of private data in local vault until the next sync. Changes of the same record
+// are merged, so only the last change is sent with the revision loaded from the server.
+func (c *CLI) queue(operationType local.OperationType, data models.Data) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	operation := local.Operation{Type: operationType, Data: data, QueuedAt: time.Now()}
+	for i, pending := range c.pending {
+		if pending.Data.ID != data.ID {
+			continue
+		}
+		switch {
+		case operationType == local.OperationUpdate:
+			// record is not sent yet or has pending update with expected revision
+			revision := c.pending[i].Data.Revision
+			c.pending[i].Data = data
+			c.pending[i].Data.Revision = revision
+			c.pending[i].QueuedAt = operation.QueuedAt
+			operation.Type = ""
+		case pending.Type == local.OperationAdd:
+			// record was never sent to the server
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			operation.Type = ""
+		default:
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+		}
+		break
+	}
+	if operation.Type != "" {
+		c.pending = append(c.pending, operation)
+	}
+
+	if operationType == local.OperationDelete {
+		delete(c.records, data.ID)
+		delete(c.revisions, data.ID)
+	} else {
+		c.records[data.ID] = data
+		c.revisions[data.ID] = data.Revision
+	}
+	return c.saveLocal()
+}
+
+// replayPending sends changes made offline in order of the changes. Changes of records changed on another device
+// are kept as conflicts, invalid changes are dropped. Other errors keep the rest of the changes.
+func (c *CLI) replayPending(ctx context.Context) error {
+	for {
+		c.mu.Lock()
+		if len(c.pending) == 0 {
+			c.mu.Unlock()
+			return nil
+		}
+		operation := c.pending[0]
+		c.mu.Unlock()
+
+		err := c.sendOperation(ctx, operation)
+		switch status.Code(err) {
+		case codes.OK:
+		case codes.AlreadyExists, codes.Aborted, codes.NotFound:
+			log.Warn().Msgf("Change of data %s made offline conflicts with change on another device: %v",
+				operation.Data.ID, err)
+			c.mu.Lock()
+			c.addConflict(operation)
+			c.mu.Unlock()
+		case codes.InvalidArgument:
+			log.Warn().Msgf("Change of data %s made offline was rejected: %v", operation.Data.ID, err)
+		default:
+			if isUnavailable(err) {
+				c.mu.Lock()
+				c.offline = true
+				c.mu.Unlock()
+			}
+			return err
+		}
+
+		c.mu.Lock()
+		c.pending = c.pending[1:]
+		err = c.saveLocal()
+		c.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// sendOperation sends change of private data made offline to the server.
+func (c *CLI) sendOperation(ctx context.Context, operation local.Operation) error {
+	switch operation.Type {
+	case local.OperationAdd:
+		return c.secretClient.AddData(ctx, operation.Data)
+	case local.OperationUpdate:
+		_, err := c.secretClient.UpdateData(ctx, operation.Data)
+		return err
+	case local.OperationDelete:
+		err := c.secretClient.DeleteData(ctx, operation.Data.ID, operation.Data.Revision)
+		if status.Code(err) == codes.NotFound {
+			// record was deleted on another device
+			return nil
+		}
+		return err
+	}
+	return fmt.Errorf("unknown operation %q", operation.Type)
+}
+
+// setRecord saves private data sent to the server in local records.
+func (c *CLI) setRecord(data models.Data) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.offline = false
+	c.records[data.ID] = data
+	c.revisions[data.ID] = data.Revision
+	_ = c.saveLocal()
+}
+
+// removeRecord removes private data deleted on the server from local records.
+func (c *CLI) removeRecord(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.offline = false
+	delete(c.records, id)
+	delete(c.revisions, id)
+	_ = c.saveLocal()
+}
@@ -0,0 +1,228 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/client/local"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/client/service"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+	pb "github.com/vstebletsov89/go-developer-course-gophkeeper/internal/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeService records changes of private data sent by the client. Errors are returned for the records
+// with configured IDs, Sync returns configured response.
+type fakeService struct {
+	pb.GophkeeperClient
+	errors  map[string]error
+	added   []*pb.Data
+	updated []*pb.UpdateDataRequest
+	deleted []*pb.DeleteDataRequest
+	sync    *pb.SyncResponse
+	syncs   int
+}
+
+func (f *fakeService) AddData(_ context.Context, in *pb.AddDataRequest, _ ...grpc.CallOption) (*pb.AddDataResponse, error) {
+	if err := f.errors[in.GetData().GetDataId()]; err != nil {
+		return nil, err
+	}
+	f.added = append(f.added, in.GetData())
+	return &pb.AddDataResponse{}, nil
+}
+
+func (f *fakeService) UpdateData(_ context.Context, in *pb.UpdateDataRequest, _ ...grpc.CallOption) (*pb.UpdateDataResponse, error) {
+	if err := f.errors[in.GetData().GetDataId()]; err != nil {
+		return nil, err
+	}
+	f.updated = append(f.updated, in)
+	return &pb.UpdateDataResponse{Revision: in.GetRevision() + 1}, nil
+}
+
+func (f *fakeService) DeleteData(_ context.Context, in *pb.DeleteDataRequest, _ ...grpc.CallOption) (*pb.DeleteDataResponse, error) {
+	if err := f.errors[in.GetDataId()]; err != nil {
+		return nil, err
+	}
+	f.deleted = append(f.deleted, in)
+	return &pb.DeleteDataResponse{}, nil
+}
+
+func (f *fakeService) Sync(_ context.Context, _ *pb.SyncRequest, _ ...grpc.CallOption) (*pb.SyncResponse, error) {
+	f.syncs++
+	if f.sync == nil {
+		return &pb.SyncResponse{}, nil
+	}
+	return f.sync, nil
+}
+
+// newTestCLI returns CLI with unlocked vault which sends requests to the fake service.
+func newTestCLI(fake *fakeService) *CLI {
+	secretClient := service.NewSecretClient()
+	secretClient.SetService(fake)
+	secretClient.SetVaultKey(bytes.Repeat([]byte{1}, 32))
+	return NewCLI(service.NewAuthClient(), secretClient)
+}
+
+func TestCLI_queue(t *testing.T) {
+	added := models.Data{ID: "id", DataType: models.TextType, DataBinary: []byte("added"),
+		Metadata: models.Metadata{Title: "added", Tags: []string{"old"}}}
+	updated := models.Data{ID: "id", DataType: models.TextType, DataBinary: []byte("updated"), Revision: 3,
+		Metadata: models.Metadata{Title: "updated", Notes: "notes"}}
+	edited := models.Data{ID: "id", DataType: models.TextType, DataBinary: []byte("edited"), Revision: 4,
+		Metadata: models.Metadata{Title: "edited", Fields: map[string]string{"url": "https://example.com"}}}
+	deleted := models.Data{ID: "id", Revision: 3}
+	another := local.Operation{Type: local.OperationAdd, Data: models.Data{ID: "another", DataBinary: []byte("another")}}
+
+	withRevision := func(data models.Data, revision int64) models.Data {
+		data.Revision = revision
+		return data
+	}
+
+	tests := []struct {
+		name          string
+		pending       []local.Operation
+		operationType local.OperationType
+		data          models.Data
+		want          []local.Operation
+		wantRecord    bool
+	}{
+		{
+			name:          "positive test (first change)",
+			pending:       []local.Operation{another},
+			operationType: local.OperationUpdate,
+			data:          updated,
+			want:          []local.Operation{another, {Type: local.OperationUpdate, Data: updated}},
+			wantRecord:    true,
+		},
+		{
+			name:          "positive test (add and update)",
+			pending:       []local.Operation{{Type: local.OperationAdd, Data: added}, another},
+			operationType: local.OperationUpdate,
+			data:          edited,
+			want:          []local.Operation{{Type: local.OperationAdd, Data: withRevision(edited, 0)}, another},
+			wantRecord:    true,
+		},
+		{
+			name:          "positive test (update and update)",
+			pending:       []local.Operation{{Type: local.OperationUpdate, Data: updated}},
+			operationType: local.OperationUpdate,
+			data:          edited,
+			want:          []local.Operation{{Type: local.OperationUpdate, Data: withRevision(edited, 3)}},
+			wantRecord:    true,
+		},
+		{
+			name:          "positive test (add and delete)",
+			pending:       []local.Operation{{Type: local.OperationAdd, Data: added}, another},
+			operationType: local.OperationDelete,
+			data:          deleted,
+			want:          []local.Operation{another},
+		},
+		{
+			name:          "positive test (update and delete)",
+			pending:       []local.Operation{{Type: local.OperationUpdate, Data: updated}},
+			operationType: local.OperationDelete,
+			data:          deleted,
+			want:          []local.Operation{{Type: local.OperationDelete, Data: deleted}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCLI(&fakeService{})
+			c.pending = append([]local.Operation(nil), tt.pending...)
+			c.records[added.ID] = added
+
+			err := c.queue(tt.operationType, tt.data)
+			require.NoError(t, err)
+
+			require.Len(t, c.pending, len(tt.want))
+			for i, operation := range c.pending {
+				assert.Equal(t, tt.want[i].Type, operation.Type)
+				assert.Equal(t, tt.want[i].Data, operation.Data)
+			}
+
+			record, ok := c.records[tt.data.ID]
+			assert.Equal(t, tt.wantRecord, ok)
+			if tt.wantRecord {
+				assert.Equal(t, tt.data, record)
+				assert.Equal(t, tt.data.Revision, c.revisions[tt.data.ID])
+			}
+		})
+	}
+}
+
+func TestCLI_flushPending(t *testing.T) {
+	added := models.Data{ID: "added", DataType: models.TextType, DataBinary: []byte("added")}
+	changed := models.Data{ID: "changed", DataType: models.TextType, DataBinary: []byte("changed"), Revision: 2}
+	deleted := models.Data{ID: "deleted", Revision: 1}
+	pending := []local.Operation{
+		{Type: local.OperationAdd, Data: added},
+		{Type: local.OperationUpdate, Data: changed},
+		{Type: local.OperationDelete, Data: deleted},
+	}
+
+	t.Run("positive test (changes are sent in order, conflicts are kept)", func(t *testing.T) {
+		fake := &fakeService{
+			// record was changed on another device
+			errors: map[string]error{changed.ID: status.Error(codes.Aborted, "revision mismatch")},
+			sync: &pb.SyncResponse{
+				Data: []*pb.Data{
+					{DataId: added.ID, DataType: pb.DataType_TEXT_TYPE, DataBinary: added.DataBinary, Revision: 1},
+					{DataId: changed.ID, DataType: pb.DataType_TEXT_TYPE, DataBinary: []byte("remote"), Revision: 3},
+				},
+				Cursor: 5,
+				Full:   true,
+			},
+		}
+		c := newTestCLI(fake)
+		c.pending = append([]local.Operation(nil), pending...)
+
+		c.flushPending(context.Background())
+
+		assert.Empty(t, c.pending)
+		if assert.Len(t, fake.added, 1) {
+			assert.Equal(t, added.ID, fake.added[0].GetDataId())
+		}
+		assert.Empty(t, fake.updated)
+		if assert.Len(t, fake.deleted, 1) {
+			assert.Equal(t, deleted.ID, fake.deleted[0].GetDataId())
+			assert.Equal(t, deleted.Revision, fake.deleted[0].GetRevision())
+		}
+		if assert.Len(t, c.conflicts, 1) {
+			assert.Equal(t, local.OperationUpdate, c.conflicts[0].Type)
+			assert.Equal(t, changed, c.conflicts[0].Data)
+		}
+		assert.Equal(t, 1, fake.syncs)
+		assert.Equal(t, int64(5), c.Cursor())
+		assert.Len(t, c.records, 2)
+		assert.False(t, c.offline)
+	})
+
+	t.Run("positive test (changes are kept while the server is not available)", func(t *testing.T) {
+		fake := &fakeService{errors: map[string]error{changed.ID: status.Error(codes.Unavailable, "connection refused")}}
+		c := newTestCLI(fake)
+		c.pending = append([]local.Operation(nil), pending...)
+
+		c.flushPending(context.Background())
+
+		assert.Len(t, fake.added, 1)
+		assert.Empty(t, fake.deleted)
+		assert.Equal(t, pending[1:], c.pending)
+		assert.Empty(t, c.conflicts)
+		assert.Equal(t, 0, fake.syncs)
+		assert.True(t, c.offline)
+	})
+
+	t.Run("positive test (nothing to send)", func(t *testing.T) {
+		fake := &fakeService{}
+		c := newTestCLI(fake)
+
+		c.flushPending(context.Background())
+
+		assert.Equal(t, 0, fake.syncs)
+	})
+}
@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+)
+
+// Trash returns deleted records kept on the server, the latest deleted record first.
+func (c *CLI) Trash(ctx context.Context) ([]models.Data, error) {
+	return c.secretClient.ListTrash(ctx)
+}
+
+// RestoreData moves deleted record from trash back.
+func (c *CLI) RestoreData(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("invalid arguments")
+	}
+
+	restored, err := c.secretClient.RestoreData(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	c.setRecord(restored)
+	return nil
+}
+
+// PurgeData deletes record from trash permanently.
+func (c *CLI) PurgeData(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return errors.New("invalid arguments")
+	}
+
+	return c.secretClient.PurgeData(ctx, args[0])
+}
+
+// LogTrash prints deleted records.
+func (c *CLI) LogTrash(trash []models.Data) {
+	log.Info().Msg("Deleted private data in trash:")
+	for _, secret := range trash {
+		log.Info().Msgf("ID: %s deleted: %s data: %s",
+			secret.ID, secret.DeletedAt.Format(time.RFC3339), string(secret.DataBinary))
+	}
+}
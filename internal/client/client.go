@@ -2,6 +2,10 @@
 package client
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"github.com/c-bata/go-prompt"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -12,10 +16,12 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"os"
 )
 
 // RunClient starts client application to communicate with the user.
-func RunClient(cfg *config.Config) error {
+// Build version is reported to the server with the name of the device for the list of sessions.
+func RunClient(cfg *config.Config, buildVersion string) error {
 	// init global logger
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 
@@ -25,7 +31,7 @@ func RunClient(cfg *config.Config) error {
 	// debug config
 	log.Debug().Msgf("%+v\n\n", cfg)
 
-	app, err := startClient(cfg)
+	app, err := startClient(cfg, buildVersion)
 	if err != nil {
 		return err
 	}
@@ -41,24 +47,56 @@ func RunClient(cfg *config.Config) error {
 	return nil
 }
 
-func startClient(cfg *config.Config) (*cli.CLI, error) {
+// newClientTLSConfig returns TLS configuration of the client. Server certificate is trusted from the file,
+// client certificate of the device is presented if it is configured.
+func newClientTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	serverCertificate, err := os.ReadFile(cfg.TLSCertFile)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(serverCertificate) {
+		return nil, fmt.Errorf("file %s does not contain PEM encoded certificate", cfg.TLSCertFile)
+	}
+
+	tlsConfig := &tls.Config{
+		RootCAs:    roots,
+		MinVersion: tls.VersionTLS12,
+	}
+	if cfg.DeviceCertFile != "" && cfg.DeviceKeyFile != "" {
+		deviceCertificate, err := tls.LoadX509KeyPair(cfg.DeviceCertFile, cfg.DeviceKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{deviceCertificate}
+	}
+	return tlsConfig, nil
+}
+
+func startClient(cfg *config.Config, buildVersion string) (*cli.CLI, error) {
 	var clientConn *grpc.ClientConn
 	authClient := service.NewAuthClient()
+	deviceName, err := os.Hostname()
+	if err != nil {
+		log.Warn().Msgf("Cannot get name of the device: %v", err)
+	}
+	authClient.SetClientInfo(deviceName, buildVersion)
 	secretClient := service.NewSecretClient()
 
 	// start GRPC client with/without TLS
-	var err error
 	if cfg.EnableTLS {
 		// Client using TLS credentials
 		log.Info().Msg("GRPC client configuration with TLS credentials")
-		transportCredentials, err := credentials.NewClientTLSFromFile("cert.pem", "")
+		tlsConfig, err := newClientTLSConfig(cfg)
 		if err != nil {
-			log.Error().Msgf("GRPC client credentials.NewClientTLSFromFile: %v", err.Error())
+			log.Error().Msgf("GRPC client TLS configuration: %v", err.Error())
 			return nil, err
 		}
+		transportCredentials := credentials.NewTLS(tlsConfig)
 
 		clientConn, err = grpc.Dial(cfg.ServerAddress, grpc.WithTransportCredentials(transportCredentials),
-			grpc.WithUnaryInterceptor(authClient.UnaryInterceptorClient))
+			grpc.WithUnaryInterceptor(authClient.UnaryInterceptorClient),
+			grpc.WithStreamInterceptor(authClient.StreamInterceptorClient))
 		if err != nil {
 			log.Error().Msgf("GRPC client Dial: %v", err.Error())
 			return nil, err
@@ -67,7 +105,8 @@ func startClient(cfg *config.Config) (*cli.CLI, error) {
 		// client without TLS credentials
 		log.Info().Msg("GRPC client configuration without TLS credentials")
 		clientConn, err = grpc.Dial(cfg.ServerAddress, grpc.WithTransportCredentials(insecure.NewCredentials()),
-			grpc.WithUnaryInterceptor(authClient.UnaryInterceptorClient))
+			grpc.WithUnaryInterceptor(authClient.UnaryInterceptorClient),
+			grpc.WithStreamInterceptor(authClient.StreamInterceptorClient))
 		if err != nil {
 			log.Error().Msgf("GRPC client Dial: %v", err.Error())
 			return nil, err
@@ -78,5 +117,13 @@ func startClient(cfg *config.Config) (*cli.CLI, error) {
 	secretClient.SetService(pb.NewGophkeeperClient(clientConn))
 
 	app := cli.NewCLI(authClient, secretClient)
+	app.SetLocalVault(cfg.LocalVaultFile)
+	if cfg.PersonalToken != "" {
+		// scripts are authenticated with personal access token without login
+		if err := app.UseAccessToken(context.Background(), cfg.PersonalToken); err != nil {
+			log.Error().Msgf("Failed to use personal access token: %v", err)
+			return nil, err
+		}
+	}
 	return app, nil
 }
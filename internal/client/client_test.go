@@ -3,11 +3,14 @@ package client
 import (
 	"context"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/client/cli"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/config"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/server"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/storage/postgres/testhelpers"
 )
@@ -26,7 +29,7 @@ func startGrpcClient() (*cli.CLI, error) {
 		panic(err)
 	}
 
-	client, err := startClient(cfg)
+	client, err := startClient(cfg, "test")
 	if err != nil {
 		return nil, err
 	}
@@ -46,6 +49,11 @@ func TestGophkeeperClient_Positive_Negative(t *testing.T) {
 	t.Setenv("DATABASE_DSN", dsn)
 	t.Setenv("SERVER_ADDRESS", "localhost:3202")
 	t.Setenv("ENABLE_MIGRATION", "true")
+	t.Setenv("ACCESS_TOKEN_TTL", "1s")
+	t.Setenv("DEV_MODE", "true")
+	vaultDir := t.TempDir()
+	clientVault := filepath.Join(vaultDir, "client.vault")
+	t.Setenv("LOCAL_VAULT_FILE", clientVault)
 
 	// start grpc server
 	go startGrpcServer(t)
@@ -73,10 +81,12 @@ func TestGophkeeperClient_Positive_Negative(t *testing.T) {
 	err = client.AddBinary(ctx, args)
 	assert.NoError(t, err)
 
-	args[0] = "text description"
-	args[1] = "some text"
-	err = client.AddText(ctx, args)
+	// metadata is set for any data type
+	err = client.AddText(ctx, []string{"text description", "some text", "--title", "note", "--tags", "work,personal",
+		"--meta", "url=https://example.com"})
 	assert.NoError(t, err)
+	err = client.AddText(ctx, []string{"text description", "some text", "--meta", "invalid"})
+	assert.Error(t, err)
 
 	args = make([]string, 3)
 	args[0] = "credentials description"
@@ -94,15 +104,276 @@ func TestGophkeeperClient_Positive_Negative(t *testing.T) {
 	err = client.AddCard(ctx, args)
 	assert.NoError(t, err)
 
+	// expired access token is refreshed silently
+	time.Sleep(2 * time.Second)
+
 	// get all data
 	data, err := client.GetData(ctx)
 	assert.NoError(t, err)
 
 	client.LogData(data)
 
+	for _, secret := range data {
+		assert.True(t, secret.ClientEncrypted)
+	}
+	tagged := cli.FilterByTag(data, "work")
+	if assert.Len(t, tagged, 1) {
+		assert.Equal(t, "note", tagged[0].Metadata.Title)
+		assert.Equal(t, []string{"work", "personal"}, tagged[0].Metadata.Tags)
+		assert.Equal(t, map[string]string{"url": "https://example.com"}, tagged[0].Metadata.Fields)
+	}
+	assert.Empty(t, cli.FilterByTag(data, "unknown"))
+	assert.Len(t, cli.FilterByTag(data, ""), 4)
+
+	// nothing to migrate for new user
+	migrated, err := client.MigrateData(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, migrated)
+
+	// edit data loaded by get-data, data changed on another device is not overwritten
+	var textID, binaryID string
+	for _, secret := range data {
+		switch secret.DataType {
+		case models.TextType:
+			textID = secret.ID
+		case models.BinaryType:
+			binaryID = secret.ID
+		}
+	}
+	err = client.EditText(ctx, []string{textID, "text description", "updated text"})
+	assert.NoError(t, err)
+	err = client.EditCard(ctx, []string{textID, "card description", "name", "number", "date", "cvv"})
+	assert.Error(t, err)
+
+	t.Setenv("LOCAL_VAULT_FILE", filepath.Join(vaultDir, "another.vault"))
+	another, err := startGrpcClient()
+	assert.NoError(t, err)
+	err = another.EditText(ctx, []string{textID, "text description", "another text"})
+	assert.ErrorIs(t, err, cli.ErrorDataNotLoaded)
+	err = another.Login(ctx, []string{"user", "password"})
+	assert.NoError(t, err)
+	_, err = another.GetData(ctx)
+	assert.NoError(t, err)
+	err = another.EditText(ctx, []string{textID, "text description", "another text"})
+	assert.NoError(t, err)
+	err = client.EditText(ctx, []string{textID, "text description", "stale text"})
+	assert.ErrorIs(t, err, cli.ErrorDataChanged)
+
+	// changes of another device are synchronized
+	changes, err := client.Sync(ctx)
+	assert.NoError(t, err)
+	assert.True(t, changes.Full)
+	assert.Len(t, client.Records(), 4)
+	// metadata is kept on edit without metadata options
+	for _, secret := range client.Records() {
+		if secret.ID == textID {
+			assert.Equal(t, "note", secret.Metadata.Title)
+		}
+	}
+	err = another.DeleteData(ctx, []string{binaryID})
+	assert.NoError(t, err)
+	changes, err = client.Sync(ctx)
+	assert.NoError(t, err)
+	assert.False(t, changes.Full)
+	assert.Len(t, changes.Tombstones, 1)
+	assert.Len(t, client.Records(), 3)
+
+	// changes of another device are pushed to the watching client
+	textRevision := func() int64 {
+		for _, secret := range client.Records() {
+			if secret.ID == textID {
+				return secret.Revision
+			}
+		}
+		return 0
+	}
+	revision := textRevision()
+	watchCtx, stopWatch := context.WithTimeout(ctx, 30*time.Second)
+	watchDone := make(chan error, 1)
+	go func() {
+		watchDone <- client.Watch(watchCtx, nil)
+	}()
+	err = another.EditText(ctx, []string{textID, "text description", "watched text"})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		return textRevision() == revision+1
+	}, 10*time.Second, 100*time.Millisecond)
+	stopWatch()
+	assert.NoError(t, <-watchDone)
+
+	err = client.EditBinary(ctx, []string{binaryID, "binary description", "deleted binary"})
+	assert.ErrorIs(t, err, cli.ErrorDataNotLoaded)
+
+	// previous revision of the record is restored from history
+	revisions, err := client.History(ctx, []string{textID})
+	assert.NoError(t, err)
+	client.LogRevisions(revisions)
+	if assert.NotEmpty(t, revisions) {
+		assert.Equal(t, revision, revisions[0].Revision)
+		assert.Equal(t, int64(1), revisions[len(revisions)-1].Revision)
+	}
+	err = client.Restore(ctx, []string{textID, "1"})
+	assert.NoError(t, err)
+	assert.Equal(t, revision+2, textRevision())
+	err = client.Restore(ctx, []string{textID, "invalid"})
+	assert.Error(t, err)
+	data, err = client.GetData(ctx)
+	assert.NoError(t, err)
+
+	// personal access token decrypts private data without password
+	token, err := client.CreateAccessToken(ctx, []string{"ci", "read", "--types", "text,card", "--expires", "1h"})
+	assert.NoError(t, err)
+	err = client.ListAccessTokens(ctx)
+	assert.NoError(t, err)
+	_, err = client.CreateAccessToken(ctx, []string{"ci", "write"})
+	assert.Error(t, err)
+
+	t.Setenv("PERSONAL_ACCESS_TOKEN", token)
+	script, err := startGrpcClient()
+	assert.NoError(t, err)
+	scriptData, err := script.GetData(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, scriptData, 2)
+	err = script.AddText(ctx, []string{"text description", "some text"})
+	assert.Error(t, err)
+
 	// delete data
 	args = make([]string, 1)
 	args[0] = data[0].ID
 	err = client.DeleteData(ctx, args)
 	assert.NoError(t, err)
+
+	// deleted data is kept in trash until it is restored or purged
+	trash, err := client.Trash(ctx)
+	assert.NoError(t, err)
+	client.LogTrash(trash)
+	if assert.Len(t, trash, 2) {
+		assert.Equal(t, data[0].ID, trash[0].ID)
+		assert.False(t, trash[0].DeletedAt.IsZero())
+	}
+	err = client.RestoreData(ctx, args)
+	assert.NoError(t, err)
+	restored, err := client.GetData(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, restored, len(data))
+	err = client.PurgeData(ctx, []string{binaryID})
+	assert.NoError(t, err)
+	err = client.PurgeData(ctx, []string{binaryID})
+	assert.Error(t, err)
+	trash, err = client.Trash(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, trash)
+
+	// change password, vault is unlocked with the new password
+	err = client.ChangePassword(ctx, []string{"password", "new_password"})
+	assert.NoError(t, err)
+	_, err = client.GetData(ctx)
+	assert.NoError(t, err)
+
+	// current session is listed
+	err = client.ListSessions(ctx)
+	assert.NoError(t, err)
+	err = client.RevokeSession(ctx, []string{"invalid"})
+	assert.Error(t, err)
+
+	// logout from all devices
+	err = client.Logout(ctx, []string{"--all"})
+	assert.NoError(t, err)
+	_, err = client.GetData(ctx)
+	assert.Error(t, err)
+
+	err = client.Login(ctx, []string{"user", "password"})
+	assert.Error(t, err)
+	err = client.Login(ctx, []string{"user", "new_password"})
+	assert.NoError(t, err)
+	data, err = client.GetData(ctx)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, data)
+
+	// records of the local vault are available without the server, changes made offline are sent on login
+	t.Setenv("LOCAL_VAULT_FILE", clientVault)
+	t.Setenv("SERVER_ADDRESS", "localhost:1")
+	offline, err := startGrpcClient()
+	assert.NoError(t, err)
+	t.Setenv("SERVER_ADDRESS", "localhost:3202")
+	err = offline.Login(ctx, []string{"user", "password"})
+	assert.Error(t, err)
+	err = offline.Login(ctx, []string{"user", "new_password"})
+	assert.NoError(t, err)
+	offlineData, err := offline.GetData(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, offlineData, len(client.Records()))
+	err = offline.AddText(ctx, []string{"offline description", "offline text"})
+	assert.NoError(t, err)
+	offlineStatus := offline.Status()
+	assert.False(t, offlineStatus.Online)
+	assert.Equal(t, 1, offlineStatus.Pending)
+	assert.Equal(t, clientVault, offlineStatus.LocalVault)
+	_, err = offline.Sync(ctx)
+	assert.Error(t, err)
+
+	var offlineID string
+	for _, secret := range offline.Records() {
+		if secret.Revision == 0 {
+			offlineID = secret.ID
+		}
+	}
+	reconnected, err := startGrpcClient()
+	assert.NoError(t, err)
+	err = reconnected.Login(ctx, []string{"user", "new_password"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, reconnected.Status().Pending)
+	assert.True(t, reconnected.Status().Online)
+	_, err = client.Sync(ctx)
+	assert.NoError(t, err)
+	synced := false
+	for _, secret := range client.Records() {
+		if secret.ID == offlineID {
+			synced = secret.Revision == 1
+		}
+	}
+	assert.True(t, synced)
+
+	// offline change of the record changed on another device is kept as conflict until it is resolved
+	t.Setenv("SERVER_ADDRESS", "localhost:1")
+	offline, err = startGrpcClient()
+	assert.NoError(t, err)
+	t.Setenv("SERVER_ADDRESS", "localhost:3202")
+	err = offline.Login(ctx, []string{"user", "new_password"})
+	assert.NoError(t, err)
+	err = client.EditText(ctx, []string{offlineID, "offline description", "remote text"})
+	assert.NoError(t, err)
+	err = offline.EditText(ctx, []string{offlineID, "offline description", "local text"})
+	assert.NoError(t, err)
+
+	reconnected, err = startGrpcClient()
+	assert.NoError(t, err)
+	err = reconnected.Login(ctx, []string{"user", "new_password"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, reconnected.Status().Pending)
+	assert.Equal(t, 1, reconnected.Status().Conflicts)
+	conflicts := reconnected.Conflicts()
+	if assert.Len(t, conflicts, 1) {
+		assert.Equal(t, offlineID, conflicts[0].ID)
+		assert.NotNil(t, conflicts[0].Local)
+		if assert.NotNil(t, conflicts[0].Remote) {
+			assert.Equal(t, int64(2), conflicts[0].Remote.Revision)
+		}
+	}
+	reconnected.LogConflicts()
+	err = reconnected.Resolve(ctx, []string{offlineID, "--keep", "invalid"})
+	assert.Error(t, err)
+	err = reconnected.Resolve(ctx, []string{textID, "--keep", "local"})
+	assert.ErrorIs(t, err, cli.ErrorConflictNotFound)
+	records := len(reconnected.Records())
+	err = reconnected.Resolve(ctx, []string{offlineID, "--keep", "both"})
+	assert.NoError(t, err)
+	assert.Empty(t, reconnected.Conflicts())
+	assert.Len(t, reconnected.Records(), records+1)
+
+	// delete account
+	err = client.DeleteAccount(ctx, []string{"new_password"})
+	assert.NoError(t, err)
+	err = client.Login(ctx, []string{"user", "new_password"})
+	assert.Error(t, err)
 }
@@ -0,0 +1,215 @@
+// Package local implements encrypted local vault of the client. Local vault mirrors private data of the user
+// in a single file, so records are available without connection to the server and changes made offline
+// are kept until they are sent to the server.
+package local
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/secure"
+)
+
+// fileVersion defines version of the format of local vault file.
+const fileVersion = 1
+
+// ErrorVaultNotFound defines an error for missing local vault file.
+var ErrorVaultNotFound = errors.New("local vault not found")
+
+// ErrorVaultMismatch defines an error for local vault of another user or encrypted with another key.
+var ErrorVaultMismatch = errors.New("local vault belongs to another user")
+
+// OperationType defines type of the change made offline.
+type OperationType string
+
+const (
+	// OperationAdd defines new private data.
+	OperationAdd OperationType = "add"
+	// OperationUpdate defines changed private data, revision of the data is the expected revision on the server.
+	OperationUpdate OperationType = "update"
+	// OperationDelete defines deleted private data.
+	OperationDelete OperationType = "delete"
+)
+
+// Operation represents a structure for change of private data which is not sent to the server yet.
+type Operation struct {
+	Type     OperationType `json:"type"`
+	Data     models.Data   `json:"data"`
+	QueuedAt time.Time     `json:"queuedAt"`
+}
+
+// Conflict represents a structure for change made offline which was rejected by the server because the record
+// was changed on another device. Local version of the record is kept until the conflict is resolved.
+type Conflict struct {
+	Type       OperationType `json:"type"`
+	Data       models.Data   `json:"data"`
+	DetectedAt time.Time     `json:"detectedAt"`
+}
+
+// State represents a structure for content of local vault: records synchronized with the server,
+// cursor of the last sync, changes made offline in order of the changes and conflicts of the changes.
+type State struct {
+	Records   []models.Data `json:"records"`
+	Cursor    int64         `json:"cursor"`
+	Pending   []Operation   `json:"pending"`
+	Conflicts []Conflict    `json:"conflicts"`
+	SyncedAt  time.Time     `json:"syncedAt"`
+}
+
+// vaultFile represents a structure for local vault file. Vault of the user is kept in plain form,
+// so the key is derived from the password without the server. State is encrypted with the vault key.
+type vaultFile struct {
+	Version int          `json:"version"`
+	Login   string       `json:"login"`
+	Vault   models.Vault `json:"vault"`
+	State   []byte       `json:"state"`
+}
+
+// Vault represents encrypted local vault of the user.
+type Vault struct {
+	path  string
+	login string
+	vault models.Vault
+	key   []byte
+}
+
+// New returns local vault of the user unlocked with the vault key. File is written on Save.
+func New(path string, login string, vault models.Vault, vaultKey []byte) *Vault {
+	return &Vault{path: path, login: login, vault: vault, key: vaultKey}
+}
+
+// Open unlocks local vault of the user with the password. It is used for login without connection to the server.
+func Open(path string, login string, password string) (*Vault, State, error) {
+	file, err := readFile(path)
+	if err != nil {
+		return nil, State{}, err
+	}
+	if file.Login != login {
+		return nil, State{}, ErrorVaultMismatch
+	}
+
+	vaultKey, err := secure.UnlockVault(password, file.Vault)
+	if err != nil {
+		return nil, State{}, err
+	}
+
+	v := New(path, login, file.Vault, vaultKey)
+	state, err := v.decryptState(file)
+	if err != nil {
+		return nil, State{}, err
+	}
+	return v, state, nil
+}
+
+// Path returns path of local vault file.
+func (v *Vault) Path() string {
+	return v.path
+}
+
+// VaultKey returns key for client side encryption of the user.
+func (v *Vault) VaultKey() []byte {
+	return v.key
+}
+
+// SetVault replaces vault of the user (e.g. vault key is wrapped with the new password).
+func (v *Vault) SetVault(vault models.Vault) {
+	v.vault = vault
+}
+
+// Load reads state of local vault. ErrorVaultMismatch is returned if the file belongs to another user
+// or is encrypted with another vault key.
+func (v *Vault) Load() (State, error) {
+	file, err := readFile(v.path)
+	if err != nil {
+		return State{}, err
+	}
+	if file.Login != v.login {
+		return State{}, ErrorVaultMismatch
+	}
+	return v.decryptState(file)
+}
+
+// Save encrypts state with the vault key and replaces local vault file.
+func (v *Vault) Save(state State) error {
+	plain, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	encrypted, err := secure.EncryptWithKey(v.key, plain, v.additionalData())
+	if err != nil {
+		return err
+	}
+	content, err := json.Marshal(vaultFile{
+		Version: fileVersion,
+		Login:   v.login,
+		Vault:   v.vault,
+		State:   encrypted,
+	})
+	if err != nil {
+		return err
+	}
+
+	// file is replaced atomically, so interrupted write does not destroy changes made offline
+	tmp, err := os.CreateTemp(filepath.Dir(v.path), filepath.Base(v.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), v.path)
+}
+
+// Remove deletes local vault file.
+func (v *Vault) Remove() error {
+	err := os.Remove(v.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (v *Vault) decryptState(file vaultFile) (State, error) {
+	plain, err := secure.DecryptWithKey(v.key, file.State, v.additionalData())
+	if err != nil {
+		return State{}, ErrorVaultMismatch
+	}
+	var state State
+	if err := json.Unmarshal(plain, &state); err != nil {
+		return State{}, err
+	}
+	return state, nil
+}
+
+// additionalData binds encrypted state to the user.
+func (v *Vault) additionalData() []byte {
+	return []byte("gophkeeper local vault|" + v.login)
+}
+
+func readFile(path string) (vaultFile, error) {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return vaultFile{}, ErrorVaultNotFound
+	}
+	if err != nil {
+		return vaultFile{}, err
+	}
+
+	var file vaultFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return vaultFile{}, err
+	}
+	if file.Version != fileVersion {
+		return vaultFile{}, errors.New("unsupported local vault version")
+	}
+	return file, nil
+}
@@ -0,0 +1,119 @@
+package local
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/secure"
+)
+
+func TestVault_SaveOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "gophkeeper.vault")
+	vault, vaultKey, err := secure.NewVault("password")
+	require.NoError(t, err)
+
+	state := State{
+		Records: []models.Data{{ID: "id", DataType: models.TextType, DataBinary: []byte("secret text"), Revision: 2}},
+		Cursor:  5,
+		Pending: []Operation{{
+			Type:     OperationAdd,
+			Data:     models.Data{ID: "new", DataType: models.CardType, DataBinary: []byte("card")},
+			QueuedAt: time.Unix(100, 0).UTC(),
+		}},
+		Conflicts: []Conflict{{
+			Type:       OperationUpdate,
+			Data:       models.Data{ID: "id", DataType: models.TextType, DataBinary: []byte("local text"), Revision: 1},
+			DetectedAt: time.Unix(150, 0).UTC(),
+		}},
+		SyncedAt: time.Unix(200, 0).UTC(),
+	}
+
+	_, err = New(path, "user", vault, vaultKey).Load()
+	assert.ErrorIs(t, err, ErrorVaultNotFound)
+	_, _, err = Open(path, "user", "password")
+	assert.ErrorIs(t, err, ErrorVaultNotFound)
+
+	err = New(path, "user", vault, vaultKey).Save(state)
+	require.NoError(t, err)
+
+	// private data is not stored in plain form
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(content), "secret text")
+	assert.NotContains(t, string(content), "local text")
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	tests := []struct {
+		name     string
+		login    string
+		password string
+		wantErr  error
+	}{
+		{
+			name:     "positive test",
+			login:    "user",
+			password: "password",
+			wantErr:  nil,
+		},
+		{
+			name:     "negative test (invalid password)",
+			login:    "user",
+			password: "invalid",
+			wantErr:  secure.ErrorVaultLocked,
+		},
+		{
+			name:     "negative test (another user)",
+			login:    "another",
+			password: "password",
+			wantErr:  ErrorVaultMismatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opened, got, err := Open(path, tt.login, tt.password)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, state, got)
+			assert.Equal(t, path, opened.Path())
+			assert.Equal(t, vaultKey, opened.VaultKey())
+		})
+	}
+
+	// vault of another user or with another key is not loaded after online login
+	got, err := New(path, "user", vault, vaultKey).Load()
+	assert.NoError(t, err)
+	assert.Equal(t, state, got)
+	_, err = New(path, "another", vault, vaultKey).Load()
+	assert.ErrorIs(t, err, ErrorVaultMismatch)
+	_, anotherKey, err := secure.NewVault("password")
+	require.NoError(t, err)
+	_, err = New(path, "user", vault, anotherKey).Load()
+	assert.ErrorIs(t, err, ErrorVaultMismatch)
+
+	// vault rewrapped with the new password is saved with the state
+	rewrapped, err := secure.RewrapVault("password", "new_password", vault)
+	require.NoError(t, err)
+	local := New(path, "user", vault, vaultKey)
+	local.SetVault(rewrapped)
+	err = local.Save(state)
+	require.NoError(t, err)
+	_, _, err = Open(path, "user", "new_password")
+	assert.NoError(t, err)
+
+	err = local.Remove()
+	assert.NoError(t, err)
+	err = local.Remove()
+	assert.NoError(t, err)
+	_, err = local.Load()
+	assert.ErrorIs(t, err, ErrorVaultNotFound)
+}
@@ -3,18 +3,39 @@ package service
 
 import (
 	"context"
+	"errors"
 	"github.com/rs/zerolog/log"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
 	pb "github.com/vstebletsov89/go-developer-course-gophkeeper/internal/proto"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/service/auth"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strings"
+	"sync"
 )
 
+// ErrorTwoFactorRequired defines an error for login which must be completed with second factor (VerifyTwoFactor).
+var ErrorTwoFactorRequired = errors.New("two-factor code required")
+
+// ErrorInvalidServerProof defines an error for server which cannot prove knowledge of SRP verifier.
+var ErrorInvalidServerProof = errors.New("server failed SRP verification")
+
 // AuthClient represents a structure for authorization service.
 type AuthClient struct {
-	user        models.User
-	accessToken string
-	service     pb.AuthClient
+	user         models.User
+	accessToken  string
+	refreshToken string
+	vault        *models.Vault
+	challenge    string
+	client       *pb.ClientInfo
+	service      pb.AuthClient
+	// passwordLogin enables login with password for legacy users without SRP verifier
+	passwordLogin bool
+	mu            sync.RWMutex
+	// refreshMu serializes refresh requests, concurrent use of the same refresh token revokes the session
+	refreshMu sync.Mutex
 }
 
 // NewAuthClient returns an instance of AuthClient.
@@ -27,16 +48,45 @@ func (a *AuthClient) SetService(service pb.AuthClient) {
 	a.service = service
 }
 
+// SetClientInfo sets name of the device and version of the client which are shown in the list of sessions.
+func (a *AuthClient) SetClientInfo(deviceName string, clientVersion string) {
+	a.client = &pb.ClientInfo{DeviceName: deviceName, ClientVersion: clientVersion}
+}
+
+// SetPasswordLogin enables login with password instead of SRP handshake. It is used once by legacy users
+// without SRP verifier to upgrade to SRP, the password is never sent if login with password is not enabled.
+func (a *AuthClient) SetPasswordLogin(enabled bool) {
+	a.passwordLogin = enabled
+}
+
 // AccessToken getter for accessToken.
 func (a *AuthClient) AccessToken() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
 	return a.accessToken
 }
 
 // SetAccessToken setter for accessToken.
 func (a *AuthClient) SetAccessToken(accessToken string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 	a.accessToken = accessToken
 }
 
+// RefreshToken getter for refreshToken.
+func (a *AuthClient) RefreshToken() string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.refreshToken
+}
+
+// SetRefreshToken setter for refreshToken.
+func (a *AuthClient) SetRefreshToken(refreshToken string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.refreshToken = refreshToken
+}
+
 // User getter for current user.
 func (a *AuthClient) User() models.User {
 	return a.user
@@ -47,35 +97,200 @@ func (a *AuthClient) SetUser(user models.User) {
 	a.user = user
 }
 
-// Login is a wrapper for Login request.
+// Vault getter for vault of the current user (nil for legacy users without vault).
+func (a *AuthClient) Vault() *models.Vault {
+	return a.vault
+}
+
+// loginResult is implemented by responses of SRP login and password login.
+type loginResult interface {
+	GetToken() *pb.Token
+	GetVault() *pb.Vault
+	GetTwoFactorRequired() bool
+	GetChallenge() string
+}
+
+// Login signs in the user with SRP handshake (LoginStart and LoginFinish), so the password never reaches
+// the server. Legacy user without SRP verifier signs in with password once (SetPasswordLogin) and is upgraded to SRP.
 func (a *AuthClient) Login(ctx context.Context) (string, error) {
+	if a.passwordLogin {
+		return a.legacyLogin(ctx)
+	}
+
+	proof, srpClient, err := a.srpProof(ctx, a.user.Password)
+	if err != nil {
+		return "", err
+	}
+
+	request := &pb.LoginFinishRequest{
+		Login:  a.user.Login,
+		Proof:  proof,
+		Client: a.client,
+	}
+
+	response, err := a.service.LoginFinish(ctx, request)
+	if err != nil {
+		return "", err
+	}
+	if !srpClient.VerifyServer(response.GetServerProof()) {
+		return "", ErrorInvalidServerProof
+	}
+
+	return a.completeLogin(response)
+}
+
+// legacyLogin is a wrapper for Login request with password. Verifier of the password is sent
+// to upgrade the user to SRP login.
+func (a *AuthClient) legacyLogin(ctx context.Context) (string, error) {
+	verifier, err := newSRPVerifier(a.user.Login, a.user.Password)
+	if err != nil {
+		return "", err
+	}
+
 	request := &pb.LoginRequest{
 		User: &pb.User{
 			Login:    a.user.Login,
 			Password: a.user.Password,
 		},
+		Client: a.client,
+		Srp:    verifier,
 	}
 
 	response, err := a.service.Login(ctx, request)
 	if err != nil {
 		return "", err
 	}
+	if response.GetUpgradeRequired() {
+		log.Warn().Msgf("User %s is not upgraded to SRP login, password login is still required", a.user.Login)
+	}
 
-	a.user.ID = response.GetToken().GetUserId()
-	log.Debug().Msgf("Client (Login): done %v", a.user)
+	return a.completeLogin(response)
+}
+
+// completeLogin saves session of the logged in user or challenge if second factor is required.
+func (a *AuthClient) completeLogin(response loginResult) (string, error) {
+	a.challenge = ""
+	if response.GetTwoFactorRequired() {
+		a.challenge = response.GetChallenge()
+		log.Debug().Msg("Client (Login): second factor required")
+		return "", ErrorTwoFactorRequired
+	}
+
+	a.setSession(response.GetToken(), response.GetVault())
+	log.Debug().Msgf("Client (Login): done %s", a.user.Login)
 	return response.GetToken().GetToken(), nil
 }
 
-// Register is a wrapper for Register request.
-func (a *AuthClient) Register(ctx context.Context) error {
+// srpProof starts SRP handshake (LoginStart) and returns proof of the password for the current user.
+func (a *AuthClient) srpProof(ctx context.Context, password string) (*pb.SRPProof, *auth.SRPClient, error) {
+	response, err := a.service.LoginStart(ctx, &pb.LoginStartRequest{Login: a.user.Login})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	srpClient, err := auth.NewSRPClient(a.user.Login, password)
+	if err != nil {
+		return nil, nil, err
+	}
+	proof, err := srpClient.Proof(response.GetSalt(), response.GetServerPublic())
+	if err != nil {
+		return nil, nil, err
+	}
+	return &pb.SRPProof{
+		HandshakeId:  response.GetHandshakeId(),
+		ClientPublic: srpClient.PublicKey(),
+		Proof:        proof,
+	}, srpClient, nil
+}
+
+func newSRPVerifier(login string, password string) (*pb.SRPVerifier, error) {
+	salt, verifier, err := auth.NewSRPVerifier(login, password)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.SRPVerifier{Salt: salt, Verifier: verifier}, nil
+}
+
+// VerifyTwoFactor is a wrapper for VerifyTwoFactor request. It completes login with TOTP or recovery code.
+func (a *AuthClient) VerifyTwoFactor(ctx context.Context, code string) (string, error) {
+	if a.challenge == "" {
+		return "", errors.New("login is required before two-factor verification")
+	}
+	request := &pb.VerifyTwoFactorRequest{
+		Challenge: a.challenge,
+		Code:      code,
+		Client:    a.client,
+	}
+
+	response, err := a.service.VerifyTwoFactor(ctx, request)
+	if err != nil {
+		return "", err
+	}
+
+	a.challenge = ""
+	a.setSession(response.GetToken(), response.GetVault())
+	log.Debug().Msgf("Client (VerifyTwoFactor): done %s", a.user.Login)
+	return response.GetToken().GetToken(), nil
+}
+
+// setSession saves refresh token and vault of the logged in user.
+func (a *AuthClient) setSession(token *pb.Token, vault *pb.Vault) {
+	a.user.ID = token.GetUserId()
+	a.SetRefreshToken(token.GetRefreshToken())
+	a.vault = nil
+	if vault != nil {
+		v := vaultFromProto(vault)
+		a.vault = &v
+	}
+}
+
+// EnableTwoFactor is a wrapper for EnableTwoFactor request. Secret must be confirmed with ConfirmTwoFactor.
+func (a *AuthClient) EnableTwoFactor(ctx context.Context) (*pb.EnableTwoFactorResponse, error) {
+	response, err := a.service.EnableTwoFactor(ctx, &pb.EnableTwoFactorRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug().Msg("Client (EnableTwoFactor): done")
+	return response, nil
+}
+
+// ConfirmTwoFactor is a wrapper for ConfirmTwoFactor request.
+func (a *AuthClient) ConfirmTwoFactor(ctx context.Context, code string) error {
+	_, err := a.service.ConfirmTwoFactor(ctx, &pb.ConfirmTwoFactorRequest{Code: code})
+	if err != nil {
+		return err
+	}
+
+	log.Debug().Msg("Client (ConfirmTwoFactor): done")
+	return nil
+}
+
+// DisableTwoFactor is a wrapper for DisableTwoFactor request.
+func (a *AuthClient) DisableTwoFactor(ctx context.Context, code string) error {
+	_, err := a.service.DisableTwoFactor(ctx, &pb.DisableTwoFactorRequest{Code: code})
+	if err != nil {
+		return err
+	}
+
+	log.Debug().Msg("Client (DisableTwoFactor): done")
+	return nil
+}
+
+// Register is a wrapper for Register request. Only SRP verifier of the password is sent to the server.
+func (a *AuthClient) Register(ctx context.Context, vault models.Vault) error {
+	verifier, err := newSRPVerifier(a.user.Login, a.user.Password)
+	if err != nil {
+		return err
+	}
+
 	request := &pb.RegisterRequest{
-		User: &pb.User{
-			Login:    a.user.Login,
-			Password: a.user.Password,
-		},
+		User:  &pb.User{Login: a.user.Login},
+		Vault: vaultToProto(vault),
+		Srp:   verifier,
 	}
 
-	_, err := a.service.Register(ctx, request)
+	_, err = a.service.Register(ctx, request)
 	if err != nil {
 		return err
 	}
@@ -84,9 +299,282 @@ func (a *AuthClient) Register(ctx context.Context) error {
 	return nil
 }
 
+// SetVault is a wrapper for SetVault request. It is used to create vault for legacy users.
+func (a *AuthClient) SetVault(ctx context.Context, vault models.Vault) error {
+	request := &pb.SetVaultRequest{Vault: vaultToProto(vault)}
+
+	_, err := a.service.SetVault(ctx, request)
+	if err != nil {
+		return err
+	}
+
+	a.vault = &vault
+	log.Debug().Msg("Client (SetVault): done")
+	return nil
+}
+
+// Refresh is a wrapper for Refresh request. Access and refresh tokens are replaced with new ones.
+func (a *AuthClient) Refresh(ctx context.Context) error {
+	request := &pb.RefreshRequest{RefreshToken: a.RefreshToken()}
+
+	response, err := a.service.Refresh(ctx, request)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	a.accessToken = response.GetToken().GetToken()
+	a.refreshToken = response.GetToken().GetRefreshToken()
+	a.mu.Unlock()
+
+	log.Debug().Msg("Client (Refresh): done")
+	return nil
+}
+
+// Logout is a wrapper for Logout request. Tokens are removed from the client in any case.
+func (a *AuthClient) Logout(ctx context.Context, allDevices bool) error {
+	request := &pb.LogoutRequest{
+		RefreshToken: a.RefreshToken(),
+		AllDevices:   allDevices,
+	}
+
+	// refresh token is removed first, so rejected request is not retried
+	a.SetRefreshToken("")
+	_, err := a.service.Logout(ctx, request)
+	a.SetAccessToken("")
+	a.vault = nil
+	if err != nil {
+		return err
+	}
+
+	log.Debug().Msg("Client (Logout): done")
+	return nil
+}
+
+// ChangePassword is a wrapper for ChangePassword request. Old password is proved with SRP handshake
+// (password is sent only if login with password is enabled), only SRP verifier of the new password is sent. Vault wrapped with the new password
+// is optional for legacy users without vault. Returns new access token.
+func (a *AuthClient) ChangePassword(ctx context.Context, oldPassword string, newPassword string, vault *models.Vault) (string, error) {
+	request := &pb.ChangePasswordRequest{}
+	var err error
+	if a.passwordLogin {
+		request.OldPassword = oldPassword
+	} else if request.Proof, _, err = a.srpProof(ctx, oldPassword); err != nil {
+		return "", err
+	}
+	request.Srp, err = newSRPVerifier(a.user.Login, newPassword)
+	if err != nil {
+		return "", err
+	}
+	if vault != nil {
+		request.Vault = vaultToProto(*vault)
+	}
+
+	response, err := a.service.ChangePassword(ctx, request)
+	if err != nil {
+		return "", err
+	}
+
+	a.user.Password = newPassword
+	a.SetRefreshToken(response.GetToken().GetRefreshToken())
+	if vault != nil {
+		a.vault = vault
+	}
+	log.Debug().Msg("Client (ChangePassword): done")
+	return response.GetToken().GetToken(), nil
+}
+
+// DeleteAccount is a wrapper for DeleteAccount request. Password is proved with SRP handshake
+// (password is sent only if login with password is enabled). Tokens are removed from the client on success.
+func (a *AuthClient) DeleteAccount(ctx context.Context, password string, code string) error {
+	request := &pb.DeleteAccountRequest{Code: code}
+	var err error
+	if a.passwordLogin {
+		request.Password = password
+	} else if request.Proof, _, err = a.srpProof(ctx, password); err != nil {
+		return err
+	}
+
+	_, err = a.service.DeleteAccount(ctx, request)
+	if err != nil {
+		return err
+	}
+
+	a.SetRefreshToken("")
+	a.SetAccessToken("")
+	a.vault = nil
+	a.user = models.User{}
+	log.Debug().Msg("Client (DeleteAccount): done")
+	return nil
+}
+
+// RegisterDevice is a wrapper for RegisterDevice request. Returns ID of the device, PEM encoded
+// client certificate and certificate of the CA.
+func (a *AuthClient) RegisterDevice(ctx context.Context, name string, csr []byte) (*pb.RegisterDeviceResponse, error) {
+	request := &pb.RegisterDeviceRequest{
+		Name: name,
+		Csr:  csr,
+	}
+
+	response, err := a.service.RegisterDevice(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug().Msg("Client (RegisterDevice): done")
+	return response, nil
+}
+
+// RevokeDevice is a wrapper for RevokeDevice request.
+func (a *AuthClient) RevokeDevice(ctx context.Context, deviceID string) error {
+	_, err := a.service.RevokeDevice(ctx, &pb.RevokeDeviceRequest{DeviceId: deviceID})
+	if err != nil {
+		return err
+	}
+
+	log.Debug().Msg("Client (RevokeDevice): done")
+	return nil
+}
+
+// ListSessions is a wrapper for ListSessions request.
+func (a *AuthClient) ListSessions(ctx context.Context) ([]*pb.Session, error) {
+	response, err := a.service.ListSessions(ctx, &pb.ListSessionsRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug().Msg("Client (ListSessions): done")
+	return response.GetSessions(), nil
+}
+
+// RevokeSession is a wrapper for RevokeSession request.
+func (a *AuthClient) RevokeSession(ctx context.Context, sessionID string) error {
+	_, err := a.service.RevokeSession(ctx, &pb.RevokeSessionRequest{SessionId: sessionID})
+	if err != nil {
+		return err
+	}
+
+	log.Debug().Msg("Client (RevokeSession): done")
+	return nil
+}
+
+// GetVault is a wrapper for GetVault request. Vault of personal access token contains only wrapped key.
+func (a *AuthClient) GetVault(ctx context.Context) (*pb.Vault, error) {
+	response, err := a.service.GetVault(ctx, &pb.GetVaultRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug().Msg("Client (GetVault): done")
+	return response.GetVault(), nil
+}
+
+// CreateAccessToken is a wrapper for CreateAccessToken request. Token is returned by the server once.
+func (a *AuthClient) CreateAccessToken(ctx context.Context, request *pb.CreateAccessTokenRequest) (*pb.CreateAccessTokenResponse, error) {
+	response, err := a.service.CreateAccessToken(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug().Msg("Client (CreateAccessToken): done")
+	return response, nil
+}
+
+// ListAccessTokens is a wrapper for ListAccessTokens request.
+func (a *AuthClient) ListAccessTokens(ctx context.Context) ([]*pb.AccessToken, error) {
+	response, err := a.service.ListAccessTokens(ctx, &pb.ListAccessTokensRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug().Msg("Client (ListAccessTokens): done")
+	return response.GetAccessTokens(), nil
+}
+
+// RevokeAccessToken is a wrapper for RevokeAccessToken request.
+func (a *AuthClient) RevokeAccessToken(ctx context.Context, tokenID string) error {
+	_, err := a.service.RevokeAccessToken(ctx, &pb.RevokeAccessTokenRequest{Id: tokenID})
+	if err != nil {
+		return err
+	}
+
+	log.Debug().Msg("Client (RevokeAccessToken): done")
+	return nil
+}
+
+// RefreshExpired refreshes tokens once for all requests which failed with the same expired access token.
+func (a *AuthClient) RefreshExpired(ctx context.Context, expiredToken string) error {
+	a.refreshMu.Lock()
+	defer a.refreshMu.Unlock()
+
+	if a.AccessToken() != expiredToken {
+		// tokens were already refreshed by another request
+		return nil
+	}
+	return a.Refresh(ctx)
+}
+
 // UnaryInterceptorClient is a client interceptor for attaching access token and current userID.
+// Request is retried once with new access token if the server rejects expired one.
 func (a *AuthClient) UnaryInterceptorClient(ctx context.Context, method string, req interface{}, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
-	newCtx := metadata.AppendToOutgoingContext(ctx, "authorization", "bearer "+a.AccessToken())
-	log.Debug().Msgf("UnaryInterceptorClient (attaching bearer with jwt token): %v", a.AccessToken())
+	accessToken := a.AccessToken()
+	newCtx := ctx
+	if accessToken != "" {
+		// device without access token is authenticated with client certificate
+		newCtx = metadata.AppendToOutgoingContext(ctx, "authorization", "bearer "+accessToken)
+		log.Debug().Msgf("UnaryInterceptorClient (attaching bearer with jwt token): %v", accessToken)
+	}
+
+	err := invoker(newCtx, method, req, reply, cc, opts...)
+	if status.Code(err) != codes.Unauthenticated || a.RefreshToken() == "" || isAuthMethod(method) {
+		return err
+	}
+
+	log.Debug().Msg("UnaryInterceptorClient: access token rejected, refreshing")
+	if refreshErr := a.RefreshExpired(ctx, accessToken); refreshErr != nil {
+		log.Error().Msgf("Failed to refresh access token: %v", refreshErr)
+		return err
+	}
+
+	newCtx = metadata.AppendToOutgoingContext(ctx, "authorization", "bearer "+a.AccessToken())
 	return invoker(newCtx, method, req, reply, cc, opts...)
 }
+
+// StreamInterceptorClient is a client interceptor for attaching access token to streaming requests.
+// Stream rejected with expired access token is reopened by the caller after RefreshExpired.
+func (a *AuthClient) StreamInterceptorClient(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	newCtx := ctx
+	if accessToken := a.AccessToken(); accessToken != "" {
+		// device without access token is authenticated with client certificate
+		newCtx = metadata.AppendToOutgoingContext(ctx, "authorization", "bearer "+accessToken)
+		log.Debug().Msgf("StreamInterceptorClient (attaching bearer with jwt token): %v", accessToken)
+	}
+	return streamer(newCtx, desc, cc, method, opts...)
+}
+
+// isAuthMethod checks that method does not require access token (no refresh and retry).
+func isAuthMethod(method string) bool {
+	return strings.HasSuffix(method, "/Register") || strings.HasSuffix(method, "/Login") ||
+		strings.HasSuffix(method, "/LoginStart") || strings.HasSuffix(method, "/LoginFinish") ||
+		strings.HasSuffix(method, "/Refresh") || strings.HasSuffix(method, "/VerifyTwoFactor")
+}
+
+func vaultToProto(vault models.Vault) *pb.Vault {
+	return &pb.Vault{
+		Salt:       vault.Salt,
+		KdfTime:    vault.KdfTime,
+		KdfMemory:  vault.KdfMemory,
+		KdfThreads: vault.KdfThreads,
+		WrappedKey: vault.WrappedKey,
+	}
+}
+
+func vaultFromProto(vault *pb.Vault) models.Vault {
+	return models.Vault{
+		Salt:       vault.GetSalt(),
+		KdfTime:    vault.GetKdfTime(),
+		KdfMemory:  vault.GetKdfMemory(),
+		KdfThreads: vault.GetKdfThreads(),
+		WrappedKey: vault.GetWrappedKey(),
+	}
+}
@@ -2,14 +2,24 @@ package service
 
 import (
 	"context"
+	"errors"
+	"strconv"
+	"time"
+
 	"github.com/rs/zerolog/log"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
 	pb "github.com/vstebletsov89/go-developer-course-gophkeeper/internal/proto"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/secure"
 )
 
+// ErrorVaultKeyNotSet defines an error for client encrypted data without unlocked vault.
+var ErrorVaultKeyNotSet = errors.New("vault is locked, login is required")
+
 // SecretClient represents a structure for core gophkeeper service.
+// Private data is encrypted on the client side with the vault key, so the server stores only opaque blobs.
 type SecretClient struct {
-	service pb.GophkeeperClient
+	vaultKey []byte
+	service  pb.GophkeeperClient
 }
 
 // NewSecretClient returns an instance of SecretClient.
@@ -22,17 +32,42 @@ func (c *SecretClient) SetService(service pb.GophkeeperClient) {
 	c.service = service
 }
 
-// AddData is a wrapper for AddData request.
+// SetVaultKey sets key for client side encryption (unlocked vault).
+func (c *SecretClient) SetVaultKey(vaultKey []byte) {
+	c.vaultKey = vaultKey
+}
+
+// VaultKey returns key for client side encryption (nil if vault is locked).
+func (c *SecretClient) VaultKey() []byte {
+	return c.vaultKey
+}
+
+// AddData is a wrapper for AddData request. Data is encrypted with the vault key.
 func (c *SecretClient) AddData(ctx context.Context, data models.Data) error {
+	if c.vaultKey == nil {
+		return ErrorVaultKeyNotSet
+	}
+
+	encrypted, err := secure.EncryptWithKey(c.vaultKey, data.DataBinary, recordAdditionalData(data.ID, data.DataType))
+	if err != nil {
+		return err
+	}
+	metadata, err := c.encryptMetadata(data)
+	if err != nil {
+		return err
+	}
+
 	request := &pb.AddDataRequest{
 		Data: &pb.Data{
-			DataId:     data.ID,
-			DataType:   pb.DataType(data.DataType),
-			DataBinary: data.DataBinary,
+			DataId:          data.ID,
+			DataType:        pb.DataType(data.DataType),
+			DataBinary:      encrypted,
+			ClientEncrypted: true,
+			MetadataBinary:  metadata,
 		},
 	}
 
-	_, err := c.service.AddData(ctx, request)
+	_, err = c.service.AddData(ctx, request)
 	if err != nil {
 		return err
 	}
@@ -41,7 +76,8 @@ func (c *SecretClient) AddData(ctx context.Context, data models.Data) error {
 	return nil
 }
 
-// GetData is a wrapper for GetData request.
+// GetData is a wrapper for GetData request. Client encrypted data is decrypted with the vault key,
+// legacy data is decrypted by the server.
 func (c *SecretClient) GetData(ctx context.Context) ([]models.Data, error) {
 	request := &pb.GetDataRequest{}
 
@@ -50,24 +86,152 @@ func (c *SecretClient) GetData(ctx context.Context) ([]models.Data, error) {
 		return nil, err
 	}
 
-	data := response.GetData()
+	convertedData, err := c.decryptData(response.GetData())
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug().Msg("Client (GetData): done")
+	return convertedData, nil
+}
+
+// Sync is a wrapper for Sync request. Returns private data changed after the cursor and tombstones
+// of deleted data, cursor of the response is used for the next sync.
+func (c *SecretClient) Sync(ctx context.Context, cursor int64) (models.DataChanges, error) {
+	request := &pb.SyncRequest{Cursor: cursor}
+
+	response, err := c.service.Sync(ctx, request)
+	if err != nil {
+		return models.DataChanges{}, err
+	}
+
+	data, err := c.decryptData(response.GetData())
+	if err != nil {
+		return models.DataChanges{}, err
+	}
+
+	changes := models.DataChanges{
+		Data:   data,
+		Cursor: response.GetCursor(),
+		Full:   response.GetFull(),
+	}
+	for _, tombstone := range response.GetTombstones() {
+		changes.Tombstones = append(changes.Tombstones, models.Tombstone{
+			DataID:    tombstone.GetDataId(),
+			DataType:  models.DataType(tombstone.GetDataType()),
+			DeletedAt: time.Unix(tombstone.GetDeletedAt(), 0),
+		})
+	}
+
+	log.Debug().Msgf("Client (Sync): done (cursor %d)", changes.Cursor)
+	return changes, nil
+}
+
+// Watch is a wrapper for Watch request. Notifications about changed private data are passed to the handler
+// until the stream is closed, error of the stream is returned.
+func (c *SecretClient) Watch(ctx context.Context, cursor int64, handler func(models.ChangeEvent)) error {
+	request := &pb.WatchRequest{Cursor: cursor}
+
+	stream, err := c.service.Watch(ctx, request)
+	if err != nil {
+		return err
+	}
+
+	log.Debug().Msgf("Client (Watch): started (cursor %d)", cursor)
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		handler(models.ChangeEvent{
+			DataID:   event.GetDataId(),
+			DataType: models.DataType(event.GetDataType()),
+			Cursor:   event.GetCursor(),
+			Deleted:  event.GetDeleted(),
+		})
+	}
+}
+
+// decryptData converts private data from the server. Client encrypted data is decrypted with the vault key,
+// legacy data is decrypted by the server.
+func (c *SecretClient) decryptData(data []*pb.Data) ([]models.Data, error) {
 	var convertedData []models.Data
 	for _, secret := range data {
-		convertedData = append(convertedData, models.Data{
-			ID:         secret.GetDataId(),
-			UserID:     "",
-			DataType:   models.DataType(secret.GetDataType()),
-			DataBinary: secret.GetDataBinary(),
-		})
+		binary := secret.GetDataBinary()
+		if secret.GetClientEncrypted() {
+			if c.vaultKey == nil {
+				return nil, ErrorVaultKeyNotSet
+			}
+			var err error
+			binary, err = secure.DecryptWithKey(c.vaultKey, binary,
+				recordAdditionalData(secret.GetDataId(), models.DataType(secret.GetDataType())))
+			if err != nil {
+				return nil, err
+			}
+		}
+		metadata, err := c.decryptMetadata(secret)
+		if err != nil {
+			return nil, err
+		}
+
+		converted := models.Data{
+			ID:              secret.GetDataId(),
+			UserID:          "",
+			DataType:        models.DataType(secret.GetDataType()),
+			DataBinary:      binary,
+			ClientEncrypted: secret.GetClientEncrypted(),
+			Revision:        secret.GetRevision(),
+			UpdatedAt:       time.Unix(secret.GetUpdatedAt(), 0),
+			Metadata:        metadata,
+		}
+		if secret.GetDeletedAt() != 0 {
+			converted.DeletedAt = time.Unix(secret.GetDeletedAt(), 0)
+		}
+		convertedData = append(convertedData, converted)
 	}
+	return convertedData, nil
+}
 
-	log.Debug().Msg("Client (GetData): done")
-	return convertedData, err
+// UpdateData is a wrapper for UpdateData request. Data is encrypted with the vault key, revision of the data
+// is the expected revision on the server. Returns new revision.
+func (c *SecretClient) UpdateData(ctx context.Context, data models.Data) (int64, error) {
+	if c.vaultKey == nil {
+		return 0, ErrorVaultKeyNotSet
+	}
+
+	encrypted, err := secure.EncryptWithKey(c.vaultKey, data.DataBinary, recordAdditionalData(data.ID, data.DataType))
+	if err != nil {
+		return 0, err
+	}
+	metadata, err := c.encryptMetadata(data)
+	if err != nil {
+		return 0, err
+	}
+
+	request := &pb.UpdateDataRequest{
+		Data: &pb.Data{
+			DataId:          data.ID,
+			DataType:        pb.DataType(data.DataType),
+			DataBinary:      encrypted,
+			ClientEncrypted: true,
+			MetadataBinary:  metadata,
+		},
+		Revision: data.Revision,
+	}
+
+	response, err := c.service.UpdateData(ctx, request)
+	if err != nil {
+		return 0, err
+	}
+
+	log.Debug().Msg("Client (UpdateData): done")
+	return response.GetRevision(), nil
 }
 
-// DeleteData is a wrapper for DeleteData request.
-func (c *SecretClient) DeleteData(ctx context.Context, dataID string) error {
-	request := &pb.DeleteDataRequest{DataId: dataID}
+// DeleteData is a wrapper for DeleteData request. Data is deleted only with the expected revision
+// on the server, zero revision deletes data of any revision.
+func (c *SecretClient) DeleteData(ctx context.Context, dataID string, revision int64) error {
+	request := &pb.DeleteDataRequest{DataId: dataID, Revision: revision}
 
 	_, err := c.service.DeleteData(ctx, request)
 	if err != nil {
@@ -77,3 +241,125 @@ func (c *SecretClient) DeleteData(ctx context.Context, dataID string) error {
 	log.Debug().Msg("Client (DeleteData): done")
 	return nil
 }
+
+// ListRevisions is a wrapper for ListRevisions request. Returns previous revisions of the record,
+// the latest revision first.
+func (c *SecretClient) ListRevisions(ctx context.Context, dataID string) ([]models.Data, error) {
+	request := &pb.ListRevisionsRequest{DataId: dataID}
+
+	response, err := c.service.ListRevisions(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions, err := c.decryptData(response.GetRevisions())
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug().Msg("Client (ListRevisions): done")
+	return revisions, nil
+}
+
+// RestoreRevision is a wrapper for RestoreRevision request. Returns restored record with new revision.
+func (c *SecretClient) RestoreRevision(ctx context.Context, dataID string, revision int64) (models.Data, error) {
+	request := &pb.RestoreRevisionRequest{DataId: dataID, Revision: revision}
+
+	response, err := c.service.RestoreRevision(ctx, request)
+	if err != nil {
+		return models.Data{}, err
+	}
+
+	restored, err := c.decryptData([]*pb.Data{response.GetData()})
+	if err != nil {
+		return models.Data{}, err
+	}
+
+	log.Debug().Msg("Client (RestoreRevision): done")
+	return restored[0], nil
+}
+
+// ListTrash is a wrapper for ListTrash request. Returns deleted records, the latest deleted record first.
+func (c *SecretClient) ListTrash(ctx context.Context) ([]models.Data, error) {
+	response, err := c.service.ListTrash(ctx, &pb.ListTrashRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	trash, err := c.decryptData(response.GetData())
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug().Msg("Client (ListTrash): done")
+	return trash, nil
+}
+
+// RestoreData is a wrapper for RestoreData request. Returns record moved from trash back.
+func (c *SecretClient) RestoreData(ctx context.Context, dataID string) (models.Data, error) {
+	request := &pb.RestoreDataRequest{DataId: dataID}
+
+	response, err := c.service.RestoreData(ctx, request)
+	if err != nil {
+		return models.Data{}, err
+	}
+
+	restored, err := c.decryptData([]*pb.Data{response.GetData()})
+	if err != nil {
+		return models.Data{}, err
+	}
+
+	log.Debug().Msg("Client (RestoreData): done")
+	return restored[0], nil
+}
+
+// PurgeData is a wrapper for PurgeData request. Record is deleted from trash permanently.
+func (c *SecretClient) PurgeData(ctx context.Context, dataID string) error {
+	request := &pb.PurgeDataRequest{DataId: dataID}
+
+	_, err := c.service.PurgeData(ctx, request)
+	if err != nil {
+		return err
+	}
+
+	log.Debug().Msg("Client (PurgeData): done")
+	return nil
+}
+
+// encryptMetadata returns metadata of the record encrypted with the vault key, empty metadata is not encrypted.
+func (c *SecretClient) encryptMetadata(data models.Data) ([]byte, error) {
+	metadata, err := data.Metadata.GetJSON()
+	if err != nil || len(metadata) == 0 {
+		return nil, err
+	}
+	return secure.EncryptWithKey(c.vaultKey, metadata, metadataAdditionalData(data.ID, data.DataType))
+}
+
+// decryptMetadata returns metadata of the record from the server. Metadata of legacy data is decrypted by the server.
+func (c *SecretClient) decryptMetadata(secret *pb.Data) (models.Metadata, error) {
+	if !secret.GetClientEncrypted() {
+		return secure.MetadataFromProto(secret.GetMetadata()), nil
+	}
+	if len(secret.GetMetadataBinary()) == 0 {
+		return models.Metadata{}, nil
+	}
+
+	metadata, err := secure.DecryptWithKey(c.vaultKey, secret.GetMetadataBinary(),
+		metadataAdditionalData(secret.GetDataId(), models.DataType(secret.GetDataType())))
+	if err != nil {
+		return models.Metadata{}, err
+	}
+	return models.ParseMetadata(metadata)
+}
+
+// recordAdditionalData binds client encrypted data to ID and type of the record, so the server cannot
+// swap or replay data between records.
+func recordAdditionalData(dataID string, dataType models.DataType) []byte {
+	return []byte("gophkeeper data " + dataID + "|" + strconv.Itoa(int(dataType)))
+}
+
+// metadataAdditionalData binds client encrypted metadata to ID and type of the record, so it cannot be swapped
+// with private data or moved to another record.
+func metadataAdditionalData(dataID string, dataType models.DataType) []byte {
+	return []byte("gophkeeper metadata " + dataID + "|" + strconv.Itoa(int(dataType)))
+}
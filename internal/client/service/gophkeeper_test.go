@@ -0,0 +1,94 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+	pb "github.com/vstebletsov89/go-developer-course-gophkeeper/internal/proto"
+	"google.golang.org/grpc"
+)
+
+// fakeGophkeeper keeps private data sent by the client as the server stores it.
+type fakeGophkeeper struct {
+	pb.GophkeeperClient
+	added []*pb.Data
+}
+
+func (f *fakeGophkeeper) AddData(_ context.Context, in *pb.AddDataRequest, _ ...grpc.CallOption) (*pb.AddDataResponse, error) {
+	f.added = append(f.added, in.GetData())
+	return &pb.AddDataResponse{}, nil
+}
+
+func newTestSecretClient(fake *fakeGophkeeper) *SecretClient {
+	client := NewSecretClient()
+	client.SetService(fake)
+	client.SetVaultKey(bytes.Repeat([]byte{1}, 32))
+	return client
+}
+
+func TestSecretClient_decryptData(t *testing.T) {
+	fake := &fakeGophkeeper{}
+	client := newTestSecretClient(fake)
+
+	for _, id := range []string{"first", "second"} {
+		err := client.AddData(context.Background(), models.Data{ID: id, DataType: models.TextType, DataBinary: []byte(id)})
+		require.NoError(t, err)
+	}
+	require.Len(t, fake.added, 2)
+
+	decrypted, err := client.decryptData(fake.added)
+	require.NoError(t, err)
+	if assert.Len(t, decrypted, 2) {
+		assert.Equal(t, []byte("first"), decrypted[0].DataBinary)
+		assert.Equal(t, []byte("second"), decrypted[1].DataBinary)
+	}
+
+	// data moved by the server to another record of the same type is rejected
+	swapped := &pb.Data{
+		DataId:          fake.added[1].GetDataId(),
+		DataType:        fake.added[0].GetDataType(),
+		DataBinary:      fake.added[0].GetDataBinary(),
+		ClientEncrypted: true,
+	}
+	_, err = client.decryptData([]*pb.Data{swapped})
+	assert.Error(t, err)
+
+	// data of another type is rejected
+	retyped := &pb.Data{
+		DataId:          fake.added[0].GetDataId(),
+		DataType:        pb.DataType_CARD_TYPE,
+		DataBinary:      fake.added[0].GetDataBinary(),
+		ClientEncrypted: true,
+	}
+	_, err = client.decryptData([]*pb.Data{retyped})
+	assert.Error(t, err)
+
+	// metadata moved by the server to another record of the same type is rejected
+	err = client.AddData(context.Background(), models.Data{ID: "third", DataType: models.TextType,
+		DataBinary: []byte("third"), Metadata: models.Metadata{Title: "title", Tags: []string{"tag"}}})
+	require.NoError(t, err)
+	require.Len(t, fake.added, 3)
+	decrypted, err = client.decryptData(fake.added[2:])
+	require.NoError(t, err)
+	if assert.Len(t, decrypted, 1) {
+		assert.Equal(t, models.Metadata{Title: "title", Tags: []string{"tag"}}, decrypted[0].Metadata)
+	}
+	moved := &pb.Data{
+		DataId:          fake.added[0].GetDataId(),
+		DataType:        fake.added[0].GetDataType(),
+		DataBinary:      fake.added[0].GetDataBinary(),
+		MetadataBinary:  fake.added[2].GetMetadataBinary(),
+		ClientEncrypted: true,
+	}
+	_, err = client.decryptData([]*pb.Data{moved})
+	assert.Error(t, err)
+
+	// vault must be unlocked
+	client.SetVaultKey(nil)
+	_, err = client.decryptData(fake.added)
+	assert.ErrorIs(t, err, ErrorVaultKeyNotSet)
+}
@@ -2,25 +2,81 @@
 package config
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
 	"github.com/rs/zerolog"
 	"sync"
+	"time"
 
 	"github.com/caarlos0/env/v6"
 )
 
 // Config contains global settings of service.
 type Config struct {
-	ServerAddress   string `env:"SERVER_ADDRESS" envDefault:"localhost:8080" json:"serverAddress"`
-	DatabaseDsn     string `env:"DATABASE_DSN" envDefault:"user=postgres dbname=postgres password=postgres host=localhost sslmode=disable" json:"databaseDsn"` //nolint:lll
-	JwtSecretKey    string `env:"JWT_SECRET" envDefault:"secret_key" json:"jwtSecretKey"`
-	EnableTLS       bool   `env:"ENABLE_TLS" envDefault:"false" json:"enableTLS"`
-	EnableMigration bool   `env:"ENABLE_MIGRATION" envDefault:"false" json:"enableMigration"`
-	LogLevel        string `env:"LOG_LEVEL" envDefault:"debug"`
+	ServerAddress   string        `env:"SERVER_ADDRESS" envDefault:"localhost:8080" json:"serverAddress"`
+	DatabaseDsn     string        `env:"DATABASE_DSN" envDefault:"user=postgres dbname=postgres password=postgres host=localhost sslmode=disable" json:"databaseDsn"` //nolint:lll
+	JwtSecretKey    string        `env:"JWT_SECRET" envDefault:"secret_key" json:"jwtSecretKey"`
+	EnableTLS       bool          `env:"ENABLE_TLS" envDefault:"false" json:"enableTLS"`
+	EnableMigration bool          `env:"ENABLE_MIGRATION" envDefault:"false" json:"enableMigration"`
+	LogLevel        string        `env:"LOG_LEVEL" envDefault:"debug"`
+	MasterKey       string        `env:"MASTER_KEY" json:"masterKey"`
+	MasterKeyFile   string        `env:"MASTER_KEY_FILE" json:"masterKeyFile"`
+	RetiredKeys     string        `env:"RETIRED_MASTER_KEYS" json:"retiredKeys"`
+	RetiredKeyFiles string        `env:"RETIRED_MASTER_KEY_FILES" json:"retiredKeyFiles"`
+	RotationBatch   int           `env:"KEY_ROTATION_BATCH_SIZE" envDefault:"100" json:"rotationBatch"`
+	AccessTokenTTL  time.Duration `env:"ACCESS_TOKEN_TTL" envDefault:"15m" json:"accessTokenTTL"`
+	RefreshTokenTTL time.Duration `env:"REFRESH_TOKEN_TTL" envDefault:"720h" json:"refreshTokenTTL"`
+	LoginAttempts   int           `env:"LOGIN_ATTEMPTS" envDefault:"5" json:"loginAttempts"`
+	LoginLockout    time.Duration `env:"LOGIN_LOCKOUT" envDefault:"15m" json:"loginLockout"`
+	JwtKeyFile      string        `env:"JWT_SIGNING_KEY_FILE" json:"jwtKeyFile"`
+	JwtPublicKeys   string        `env:"JWT_VERIFICATION_KEY_FILES" json:"jwtPublicKeys"`
+	DevMode         bool          `env:"DEV_MODE" envDefault:"false" json:"devMode"`
+	TLSCertFile     string        `env:"TLS_CERT_FILE" envDefault:"cert.pem" json:"tlsCertFile"`
+	TLSKeyFile      string        `env:"TLS_KEY_FILE" envDefault:"key.pem" json:"tlsKeyFile"`
+	TLSCAFile       string        `env:"TLS_CA_FILE" json:"tlsCAFile"`
+	TLSCAKeyFile    string        `env:"TLS_CA_KEY_FILE" json:"tlsCAKeyFile"`
+	DeviceCertTTL   time.Duration `env:"DEVICE_CERT_TTL" envDefault:"2160h" json:"deviceCertTTL"`
+	DeviceCertFile  string        `env:"DEVICE_CERT_FILE" json:"deviceCertFile"`
+	DeviceKeyFile   string        `env:"DEVICE_KEY_FILE" json:"deviceKeyFile"`
+	PersonalToken   string        `env:"PERSONAL_ACCESS_TOKEN" json:"personalToken"`
+	LegacyLogin     bool          `env:"LEGACY_LOGIN" envDefault:"true" json:"legacyLogin"`
+	LocalVaultFile  string        `env:"LOCAL_VAULT_FILE" envDefault:"gophkeeper.vault" json:"localVaultFile"`
+	HistoryLimit    int           `env:"HISTORY_REVISIONS" envDefault:"10" json:"historyLimit"`
+	TrashRetention  time.Duration `env:"TRASH_RETENTION" envDefault:"720h" json:"trashRetention"`
+	StreamRecheck   time.Duration `env:"STREAM_RECHECK_INTERVAL" envDefault:"1m" json:"streamRecheck"`
 }
 
+// DefaultJwtSecretKey defines default shared secret for jwt tokens. It is allowed only in development mode.
+const DefaultJwtSecretKey = "secret_key"
+
+// redacted replaces secret settings in the log output.
+const redacted = "[redacted]"
+
 var once sync.Once //nolint:gochecknoglobals
 
+// redact returns a copy of settings without secrets (keys and credentials), so settings can be logged.
+func (c Config) redact() Config {
+	for _, secret := range []*string{&c.DatabaseDsn, &c.JwtSecretKey, &c.MasterKey, &c.RetiredKeys, &c.PersonalToken} {
+		if *secret != "" {
+			*secret = redacted
+		}
+	}
+	return c
+}
+
+// String returns settings without secrets.
+func (c Config) String() string {
+	type settings Config
+	return fmt.Sprintf("%+v", settings(c.redact()))
+}
+
+// MarshalJSON returns settings without secrets in JSON format.
+func (c Config) MarshalJSON() ([]byte, error) {
+	type settings Config
+	return json.Marshal(settings(c.redact()))
+}
+
 func (c *Config) readCommandLineArgs() {
 	once.Do(func() {
 		flag.StringVar(&c.ServerAddress, "a", c.ServerAddress, "server and port to listen on")
@@ -29,6 +85,14 @@ func (c *Config) readCommandLineArgs() {
 		flag.StringVar(&c.JwtSecretKey, "j", c.JwtSecretKey, "jwt secret key")
 		flag.BoolVar(&c.EnableTLS, "s", c.EnableTLS, "enable secure mode")
 		flag.BoolVar(&c.EnableMigration, "m", c.EnableMigration, "enable database migration")
+		flag.StringVar(&c.MasterKeyFile, "k", c.MasterKeyFile, "master key file")
+		flag.StringVar(&c.RetiredKeyFiles, "r", c.RetiredKeyFiles, "retired master key files (comma separated)")
+		flag.IntVar(&c.RotationBatch, "b", c.RotationBatch, "key rotation batch size")
+		flag.StringVar(&c.JwtKeyFile, "jk", c.JwtKeyFile, "jwt signing key file (Ed25519 or RSA private key)")
+		flag.BoolVar(&c.DevMode, "dev", c.DevMode, "enable development mode")
+		flag.StringVar(&c.TLSCertFile, "tc", c.TLSCertFile, "tls certificate file")
+		flag.StringVar(&c.TLSKeyFile, "tk", c.TLSKeyFile, "tls private key file")
+		flag.StringVar(&c.TLSCAFile, "ca", c.TLSCAFile, "device CA certificate file (enables client certificates)")
 		flag.Parse()
 	})
 }
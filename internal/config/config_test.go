@@ -1,10 +1,13 @@
 package config
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestReadConfig(t *testing.T) {
@@ -22,6 +25,31 @@ func TestReadConfig(t *testing.T) {
 				LogLevel:        "debug",
 				EnableTLS:       false,
 				EnableMigration: false,
+				MasterKey:       "",
+				MasterKeyFile:   "",
+				RetiredKeys:     "",
+				RetiredKeyFiles: "",
+				RotationBatch:   100,
+				AccessTokenTTL:  15 * time.Minute,
+				RefreshTokenTTL: 720 * time.Hour,
+				LoginAttempts:   5,
+				LoginLockout:    15 * time.Minute,
+				JwtKeyFile:      "",
+				JwtPublicKeys:   "",
+				DevMode:         false,
+				TLSCertFile:     "cert.pem",
+				TLSKeyFile:      "key.pem",
+				TLSCAFile:       "",
+				TLSCAKeyFile:    "",
+				DeviceCertTTL:   2160 * time.Hour,
+				DeviceCertFile:  "",
+				DeviceKeyFile:   "",
+				PersonalToken:   "",
+				LegacyLogin:     true,
+				LocalVaultFile:  "gophkeeper.vault",
+				HistoryLimit:    10,
+				TrashRetention:  720 * time.Hour,
+				StreamRecheck:   time.Minute,
 			},
 		},
 	}
@@ -39,6 +67,29 @@ func TestReadConfig(t *testing.T) {
 	}
 }
 
+func TestConfig_String(t *testing.T) {
+	cfg := Config{
+		ServerAddress: "localhost:8080",
+		DatabaseDsn:   "user=postgres password=db_password",
+		JwtSecretKey:  "jwt_secret",
+		MasterKey:     "master_key",
+		RetiredKeys:   "retired_key",
+		PersonalToken: "personal_token",
+	}
+	secrets := []string{"db_password", "jwt_secret", "master_key", "retired_key", "personal_token"}
+
+	str := cfg.String()
+	binary, err := json.Marshal(cfg)
+	assert.NoError(t, err)
+	for _, output := range []string{str, string(binary), fmt.Sprintf("%+v", &cfg)} {
+		assert.Contains(t, output, "localhost:8080")
+		for _, secret := range secrets {
+			assert.NotContains(t, output, secret)
+		}
+	}
+	assert.Equal(t, "master_key", cfg.MasterKey)
+}
+
 func Test_parseLogLevel(t *testing.T) {
 	tests := []struct {
 		name  string
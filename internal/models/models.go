@@ -3,13 +3,121 @@ package models
 
 import (
 	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
 )
 
 // User represents a structure for user data.
+// Password is stored as bcrypt hash for legacy login, it is empty in storage for users with SRP verifier.
 type User struct {
-	ID       string `json:"id"`
-	Login    string `json:"login"`
-	Password string `json:"password"`
+	ID          string `json:"id"`
+	Login       string `json:"login"`
+	Password    string `json:"password"`
+	SRPSalt     []byte `json:"srpSalt" db:"srp_salt"`
+	SRPVerifier []byte `json:"srpVerifier" db:"srp_verifier"`
+}
+
+// SRPHandshake represents a structure for started SRP login with ephemeral secret of the server.
+type SRPHandshake struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	Secret    []byte    `json:"secret"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// Vault represents a structure for parameters of client side encryption.
+// Vault key is random and wrapped by the key derived from the user password with Argon2id,
+// so the server never has access to it.
+type Vault struct {
+	UserID     string `json:"userId"`
+	Salt       []byte `json:"salt"`
+	KdfTime    uint32 `json:"kdfTime"`
+	KdfMemory  uint32 `json:"kdfMemory"`
+	KdfThreads uint32 `json:"kdfThreads"`
+	WrappedKey []byte `json:"wrappedKey"`
+}
+
+// UserKey represents a structure for data encryption key of the user.
+// Key is wrapped by the master key, so rotation of the master key re-wraps only user keys.
+type UserKey struct {
+	UserID     string `json:"userId"`
+	WrappedKey []byte `json:"wrappedKey"`
+}
+
+// RefreshToken represents a structure for refresh token of the user session.
+// Refresh tokens are rotated: every token is used once and replaced with a new token of the same family.
+type RefreshToken struct {
+	TokenHash string    `json:"tokenHash"`
+	UserID    string    `json:"userId"`
+	FamilyID  string    `json:"familyId"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Used      bool      `json:"used"`
+	Revoked   bool      `json:"revoked"`
+}
+
+// Session represents a structure for login session of the user. ID of the session is ID of the refresh token family.
+type Session struct {
+	ID            string    `json:"id"`
+	UserID        string    `json:"userId"`
+	DeviceName    string    `json:"deviceName"`
+	ClientVersion string    `json:"clientVersion"`
+	Address       string    `json:"address"`
+	CreatedAt     time.Time `json:"createdAt"`
+	LastSeenAt    time.Time `json:"lastSeenAt"`
+	Revoked       bool      `json:"revoked"`
+}
+
+// RevokedToken represents a structure for access token revoked before expiration.
+type RevokedToken struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// TwoFactor represents a structure for TOTP second factor of the user.
+// Secret is encrypted with data encryption key of the user, recovery codes are stored as hashes.
+type TwoFactor struct {
+	UserID        string   `json:"userId"`
+	Secret        []byte   `json:"secret"`
+	Enabled       bool     `json:"enabled"`
+	LastUsedStep  int64    `json:"lastUsedStep"`
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+// LoginAttempt represents a structure for failed login attempts of the login or peer address.
+type LoginAttempt struct {
+	Key           string    `json:"key"`
+	Failures      int       `json:"failures"`
+	LastFailureAt time.Time `json:"lastFailureAt"`
+}
+
+// Device represents a structure for device of the user authenticated with client certificate (mTLS).
+type Device struct {
+	ID          string    `json:"id"`
+	UserID      string    `json:"userId"`
+	Name        string    `json:"name"`
+	Fingerprint string    `json:"fingerprint"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+	Revoked     bool      `json:"revoked"`
+}
+
+// AccessToken represents a structure for personal access token of the user (scripts and CI).
+// Token is stored as hash, empty lists of records and types mean access to all private data.
+// Vault key is wrapped with the key which is kept only in the token string of the client.
+type AccessToken struct {
+	ID         string     `json:"id"`
+	UserID     string     `json:"userId"`
+	Name       string     `json:"name"`
+	TokenHash  string     `json:"tokenHash"`
+	ReadOnly   bool       `json:"readOnly"`
+	DataIDs    []string   `json:"dataIds" db:"data_ids"`
+	DataTypes  []DataType `json:"dataTypes"`
+	WrappedKey []byte     `json:"wrappedKey"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	Revoked    bool       `json:"revoked"`
 }
 
 // DataType enum type for data types (same as in grpc).
@@ -23,12 +131,146 @@ const (
 	CardType        DataType = 3
 )
 
+// dataTypeNames defines names of data types for users.
+var dataTypeNames = map[DataType]string{ //nolint:gochecknoglobals
+	CredentialsType: "credentials",
+	TextType:        "text",
+	BinaryType:      "binary",
+	CardType:        "card",
+}
+
+// String returns name of the data type.
+func (t DataType) String() string {
+	if name, ok := dataTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", int32(t))
+}
+
+// ParseDataType returns data type by name.
+func ParseDataType(name string) (DataType, error) {
+	for t, n := range dataTypeNames {
+		if n == name {
+			return t, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown data type %q", name)
+}
+
 // Data represents a structure for data type.
+// Revision is incremented on every update, it is used to detect concurrent changes of the record.
+// DeletedAt is set for data in trash.
+// Metadata is decrypted meta-information of the record, MetadataBinary is encrypted metadata in storage.
 type Data struct {
-	ID         string
-	UserID     string
-	DataType   DataType
-	DataBinary []byte
+	ID              string
+	UserID          string
+	DataType        DataType
+	DataBinary      []byte
+	ClientEncrypted bool
+	Revision        int64
+	UpdatedAt       time.Time
+	DeletedAt       time.Time
+	Metadata        Metadata
+	MetadataBinary  []byte
+}
+
+// Metadata represents a structure for free-form meta-information of the record (any data type).
+// Metadata is encrypted together with private data, so records are filtered by tags on the client.
+type Metadata struct {
+	Title  string            `json:"title,omitempty"`
+	Tags   []string          `json:"tags,omitempty"`
+	Notes  string            `json:"notes,omitempty"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// IsEmpty returns true if metadata has no meta-information.
+func (m Metadata) IsEmpty() bool {
+	return m.Title == "" && len(m.Tags) == 0 && m.Notes == "" && len(m.Fields) == 0
+}
+
+// HasTag returns true if metadata has the tag. Tags are compared case-insensitively.
+func (m Metadata) HasTag(tag string) bool {
+	for _, t := range m.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Equal returns true if metadata has the same meta-information. Nil and empty tags or fields are equal.
+func (m Metadata) Equal(other Metadata) bool {
+	if m.Title != other.Title || m.Notes != other.Notes ||
+		len(m.Tags) != len(other.Tags) || len(m.Fields) != len(other.Fields) {
+		return false
+	}
+	for i := range m.Tags {
+		if m.Tags[i] != other.Tags[i] {
+			return false
+		}
+	}
+	for key, value := range m.Fields {
+		if otherValue, ok := other.Fields[key]; !ok || otherValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// GetJSON getter for Metadata binary data. Empty metadata is an empty binary.
+func (m Metadata) GetJSON() ([]byte, error) {
+	if m.IsEmpty() {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// ParseMetadata returns metadata from binary data. Empty binary is an empty metadata.
+func ParseMetadata(data []byte) (Metadata, error) {
+	var metadata Metadata
+	if len(data) == 0 {
+		return metadata, nil
+	}
+
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return Metadata{}, err
+	}
+	return metadata, nil
+}
+
+// Tombstone represents a structure for deleted private data. Tombstones are returned by sync,
+// so other clients of the user remove deleted records.
+type Tombstone struct {
+	DataID    string    `json:"dataId"`
+	UserID    string    `json:"userId"`
+	DataType  DataType  `json:"dataType"`
+	DeletedAt time.Time `json:"deletedAt"`
+}
+
+// DataChanges represents a structure for changes of private data of the user after the cursor.
+// Cursor is a sequence number of the last change of the user, it is sent by the client on the next sync.
+// Full is set if Data contains all records of the user instead of changes.
+type DataChanges struct {
+	Data       []Data      `json:"data"`
+	Tombstones []Tombstone `json:"tombstones"`
+	Cursor     int64       `json:"cursor"`
+	Full       bool        `json:"full"`
+}
+
+// ChangeEvent represents a structure for notification about changed private data of the user.
+// Cursor is a sequence number of the change, the client loads the change itself with sync.
+type ChangeEvent struct {
+	UserID   string   `json:"userId"`
+	DataID   string   `json:"dataId"`
+	DataType DataType `json:"dataType"`
+	Cursor   int64    `json:"cursor"`
+	Deleted  bool     `json:"deleted"`
 }
 
 // PrivateData is the interface that must be implemented by specific data type (credentials, text, binary, card).
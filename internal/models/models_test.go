@@ -285,3 +285,137 @@ func Test_text_GetType(t *testing.T) {
 		})
 	}
 }
+
+func TestParseDataType(t *testing.T) {
+	tests := []struct {
+		name     string
+		dataType string
+		want     DataType
+		wantErr  bool
+	}{
+		{
+			name:     "positive test (text)",
+			dataType: "text",
+			want:     TextType,
+		},
+		{
+			name:     "positive test (credentials)",
+			dataType: "credentials",
+			want:     CredentialsType,
+		},
+		{
+			name:     "negative test (unknown type)",
+			dataType: "unknown",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDataType(tt.dataType)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.dataType, got.String())
+		})
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata Metadata
+		tag      string
+		hasTag   bool
+	}{
+		{
+			name: "positive test (all fields)",
+			metadata: Metadata{
+				Title:  "bank",
+				Tags:   []string{"Finance", "personal"},
+				Notes:  "main account",
+				Fields: map[string]string{"url": "https://bank.example"},
+			},
+			tag:    "finance",
+			hasTag: true,
+		},
+		{
+			name:     "positive test (title only)",
+			metadata: Metadata{Title: "note"},
+			tag:      "finance",
+		},
+		{
+			name: "positive test (empty metadata)",
+			tag:  "finance",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.hasTag, tt.metadata.HasTag(tt.tag))
+
+			data, err := tt.metadata.GetJSON()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.metadata.IsEmpty(), len(data) == 0)
+
+			got, err := ParseMetadata(data)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.metadata, got)
+		})
+	}
+
+	_, err := ParseMetadata([]byte("invalid"))
+	assert.Error(t, err)
+}
+
+func TestMetadata_Equal(t *testing.T) {
+	metadata := Metadata{
+		Title:  "bank",
+		Tags:   []string{"finance", "personal"},
+		Notes:  "main account",
+		Fields: map[string]string{"url": "https://bank.example"},
+	}
+	tests := []struct {
+		name  string
+		other Metadata
+		want  bool
+	}{
+		{
+			name: "positive test (same metadata)",
+			other: Metadata{
+				Title:  "bank",
+				Tags:   []string{"finance", "personal"},
+				Notes:  "main account",
+				Fields: map[string]string{"url": "https://bank.example"},
+			},
+			want: true,
+		},
+		{
+			name:  "negative test (another title)",
+			other: Metadata{Title: "card", Tags: metadata.Tags, Notes: metadata.Notes, Fields: metadata.Fields},
+		},
+		{
+			name:  "negative test (another tags)",
+			other: Metadata{Title: metadata.Title, Tags: []string{"finance"}, Notes: metadata.Notes, Fields: metadata.Fields},
+		},
+		{
+			name:  "negative test (another notes)",
+			other: Metadata{Title: metadata.Title, Tags: metadata.Tags, Fields: metadata.Fields},
+		},
+		{
+			name: "negative test (another fields)",
+			other: Metadata{Title: metadata.Title, Tags: metadata.Tags, Notes: metadata.Notes,
+				Fields: map[string]string{"url": "https://another.example"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, metadata.Equal(tt.other))
+			assert.Equal(t, tt.want, tt.other.Equal(metadata))
+		})
+	}
+
+	// nil and empty tags and fields are equal
+	assert.True(t, Metadata{}.Equal(Metadata{Tags: []string{}, Fields: map[string]string{}}))
+}
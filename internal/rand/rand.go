@@ -1,21 +1,70 @@
 // Package rand provides primitives for random.
+// Random bytes are read from crypto/rand, the source can be replaced with deterministic one only in tests
+// of the package.
 package rand
 
 import (
-	"math/rand"
-	"time"
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync"
 )
 
-func init() {
-	rand.Seed(time.Now().UnixNano())
+// Letters defines alphabet for human-facing random strings.
+const Letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ123456789"
+
+// ErrorInvalidAlphabet defines an error for alphabet which cannot be used for random strings.
+var ErrorInvalidAlphabet = errors.New("alphabet must contain from 1 to 256 symbols")
+
+var (
+	reader io.Reader = rand.Reader
+	mu     sync.RWMutex
+)
+
+// Read fills b with random bytes.
+func Read(b []byte) error {
+	mu.RLock()
+	r := reader
+	mu.RUnlock()
+
+	_, err := io.ReadFull(r, b)
+	return err
 }
 
-// GenerateRandom generates slice of random bytes with specified size.
-func GenerateRandom(size int) []byte {
-	var Letters = []byte("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ123456789")
+// Bytes returns slice of random bytes with specified size (full entropy, e.g. for keys and nonces).
+func Bytes(size int) ([]byte, error) {
 	b := make([]byte, size)
-	for i := range b {
-		b[i] = Letters[rand.Intn(len(Letters))]
+	if err := Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// String returns human-facing random string with specified size from Letters alphabet.
+func String(size int) (string, error) {
+	return StringFrom(Letters, size)
+}
+
+// StringFrom returns random string with specified size from the alphabet.
+// Every symbol of the alphabet has the same probability (no modulo bias).
+func StringFrom(alphabet string, size int) (string, error) {
+	if len(alphabet) == 0 || len(alphabet) > 256 {
+		return "", ErrorInvalidAlphabet
+	}
+
+	// bytes above the limit are rejected to keep distribution uniform
+	limit := 256 - 256%len(alphabet)
+	result := make([]byte, 0, size)
+	buf := make([]byte, size)
+	for len(result) < size {
+		if err := Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) < limit && len(result) < size {
+				result = append(result, alphabet[int(b)%len(alphabet)])
+			}
+		}
 	}
-	return b
+	return string(result), nil
 }
@@ -1,11 +1,14 @@
 package rand
 
 import (
-	"reflect"
+	"errors"
+	"strings"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
-func TestGenerateRandom(t *testing.T) {
+func TestBytes(t *testing.T) {
 	tests := []struct {
 		name   string
 		size   int
@@ -17,21 +20,121 @@ func TestGenerateRandom(t *testing.T) {
 			result: 1,
 		},
 		{
-			name:   "generate random with 10 byte length",
-			size:   10,
-			result: 10,
+			name:   "generate random with 12 byte length",
+			size:   12,
+			result: 12,
+		},
+		{
+			name:   "generate random with 32 byte length",
+			size:   32,
+			result: 32,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Bytes(tt.size)
+			assert.NoError(t, err)
+			if len(got) != tt.result {
+				t.Errorf("Bytes() = %v, want %v", got, tt.result)
+			}
+		})
+	}
+
+	// full entropy: values are not limited by alphabet
+	first, err := Bytes(1024)
+	assert.NoError(t, err)
+	second, err := Bytes(1024)
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, second)
+	outside := 0
+	for _, b := range first {
+		if !strings.ContainsRune(Letters, rune(b)) {
+			outside++
+		}
+	}
+	assert.NotZero(t, outside)
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		name     string
+		alphabet string
+		size     int
+		wantErr  bool
+	}{
+		{
+			name:     "positive test (letters)",
+			alphabet: Letters,
+			size:     100,
+			wantErr:  false,
+		},
+		{
+			name:     "positive test (digits)",
+			alphabet: "0123456789",
+			size:     8,
+			wantErr:  false,
 		},
 		{
-			name:   "generate random with 100 byte length",
-			size:   100,
-			result: 100,
+			name:     "negative test (empty alphabet)",
+			alphabet: "",
+			size:     8,
+			wantErr:  true,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := GenerateRandom(tt.size); !reflect.DeepEqual(len(got), tt.result) {
-				t.Errorf("GenerateRandom() = %v, want %v", got, tt.result)
+			got, err := StringFrom(tt.alphabet, tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("StringFrom() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrorInvalidAlphabet)
+				return
+			}
+			assert.Len(t, got, tt.size)
+			for _, r := range got {
+				assert.True(t, strings.ContainsRune(tt.alphabet, r))
 			}
 		})
 	}
 }
+
+func Test_setReader(t *testing.T) {
+	restore := setReader(newDeterministicReader("seed"))
+	first, err := Bytes(40)
+	assert.NoError(t, err)
+	restore()
+
+	restore = setReader(newDeterministicReader("seed"))
+	second, err := Bytes(40)
+	assert.NoError(t, err)
+	text, err := String(10)
+	assert.NoError(t, err)
+	restore()
+
+	// deterministic source produces the same sequence for the same seed
+	assert.Equal(t, first, second)
+	restore = setReader(newDeterministicReader("seed"))
+	_, _ = Bytes(40)
+	again, err := String(10)
+	assert.NoError(t, err)
+	restore()
+	assert.Equal(t, text, again)
+
+	// failed source is reported
+	restore = setReader(failedReader{})
+	_, err = Bytes(1)
+	assert.Error(t, err)
+	restore()
+
+	// default source is restored
+	_, err = Bytes(1)
+	assert.NoError(t, err)
+}
+
+type failedReader struct{}
+
+func (failedReader) Read([]byte) (int, error) {
+	return 0, errors.New("source failed")
+}
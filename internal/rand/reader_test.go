@@ -0,0 +1,52 @@
+package rand
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+)
+
+// setReader replaces source of random bytes and returns function to restore previous source.
+func setReader(r io.Reader) (restore func()) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	previous := reader
+	reader = r
+	return func() {
+		mu.Lock()
+		defer mu.Unlock()
+		reader = previous
+	}
+}
+
+// deterministicReader represents a reproducible source of bytes for tests.
+// It produces SHA-256(seed | counter) blocks and must never be used for real keys.
+type deterministicReader struct {
+	seed    []byte
+	counter uint64
+	block   []byte
+}
+
+// newDeterministicReader returns an instance of deterministicReader.
+func newDeterministicReader(seed string) *deterministicReader {
+	return &deterministicReader{seed: []byte(seed)}
+}
+
+// Read fills p with the next bytes of the stream.
+func (d *deterministicReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(d.block) == 0 {
+			counter := make([]byte, 8)
+			binary.BigEndian.PutUint64(counter, d.counter)
+			hash := sha256.Sum256(append(append([]byte(nil), d.seed...), counter...))
+			d.block = hash[:]
+			d.counter++
+		}
+		copied := copy(p[n:], d.block)
+		d.block = d.block[copied:]
+		n += copied
+	}
+	return n, nil
+}
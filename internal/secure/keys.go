@@ -0,0 +1,120 @@
+package secure
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/rand"
+)
+
+// MasterKeySize defines size of the master key (AES-256).
+const MasterKeySize = 32
+
+// ErrorInvalidMasterKey defines an error for master key with invalid format or size.
+var ErrorInvalidMasterKey = errors.New("master key must be hex encoded 32 bytes")
+
+// KeyProvider is the interface that must be implemented by specific source of the master key.
+type KeyProvider interface {
+	// MasterKey returns master key which is used for encryption of private data.
+	MasterKey() ([]byte, error)
+}
+
+// check that providers implement all required methods.
+var (
+	_ KeyProvider = (*StaticKeyProvider)(nil)
+	_ KeyProvider = (*FileKeyProvider)(nil)
+	_ KeyProvider = (*RandomKeyProvider)(nil)
+)
+
+// StaticKeyProvider represents a master key supplied by settings (e.g. environment variable).
+type StaticKeyProvider struct {
+	key string
+}
+
+// NewStaticKeyProvider returns an instance of StaticKeyProvider for hex encoded key.
+func NewStaticKeyProvider(key string) *StaticKeyProvider {
+	return &StaticKeyProvider{key: key}
+}
+
+// MasterKey decodes master key from settings.
+func (p *StaticKeyProvider) MasterKey() ([]byte, error) {
+	return decodeMasterKey(p.key)
+}
+
+// FileKeyProvider represents a master key stored in the file.
+type FileKeyProvider struct {
+	path string
+}
+
+// NewFileKeyProvider returns an instance of FileKeyProvider.
+func NewFileKeyProvider(path string) *FileKeyProvider {
+	return &FileKeyProvider{path: path}
+}
+
+// MasterKey reads hex encoded master key from the file.
+func (p *FileKeyProvider) MasterKey() ([]byte, error) {
+	content, err := os.ReadFile(p.path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read master key file: %w", err)
+	}
+	return decodeMasterKey(string(content))
+}
+
+// RandomKeyProvider represents an ephemeral master key which is lost after restart.
+type RandomKeyProvider struct{}
+
+// MasterKey generates new random master key.
+func (p *RandomKeyProvider) MasterKey() ([]byte, error) {
+	return rand.Bytes(MasterKeySize)
+}
+
+// NewKeyProvider returns key provider according to settings. Key file has priority over the key itself.
+// Ephemeral random key is used if nothing is configured.
+func NewKeyProvider(key string, keyFile string) KeyProvider {
+	switch {
+	case keyFile != "":
+		log.Info().Msgf("Master key: file %s", keyFile)
+		return NewFileKeyProvider(keyFile)
+	case key != "":
+		log.Info().Msg("Master key: settings")
+		return NewStaticKeyProvider(key)
+	}
+	log.Warn().Msg("Master key is not configured, ephemeral key is used. Data will be lost after restart.")
+	return &RandomKeyProvider{}
+}
+
+// NewRetiredKeyProviders returns key providers for retired master keys (comma separated keys and key files).
+// Retired keys are used only to decrypt data which was encrypted before key rotation.
+func NewRetiredKeyProviders(keys string, keyFiles string) []KeyProvider {
+	var providers []KeyProvider
+	for _, key := range splitList(keys) {
+		providers = append(providers, NewStaticKeyProvider(key))
+	}
+	for _, file := range splitList(keyFiles) {
+		providers = append(providers, NewFileKeyProvider(file))
+	}
+	log.Info().Msgf("Retired master keys: %d", len(providers))
+	return providers
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func decodeMasterKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(key) != MasterKeySize {
+		return nil, ErrorInvalidMasterKey
+	}
+	return key, nil
+}
@@ -0,0 +1,190 @@
+package secure
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testMasterKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+func TestStaticKeyProvider_MasterKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{
+			name:    "positive test",
+			key:     testMasterKey,
+			wantErr: false,
+		},
+		{
+			name:    "negative test (invalid hex)",
+			key:     "invalid_key",
+			wantErr: true,
+		},
+		{
+			name:    "negative test (invalid size)",
+			key:     "0001020304",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewStaticKeyProvider(tt.key).MasterKey()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("MasterKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrorInvalidMasterKey)
+				return
+			}
+			assert.Len(t, got, MasterKeySize)
+		})
+	}
+}
+
+func TestFileKeyProvider_MasterKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		create  bool
+		wantErr bool
+	}{
+		{
+			name:    "positive test",
+			content: testMasterKey + "\n",
+			create:  true,
+			wantErr: false,
+		},
+		{
+			name:    "negative test (invalid content)",
+			content: "invalid_key",
+			create:  true,
+			wantErr: true,
+		},
+		{
+			name:    "negative test (file not found)",
+			create:  false,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "master.key")
+			if tt.create {
+				err := os.WriteFile(path, []byte(tt.content), 0o600)
+				assert.NoError(t, err)
+			}
+
+			got, err := NewFileKeyProvider(path).MasterKey()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("MasterKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr {
+				assert.Len(t, got, MasterKeySize)
+			}
+		})
+	}
+}
+
+func TestRandomKeyProvider_MasterKey(t *testing.T) {
+	p := &RandomKeyProvider{}
+	first, err := p.MasterKey()
+	assert.NoError(t, err)
+	second, err := p.MasterKey()
+	assert.NoError(t, err)
+
+	assert.Len(t, first, MasterKeySize)
+	assert.NotEqual(t, first, second)
+}
+
+func TestNewKeyProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		keyFile string
+		want    string
+	}{
+		{
+			name:    "key file has priority",
+			key:     testMasterKey,
+			keyFile: "master.key",
+			want:    "*secure.FileKeyProvider",
+		},
+		{
+			name: "key from settings",
+			key:  testMasterKey,
+			want: "*secure.StaticKeyProvider",
+		},
+		{
+			name: "ephemeral key",
+			want: "*secure.RandomKeyProvider",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := NewKeyProvider(tt.key, tt.keyFile)
+			assert.Equal(t, tt.want, fmt.Sprintf("%T", got))
+		})
+	}
+}
+
+func TestSetKeyProvider(t *testing.T) {
+	t.Cleanup(func() {
+		err := SetKeyProvider(&RandomKeyProvider{})
+		assert.NoError(t, err)
+	})
+
+	// data encrypted with the same master key must survive re-initialization (restart)
+	err := SetKeyProvider(NewStaticKeyProvider(testMasterKey))
+	assert.NoError(t, err)
+	encrypted, err := Encrypt([]byte("persistent data"), nil)
+	assert.NoError(t, err)
+
+	err = SetKeyProvider(NewStaticKeyProvider(testMasterKey))
+	assert.NoError(t, err)
+	decrypted, err := Decrypt(encrypted, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("persistent data"), decrypted)
+
+	// another master key cannot decrypt the data
+	err = SetKeyProvider(&RandomKeyProvider{})
+	assert.NoError(t, err)
+	_, err = Decrypt(encrypted, nil)
+	assert.ErrorIs(t, err, ErrorUnknownKey)
+
+	err = SetKeyProvider(NewStaticKeyProvider("invalid_key"))
+	assert.ErrorIs(t, err, ErrorInvalidMasterKey)
+}
+
+func TestNewRetiredKeyProviders(t *testing.T) {
+	tests := []struct {
+		name     string
+		keys     string
+		keyFiles string
+		want     int
+	}{
+		{
+			name: "no retired keys",
+			want: 0,
+		},
+		{
+			name:     "keys and key files",
+			keys:     testMasterKey + ", " + testMasterKey,
+			keyFiles: "first.key,second.key,",
+			want:     4,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Len(t, NewRetiredKeyProviders(tt.keys, tt.keyFiles), tt.want)
+		})
+	}
+}
@@ -2,105 +2,390 @@
 package secure
 
 import (
-	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"github.com/google/uuid"
-	"github.com/rs/zerolog/log"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/proto"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/rand"
 	"sync"
 )
 
+// Envelope format of data encrypted with the master key (hex encoded):
+//
+//	| version 1 (1 byte) | key ID (4 bytes) | nonce (12 bytes) | ciphertext with GCM tag |
+//
+// Envelope format of data encrypted with the user key (hex encoded):
+//
+//	| version 2 (1 byte) | nonce (12 bytes) | ciphertext with GCM tag |
+//
+// Header is authenticated together with additional data of the record.
+const (
+	// EnvelopeVersion defines version of the ciphertext envelope encrypted with the master key.
+	// It is used for user keys and legacy private data.
+	EnvelopeVersion byte = 1
+	// UserKeyEnvelopeVersion defines version of the ciphertext envelope encrypted with the user key.
+	UserKeyEnvelopeVersion byte = 2
+	// KeyIDSize defines size of the key identifier in the envelope.
+	KeyIDSize  = 4
+	headerSize = 1 + KeyIDSize
+)
+
+// ErrorInvalidEnvelope defines an error for malformed encrypted data.
+var ErrorInvalidEnvelope = errors.New("encrypted data has invalid format")
+
+// ErrorUnsupportedVersion defines an error for unknown version of encrypted data.
+var ErrorUnsupportedVersion = errors.New("encrypted data has unsupported version")
+
+// ErrorUnknownKey defines an error for data encrypted with another key.
+var ErrorUnknownKey = errors.New("encrypted data has unknown key ID")
+
+// ErrorUserKeyRequired defines an error for data encrypted with the user key when key is not provided.
+var ErrorUserKeyRequired = errors.New("user key is required to decrypt data")
+
 // cipherData represents helper structure for crypto/aes encryption/decryption.
 type cipherData struct {
-	key    []byte
-	nonce  []byte
+	keyID  []byte
 	aesGCM cipher.AEAD
 }
 
-var cipherInstance *cipherData
-var once sync.Once
+// KeyRing represents a set of master keys. Data is always encrypted with the active key
+// and can be decrypted with any key of the ring (e.g. retired after rotation).
+type KeyRing struct {
+	active *cipherData
+	keys   map[string]*cipherData
+	// derivationKey is used to derive keys for other purposes than encryption
+	derivationKey []byte
+}
+
+// NewKeyRing returns an instance of KeyRing.
+func NewKeyRing(active []byte, retired ...[]byte) (*KeyRing, error) {
+	c, err := newCipher(active)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, active)
+	mac.Write([]byte("gophkeeper key derivation"))
+	ring := &KeyRing{active: c, keys: map[string]*cipherData{string(c.keyID): c}, derivationKey: mac.Sum(nil)}
+	for _, key := range retired {
+		c, err := newCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := ring.keys[string(c.keyID)]; !ok {
+			ring.keys[string(c.keyID)] = c
+		}
+	}
+	return ring, nil
+}
+
+// ActiveKeyID returns identifier of the key which is used for encryption.
+func (r *KeyRing) ActiveKeyID() []byte {
+	return r.active.keyID
+}
+
+func (r *KeyRing) cipher(keyID []byte) (*cipherData, error) {
+	c, ok := r.keys[string(keyID)]
+	if !ok {
+		return nil, ErrorUnknownKey
+	}
+	return c, nil
+}
+
+var keyRing *KeyRing
+var mu sync.RWMutex
+
+func newCipher(key []byte) (*cipherData, error) {
+	aesgcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
 
-func cipherInit() error {
-	var e error
-	once.Do(func() {
-		key := rand.GenerateRandom(2 * aes.BlockSize)
+	return &cipherData{keyID: KeyID(key), aesGCM: aesgcm}, nil
+}
+
+// KeyID returns identifier of the key (fingerprint) which is stored in the envelope.
+func KeyID(key []byte) []byte {
+	hash := sha256.Sum256(key)
+	return hash[:KeyIDSize]
+}
 
-		aesblock, err := aes.NewCipher(key)
+// SetKeyProvider initializes encryption with the active master key and optional retired keys.
+func SetKeyProvider(active KeyProvider, retired ...KeyProvider) error {
+	key, err := active.MasterKey()
+	if err != nil {
+		return err
+	}
+
+	retiredKeys := make([][]byte, 0, len(retired))
+	for _, provider := range retired {
+		k, err := provider.MasterKey()
 		if err != nil {
-			e = err
+			return err
 		}
+		retiredKeys = append(retiredKeys, k)
+	}
+
+	ring, err := NewKeyRing(key, retiredKeys...)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	keyRing = ring
+	return nil
+}
+
+// cipherInit initializes encryption with ephemeral key if master key was not set.
+func cipherInit() (*KeyRing, error) {
+	mu.RLock()
+	ring := keyRing
+	mu.RUnlock()
+	if ring != nil {
+		return ring, nil
+	}
 
-		aesgcm, err := cipher.NewGCM(aesblock)
+	mu.Lock()
+	defer mu.Unlock()
+	if keyRing == nil {
+		key, err := (&RandomKeyProvider{}).MasterKey()
 		if err != nil {
-			e = err
+			return nil, err
 		}
+		keyRing, err = NewKeyRing(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keyRing, nil
+}
+
+// ActiveKeyID returns identifier of the master key which is used for encryption.
+func ActiveKeyID() ([]byte, error) {
+	ring, err := cipherInit()
+	if err != nil {
+		return nil, err
+	}
+	return ring.ActiveKeyID(), nil
+}
+
+// DeriveKey returns key for the purpose derived from the active master key (HMAC-SHA256).
+func DeriveKey(purpose string) ([]byte, error) {
+	ring, err := cipherInit()
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, ring.derivationKey)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil), nil
+}
+
+// GetEnvelopeVersion returns version of the envelope (master key or user key).
+func GetEnvelopeVersion(data []byte) (byte, error) {
+	dst, err := decodeEnvelope(data)
+	if err != nil {
+		return 0, err
+	}
+	return dst[0], nil
+}
+
+// EnvelopeKeyID returns identifier of the master key which was used to encrypt data.
+func EnvelopeKeyID(data []byte) ([]byte, error) {
+	dst, err := decodeEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(dst) < headerSize {
+		return nil, ErrorInvalidEnvelope
+	}
+	if dst[0] != EnvelopeVersion {
+		return nil, ErrorUnsupportedVersion
+	}
+	return dst[1:headerSize], nil
+}
+
+func decodeEnvelope(data []byte) ([]byte, error) {
+	dst := make([]byte, hex.DecodedLen(len(data)))
+	if _, err := hex.Decode(dst, data); err != nil {
+		return nil, err
+	}
+	if len(dst) == 0 {
+		return nil, ErrorInvalidEnvelope
+	}
+	return dst, nil
+}
+
+// RecordAdditionalData returns additional data which binds ciphertext to the record and its owner.
+func RecordAdditionalData(dataID string, userID string) []byte {
+	return []byte(dataID + "|" + userID)
+}
 
-		nonce := rand.GenerateRandom(aesgcm.NonceSize())
-		cipherInstance = &cipherData{key: key, aesGCM: aesgcm, nonce: nonce}
-	})
-	return e
+// metadataAdditionalData binds encrypted metadata to the record, so it cannot be swapped with private data.
+func metadataAdditionalData(dataID string, userID string) []byte {
+	return []byte(dataID + "|" + userID + "|metadata")
 }
 
-// Encrypt returns encrypted data.
-func Encrypt(data []byte) ([]byte, error) {
-	if err := cipherInit(); err != nil {
+// Encrypt returns encrypted data in envelope format. Additional data is authenticated but not encrypted.
+func Encrypt(data []byte, additionalData []byte) ([]byte, error) {
+	ring, err := cipherInit()
+	if err != nil {
 		return nil, err
 	}
-	log.Debug().Msgf("Data to be encrypted: %s", string(data))
+	c := ring.active
+
+	nonceSize := c.aesGCM.NonceSize()
+	envelope := make([]byte, headerSize+nonceSize, headerSize+nonceSize+len(data)+c.aesGCM.Overhead())
+	envelope[0] = EnvelopeVersion
+	copy(envelope[1:headerSize], c.keyID)
 
-	encrypted := cipherInstance.aesGCM.Seal(nil, cipherInstance.nonce, data, nil)
+	// unique nonce for every record
+	nonce := envelope[headerSize:]
+	if err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	aad := append(envelope[:headerSize:headerSize], additionalData...)
+	encrypted := c.aesGCM.Seal(envelope, nonce, data, aad)
 	dst := make([]byte, hex.EncodedLen(len(encrypted)))
 	hex.Encode(dst, encrypted)
 
-	log.Debug().Msgf("Encrypted data: %s", string(dst))
 	return dst, nil
 }
 
-// Decrypt returns decrypted data.
-func Decrypt(data []byte) ([]byte, error) {
-	if err := cipherInit(); err != nil {
+// Decrypt returns decrypted data from envelope format. Additional data must be the same as for encryption.
+func Decrypt(data []byte, additionalData []byte) ([]byte, error) {
+	ring, err := cipherInit()
+	if err != nil {
 		return nil, err
 	}
-	log.Debug().Msgf("Data to be decrypted: %s", string(data))
 
 	dst := make([]byte, hex.DecodedLen(len(data)))
-	_, err := hex.Decode(dst, data)
+	_, err = hex.Decode(dst, data)
 	if err != nil {
 		return nil, err
 	}
 
-	decrypted, err := cipherInstance.aesGCM.Open(nil, cipherInstance.nonce, dst, nil)
+	if len(dst) < headerSize {
+		return nil, ErrorInvalidEnvelope
+	}
+	if dst[0] != EnvelopeVersion {
+		return nil, ErrorUnsupportedVersion
+	}
+	c, err := ring.cipher(dst[1:headerSize])
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := c.aesGCM.NonceSize()
+	if len(dst) < headerSize+nonceSize+c.aesGCM.Overhead() {
+		return nil, ErrorInvalidEnvelope
+	}
+
+	header := dst[:headerSize:headerSize]
+	nonce := dst[headerSize : headerSize+nonceSize]
+	decrypted, err := c.aesGCM.Open(nil, nonce, dst[headerSize+nonceSize:], append(header, additionalData...))
 	if err != nil {
 		return nil, err
 	}
 
-	log.Debug().Msgf("Decrypted data: %s", string(decrypted))
 	return decrypted, nil
 }
 
-// EncryptPrivateData encrypts user private data.
-func EncryptPrivateData(data *proto.Data, userID string) (models.Data, error) {
+// EncryptWithUserKey returns data encrypted with the user key in envelope format.
+func EncryptWithUserKey(userKey []byte, data []byte, additionalData []byte) ([]byte, error) {
+	header := []byte{UserKeyEnvelopeVersion}
+	encrypted, err := EncryptWithKey(userKey, data, append(header, additionalData...))
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := append(header, encrypted...)
+	dst := make([]byte, hex.EncodedLen(len(envelope)))
+	hex.Encode(dst, envelope)
+	return dst, nil
+}
+
+// DecryptWithUserKey returns decrypted data from envelope encrypted with the user key.
+func DecryptWithUserKey(userKey []byte, data []byte, additionalData []byte) ([]byte, error) {
+	dst, err := decodeEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	if dst[0] != UserKeyEnvelopeVersion {
+		return nil, ErrorUnsupportedVersion
+	}
+	if userKey == nil {
+		return nil, ErrorUserKeyRequired
+	}
+
+	header := dst[:1:1]
+	return DecryptWithKey(userKey, dst[1:], append(header, additionalData...))
+}
+
+// EncryptPrivateData encrypts user private data with the user key. ID of the record is kept if the client
+// has generated it, otherwise new ID is assigned.
+func EncryptPrivateData(data *proto.Data, userID string, userKey []byte) (models.Data, error) {
 	var securedData models.Data
-	encryptedBinary, err := Encrypt(data.GetDataBinary())
+	securedData.ID = data.GetDataId()
+	if _, err := uuid.Parse(securedData.ID); err != nil {
+		securedData.ID = uuid.NewString()
+	}
+
+	encryptedBinary, err := EncryptWithUserKey(userKey, data.GetDataBinary(), RecordAdditionalData(securedData.ID, userID))
 	if err != nil {
 		return models.Data{}, err
 	}
 
-	securedData.ID = uuid.NewString()
 	securedData.UserID = userID
 	securedData.DataType = models.DataType(data.GetDataType())
 	securedData.DataBinary = encryptedBinary
+	securedData.ClientEncrypted = data.GetClientEncrypted()
+
+	// metadata of client encrypted data is encrypted by the client as well
+	metadataBinary := data.GetMetadataBinary()
+	if !data.GetClientEncrypted() {
+		metadataBinary, err = MetadataFromProto(data.GetMetadata()).GetJSON()
+		if err != nil {
+			return models.Data{}, err
+		}
+	}
+	if len(metadataBinary) != 0 {
+		securedData.MetadataBinary, err = EncryptWithUserKey(userKey, metadataBinary,
+			metadataAdditionalData(securedData.ID, userID))
+		if err != nil {
+			return models.Data{}, err
+		}
+	}
 
 	return securedData, nil
 }
 
-// DecryptPrivateData decrypts user private data.
-func DecryptPrivateData(data models.Data) (*proto.Data, error) {
+// ReencryptPrivateData encrypts user private data with the user key. Record ID is not changed.
+// It is used to migrate legacy data encrypted with the master key.
+func ReencryptPrivateData(data models.Data, userKey []byte) (models.Data, error) {
+	decrypted, err := decryptPrivateBinary(data, userKey)
+	if err != nil {
+		return models.Data{}, err
+	}
+
+	encrypted, err := EncryptWithUserKey(userKey, decrypted, RecordAdditionalData(data.ID, data.UserID))
+	if err != nil {
+		return models.Data{}, err
+	}
+
+	data.DataBinary = encrypted
+	return data, nil
+}
+
+// DecryptPrivateData decrypts user private data. Legacy data is decrypted with the master key.
+func DecryptPrivateData(data models.Data, userKey []byte) (*proto.Data, error) {
 	var securedData proto.Data
-	decryptedBinary, err := Decrypt(data.DataBinary)
+	decryptedBinary, err := decryptPrivateBinary(data, userKey)
 	if err != nil {
 		return nil, err
 	}
@@ -108,6 +393,67 @@ func DecryptPrivateData(data models.Data) (*proto.Data, error) {
 	securedData.DataId = data.ID
 	securedData.DataType = proto.DataType(data.DataType)
 	securedData.DataBinary = decryptedBinary
+	securedData.ClientEncrypted = data.ClientEncrypted
+	securedData.Revision = data.Revision
+	if !data.UpdatedAt.IsZero() {
+		securedData.UpdatedAt = data.UpdatedAt.Unix()
+	}
+	if !data.DeletedAt.IsZero() {
+		securedData.DeletedAt = data.DeletedAt.Unix()
+	}
+
+	if len(data.MetadataBinary) != 0 {
+		metadataBinary, err := DecryptWithUserKey(userKey, data.MetadataBinary,
+			metadataAdditionalData(data.ID, data.UserID))
+		if err != nil {
+			return nil, err
+		}
+		if data.ClientEncrypted {
+			securedData.MetadataBinary = metadataBinary
+		} else {
+			metadata, err := models.ParseMetadata(metadataBinary)
+			if err != nil {
+				return nil, err
+			}
+			securedData.Metadata = MetadataToProto(metadata)
+		}
+	}
 
 	return &securedData, nil
 }
+
+// MetadataFromProto converts metadata of the record from grpc structure.
+func MetadataFromProto(metadata *proto.Metadata) models.Metadata {
+	return models.Metadata{
+		Title:  metadata.GetTitle(),
+		Tags:   metadata.GetTags(),
+		Notes:  metadata.GetNotes(),
+		Fields: metadata.GetFields(),
+	}
+}
+
+// MetadataToProto converts metadata of the record to grpc structure, empty metadata is nil.
+func MetadataToProto(metadata models.Metadata) *proto.Metadata {
+	if metadata.IsEmpty() {
+		return nil
+	}
+	return &proto.Metadata{
+		Title:  metadata.Title,
+		Tags:   metadata.Tags,
+		Notes:  metadata.Notes,
+		Fields: metadata.Fields,
+	}
+}
+
+func decryptPrivateBinary(data models.Data, userKey []byte) ([]byte, error) {
+	version, err := GetEnvelopeVersion(data.DataBinary)
+	if err != nil {
+		return nil, err
+	}
+
+	aad := RecordAdditionalData(data.ID, data.UserID)
+	if version == EnvelopeVersion {
+		return Decrypt(data.DataBinary, aad)
+	}
+	return DecryptWithUserKey(userKey, data.DataBinary, aad)
+}
@@ -1,6 +1,11 @@
 package secure
 
 import (
+	"bytes"
+	"encoding/hex"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/proto"
@@ -27,10 +32,10 @@ func TestDecrypt(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			encrypted, err := Encrypt(tt.args.data)
+			encrypted, err := Encrypt(tt.args.data, RecordAdditionalData("dataID", "userID"))
 			assert.NoError(t, err)
 
-			got, err := Decrypt(encrypted)
+			got, err := Decrypt(encrypted, RecordAdditionalData("dataID", "userID"))
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Decrypt() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -42,16 +47,92 @@ func TestDecrypt(t *testing.T) {
 	}
 }
 
+func TestDecrypt_Envelope(t *testing.T) {
+	data := []byte("data to be encrypted")
+	aad := RecordAdditionalData("dataID", "userID")
+
+	first, err := Encrypt(data, aad)
+	assert.NoError(t, err)
+	second, err := Encrypt(data, aad)
+	assert.NoError(t, err)
+
+	// every record has its own nonce
+	assert.NotEqual(t, first, second)
+
+	raw, err := hex.DecodeString(string(first))
+	assert.NoError(t, err)
+	assert.Equal(t, EnvelopeVersion, raw[0])
+
+	tests := []struct {
+		name    string
+		data    []byte
+		aad     []byte
+		wantErr error
+	}{
+		{
+			name: "negative test (another record)",
+			data: first,
+			aad:  RecordAdditionalData("anotherDataID", "userID"),
+		},
+		{
+			name: "negative test (another user)",
+			data: first,
+			aad:  RecordAdditionalData("dataID", "anotherUserID"),
+		},
+		{
+			name:    "negative test (unsupported version)",
+			data:    tamper(raw, 0),
+			aad:     aad,
+			wantErr: ErrorUnsupportedVersion,
+		},
+		{
+			name:    "negative test (unknown key)",
+			data:    tamper(raw, 1),
+			aad:     aad,
+			wantErr: ErrorUnknownKey,
+		},
+		{
+			name: "negative test (modified nonce)",
+			data: tamper(raw, headerSize),
+			aad:  aad,
+		},
+		{
+			name:    "negative test (truncated)",
+			data:    first[:10],
+			aad:     aad,
+			wantErr: ErrorInvalidEnvelope,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decrypt(tt.data, tt.aad)
+			assert.Error(t, err)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// tamper flips one byte of the raw envelope and returns it hex encoded.
+func tamper(raw []byte, pos int) []byte {
+	modified := append([]byte(nil), raw...)
+	modified[pos] ^= 0xff
+	return []byte(hex.EncodeToString(modified))
+}
+
 func TestDecryptPrivateData(t *testing.T) {
 	type args struct {
 		data *proto.Data
 	}
 	tests := []struct {
-		name     string
-		args     args
-		want     *proto.Data
-		wantData []byte
-		wantErr  bool
+		name               string
+		args               args
+		want               *proto.Data
+		wantData           []byte
+		wantMetadata       models.Metadata
+		wantMetadataBinary []byte
+		wantErr            bool
 	}{
 		{
 			name: "positive test",
@@ -62,22 +143,96 @@ func TestDecryptPrivateData(t *testing.T) {
 			wantData: []byte("some binary data"),
 			wantErr:  false,
 		},
+		{
+			name: "positive test (metadata)",
+			args: args{data: &proto.Data{
+				DataType:   1,
+				DataBinary: []byte("some text"),
+				Metadata: &proto.Metadata{
+					Title:  "title",
+					Tags:   []string{"work"},
+					Fields: map[string]string{"url": "https://example.com"},
+				},
+			}},
+			wantData: []byte("some text"),
+			wantMetadata: models.Metadata{
+				Title:  "title",
+				Tags:   []string{"work"},
+				Fields: map[string]string{"url": "https://example.com"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "positive test (metadata encrypted by the client)",
+			args: args{data: &proto.Data{
+				DataType:        1,
+				DataBinary:      []byte("client ciphertext"),
+				ClientEncrypted: true,
+				MetadataBinary:  []byte("client metadata ciphertext"),
+			}},
+			wantData:           []byte("client ciphertext"),
+			wantMetadataBinary: []byte("client metadata ciphertext"),
+			wantErr:            false,
+		},
 	}
+	_, userKey, err := NewUserKey("userId")
+	assert.NoError(t, err)
+	_, anotherUserKey, err := NewUserKey("anotherUserId")
+	assert.NoError(t, err)
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			encrypted, err := EncryptPrivateData(tt.args.data, "userId")
+			encrypted, err := EncryptPrivateData(tt.args.data, "userId", userKey)
 			assert.NoError(t, err)
 
-			got, err := DecryptPrivateData(encrypted)
+			got, err := DecryptPrivateData(encrypted, userKey)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("DecryptPrivateData() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			assert.Equal(t, tt.wantData, got.DataBinary)
+			assert.Equal(t, tt.wantMetadata, MetadataFromProto(got.GetMetadata()))
+			assert.Equal(t, tt.wantMetadataBinary, got.GetMetadataBinary())
+
+			// user key is required
+			_, err = DecryptPrivateData(encrypted, nil)
+			assert.ErrorIs(t, err, ErrorUserKeyRequired)
+
+			// record cannot be decrypted with the key of another user
+			_, err = DecryptPrivateData(encrypted, anotherUserKey)
+			assert.Error(t, err)
+
+			// record copied to another user cannot be decrypted
+			encrypted.UserID = "anotherUserId"
+			_, err = DecryptPrivateData(encrypted, userKey)
+			assert.Error(t, err)
 		})
 	}
 }
 
+func TestEncrypt_NoSecretsLogged(t *testing.T) {
+	var output bytes.Buffer
+	logger := log.Logger
+	log.Logger = zerolog.New(&output).Level(zerolog.TraceLevel)
+	t.Cleanup(func() { log.Logger = logger })
+
+	userKey, key, err := NewUserKey("userID")
+	assert.NoError(t, err)
+	unwrapped, err := UnwrapUserKey(userKey)
+	assert.NoError(t, err)
+	assert.Equal(t, key, unwrapped)
+
+	encrypted, err := Encrypt([]byte("plaintext secret"), nil)
+	assert.NoError(t, err)
+	_, err = Decrypt(encrypted, nil)
+	assert.NoError(t, err)
+
+	for _, secret := range [][]byte{[]byte("plaintext secret"), encrypted, userKey.WrappedKey,
+		[]byte(hex.EncodeToString(key))} {
+		assert.NotContains(t, output.String(), string(secret))
+	}
+}
+
 func TestEncrypt(t *testing.T) {
 	type args struct {
 		data []byte
@@ -95,7 +250,7 @@ func TestEncrypt(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := Encrypt(tt.args.data)
+			got, err := Encrypt(tt.args.data, nil)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Encrypt() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -124,15 +279,48 @@ func TestEncryptPrivateData(t *testing.T) {
 			want:    models.Data{},
 			wantErr: false,
 		},
+		{
+			name: "positive test (ID of the client is kept)",
+			args: args{data: &proto.Data{
+				DataId:     "5f0c5fd1-8c44-4b4c-9f6c-1f4a8b1e6b0a",
+				DataType:   1,
+				DataBinary: []byte("some text"),
+			}},
+			want:    models.Data{ID: "5f0c5fd1-8c44-4b4c-9f6c-1f4a8b1e6b0a"},
+			wantErr: false,
+		},
+		{
+			name: "positive test (invalid ID is replaced)",
+			args: args{data: &proto.Data{
+				DataId:     "invalid",
+				DataType:   1,
+				DataBinary: []byte("some text"),
+			}},
+			want:    models.Data{},
+			wantErr: false,
+		},
 	}
+	_, userKey, err := NewUserKey("userID")
+	assert.NoError(t, err)
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := EncryptPrivateData(tt.args.data, "userID")
+			got, err := EncryptPrivateData(tt.args.data, "userID", userKey)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("EncryptPrivateData() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			assert.NotNil(t, got)
+			if tt.want.ID != "" {
+				assert.Equal(t, tt.want.ID, got.ID)
+			} else {
+				_, err = uuid.Parse(got.ID)
+				assert.NoError(t, err)
+			}
+
+			version, err := GetEnvelopeVersion(got.DataBinary)
+			assert.NoError(t, err)
+			assert.Equal(t, UserKeyEnvelopeVersion, version)
 		})
 	}
 }
@@ -149,9 +337,151 @@ func Test_cipherInit(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := cipherInit(); (err != nil) != tt.wantErr {
+			if _, err := cipherInit(); (err != nil) != tt.wantErr {
 				t.Errorf("cipherInit() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
+
+func TestKeyRing(t *testing.T) {
+	t.Cleanup(func() {
+		err := SetKeyProvider(&RandomKeyProvider{})
+		assert.NoError(t, err)
+	})
+
+	oldKey := NewStaticKeyProvider(testMasterKey)
+	newKey := NewStaticKeyProvider("ffeeddccbbaa99887766554433221100ffeeddccbbaa99887766554433221100")
+
+	err := SetKeyProvider(oldKey)
+	assert.NoError(t, err)
+	oldKeyID, err := ActiveKeyID()
+	assert.NoError(t, err)
+
+	userKey, key, err := NewUserKey("userID")
+	assert.NoError(t, err)
+	data, err := EncryptPrivateData(&proto.Data{DataBinary: []byte("secret")}, "userID", key)
+	assert.NoError(t, err)
+
+	// legacy data encrypted with the master key
+	legacy := models.Data{ID: "legacyID", UserID: "userID"}
+	legacy.DataBinary, err = Encrypt([]byte("legacy secret"), RecordAdditionalData(legacy.ID, legacy.UserID))
+	assert.NoError(t, err)
+
+	keyID, err := EnvelopeKeyID(userKey.WrappedKey)
+	assert.NoError(t, err)
+	assert.Equal(t, oldKeyID, keyID)
+
+	// rotate master key: old key is retired but still can unwrap user keys
+	err = SetKeyProvider(newKey, oldKey)
+	assert.NoError(t, err)
+	newKeyID, err := ActiveKeyID()
+	assert.NoError(t, err)
+	assert.NotEqual(t, oldKeyID, newKeyID)
+
+	unwrapped, err := UnwrapUserKey(userKey)
+	assert.NoError(t, err)
+	assert.Equal(t, key, unwrapped)
+
+	// re-wrap user key with the active key, private data is not changed
+	rewrapped, err := RewrapUserKey(userKey)
+	assert.NoError(t, err)
+	keyID, err = EnvelopeKeyID(rewrapped.WrappedKey)
+	assert.NoError(t, err)
+	assert.Equal(t, newKeyID, keyID)
+
+	// migrate legacy data to the user key
+	migrated, err := ReencryptPrivateData(legacy, key)
+	assert.NoError(t, err)
+	assert.Equal(t, legacy.ID, migrated.ID)
+	version, err := GetEnvelopeVersion(migrated.DataBinary)
+	assert.NoError(t, err)
+	assert.Equal(t, UserKeyEnvelopeVersion, version)
+
+	// user key is not available without retired key
+	err = SetKeyProvider(newKey)
+	assert.NoError(t, err)
+	_, err = UnwrapUserKey(userKey)
+	assert.ErrorIs(t, err, ErrorUnknownKey)
+	_, err = DecryptPrivateData(legacy, nil)
+	assert.ErrorIs(t, err, ErrorUnknownKey)
+
+	unwrapped, err = UnwrapUserKey(rewrapped)
+	assert.NoError(t, err)
+	decrypted, err := DecryptPrivateData(data, unwrapped)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("secret"), decrypted.GetDataBinary())
+	decrypted, err = DecryptPrivateData(migrated, unwrapped)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("legacy secret"), decrypted.GetDataBinary())
+
+	err = SetKeyProvider(NewStaticKeyProvider(testMasterKey), NewStaticKeyProvider("invalid_key"))
+	assert.ErrorIs(t, err, ErrorInvalidMasterKey)
+}
+
+func TestDeriveKey(t *testing.T) {
+	t.Cleanup(func() {
+		err := SetKeyProvider(&RandomKeyProvider{})
+		assert.NoError(t, err)
+	})
+
+	err := SetKeyProvider(NewStaticKeyProvider(testMasterKey))
+	assert.NoError(t, err)
+	first, err := DeriveKey("purpose")
+	assert.NoError(t, err)
+	other, err := DeriveKey("another purpose")
+	assert.NoError(t, err)
+	assert.NotEqual(t, first, other)
+
+	// derived key is the same after restart with the same master key
+	err = SetKeyProvider(NewStaticKeyProvider(testMasterKey))
+	assert.NoError(t, err)
+	second, err := DeriveKey("purpose")
+	assert.NoError(t, err)
+	assert.Equal(t, first, second)
+}
+
+func TestEnvelopeKeyID(t *testing.T) {
+	encrypted, err := Encrypt([]byte("data"), nil)
+	assert.NoError(t, err)
+	activeKeyID, err := ActiveKeyID()
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []byte
+		wantErr bool
+	}{
+		{
+			name: "positive test",
+			data: encrypted,
+			want: activeKeyID,
+		},
+		{
+			name:    "negative test (invalid hex)",
+			data:    []byte("invalid"),
+			wantErr: true,
+		},
+		{
+			name:    "negative test (short envelope)",
+			data:    encrypted[:4],
+			wantErr: true,
+		},
+		{
+			name:    "negative test (unsupported version)",
+			data:    []byte("ff00000000"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := EnvelopeKeyID(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("EnvelopeKeyID() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
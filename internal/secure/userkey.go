@@ -0,0 +1,51 @@
+package secure
+
+import (
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/rand"
+)
+
+// UserKeySize defines size of the data encryption key of the user (AES-256).
+const UserKeySize = 32
+
+// UserKeyAdditionalData binds wrapped key to its owner.
+func UserKeyAdditionalData(userID string) []byte {
+	return []byte("user key|" + userID)
+}
+
+// NewUserKey generates random data encryption key of the user and wraps it with the active master key.
+func NewUserKey(userID string) (models.UserKey, []byte, error) {
+	key, err := rand.Bytes(UserKeySize)
+	if err != nil {
+		return models.UserKey{}, nil, err
+	}
+
+	wrappedKey, err := Encrypt(key, UserKeyAdditionalData(userID))
+	if err != nil {
+		return models.UserKey{}, nil, err
+	}
+
+	return models.UserKey{UserID: userID, WrappedKey: wrappedKey}, key, nil
+}
+
+// UnwrapUserKey returns data encryption key of the user.
+func UnwrapUserKey(userKey models.UserKey) ([]byte, error) {
+	return Decrypt(userKey.WrappedKey, UserKeyAdditionalData(userKey.UserID))
+}
+
+// RewrapUserKey wraps data encryption key of the user with the active master key.
+// Private data encrypted with the user key is not changed.
+func RewrapUserKey(userKey models.UserKey) (models.UserKey, error) {
+	key, err := UnwrapUserKey(userKey)
+	if err != nil {
+		return models.UserKey{}, err
+	}
+
+	wrappedKey, err := Encrypt(key, UserKeyAdditionalData(userKey.UserID))
+	if err != nil {
+		return models.UserKey{}, err
+	}
+
+	userKey.WrappedKey = wrappedKey
+	return userKey, nil
+}
@@ -0,0 +1,48 @@
+package secure
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+)
+
+func TestUnwrapUserKey(t *testing.T) {
+	userKey, key, err := NewUserKey("userID")
+	assert.NoError(t, err)
+	assert.Len(t, key, UserKeySize)
+
+	tests := []struct {
+		name    string
+		userKey models.UserKey
+		wantErr bool
+	}{
+		{
+			name:    "positive test",
+			userKey: userKey,
+			wantErr: false,
+		},
+		{
+			name:    "negative test (key of another user)",
+			userKey: models.UserKey{UserID: "anotherUserID", WrappedKey: userKey.WrappedKey},
+			wantErr: true,
+		},
+		{
+			name:    "negative test (destroyed key)",
+			userKey: models.UserKey{UserID: "userID"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := UnwrapUserKey(tt.userKey)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("UnwrapUserKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr {
+				assert.Equal(t, key, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package secure
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/rand"
+	"golang.org/x/crypto/argon2"
+)
+
+// Default Argon2id parameters for derivation of the key from the user password (RFC 9106).
+const (
+	DefaultKdfTime    uint32 = 3
+	DefaultKdfMemory  uint32 = 64 * 1024
+	DefaultKdfThreads uint32 = 4
+	// Upper bounds of Argon2id parameters. Parameters of the vault come from the server,
+	// so a hostile server cannot exhaust memory or CPU of the client.
+	MaxKdfTime    uint32 = 10
+	MaxKdfMemory  uint32 = 1024 * 1024
+	MaxKdfThreads uint32 = 255
+	// VaultKeySize defines size of the vault key (AES-256).
+	VaultKeySize = 32
+	saltSize     = 16
+)
+
+// vaultKeyAdditionalData binds wrapped vault key to its purpose.
+var vaultKeyAdditionalData = []byte("gophkeeper vault key")
+
+// tokenKeyAdditionalData binds vault key wrapped for personal access token to its purpose.
+var tokenKeyAdditionalData = []byte("gophkeeper access token key")
+
+// ErrorInvalidVault defines an error for vault with invalid parameters.
+var ErrorInvalidVault = errors.New("vault has invalid parameters")
+
+// ErrorVaultLocked defines an error for vault which cannot be unlocked with the password.
+var ErrorVaultLocked = errors.New("vault cannot be unlocked with the password")
+
+// NewVault generates random vault key and wraps it with the key derived from the password.
+// Vault is stored on the server, vault key is used only on the client side.
+func NewVault(password string) (models.Vault, []byte, error) {
+	vault := models.Vault{
+		Salt:       make([]byte, saltSize),
+		KdfTime:    DefaultKdfTime,
+		KdfMemory:  DefaultKdfMemory,
+		KdfThreads: DefaultKdfThreads,
+	}
+	if err := rand.Read(vault.Salt); err != nil {
+		return models.Vault{}, nil, err
+	}
+
+	vaultKey, err := rand.Bytes(VaultKeySize)
+	if err != nil {
+		return models.Vault{}, nil, err
+	}
+
+	wrappedKey, err := EncryptWithKey(DerivePasswordKey(password, vault), vaultKey, vaultKeyAdditionalData)
+	if err != nil {
+		return models.Vault{}, nil, err
+	}
+	vault.WrappedKey = wrappedKey
+
+	return vault, vaultKey, nil
+}
+
+// UnlockVault derives the key from the password and unwraps vault key. Vault with KDF parameters
+// out of bounds is not unlocked (ErrorInvalidVault).
+func UnlockVault(password string, vault models.Vault) ([]byte, error) {
+	if len(vault.Salt) == 0 || vault.KdfTime == 0 || vault.KdfTime > MaxKdfTime ||
+		vault.KdfMemory == 0 || vault.KdfMemory > MaxKdfMemory ||
+		vault.KdfThreads == 0 || vault.KdfThreads > MaxKdfThreads {
+		return nil, ErrorInvalidVault
+	}
+
+	vaultKey, err := DecryptWithKey(DerivePasswordKey(password, vault), vault.WrappedKey, vaultKeyAdditionalData)
+	if err != nil {
+		return nil, ErrorVaultLocked
+	}
+	return vaultKey, nil
+}
+
+// RewrapVault wraps vault key with the key derived from the new password (change of the password).
+// Vault key is not changed, so private data is not re-encrypted.
+func RewrapVault(oldPassword string, newPassword string, vault models.Vault) (models.Vault, error) {
+	vaultKey, err := UnlockVault(oldPassword, vault)
+	if err != nil {
+		return models.Vault{}, err
+	}
+
+	rewrapped := models.Vault{
+		UserID:     vault.UserID,
+		Salt:       make([]byte, saltSize),
+		KdfTime:    DefaultKdfTime,
+		KdfMemory:  DefaultKdfMemory,
+		KdfThreads: DefaultKdfThreads,
+	}
+	if err := rand.Read(rewrapped.Salt); err != nil {
+		return models.Vault{}, err
+	}
+
+	rewrapped.WrappedKey, err = EncryptWithKey(DerivePasswordKey(newPassword, rewrapped), vaultKey, vaultKeyAdditionalData)
+	if err != nil {
+		return models.Vault{}, err
+	}
+	return rewrapped, nil
+}
+
+// WrapVaultKeyForToken generates random key of personal access token and wraps vault key with it.
+// Returns the token key and wrapped vault key. Token key is kept by the client only,
+// so the server cannot unwrap vault key with the stored data.
+func WrapVaultKeyForToken(vaultKey []byte) ([]byte, []byte, error) {
+	tokenKey, err := rand.Bytes(VaultKeySize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wrappedKey, err := EncryptWithKey(tokenKey, vaultKey, tokenKeyAdditionalData)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tokenKey, wrappedKey, nil
+}
+
+// UnwrapVaultKeyWithToken unwraps vault key with the key of personal access token.
+func UnwrapVaultKeyWithToken(tokenKey []byte, wrappedKey []byte) ([]byte, error) {
+	vaultKey, err := DecryptWithKey(tokenKey, wrappedKey, tokenKeyAdditionalData)
+	if err != nil {
+		return nil, ErrorVaultLocked
+	}
+	return vaultKey, nil
+}
+
+// DerivePasswordKey derives the key from the password with Argon2id and parameters of the vault.
+func DerivePasswordKey(password string, vault models.Vault) []byte {
+	return argon2.IDKey([]byte(password), vault.Salt, vault.KdfTime, vault.KdfMemory, uint8(vault.KdfThreads), VaultKeySize)
+}
+
+// EncryptWithKey encrypts data with AES-GCM and the specified key. Result is: nonce | ciphertext.
+func EncryptWithKey(key []byte, data []byte, additionalData []byte) ([]byte, error) {
+	aesgcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aesgcm.NonceSize(), aesgcm.NonceSize()+len(data)+aesgcm.Overhead())
+	if err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aesgcm.Seal(nonce, nonce, data, additionalData), nil
+}
+
+// DecryptWithKey decrypts data encrypted by EncryptWithKey.
+func DecryptWithKey(key []byte, data []byte, additionalData []byte) ([]byte, error) {
+	aesgcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < aesgcm.NonceSize()+aesgcm.Overhead() {
+		return nil, ErrorInvalidEnvelope
+	}
+	nonce := data[:aesgcm.NonceSize()]
+	return aesgcm.Open(nil, nonce, data[aesgcm.NonceSize():], additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	aesblock, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(aesblock)
+}
@@ -0,0 +1,182 @@
+package secure
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+)
+
+func TestUnlockVault(t *testing.T) {
+	vault, vaultKey, err := NewVault("password")
+	require.NoError(t, err)
+	assert.Len(t, vaultKey, VaultKeySize)
+	assert.NotContains(t, string(vault.WrappedKey), string(vaultKey))
+
+	invalidVault := vault
+	invalidVault.KdfThreads = 0
+
+	tests := []struct {
+		name     string
+		password string
+		vault    models.Vault
+		wantErr  error
+	}{
+		{
+			name:     "positive test",
+			password: "password",
+			vault:    vault,
+			wantErr:  nil,
+		},
+		{
+			name:     "negative test (invalid password)",
+			password: "invalid_password",
+			vault:    vault,
+			wantErr:  ErrorVaultLocked,
+		},
+		{
+			name:     "negative test (invalid vault)",
+			password: "password",
+			vault:    invalidVault,
+			wantErr:  ErrorInvalidVault,
+		},
+		{
+			name:     "negative test (KDF memory above the limit)",
+			password: "password",
+			vault:    models.Vault{Salt: vault.Salt, KdfTime: 1, KdfMemory: 4 * 1024 * 1024, KdfThreads: 1},
+			wantErr:  ErrorInvalidVault,
+		},
+		{
+			name:     "negative test (KDF time above the limit)",
+			password: "password",
+			vault:    models.Vault{Salt: vault.Salt, KdfTime: 1000, KdfMemory: 8, KdfThreads: 1},
+			wantErr:  ErrorInvalidVault,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := UnlockVault(tt.password, tt.vault)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, vaultKey, got)
+		})
+	}
+}
+
+func TestRewrapVault(t *testing.T) {
+	vault, vaultKey, err := NewVault("password")
+	require.NoError(t, err)
+
+	_, err = RewrapVault("invalid_password", "new_password", vault)
+	assert.ErrorIs(t, err, ErrorVaultLocked)
+
+	rewrapped, err := RewrapVault("password", "new_password", vault)
+	require.NoError(t, err)
+	assert.NotEqual(t, vault.Salt, rewrapped.Salt)
+
+	// vault key is the same, old password is not valid anymore
+	got, err := UnlockVault("new_password", rewrapped)
+	assert.NoError(t, err)
+	assert.Equal(t, vaultKey, got)
+	_, err = UnlockVault("password", rewrapped)
+	assert.ErrorIs(t, err, ErrorVaultLocked)
+}
+
+func TestWrapVaultKeyForToken(t *testing.T) {
+	_, vaultKey, err := NewVault("password")
+	require.NoError(t, err)
+
+	tokenKey, wrappedKey, err := WrapVaultKeyForToken(vaultKey)
+	require.NoError(t, err)
+	assert.Len(t, tokenKey, VaultKeySize)
+
+	got, err := UnwrapVaultKeyWithToken(tokenKey, wrappedKey)
+	assert.NoError(t, err)
+	assert.Equal(t, vaultKey, got)
+
+	otherKey, _, err := WrapVaultKeyForToken(vaultKey)
+	require.NoError(t, err)
+	_, err = UnwrapVaultKeyWithToken(otherKey, wrappedKey)
+	assert.ErrorIs(t, err, ErrorVaultLocked)
+}
+
+func TestDerivePasswordKey(t *testing.T) {
+	vault, _, err := NewVault("password")
+	require.NoError(t, err)
+
+	// the same password and parameters derive the same key
+	assert.Equal(t, DerivePasswordKey("password", vault), DerivePasswordKey("password", vault))
+	assert.NotEqual(t, DerivePasswordKey("password", vault), DerivePasswordKey("another", vault))
+
+	// every vault has random salt
+	another, _, err := NewVault("password")
+	require.NoError(t, err)
+	assert.NotEqual(t, vault.Salt, another.Salt)
+	assert.NotEqual(t, DerivePasswordKey("password", vault), DerivePasswordKey("password", another))
+}
+
+func TestEncryptWithKey(t *testing.T) {
+	_, vaultKey, err := NewVault("password")
+	require.NoError(t, err)
+	_, anotherKey, err := NewVault("password")
+	require.NoError(t, err)
+
+	data := []byte("private data")
+	aad := []byte("additional data")
+
+	encrypted, err := EncryptWithKey(vaultKey, data, aad)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		key     []byte
+		data    []byte
+		aad     []byte
+		wantErr bool
+	}{
+		{
+			name:    "positive test",
+			key:     vaultKey,
+			data:    encrypted,
+			aad:     aad,
+			wantErr: false,
+		},
+		{
+			name:    "negative test (another key)",
+			key:     anotherKey,
+			data:    encrypted,
+			aad:     aad,
+			wantErr: true,
+		},
+		{
+			name:    "negative test (another additional data)",
+			key:     vaultKey,
+			data:    encrypted,
+			aad:     []byte("another additional data"),
+			wantErr: true,
+		},
+		{
+			name:    "negative test (truncated data)",
+			key:     vaultKey,
+			data:    encrypted[:10],
+			aad:     aad,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecryptWithKey(tt.key, tt.data, tt.aad)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("DecryptWithKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr {
+				assert.Equal(t, data, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+	pb "github.com/vstebletsov89/go-developer-course-gophkeeper/internal/proto"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/service/auth"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// errorScopeDenied is returned for request which is not allowed by scope of personal access token.
+var errorScopeDenied = status.Error(codes.PermissionDenied, "request is not allowed by scope of the access token")
+
+// CreateAccessToken creates personal access token of the current user. Token is returned once,
+// only hash of the token is stored. Vault key wrapped by the client with the key of the token
+// is returned by GetVault to the token owner.
+func (a *AuthServer) CreateAccessToken(ctx context.Context, request *pb.CreateAccessTokenRequest) (*pb.CreateAccessTokenResponse, error) {
+	var response pb.CreateAccessTokenResponse
+	userID := auth.ExtractUserIDFromContext(ctx)
+
+	if request.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "token name is required")
+	}
+	if request.GetExpiresIn() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid token expiration")
+	}
+
+	dataTypes := make([]models.DataType, 0, len(request.GetDataTypes()))
+	for _, name := range request.GetDataTypes() {
+		dataType, err := models.ParseDataType(name)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		dataTypes = append(dataTypes, dataType)
+	}
+	if err := a.checkDataOwner(ctx, userID, request.GetDataIds()); err != nil {
+		return nil, err
+	}
+
+	token, err := auth.GeneratePersonalToken()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	accessToken := models.AccessToken{
+		ID:         uuid.NewString(),
+		UserID:     userID,
+		Name:       request.GetName(),
+		TokenHash:  auth.HashPersonalToken(token),
+		ReadOnly:   request.GetReadOnly(),
+		DataIDs:    request.GetDataIds(),
+		DataTypes:  dataTypes,
+		WrappedKey: request.GetWrappedKey(),
+		CreatedAt:  time.Now(),
+	}
+	if request.GetExpiresIn() > 0 {
+		expiresAt := accessToken.CreatedAt.Add(time.Duration(request.GetExpiresIn()) * time.Second)
+		accessToken.ExpiresAt = &expiresAt
+	}
+
+	err = a.service.SaveAccessToken(ctx, accessToken)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	response.AccessToken = accessTokenToProto(accessToken)
+	response.Token = token
+
+	log.Debug().Msg("Server (CreateAccessToken): done")
+	return &response, nil
+}
+
+// ListAccessTokens returns personal access tokens of the current user (without secret part).
+func (a *AuthServer) ListAccessTokens(ctx context.Context, request *pb.ListAccessTokensRequest) (*pb.ListAccessTokensResponse, error) {
+	var response pb.ListAccessTokensResponse
+	userID := auth.ExtractUserIDFromContext(ctx)
+
+	tokens, err := a.service.GetAccessTokens(ctx, userID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	response.AccessTokens = make([]*pb.AccessToken, 0, len(tokens))
+	for _, token := range tokens {
+		response.AccessTokens = append(response.AccessTokens, accessTokenToProto(token))
+	}
+
+	log.Debug().Msg("Server (ListAccessTokens): done")
+	return &response, nil
+}
+
+// RevokeAccessToken revokes personal access token of the current user.
+func (a *AuthServer) RevokeAccessToken(ctx context.Context, request *pb.RevokeAccessTokenRequest) (*pb.RevokeAccessTokenResponse, error) {
+	var response pb.RevokeAccessTokenResponse
+	userID := auth.ExtractUserIDFromContext(ctx)
+
+	if _, err := uuid.Parse(request.GetId()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid token id")
+	}
+
+	err := a.service.RevokeAccessToken(ctx, userID, request.GetId())
+	if errors.Is(err, storage.ErrorAccessTokenNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.Debug().Msg("Server (RevokeAccessToken): done")
+	return &response, nil
+}
+
+// checkDataOwner checks that private data belongs to the user.
+func (a *AuthServer) checkDataOwner(ctx context.Context, userID string, dataIDs []string) error {
+	for _, id := range dataIDs {
+		if _, err := uuid.Parse(id); err != nil {
+			return status.Errorf(codes.InvalidArgument, "unknown data %s", id)
+		}
+		_, err := a.service.GetDataByID(ctx, userID, id)
+		if errors.Is(err, storage.ErrorPrivateDataNotFound) {
+			return status.Errorf(codes.InvalidArgument, "unknown data %s", id)
+		}
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+	return nil
+}
+
+func accessTokenToProto(token models.AccessToken) *pb.AccessToken {
+	result := &pb.AccessToken{
+		Id:        token.ID,
+		Name:      token.Name,
+		ReadOnly:  token.ReadOnly,
+		DataIds:   token.DataIDs,
+		CreatedAt: token.CreatedAt.Unix(),
+	}
+	for _, dataType := range token.DataTypes {
+		result.DataTypes = append(result.DataTypes, dataType.String())
+	}
+	if token.ExpiresAt != nil {
+		result.ExpiresAt = token.ExpiresAt.Unix()
+	}
+	if token.LastUsedAt != nil {
+		result.LastUsedAt = token.LastUsedAt.Unix()
+	}
+	return result
+}
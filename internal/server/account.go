@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/rs/zerolog/log"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+	pb "github.com/vstebletsov89/go-developer-course-gophkeeper/internal/proto"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/secure"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/service/auth"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// ChangePassword replaces SRP verifier of the current user after verification of the old password
+// (SRP proof or password in legacy mode). All tokens of the user are revoked, new tokens are returned
+// for the current device. Vault wrapped with the new password is required for users with client side encryption.
+func (a *AuthServer) ChangePassword(ctx context.Context, request *pb.ChangePasswordRequest) (*pb.ChangePasswordResponse, error) {
+	var response pb.ChangePasswordResponse
+	userID := auth.ExtractUserIDFromContext(ctx)
+
+	userDB, err := a.authorizeUser(ctx, userID, request.GetOldPassword(), request.GetProof())
+	if err != nil {
+		return nil, err
+	}
+	if err := a.setCredentials(&userDB, request.GetNewPassword(), request.GetSrp()); err != nil {
+		return nil, err
+	}
+
+	var vault *models.Vault
+	if request.GetVault() != nil {
+		if len(request.GetVault().GetSalt()) == 0 || len(request.GetVault().GetWrappedKey()) == 0 {
+			return nil, status.Error(codes.InvalidArgument, secure.ErrorInvalidVault.Error())
+		}
+		v := vaultFromProto(request.GetVault(), userID)
+		vault = &v
+	} else {
+		// vault wrapped with the old password cannot be unlocked after the change
+		_, err := a.service.GetVault(ctx, userID)
+		if err == nil {
+			return nil, status.Error(codes.InvalidArgument, "vault wrapped with the new password is required")
+		}
+		if !errors.Is(err, storage.ErrorVaultNotFound) {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	err = a.service.UpdateUserPassword(ctx, userDB, vault)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	// tokens of the current session are revoked with the session, new tokens below stay valid
+	err = a.service.RevokeUserTokens(ctx, userID, time.Now())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	// current device continues with new session
+	client := &pb.ClientInfo{}
+	if claims := auth.ExtractClaimsFromContext(ctx); claims != nil && claims.SessionID != "" {
+		session, err := a.service.GetSession(ctx, userID, claims.SessionID)
+		if err != nil && !errors.Is(err, storage.ErrorSessionNotFound) {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		client.DeviceName = session.DeviceName
+		client.ClientVersion = session.ClientVersion
+	}
+	response.Token, err = a.startSession(ctx, userID, client)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug().Msg("Server (ChangePassword): done")
+	return &response, nil
+}
+
+// DeleteAccount deletes the current user with all private data after verification of the password
+// (SRP proof or password in legacy mode). Code of the second factor is required if two-factor authentication is enabled.
+func (a *AuthServer) DeleteAccount(ctx context.Context, request *pb.DeleteAccountRequest) (*pb.DeleteAccountResponse, error) {
+	var response pb.DeleteAccountResponse
+	userID := auth.ExtractUserIDFromContext(ctx)
+
+	if _, err := a.authorizeUser(ctx, userID, request.GetPassword(), request.GetProof()); err != nil {
+		return nil, err
+	}
+
+	twoFactorEnabled, err := a.isTwoFactorEnabled(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if twoFactorEnabled {
+		twoFactor, err := a.getTwoFactor(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.verifyTwoFactorCode(ctx, twoFactor, request.GetCode()); err != nil {
+			return nil, err
+		}
+	}
+
+	err = a.service.DeleteUser(ctx, userID)
+	if errors.Is(err, storage.ErrorUserNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.Debug().Msg("Server (DeleteAccount): done")
+	return &response, nil
+}
+
+// authorizeUser verifies password of the current user with proof from SRP handshake.
+// Password itself is verified only in legacy mode. Failed attempts are tracked like in login,
+// so the password cannot be guessed with access token of the user.
+func (a *AuthServer) authorizeUser(ctx context.Context, userID string, password string, proof *pb.SRPProof) (models.User, error) {
+	userDB, err := a.service.GetUserByID(ctx, userID)
+	if errors.Is(err, storage.ErrorUserNotFound) {
+		return models.User{}, status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err != nil {
+		return models.User{}, status.Error(codes.Internal, err.Error())
+	}
+
+	loginKey := loginAttemptKey(userDB.Login)
+	peerKey := peerAttemptKey(ctx)
+	if err := a.checkAttempts(ctx, loginKey, peerKey); err != nil {
+		return models.User{}, err
+	}
+
+	if proof != nil {
+		_, err = a.verifySRPProof(ctx, userDB, proof)
+	} else {
+		err = a.verifyPassword(userDB, password)
+	}
+	if status.Code(err) == codes.Unauthenticated {
+		return models.User{}, a.addFailure(ctx, err, loginKey, peerKey)
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+
+	// failures of the address are not reset, otherwise own account could be used to continue brute force
+	if err := a.service.DeleteLoginAttempts(ctx, loginKey); err != nil {
+		return models.User{}, status.Error(codes.Internal, err.Error())
+	}
+	return userDB, nil
+}
+
+// verifyPassword verifies password of the user in legacy mode.
+func (a *AuthServer) verifyPassword(userDB models.User, password string) error {
+	if !a.legacyLogin {
+		return errorLegacyLoginDisabled
+	}
+
+	user := models.User{Login: userDB.Login, Password: password}
+	ok, err := auth.IsUserAuthorized(&user, &userDB)
+	if err != nil || !ok {
+		return status.Error(codes.Unauthenticated, "incorrect password")
+	}
+	return nil
+}
@@ -2,59 +2,114 @@ package server
 
 import (
 	"context"
+	"crypto/x509"
+	"errors"
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
 	pb "github.com/vstebletsov89/go-developer-course-gophkeeper/internal/proto"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/secure"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/service"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/service/auth"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/storage"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"time"
 )
 
 // AuthServer represents a structure for authorization service.
 type AuthServer struct {
 	pb.UnimplementedAuthServer
-	service service.Service
-	jwt     auth.JWT
+	service         service.Service
+	jwt             auth.JWT
+	refreshTokenTTL time.Duration
+	lockout         auth.LockoutPolicy
+	deviceCA        *auth.DeviceCA
+	deviceCertTTL   time.Duration
+	// legacyLogin enables login with password (bcrypt) for users without SRP verifier
+	legacyLogin bool
 }
 
 // NewAuthServer returns an instance of AuthServer.
-func NewAuthServer(service service.Service, jwt auth.JWT) *AuthServer {
-	return &AuthServer{service: service, jwt: jwt}
+func NewAuthServer(service service.Service, jwt auth.JWT, refreshTokenTTL time.Duration, lockout auth.LockoutPolicy) *AuthServer {
+	return &AuthServer{service: service, jwt: jwt, refreshTokenTTL: refreshTokenTTL, lockout: lockout}
 }
 
-// Register is a registration of the new user with encrypted password.
+// SetDeviceCA sets certificate authority for client certificates of the user devices (mTLS).
+func (a *AuthServer) SetDeviceCA(ca *auth.DeviceCA, certTTL time.Duration) {
+	a.deviceCA = ca
+	a.deviceCertTTL = certTTL
+}
+
+// SetLegacyLogin enables registration and login with password (bcrypt) instead of SRP handshake.
+func (a *AuthServer) SetLegacyLogin(enabled bool) {
+	a.legacyLogin = enabled
+}
+
+// Register is a registration of the new user with SRP verifier. Password is accepted only in legacy mode.
 func (a *AuthServer) Register(ctx context.Context, request *pb.RegisterRequest) (*pb.RegisterResponse, error) {
 	var response pb.RegisterResponse
 
-	encryptedPassword, err := auth.EncryptPassword(request.GetUser().GetPassword())
+	user := models.User{
+		ID:    uuid.NewString(),
+		Login: request.GetUser().GetLogin(),
+	}
+	if err := a.setCredentials(&user, request.GetUser().GetPassword(), request.GetSrp()); err != nil {
+		return nil, err
+	}
+
+	err := a.service.RegisterUser(ctx, user)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	user := models.User{
-		ID:       uuid.NewString(),
-		Login:    request.GetUser().GetLogin(),
-		Password: encryptedPassword,
+	// data encryption key of the user is wrapped by the master key
+	userKey, _, err := secure.NewUserKey(user.ID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
 	}
-
-	err = a.service.RegisterUser(ctx, user)
+	err = a.service.SaveUserKey(ctx, userKey)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
+	// vault is optional for legacy clients without client side encryption
+	if request.GetVault() != nil {
+		if err := a.saveVault(ctx, user.ID, request.GetVault()); err != nil {
+			return nil, err
+		}
+	}
+
 	log.Debug().Msg("Server (Register): done")
 	return &response, nil
 }
 
-// Login is a login of existing user in the service. JWT token returns in case of success.
+// Login is a login of existing user with password (legacy mode). JWT token returns in case of success.
+// Legacy user is upgraded to SRP if the client sends verifier of the password.
 func (a *AuthServer) Login(ctx context.Context, request *pb.LoginRequest) (*pb.LoginResponse, error) {
-	var response pb.LoginResponse
+	if !a.legacyLogin {
+		return nil, errorLegacyLoginDisabled
+	}
+
+	// failed attempts are tracked for the login and address of the client
+	loginKey := loginAttemptKey(request.GetUser().GetLogin())
+	peerKey := peerAttemptKey(ctx)
+	if err := a.checkAttempts(ctx, loginKey, peerKey); err != nil {
+		return nil, err
+	}
 
 	userDB, err := a.service.GetUserByLogin(ctx, request.GetUser().GetLogin())
+	if errors.Is(err, storage.ErrorUserNotFound) {
+		auth.CompareDummyPassword(request.GetUser().GetPassword())
+		return nil, a.addFailure(ctx, errorIncorrectCredentials, loginKey, peerKey)
+	}
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "cannot find user: %v", err)
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if userDB.Password == "" {
+		// user upgraded to SRP has no password hash, response takes the same time as for unknown user
+		auth.CompareDummyPassword(request.GetUser().GetPassword())
+		return nil, a.addFailure(ctx, errorIncorrectCredentials, loginKey, peerKey)
 	}
 	user := models.User{
 		ID:       "",
@@ -64,20 +119,321 @@ func (a *AuthServer) Login(ctx context.Context, request *pb.LoginRequest) (*pb.L
 
 	ok, err := auth.IsUserAuthorized(&user, &userDB)
 	if err != nil || !ok {
-		return nil, status.Errorf(codes.Unauthenticated, "incorrect username/password")
+		return nil, a.addFailure(ctx, errorIncorrectCredentials, loginKey, peerKey)
+	}
+
+	// failures of the address are not reset, otherwise own account could be used to continue brute force
+	if err := a.service.DeleteLoginAttempts(ctx, loginKey); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if request.GetSrp() != nil {
+		if err := a.setCredentials(&userDB, "", request.GetSrp()); err != nil {
+			return nil, err
+		}
+		if err := a.service.UpdateUserPassword(ctx, userDB, nil); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		log.Info().Msgf("User %s was upgraded to SRP login", userDB.ID)
+	}
+
+	response, err := a.completeLogin(ctx, userDB.ID, request.GetClient())
+	if err != nil {
+		return nil, err
+	}
+	// upgrade is reported only after the password is verified
+	response.UpgradeRequired = len(userDB.SRPVerifier) == 0
+
+	log.Debug().Msg("Server (Login): done")
+	return response, nil
+}
+
+// completeLogin issues tokens for the user after check of the password. Challenge for the second factor
+// is returned instead of tokens if two-factor authentication is enabled.
+func (a *AuthServer) completeLogin(ctx context.Context, userID string, client *pb.ClientInfo) (*pb.LoginResponse, error) {
+	var response pb.LoginResponse
+
+	twoFactorEnabled, err := a.isTwoFactorEnabled(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if twoFactorEnabled {
+		// tokens are issued by VerifyTwoFactor after check of the second factor
+		response.TwoFactorRequired = true
+		response.Challenge, err = a.jwt.GenerateChallenge(userID)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "cannot generate challenge")
+		}
+
+		log.Debug().Msg("Server (Login): second factor required")
+		return &response, nil
+	}
+
+	response.Token, err = a.startSession(ctx, userID, client)
+	if err != nil {
+		return nil, err
+	}
+	response.Vault, err = a.loginVault(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+// loginVault returns vault of the user or nil for legacy user without vault.
+func (a *AuthServer) loginVault(ctx context.Context, userID string) (*pb.Vault, error) {
+	vault, err := a.service.GetVault(ctx, userID)
+	if errors.Is(err, storage.ErrorVaultNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return vaultToProto(vault), nil
+}
+
+// Refresh exchanges refresh token for new access and refresh tokens. Every refresh token is used once,
+// reuse of the rotated token revokes the whole family (session) because the token is probably stolen.
+func (a *AuthServer) Refresh(ctx context.Context, request *pb.RefreshRequest) (*pb.RefreshResponse, error) {
+	var response pb.RefreshResponse
+	tokenHash := auth.HashRefreshToken(request.GetRefreshToken())
+
+	refreshToken, err := a.service.GetRefreshToken(ctx, tokenHash)
+	if errors.Is(err, storage.ErrorRefreshTokenNotFound) {
+		return nil, status.Error(codes.Unauthenticated, "invalid refresh token")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if refreshToken.Revoked {
+		return nil, status.Error(codes.Unauthenticated, "refresh token revoked")
+	}
+	if refreshToken.Used {
+		return nil, a.revokeReusedToken(ctx, refreshToken)
+	}
+	if time.Now().After(refreshToken.ExpiresAt) {
+		return nil, status.Error(codes.Unauthenticated, "refresh token expired")
+	}
+
+	err = a.service.UseRefreshToken(ctx, tokenHash)
+	if errors.Is(err, storage.ErrorRefreshTokenNotFound) {
+		// token was used by concurrent request
+		return nil, a.revokeReusedToken(ctx, refreshToken)
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	err = a.service.TouchSession(ctx, refreshToken.FamilyID, time.Now())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	token, err := a.jwt.GenerateToken(userDB.ID)
+	response.Token, err = a.issueTokens(ctx, refreshToken.UserID, refreshToken.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug().Msg("Server (Refresh): done")
+	return &response, nil
+}
+
+func (a *AuthServer) revokeReusedToken(ctx context.Context, refreshToken models.RefreshToken) error {
+	log.Warn().Msgf("Refresh token reuse detected (user %s), session revoked", refreshToken.UserID)
+	if err := a.service.RevokeRefreshTokenFamily(ctx, refreshToken.FamilyID); err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	// access tokens of the session are revoked as well
+	err := a.service.RevokeSession(ctx, refreshToken.UserID, refreshToken.FamilyID)
+	if err != nil && !errors.Is(err, storage.ErrorSessionNotFound) {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return status.Error(codes.Unauthenticated, "refresh token reused")
+}
+
+// issueTokens generates access token and next refresh token of the family. Family of refresh tokens is the session.
+func (a *AuthServer) issueTokens(ctx context.Context, userID string, familyID string) (*pb.Token, error) {
+	accessToken, err := a.jwt.GenerateToken(userID, familyID)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "cannot generate access token")
 	}
 
-	response.User = request.GetUser()
-	response.Token = &pb.Token{
-		UserId: userDB.ID,
-		Token:  token,
+	refreshToken, err := auth.GenerateRefreshToken()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "cannot generate refresh token")
 	}
 
-	log.Debug().Msg("Server (Login): done")
+	err = a.service.SaveRefreshToken(ctx, models.RefreshToken{
+		TokenHash: auth.HashRefreshToken(refreshToken),
+		UserID:    userID,
+		FamilyID:  familyID,
+		ExpiresAt: time.Now().Add(a.refreshTokenTTL),
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return &pb.Token{
+		UserId:       userID,
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// Logout revokes access token of the current user and refresh tokens of the session.
+// All tokens of the user are revoked for all devices option.
+func (a *AuthServer) Logout(ctx context.Context, request *pb.LogoutRequest) (*pb.LogoutResponse, error) {
+	var response pb.LogoutResponse
+	userID := auth.ExtractUserIDFromContext(ctx)
+	claims := auth.ExtractClaimsFromContext(ctx)
+	if claims == nil {
+		return nil, status.Error(codes.Unauthenticated, "access token is required")
+	}
+
+	err := a.service.RevokeToken(ctx, models.RevokedToken{
+		ID:        claims.ID,
+		UserID:    userID,
+		ExpiresAt: claims.ExpiresAt.Time,
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if request.GetRefreshToken() != "" {
+		refreshToken, err := a.service.GetRefreshToken(ctx, auth.HashRefreshToken(request.GetRefreshToken()))
+		if err != nil && !errors.Is(err, storage.ErrorRefreshTokenNotFound) {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		// refresh token of another user is ignored
+		if err == nil && refreshToken.UserID == userID {
+			if err := a.service.RevokeRefreshTokenFamily(ctx, refreshToken.FamilyID); err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+		}
+	}
+
+	if claims.SessionID != "" {
+		err := a.service.RevokeSession(ctx, userID, claims.SessionID)
+		if err != nil && !errors.Is(err, storage.ErrorSessionNotFound) {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	if request.GetAllDevices() {
+		if err := a.service.RevokeUserTokens(ctx, userID, time.Now()); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+	}
+
+	log.Debug().Msg("Server (Logout): done")
+	return &response, nil
+}
+
+// GetSigningKeys returns public keys for verification of access tokens (JWKS). Shared secret is never returned,
+// so the list is empty for HS256 tokens.
+func (a *AuthServer) GetSigningKeys(ctx context.Context, request *pb.GetSigningKeysRequest) (*pb.GetSigningKeysResponse, error) {
+	var response pb.GetSigningKeysResponse
+
+	keys := a.jwt.VerificationKeys()
+	for _, key := range keys {
+		publicKey, err := x509.MarshalPKIXPublicKey(key.PublicKey)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		response.Keys = append(response.Keys, &pb.SigningKey{
+			Kid:       key.ID,
+			Alg:       key.Algorithm,
+			PublicKey: publicKey,
+		})
+	}
+
+	jwks, err := auth.JWKS(keys)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	response.Jwks = string(jwks)
+
+	log.Debug().Msg("Server (GetSigningKeys): done")
+	return &response, nil
+}
+
+// GetVault returns parameters of client side encryption for the current user.
+func (a *AuthServer) GetVault(ctx context.Context, request *pb.GetVaultRequest) (*pb.GetVaultResponse, error) {
+	var response pb.GetVaultResponse
+	userID := auth.ExtractUserIDFromContext(ctx)
+
+	// personal access token unlocks vault with its own key instead of the password
+	if scope := auth.ExtractScopeFromContext(ctx); scope != nil {
+		if len(scope.WrappedKey) == 0 {
+			return nil, status.Error(codes.NotFound, storage.ErrorVaultNotFound.Error())
+		}
+		response.Vault = &pb.Vault{WrappedKey: scope.WrappedKey}
+
+		log.Debug().Msg("Server (GetVault): done")
+		return &response, nil
+	}
+
+	vault, err := a.service.GetVault(ctx, userID)
+	if errors.Is(err, storage.ErrorVaultNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	response.Vault = vaultToProto(vault)
+
+	log.Debug().Msg("Server (GetVault): done")
+	return &response, nil
+}
+
+// SetVault saves parameters of client side encryption for the current user (legacy users without vault).
+// Existing vault cannot be replaced.
+func (a *AuthServer) SetVault(ctx context.Context, request *pb.SetVaultRequest) (*pb.SetVaultResponse, error) {
+	var response pb.SetVaultResponse
+	userID := auth.ExtractUserIDFromContext(ctx)
+
+	if err := a.saveVault(ctx, userID, request.GetVault()); err != nil {
+		return nil, err
+	}
+
+	log.Debug().Msg("Server (SetVault): done")
 	return &response, nil
 }
+
+func (a *AuthServer) saveVault(ctx context.Context, userID string, vault *pb.Vault) error {
+	if len(vault.GetSalt()) == 0 || len(vault.GetWrappedKey()) == 0 {
+		return status.Error(codes.InvalidArgument, secure.ErrorInvalidVault.Error())
+	}
+
+	err := a.service.SaveVault(ctx, vaultFromProto(vault, userID))
+	if errors.Is(err, storage.ErrorVaultAlreadyExist) {
+		return status.Error(codes.AlreadyExists, err.Error())
+	}
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+func vaultToProto(vault models.Vault) *pb.Vault {
+	return &pb.Vault{
+		Salt:       vault.Salt,
+		KdfTime:    vault.KdfTime,
+		KdfMemory:  vault.KdfMemory,
+		KdfThreads: vault.KdfThreads,
+		WrappedKey: vault.WrappedKey,
+	}
+}
+
+func vaultFromProto(vault *pb.Vault, userID string) models.Vault {
+	return models.Vault{
+		UserID:     userID,
+		Salt:       vault.GetSalt(),
+		KdfTime:    vault.GetKdfTime(),
+		KdfMemory:  vault.GetKdfMemory(),
+		KdfThreads: vault.GetKdfThreads(),
+		WrappedKey: vault.GetWrappedKey(),
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+	pb "github.com/vstebletsov89/go-developer-course-gophkeeper/internal/proto"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/service/auth"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RegisterDevice signs certificate signing request of the user device with the server CA.
+// Issued certificate authenticates the device (mTLS) without access token until it is revoked or expired.
+func (a *AuthServer) RegisterDevice(ctx context.Context, request *pb.RegisterDeviceRequest) (*pb.RegisterDeviceResponse, error) {
+	var response pb.RegisterDeviceResponse
+	userID := auth.ExtractUserIDFromContext(ctx)
+
+	if a.deviceCA == nil {
+		return nil, status.Error(codes.FailedPrecondition, "client certificates are not configured")
+	}
+	if request.GetName() == "" {
+		return nil, status.Error(codes.InvalidArgument, "device name is required")
+	}
+
+	certificate, certificatePEM, err := a.deviceCA.SignCSR(request.GetCsr(), userID, a.deviceCertTTL)
+	if errors.Is(err, auth.ErrorInvalidCSR) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	device := models.Device{
+		ID:          uuid.NewString(),
+		UserID:      userID,
+		Name:        request.GetName(),
+		Fingerprint: auth.CertificateFingerprint(certificate.Raw),
+		ExpiresAt:   certificate.NotAfter,
+	}
+	err = a.service.SaveDevice(ctx, device)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	response.DeviceId = device.ID
+	response.Certificate = certificatePEM
+	response.CaCertificate = a.deviceCA.CertificatePEM()
+
+	log.Debug().Msg("Server (RegisterDevice): done")
+	return &response, nil
+}
+
+// RevokeDevice revokes client certificate of the user device.
+func (a *AuthServer) RevokeDevice(ctx context.Context, request *pb.RevokeDeviceRequest) (*pb.RevokeDeviceResponse, error) {
+	var response pb.RevokeDeviceResponse
+	userID := auth.ExtractUserIDFromContext(ctx)
+
+	err := a.service.RevokeDevice(ctx, userID, request.GetDeviceId())
+	if errors.Is(err, storage.ErrorDeviceNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.Debug().Msg("Server (RevokeDevice): done")
+	return &response, nil
+}
@@ -3,16 +3,24 @@ package server
 
 import (
 	"context"
+	"crypto"
+	"crypto/tls"
 	"database/sql"
+	"errors"
+	"fmt"
 	_ "github.com/lib/pq" // load postgres driver
 	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
+	"github.com/google/uuid"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/secure"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/service"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/service/auth"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/storage"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/storage/postgres"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc/codes"
@@ -27,15 +35,29 @@ import (
 	pb "github.com/vstebletsov89/go-developer-course-gophkeeper/internal/proto"
 )
 
+// ErrorDefaultJwtSecret defines an error for default jwt secret outside of development mode.
+var ErrorDefaultJwtSecret = errors.New("default jwt secret is not allowed, set JWT_SIGNING_KEY_FILE or JWT_SECRET (or DEV_MODE for development)")
+
+// masterKeyCheckSize defines number of stored records to be checked with master key on startup.
+const masterKeyCheckSize = 10
+
 // GophkeeperServer represents a structure for gophkeeper service.
 type GophkeeperServer struct {
 	pb.UnimplementedGophkeeperServer
-	service service.Service
+	service          service.Service
+	changes          *ChangeHub
+	historyRevisions int
 }
 
 // NewGophkeeperServer returns an instance of GophkeeperServer.
 func NewGophkeeperServer(service service.Service) *GophkeeperServer {
-	return &GophkeeperServer{service: service}
+	return &GophkeeperServer{service: service, changes: NewChangeHub()}
+}
+
+// SetHistoryRetention sets number of the latest previous revisions kept in history of every record
+// (0 disables history).
+func (g *GophkeeperServer) SetHistoryRetention(revisions int) {
+	g.historyRevisions = revisions
 }
 
 // AddData adds encrypted private data to the storage.
@@ -43,12 +65,26 @@ func (g *GophkeeperServer) AddData(ctx context.Context, request *pb.AddDataReque
 	userID := auth.ExtractUserIDFromContext(ctx)
 
 	var response pb.AddDataResponse
-	data, err := secure.EncryptPrivateData(request.GetData(), userID)
+	// access token restricted to existing records cannot add new records
+	if scope := auth.ExtractScopeFromContext(ctx); scope != nil &&
+		(scope.ReadOnly || len(scope.DataIDs) != 0 || !scope.AllowsType(models.DataType(request.GetData().GetDataType()))) {
+		return nil, errorScopeDenied
+	}
+
+	userKey, err := loadUserKey(ctx, g.service, userID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	data, err := secure.EncryptPrivateData(request.GetData(), userID, userKey)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
 	err = g.service.AddData(ctx, data)
+	if errors.Is(err, storage.ErrorPrivateDataAlreadyExist) {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	}
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
@@ -64,28 +100,155 @@ func (g *GophkeeperServer) GetData(ctx context.Context, request *pb.GetDataReque
 	userID := auth.ExtractUserIDFromContext(ctx)
 	var response pb.GetDataResponse
 
+	// user without private data gets empty response
 	data, err := g.service.GetDataByUserID(ctx, userID)
+	if err != nil && !errors.Is(err, storage.ErrorPrivateDataNotFound) {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	response.Data, err = g.decryptData(ctx, userID, data)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug().Msg("Server (GetData): done")
+	return &response, nil
+}
+
+// Sync returns private data of the user created or updated after the cursor of the client and tombstones
+// of deleted data. Cursor ahead of the server (e.g. restored database) or before tombstones of data purged
+// from trash leads to full sync.
+func (g *GophkeeperServer) Sync(ctx context.Context, request *pb.SyncRequest) (*pb.SyncResponse, error) {
+	userID := auth.ExtractUserIDFromContext(ctx)
+
+	var response pb.SyncResponse
+	if request.GetCursor() < 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid cursor")
+	}
+
+	changes, err := g.service.GetDataChanges(ctx, userID, request.GetCursor())
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
+	response.Full = request.GetCursor() == 0 || changes.Full
+	if request.GetCursor() > changes.Cursor {
+		changes, err = g.service.GetDataChanges(ctx, userID, 0)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		response.Full = true
+	}
+
+	response.Data, err = g.decryptData(ctx, userID, changes.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	scope := auth.ExtractScopeFromContext(ctx)
+	for _, v := range changes.Tombstones {
+		if response.Full || (scope != nil && !scope.AllowsData(v.DataID, v.DataType)) {
+			continue
+		}
+		response.Tombstones = append(response.Tombstones, &pb.Tombstone{
+			DataId:    v.DataID,
+			DataType:  pb.DataType(v.DataType),
+			DeletedAt: v.DeletedAt.Unix(),
+		})
+	}
+	response.Cursor = changes.Cursor
+
+	log.Debug().Msgf("Server (Sync): done (%d records, %d tombstones)", len(response.Data), len(response.Tombstones))
+	return &response, nil
+}
 
+// decryptData decrypts private data of the user. Data outside of the personal access token scope is skipped.
+func (g *GophkeeperServer) decryptData(ctx context.Context, userID string, data []models.Data) ([]*pb.Data, error) {
+	userKey, err := loadUserKey(ctx, g.service, userID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	var result []*pb.Data
+	scope := auth.ExtractScopeFromContext(ctx)
 	for _, v := range data {
-		secret, err := secure.DecryptPrivateData(v)
+		if scope != nil && !scope.AllowsData(v.ID, v.DataType) {
+			continue
+		}
+		secret, err := secure.DecryptPrivateData(v, userKey)
 		if err != nil {
 			return nil, status.Error(codes.Internal, err.Error())
 		}
-		response.Data = append(response.Data, secret)
+		result = append(result, secret)
 	}
+	return result, nil
+}
 
-	log.Debug().Msg("Server (GetData): done")
+// UpdateData replaces encrypted private data of the user. Data is updated only if the expected revision
+// is the current revision of the record, otherwise it was changed concurrently (Aborted).
+func (g *GophkeeperServer) UpdateData(ctx context.Context, request *pb.UpdateDataRequest) (*pb.UpdateDataResponse, error) {
+	userID := auth.ExtractUserIDFromContext(ctx)
+
+	var response pb.UpdateDataResponse
+	if _, err := uuid.Parse(request.GetData().GetDataId()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid data ID")
+	}
+
+	if scope := auth.ExtractScopeFromContext(ctx); scope != nil {
+		if err := g.checkScope(ctx, scope, request.GetData().GetDataId()); err != nil {
+			return nil, err
+		}
+	}
+
+	userKey, err := loadUserKey(ctx, g.service, userID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	data, err := secure.EncryptPrivateData(request.GetData(), userID, userKey)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	response.Revision, err = g.service.UpdateData(ctx, data, request.GetRevision(), g.historyRevisions)
+	if errors.Is(err, storage.ErrorPrivateDataNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if errors.Is(err, storage.ErrorRevisionMismatch) {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+	if errors.Is(err, storage.ErrorInvalidDataType) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.Debug().Msg("Server (UpdateData): done")
 	return &response, nil
 }
 
-// DeleteData deletes private data from the storage.
+// DeleteData deletes private data of the user from the storage. Data of another user is not found.
 func (g *GophkeeperServer) DeleteData(ctx context.Context, request *pb.DeleteDataRequest) (*pb.DeleteDataResponse, error) {
+	userID := auth.ExtractUserIDFromContext(ctx)
+
 	var response pb.DeleteDataResponse
+	if _, err := uuid.Parse(request.GetDataId()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid data ID")
+	}
+
+	if scope := auth.ExtractScopeFromContext(ctx); scope != nil {
+		if err := g.checkScope(ctx, scope, request.GetDataId()); err != nil {
+			return nil, err
+		}
+	}
 
-	err := g.service.DeleteDataByDataID(ctx, request.GetDataId())
+	err := g.service.DeleteDataByDataID(ctx, userID, request.GetDataId(), request.GetRevision())
+	if errors.Is(err, storage.ErrorPrivateDataNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if errors.Is(err, storage.ErrorRevisionMismatch) {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
@@ -94,6 +257,148 @@ func (g *GophkeeperServer) DeleteData(ctx context.Context, request *pb.DeleteDat
 	return &response, nil
 }
 
+// checkScope checks that private data can be changed with personal access token.
+func (g *GophkeeperServer) checkScope(ctx context.Context, scope *auth.AccessScope, dataID string) error {
+	if scope.ReadOnly {
+		return errorScopeDenied
+	}
+	return g.checkReadScope(ctx, scope, dataID)
+}
+
+// checkReadScope checks that private data can be read with personal access token.
+func (g *GophkeeperServer) checkReadScope(ctx context.Context, scope *auth.AccessScope, dataID string) error {
+	data, err := g.service.GetDataByID(ctx, auth.ExtractUserIDFromContext(ctx), dataID)
+	if errors.Is(err, storage.ErrorPrivateDataNotFound) {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if !scope.AllowsData(data.ID, data.DataType) {
+		return errorScopeDenied
+	}
+	return nil
+}
+
+// loadUserKey returns data encryption key of the user. Key is created for legacy users without key.
+func loadUserKey(ctx context.Context, svc service.Service, userID string) ([]byte, error) {
+	userKey, err := svc.GetUserKey(ctx, userID)
+	if err == nil {
+		return secure.UnwrapUserKey(userKey)
+	}
+	if !errors.Is(err, storage.ErrorUserKeyNotFound) {
+		return nil, err
+	}
+
+	newUserKey, key, err := secure.NewUserKey(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = svc.SaveUserKey(ctx, newUserKey)
+	if errors.Is(err, storage.ErrorUserKeyAlreadyExist) {
+		// key was created by concurrent request
+		userKey, err = svc.GetUserKey(ctx, userID)
+		if err != nil {
+			return nil, err
+		}
+		return secure.UnwrapUserKey(userKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// verifyMasterKey checks that master key is able to unwrap user keys and decrypt legacy private data
+// which are already stored.
+func verifyMasterKey(ctx context.Context, svc *service.Service) error {
+	keys, err := svc.GetUserKeyBatch(ctx, minDataID, masterKeyCheckSize)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range keys {
+		if _, err := secure.UnwrapUserKey(v); err != nil {
+			return fmt.Errorf("master key cannot unwrap user key (user %s): %w", v.UserID, err)
+		}
+	}
+
+	data, err := svc.GetDataSample(ctx, masterKeyCheckSize)
+	if err != nil {
+		return err
+	}
+
+	checked := 0
+	for _, v := range data {
+		version, err := secure.GetEnvelopeVersion(v.DataBinary)
+		if err != nil {
+			return fmt.Errorf("stored data has invalid format (id %s): %w", v.ID, err)
+		}
+		if version != secure.EnvelopeVersion {
+			// data encrypted with the user key is verified by user keys
+			continue
+		}
+
+		if _, err := secure.DecryptPrivateData(v, nil); err != nil {
+			return fmt.Errorf("master key cannot decrypt stored data (id %s): %w", v.ID, err)
+		}
+		checked++
+	}
+
+	log.Info().Msgf("Master key verification: OK (%d user keys and %d legacy records checked)", len(keys), checked)
+	return nil
+}
+
+// newJWTManager returns jwt manager with Ed25519/RSA signing key or with shared secret.
+// Default shared secret is allowed only in development mode.
+func newJWTManager(cfg *config.Config) (*auth.JWTManager, error) {
+	if cfg.JwtKeyFile == "" {
+		if cfg.JwtSecretKey == config.DefaultJwtSecretKey && !cfg.DevMode {
+			return nil, ErrorDefaultJwtSecret
+		}
+		log.Warn().Msg("JWT tokens are signed with shared secret (HS256)")
+		return auth.NewJWTManager(cfg.JwtSecretKey, cfg.AccessTokenTTL), nil
+	}
+
+	signingKey, err := auth.LoadSigningKey(cfg.JwtKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var previousKeys []crypto.PublicKey
+	for _, path := range strings.Split(cfg.JwtPublicKeys, ",") {
+		if strings.TrimSpace(path) == "" {
+			continue
+		}
+		publicKey, err := auth.LoadVerificationKey(strings.TrimSpace(path))
+		if err != nil {
+			return nil, err
+		}
+		previousKeys = append(previousKeys, publicKey)
+	}
+	return auth.NewSignedJWTManager(signingKey, previousKeys, cfg.AccessTokenTTL)
+}
+
+// newServerTLSConfig returns TLS configuration of the server. Client certificate is optional (mTLS),
+// it is verified with the device CA and replaces access token.
+func newServerTLSConfig(cfg *config.Config, deviceCA *auth.DeviceCA) (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if deviceCA != nil {
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		tlsConfig.ClientCAs = deviceCA.Pool()
+	}
+	return tlsConfig, nil
+}
+
 // RunServer starts server application for gophkeeper service.
 //
 //nolint:funlen
@@ -107,6 +412,12 @@ func RunServer(cfg *config.Config) error {
 	// debug config
 	log.Debug().Msgf("%+v\n\n", cfg)
 
+	// refuse to start with invalid or insecure jwt settings before connection to the database
+	jwtManager, err := newJWTManager(cfg)
+	if err != nil {
+		return err
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -149,10 +460,47 @@ func RunServer(cfg *config.Config) error {
 	// create new service
 	svc := service.NewService(storage)
 
-	jwtManager := auth.NewJWTManager(cfg.JwtSecretKey)
-	jwt := NewJwtInterceptor(jwtManager)
-	authServer := NewAuthServer(*svc, jwtManager)
+	// init master key and refuse to start if it cannot decrypt existing data
+	err = secure.SetKeyProvider(secure.NewKeyProvider(cfg.MasterKey, cfg.MasterKeyFile),
+		secure.NewRetiredKeyProviders(cfg.RetiredKeys, cfg.RetiredKeyFiles)...)
+	if err != nil {
+		return err
+	}
+	if err := verifyMasterKey(ctx, svc); err != nil {
+		return err
+	}
+
+	jwt := NewJwtInterceptor(jwtManager, *svc)
+	// open streams are closed when credentials are revoked
+	jwt.SetStreamRecheck(cfg.StreamRecheck)
+	authServer := NewAuthServer(*svc, jwtManager, cfg.RefreshTokenTTL,
+		auth.NewLockoutPolicy(cfg.LoginAttempts, cfg.LoginLockout))
+	// password login is kept for legacy users until they are upgraded to SRP
+	authServer.SetLegacyLogin(cfg.LegacyLogin)
 	gophkeeperServer := NewGophkeeperServer(*svc)
+	gophkeeperServer.SetHistoryRetention(cfg.HistoryLimit)
+
+	// client certificates of the devices are issued only with configured CA
+	var deviceCA *auth.DeviceCA
+	if cfg.TLSCAFile != "" && cfg.TLSCAKeyFile != "" {
+		deviceCA, err = auth.LoadDeviceCA(cfg.TLSCAFile, cfg.TLSCAKeyFile)
+		if err != nil {
+			return err
+		}
+		authServer.SetDeviceCA(deviceCA, cfg.DeviceCertTTL)
+	}
+
+	// changes of private data are pushed to watching clients
+	g.Go(func() error {
+		gophkeeperServer.changes.Run(ctx, *svc)
+		return nil
+	})
+
+	// private data is deleted permanently after retention period in trash
+	g.Go(func() error {
+		NewTrashPurge(*svc, cfg.TrashRetention).Run(ctx)
+		return nil
+	})
 
 	var grpcSrv *grpc.Server
 
@@ -170,20 +518,23 @@ func RunServer(cfg *config.Config) error {
 		if cfg.EnableTLS {
 			// Server using TLS credentials
 			log.Info().Msg("GRPC server configuration with TLS credentials")
-			transportCredentials, err := credentials.NewServerTLSFromFile("cert.pem", "key.pem")
+			tlsConfig, err := newServerTLSConfig(cfg, deviceCA)
 			if err != nil {
-				log.Error().Msgf("GRPC server credentials.NewServerTLSFromFile: %v", err.Error())
+				log.Error().Msgf("GRPC server TLS configuration: %v", err.Error())
 				return err
 			}
+			transportCredentials := credentials.NewTLS(tlsConfig)
 
 			grpcSrv = grpc.NewServer(
 				grpc.Creds(transportCredentials),
-				grpc.UnaryInterceptor(jwt.UnaryInterceptor))
+				grpc.UnaryInterceptor(jwt.UnaryInterceptor),
+				grpc.StreamInterceptor(jwt.StreamInterceptor))
 		} else {
 			// server without TLS credentials
 			log.Info().Msg("GRPC server configuration without TLS credentials")
 			grpcSrv = grpc.NewServer(
-				grpc.UnaryInterceptor(jwt.UnaryInterceptor))
+				grpc.UnaryInterceptor(jwt.UnaryInterceptor),
+				grpc.StreamInterceptor(jwt.StreamInterceptor))
 		}
 
 		pb.RegisterAuthServer(grpcSrv, authServer)
@@ -200,6 +551,8 @@ func RunServer(cfg *config.Config) error {
 
 	<-sigint
 
+	// watch streams are endless, they are stopped before graceful shutdown
+	gophkeeperServer.changes.Close()
 	grpcSrv.GracefulStop()
 
 	// stop server context and release resources
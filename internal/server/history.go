@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+	pb "github.com/vstebletsov89/go-developer-course-gophkeeper/internal/proto"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/service/auth"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ListRevisions returns previous revisions of the record kept in history, the latest revision first.
+func (g *GophkeeperServer) ListRevisions(ctx context.Context, request *pb.ListRevisionsRequest) (*pb.ListRevisionsResponse, error) {
+	userID := auth.ExtractUserIDFromContext(ctx)
+
+	var response pb.ListRevisionsResponse
+	if _, err := uuid.Parse(request.GetDataId()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid data ID")
+	}
+
+	if scope := auth.ExtractScopeFromContext(ctx); scope != nil {
+		if err := g.checkReadScope(ctx, scope, request.GetDataId()); err != nil {
+			return nil, err
+		}
+	}
+
+	revisions, err := g.service.GetDataRevisions(ctx, userID, request.GetDataId())
+	if errors.Is(err, storage.ErrorPrivateDataNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	response.Revisions, err = g.decryptData(ctx, userID, revisions)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug().Msgf("Server (ListRevisions): done (%d revisions)", len(response.Revisions))
+	return &response, nil
+}
+
+// RestoreRevision replaces the record with the previous revision from history. Restored record gets new revision,
+// so other clients of the user load it on sync.
+func (g *GophkeeperServer) RestoreRevision(ctx context.Context, request *pb.RestoreRevisionRequest) (*pb.RestoreRevisionResponse, error) {
+	userID := auth.ExtractUserIDFromContext(ctx)
+
+	var response pb.RestoreRevisionResponse
+	if _, err := uuid.Parse(request.GetDataId()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid data ID")
+	}
+	if request.GetRevision() <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid revision")
+	}
+
+	if scope := auth.ExtractScopeFromContext(ctx); scope != nil {
+		if err := g.checkScope(ctx, scope, request.GetDataId()); err != nil {
+			return nil, err
+		}
+	}
+
+	restored, err := g.service.RestoreDataRevision(ctx, userID, request.GetDataId(), request.GetRevision(),
+		g.historyRevisions)
+	if errors.Is(err, storage.ErrorPrivateDataNotFound) || errors.Is(err, storage.ErrorRevisionNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	data, err := g.decryptData(ctx, userID, []models.Data{restored})
+	if err != nil {
+		return nil, err
+	}
+	response.Data = data[0]
+
+	log.Debug().Msgf("Server (RestoreRevision): done (revision %d)", restored.Revision)
+	return &response, nil
+}
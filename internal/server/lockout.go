@@ -0,0 +1,104 @@
+package server
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/service/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// peerAttemptsFactor defines how many times more failures are allowed for peer address than for single login
+// (several users can share the same address).
+const peerAttemptsFactor = 4
+
+// errorIncorrectCredentials is the same for unknown user and invalid password, so users cannot be enumerated.
+var errorIncorrectCredentials = status.Error(codes.Unauthenticated, "incorrect username/password")
+
+// errorTooManyAttempts is returned while login or peer address is locked.
+var errorTooManyAttempts = status.Error(codes.ResourceExhausted, "too many failed attempts, try again later")
+
+// loginAttemptKey returns key of failed attempts for the login.
+func loginAttemptKey(login string) string {
+	return "login:" + strings.ToLower(login)
+}
+
+// twoFactorAttemptKey returns key of failed attempts of the second factor for the user.
+func twoFactorAttemptKey(userID string) string {
+	return "2fa:" + userID
+}
+
+// peerAttemptKey returns key of failed attempts for address of the client (empty if address is unknown).
+func peerAttemptKey(ctx context.Context) string {
+	host := peerHost(ctx)
+	if host == "" {
+		return ""
+	}
+	return "peer:" + host
+}
+
+// peerHost returns address of the client without port (empty if address is unknown).
+func peerHost(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
+
+// checkAttempts rejects request if any of the keys is locked after failed attempts.
+func (a *AuthServer) checkAttempts(ctx context.Context, keys ...string) error {
+	attempts, err := a.service.GetLoginAttempts(ctx, nonEmpty(keys))
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+
+	now := time.Now()
+	for _, attempt := range attempts {
+		if now.Before(a.lockoutPolicy(attempt).LockedUntil(attempt)) {
+			log.Warn().Msgf("Too many failed attempts (%s: %d)", attempt.Key, attempt.Failures)
+			return errorTooManyAttempts
+		}
+	}
+	return nil
+}
+
+// addFailure increments failed attempts of the keys and returns the specified error for the client.
+func (a *AuthServer) addFailure(ctx context.Context, failure error, keys ...string) error {
+	now := time.Now()
+	for _, key := range nonEmpty(keys) {
+		if err := a.service.AddLoginFailure(ctx, key, now, now.Add(-a.lockout.ResetAfter)); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+	}
+	return failure
+}
+
+// lockoutPolicy returns policy for the key of failed attempts.
+func (a *AuthServer) lockoutPolicy(attempt models.LoginAttempt) auth.LockoutPolicy {
+	policy := a.lockout
+	if strings.HasPrefix(attempt.Key, "peer:") {
+		policy.FreeAttempts *= peerAttemptsFactor
+	}
+	return policy
+}
+
+func nonEmpty(keys []string) []string {
+	result := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if key != "" {
+			result = append(result, key)
+		}
+	}
+	return result
+}
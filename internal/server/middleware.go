@@ -2,37 +2,156 @@ package server
 
 import (
 	"context"
+	"errors"
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
 	"github.com/rs/zerolog/log"
+	pb "github.com/vstebletsov89/go-developer-course-gophkeeper/internal/proto"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/service"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/service/auth"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/storage"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 	"strings"
+	"time"
 )
 
+// publicMethods defines grpc methods which are available without authorization.
+var publicMethods = []string{"/Register", "/Login", "/LoginStart", "/LoginFinish", "/Refresh", "/VerifyTwoFactor", "/GetSigningKeys"}
+
 // JwtInterceptor represents a structure for jwt interceptor.
 type JwtInterceptor struct {
-	jwt auth.JWT
+	jwt           auth.JWT
+	service       service.Service
+	streamRecheck time.Duration
 }
 
+// defaultStreamRecheck defines how often credentials of open streams are authorized again.
+const defaultStreamRecheck = time.Minute
+
 // NewJwtInterceptor returns an instance of JwtInterceptor.
-func NewJwtInterceptor(jwt auth.JWT) *JwtInterceptor {
-	return &JwtInterceptor{jwt: jwt}
+func NewJwtInterceptor(jwt auth.JWT, service service.Service) *JwtInterceptor {
+	return &JwtInterceptor{jwt: jwt, service: service, streamRecheck: defaultStreamRecheck}
+}
+
+// SetStreamRecheck sets how often credentials of open streams are authorized again, so streams of revoked
+// tokens, sessions and devices are closed.
+func (j *JwtInterceptor) SetStreamRecheck(interval time.Duration) {
+	if interval > 0 {
+		j.streamRecheck = interval
+	}
 }
 
 // UnaryInterceptor grpc interceptor to validate access token. It is used for authorization of users.
 func (j *JwtInterceptor) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
 	log.Debug().Msg("Interceptor authorization (grpc_middleware)")
 
-	if strings.Contains(info.FullMethod, "Register") || strings.Contains(info.FullMethod, "Login") {
-		// skip validation jwt token for register and login
-		return handler(ctx, req)
+	newCtx, err := j.authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
 	}
+	return handler(newCtx, req)
+}
 
-	token, err := grpc_auth.AuthFromMD(ctx, "bearer")
+// StreamInterceptor grpc interceptor to validate access token of streaming requests. Authorization is the same
+// as for unary requests, context of the stream contains user of the token. Credentials are authorized again
+// while the stream is open and the stream is closed when they are revoked (logout, revoked session or device,
+// changed password).
+func (j *JwtInterceptor) StreamInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	log.Debug().Msg("Interceptor authorization (grpc_middleware stream)")
+
+	newCtx, err := j.authorize(stream.Context(), info.FullMethod)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	wrapped := grpc_middleware.WrapServerStream(stream)
+	if isPublicMethod(info.FullMethod) {
+		wrapped.WrappedContext = newCtx
+		return handler(srv, wrapped)
+	}
+
+	ctx, cancel := context.WithCancel(newCtx)
+	defer cancel()
+	revoked := make(chan error, 1)
+	go j.recheckStream(ctx, stream.Context(), info.FullMethod, revoked, cancel)
+
+	wrapped.WrappedContext = ctx
+	err = handler(srv, wrapped)
+	select {
+	case revokedErr := <-revoked:
+		return revokedErr
+	default:
+		return err
+	}
+}
+
+// recheckStream authorizes credentials of the stream periodically. Stream is cancelled and the error is sent
+// to revoked channel when credentials are not valid anymore.
+func (j *JwtInterceptor) recheckStream(ctx context.Context, streamCtx context.Context, fullMethod string,
+	revoked chan<- error, cancel context.CancelFunc) {
+	ticker := time.NewTicker(j.streamRecheck)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := j.authorize(streamCtx, fullMethod)
+			if err == nil {
+				continue
+			}
+			if status.Code(err) == codes.Internal {
+				// storage is not available, stream is kept until the next check
+				log.Error().Msgf("Interceptor authorization (stream recheck) error %s", err)
+				continue
+			}
+			log.Debug().Msg("Interceptor authorization (stream recheck): credentials revoked")
+			revoked <- err
+			cancel()
+			return
+		}
+	}
+}
+
+// authorize validates access token, personal access token or client certificate of the request
+// and returns context with the user.
+func (j *JwtInterceptor) authorize(ctx context.Context, fullMethod string) (context.Context, error) {
+	if isPublicMethod(fullMethod) {
+		// skip validation jwt token for register, login, refresh, second factor (challenge is validated by handler)
+		// and public signing keys
+		return ctx, nil
+	}
+
+	token, err := grpc_auth.AuthFromMD(ctx, "bearer")
+	if err != nil || token == "" {
+		// verified client certificate of the device is an alternative to access token
+		userID, ok, deviceErr := j.deviceIdentity(ctx)
+		if deviceErr != nil {
+			return nil, deviceErr
+		}
+		if !ok {
+			if err == nil {
+				err = status.Error(codes.Unauthenticated, "invalid authorization token: empty token")
+			}
+			return nil, err
+		}
+
+		log.Debug().Msg("Interceptor authorization (client certificate): OK")
+		return context.WithValue(ctx, auth.UserCtx, userID), nil
+	}
+
+	if auth.IsPersonalToken(token) {
+		newCtx, err := j.personalTokenContext(ctx, fullMethod, token)
+		if err != nil {
+			return nil, err
+		}
+
+		log.Debug().Msg("Interceptor authorization (personal access token): OK")
+		return newCtx, nil
 	}
 
 	log.Debug().Msgf("Validation token: %v", token)
@@ -41,8 +160,86 @@ func (j *JwtInterceptor) UnaryInterceptor(ctx context.Context, req interface{},
 		return nil, status.Errorf(codes.Unauthenticated, "invalid authorization token: %v", err)
 	}
 
-	newCtx := context.WithValue(ctx, auth.UserCtx, claims.ID)
+	revoked, err := j.service.IsTokenRevoked(ctx, claims.ID, claims.Subject, claims.SessionID, claims.IssuedAt.Time)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if revoked {
+		return nil, status.Error(codes.Unauthenticated, "invalid authorization token: token revoked")
+	}
+
+	newCtx := context.WithValue(ctx, auth.UserCtx, claims.Subject)
+	newCtx = context.WithValue(newCtx, auth.ClaimsCtx, claims)
 
 	log.Debug().Msg("Interceptor authorization: OK")
-	return handler(newCtx, req)
+	return newCtx, nil
+}
+
+// isPublicMethod checks that grpc method is available without authorization.
+func isPublicMethod(fullMethod string) bool {
+	for _, method := range publicMethods {
+		if strings.HasSuffix(fullMethod, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// deviceIdentity returns user ID of the verified client certificate (mTLS). Certificate must belong
+// to registered device which is not revoked.
+func (j *JwtInterceptor) deviceIdentity(ctx context.Context) (string, bool, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", false, nil
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return "", false, nil
+	}
+
+	certificate := tlsInfo.State.VerifiedChains[0][0]
+	userID, ok := auth.DeviceUserID(certificate)
+	if !ok {
+		return "", false, nil
+	}
+
+	device, err := j.service.GetDeviceByFingerprint(ctx, auth.CertificateFingerprint(certificate.Raw))
+	if errors.Is(err, storage.ErrorDeviceNotFound) {
+		return "", false, status.Error(codes.Unauthenticated, "invalid client certificate: unknown device")
+	}
+	if err != nil {
+		return "", false, status.Error(codes.Internal, err.Error())
+	}
+	if device.Revoked || device.UserID != userID || time.Now().After(device.ExpiresAt) {
+		return "", false, status.Error(codes.Unauthenticated, "invalid client certificate: device revoked")
+	}
+	return userID, true, nil
+}
+
+// personalTokenContext validates personal access token and returns context with the user and scope of the token.
+// Personal access tokens are accepted only for private data and vault (no account management).
+func (j *JwtInterceptor) personalTokenContext(ctx context.Context, fullMethod string, token string) (context.Context, error) {
+	accessToken, err := j.service.GetAccessToken(ctx, auth.HashPersonalToken(token))
+	if errors.Is(err, storage.ErrorAccessTokenNotFound) {
+		return nil, status.Error(codes.Unauthenticated, "invalid authorization token: unknown access token")
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	now := time.Now()
+	if accessToken.ExpiresAt != nil && now.After(*accessToken.ExpiresAt) {
+		return nil, status.Error(codes.Unauthenticated, "invalid authorization token: access token expired")
+	}
+	if !strings.HasPrefix(fullMethod, "/"+pb.Gophkeeper_ServiceDesc.ServiceName+"/") &&
+		fullMethod != "/"+pb.Auth_ServiceDesc.ServiceName+"/GetVault" {
+		return nil, errorScopeDenied
+	}
+
+	if err := j.service.TouchAccessToken(ctx, accessToken.ID, now); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	newCtx := context.WithValue(ctx, auth.UserCtx, accessToken.UserID)
+	return context.WithValue(newCtx, auth.ScopeCtx, auth.NewAccessScope(accessToken)), nil
 }
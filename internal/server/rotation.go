@@ -0,0 +1,319 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/signal"
+	"syscall"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/config"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/secure"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/service"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/storage"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/storage/postgres"
+)
+
+// minDataID is a lower bound for keyset pagination of data records.
+const minDataID = "00000000-0000-0000-0000-000000000000"
+
+// ErrorMasterKeyNotConfigured defines an error for key rotation without persistent master key.
+var ErrorMasterKeyNotConfigured = errors.New("master key is not configured")
+
+// RotationProgress represents progress of master key rotation.
+type RotationProgress struct {
+	// user keys
+	Keys      int64
+	Rewrapped int64
+	// private data records
+	Total     int64
+	Processed int64
+	Migrated  int64
+	// revisions of private data in history
+	Revisions         int64
+	MigratedRevisions int64
+	// already up to date or changed concurrently (user keys and records)
+	Skipped int64
+	Changed int64
+}
+
+// KeyRotation represents a job which re-wraps user keys with the active master key.
+// Legacy private data and its revisions in history encrypted with the master key are migrated to the user keys.
+type KeyRotation struct {
+	service   service.Service
+	batchSize int
+	userKeys  map[string][]byte
+}
+
+// NewKeyRotation returns an instance of KeyRotation.
+func NewKeyRotation(service service.Service, batchSize int) *KeyRotation {
+	return &KeyRotation{service: service, batchSize: batchSize, userKeys: make(map[string][]byte)}
+}
+
+// Run re-wraps user keys and migrates legacy data in batches. Private data encrypted with user keys is not changed.
+// User keys which are already wrapped with the active key and migrated records are skipped,
+// so interrupted rotation is resumed by running it again.
+func (k *KeyRotation) Run(ctx context.Context) (RotationProgress, error) {
+	var progress RotationProgress
+
+	activeKeyID, err := secure.ActiveKeyID()
+	if err != nil {
+		return progress, err
+	}
+	log.Info().Msgf("Key rotation started: active key %x", activeKeyID)
+
+	if err := k.rewrapUserKeys(ctx, activeKeyID, &progress); err != nil {
+		return progress, err
+	}
+
+	if err := k.migrateLegacyData(ctx, &progress); err != nil {
+		return progress, err
+	}
+
+	if err := k.migrateLegacyHistory(ctx, &progress); err != nil {
+		return progress, err
+	}
+
+	log.Info().Msg("Key rotation done")
+	return progress, nil
+}
+
+func (k *KeyRotation) rewrapUserKeys(ctx context.Context, activeKeyID []byte, progress *RotationProgress) error {
+	afterID := minDataID
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		batch, err := k.service.GetUserKeyBatch(ctx, afterID, k.batchSize)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, userKey := range batch {
+			if err := k.rewrap(ctx, userKey, activeKeyID, progress); err != nil {
+				return fmt.Errorf("key rotation failed for user %s: %w", userKey.UserID, err)
+			}
+			progress.Keys++
+		}
+		afterID = batch[len(batch)-1].UserID
+
+		log.Info().Msgf("Key rotation progress: %d user keys (rewrapped %d)", progress.Keys, progress.Rewrapped)
+	}
+	return nil
+}
+
+func (k *KeyRotation) rewrap(ctx context.Context, userKey models.UserKey, activeKeyID []byte, progress *RotationProgress) error {
+	keyID, err := secure.EnvelopeKeyID(userKey.WrappedKey)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(keyID, activeKeyID) {
+		progress.Skipped++
+		return nil
+	}
+
+	rewrapped, err := secure.RewrapUserKey(userKey)
+	if err != nil {
+		return err
+	}
+
+	err = k.service.UpdateUserKey(ctx, rewrapped, userKey.WrappedKey)
+	if errors.Is(err, storage.ErrorUserKeyNotFound) {
+		// user was deleted concurrently
+		progress.Changed++
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	progress.Rewrapped++
+	return nil
+}
+
+func (k *KeyRotation) migrateLegacyData(ctx context.Context, progress *RotationProgress) error {
+	var err error
+	progress.Total, err = k.service.CountData(ctx)
+	if err != nil {
+		return err
+	}
+
+	afterID := minDataID
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		batch, err := k.service.GetDataBatch(ctx, afterID, k.batchSize)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, data := range batch {
+			if err := k.migrate(ctx, data, progress); err != nil {
+				return fmt.Errorf("key rotation failed for record %s: %w", data.ID, err)
+			}
+			progress.Processed++
+		}
+		afterID = batch[len(batch)-1].ID
+
+		log.Info().Msgf("Key rotation progress: %d/%d records (migrated %d, skipped %d, changed %d)",
+			progress.Processed, progress.Total, progress.Migrated, progress.Skipped, progress.Changed)
+	}
+	return nil
+}
+
+func (k *KeyRotation) migrate(ctx context.Context, data models.Data, progress *RotationProgress) error {
+	migrated, ok, err := k.reencrypt(ctx, data)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		progress.Skipped++
+		return nil
+	}
+
+	err = k.service.UpdateDataBinary(ctx, migrated, data.DataBinary)
+	if errors.Is(err, storage.ErrorPrivateDataNotFound) {
+		// record was changed or deleted concurrently, it is encrypted with the user key by the server
+		progress.Changed++
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	progress.Migrated++
+	return nil
+}
+
+func (k *KeyRotation) migrateLegacyHistory(ctx context.Context, progress *RotationProgress) error {
+	afterID := minDataID
+	var afterRevision int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		batch, err := k.service.GetDataHistoryBatch(ctx, afterID, afterRevision, k.batchSize)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, revision := range batch {
+			if err := k.migrateRevision(ctx, revision, progress); err != nil {
+				return fmt.Errorf("key rotation failed for revision %d of record %s: %w", revision.Revision, revision.ID, err)
+			}
+			progress.Revisions++
+		}
+		afterID = batch[len(batch)-1].ID
+		afterRevision = batch[len(batch)-1].Revision
+
+		log.Info().Msgf("Key rotation progress: %d revisions (migrated %d, skipped %d, changed %d)",
+			progress.Revisions, progress.MigratedRevisions, progress.Skipped, progress.Changed)
+	}
+	return nil
+}
+
+func (k *KeyRotation) migrateRevision(ctx context.Context, revision models.Data, progress *RotationProgress) error {
+	migrated, ok, err := k.reencrypt(ctx, revision)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		progress.Skipped++
+		return nil
+	}
+
+	err = k.service.UpdateDataHistoryBinary(ctx, migrated, revision.DataBinary)
+	if errors.Is(err, storage.ErrorRevisionNotFound) {
+		// revision was removed from history concurrently
+		progress.Changed++
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	progress.MigratedRevisions++
+	return nil
+}
+
+// reencrypt encrypts legacy private data with the user key. False is returned for data which is already
+// encrypted with the user key.
+func (k *KeyRotation) reencrypt(ctx context.Context, data models.Data) (models.Data, bool, error) {
+	version, err := secure.GetEnvelopeVersion(data.DataBinary)
+	if err != nil {
+		return models.Data{}, false, err
+	}
+	if version != secure.EnvelopeVersion {
+		return models.Data{}, false, nil
+	}
+
+	userKey, ok := k.userKeys[data.UserID]
+	if !ok {
+		userKey, err = loadUserKey(ctx, k.service, data.UserID)
+		if err != nil {
+			return models.Data{}, false, err
+		}
+		k.userKeys[data.UserID] = userKey
+	}
+
+	migrated, err := secure.ReencryptPrivateData(data, userKey)
+	if err != nil {
+		return models.Data{}, false, err
+	}
+	return migrated, true, nil
+}
+
+// RunKeyRotation re-wraps user keys with the active master key and migrates legacy private data with its history.
+// Retired master keys must be configured to decrypt data which was encrypted before rotation.
+func RunKeyRotation(cfg *config.Config) error {
+	// init global logger
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	zerolog.SetGlobalLevel(config.ParseLogLevel(cfg.LogLevel))
+
+	if cfg.MasterKey == "" && cfg.MasterKeyFile == "" {
+		return ErrorMasterKeyNotConfigured
+	}
+
+	// rotation can be interrupted and resumed later
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
+	defer cancel()
+
+	db, err := postgres.ConnectDB(ctx, cfg.DatabaseDsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	err = secure.SetKeyProvider(secure.NewKeyProvider(cfg.MasterKey, cfg.MasterKeyFile),
+		secure.NewRetiredKeyProviders(cfg.RetiredKeys, cfg.RetiredKeyFiles)...)
+	if err != nil {
+		return err
+	}
+
+	svc := service.NewService(postgres.NewDBStorage(db))
+	progress, err := NewKeyRotation(*svc, cfg.RotationBatch).Run(ctx)
+	if err != nil {
+		log.Error().Msgf("Key rotation interrupted: %v", err)
+		return err
+	}
+
+	log.Info().Msgf("Key rotation summary: %+v", progress)
+	return nil
+}
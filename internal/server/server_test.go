@@ -2,16 +2,39 @@ package server
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/config"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
 	pb "github.com/vstebletsov89/go-developer-course-gophkeeper/internal/proto"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/secure"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/service"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/service/auth"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/storage"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/storage/postgres"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/storage/postgres/testhelpers"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"math/big"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func startGrpcServer(t *testing.T) {
@@ -22,6 +45,107 @@ func startGrpcServer(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+// writeSigningKey generates Ed25519 signing key in PEM format and returns path to the file.
+func writeSigningKey(t *testing.T) string {
+	_, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwt.pem")
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	require.NoError(t, err)
+	return path
+}
+
+func TestNewJWTManager(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		wantAlg string
+		wantErr bool
+	}{
+		{
+			name:    "positive test (signing key)",
+			cfg:     config.Config{JwtSecretKey: config.DefaultJwtSecretKey, JwtKeyFile: writeSigningKey(t)},
+			wantAlg: "EdDSA",
+		},
+		{
+			name: "positive test (custom secret)",
+			cfg:  config.Config{JwtSecretKey: "custom_secret_key"},
+		},
+		{
+			name: "positive test (default secret in development mode)",
+			cfg:  config.Config{JwtSecretKey: config.DefaultJwtSecretKey, DevMode: true},
+		},
+		{
+			name:    "negative test (default secret)",
+			cfg:     config.Config{JwtSecretKey: config.DefaultJwtSecretKey},
+			wantErr: true,
+		},
+		{
+			name:    "negative test (invalid signing key)",
+			cfg:     config.Config{JwtKeyFile: filepath.Join(t.TempDir(), "unknown.pem")},
+			wantErr: true,
+		},
+		{
+			name:    "negative test (invalid verification key)",
+			cfg:     config.Config{JwtKeyFile: writeSigningKey(t), JwtPublicKeys: "unknown.pem"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newJWTManager(&tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("newJWTManager() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			keys := got.VerificationKeys()
+			if tt.wantAlg == "" {
+				assert.Empty(t, keys)
+				return
+			}
+			require.Len(t, keys, 1)
+			assert.Equal(t, tt.wantAlg, keys[0].Algorithm)
+		})
+	}
+
+	// server refuses to start with default secret
+	err := RunServer(&config.Config{JwtSecretKey: config.DefaultJwtSecretKey})
+	assert.ErrorIs(t, err, ErrorDefaultJwtSecret)
+}
+
+func TestChangeHub(t *testing.T) {
+	hub := NewChangeHub()
+	userEvents, unsubscribeUser := hub.Subscribe("user")
+	anotherEvents, unsubscribeAnother := hub.Subscribe("another")
+	defer unsubscribeAnother()
+
+	// slow subscriber gets the last event only
+	hub.Publish(models.ChangeEvent{UserID: "user", Cursor: 1})
+	hub.Publish(models.ChangeEvent{UserID: "user", Cursor: 2})
+	assert.Equal(t, int64(2), (<-userEvents).Cursor)
+	assert.Empty(t, anotherEvents)
+
+	// event without user is sent to all subscribers
+	hub.Publish(models.ChangeEvent{})
+	assert.Len(t, userEvents, 1)
+	assert.Len(t, anotherEvents, 1)
+	<-userEvents
+
+	unsubscribeUser()
+	hub.Publish(models.ChangeEvent{UserID: "user", Cursor: 3})
+	assert.Empty(t, userEvents)
+
+	hub.Close()
+	hub.Close()
+	_, ok := <-hub.Done()
+	assert.False(t, ok)
+}
+
 func TestGophkeeperServer_Positive_Negative(t *testing.T) {
 	if testhelpers.IsGithubActions() {
 		// skip testcontainers for github actions
@@ -35,6 +159,8 @@ func TestGophkeeperServer_Positive_Negative(t *testing.T) {
 	t.Setenv("DATABASE_DSN", dsn)
 	t.Setenv("SERVER_ADDRESS", "localhost:3201")
 	t.Setenv("ENABLE_MIGRATION", "true")
+	t.Setenv("JWT_SIGNING_KEY_FILE", writeSigningKey(t))
+	t.Setenv("STREAM_RECHECK_INTERVAL", "100ms")
 
 	// start grpc server
 	go startGrpcServer(t)
@@ -68,11 +194,46 @@ func TestGophkeeperServer_Positive_Negative(t *testing.T) {
 	loginResponse, err := authClient.Login(ctx, &pb.LoginRequest{User: user})
 	assert.NoError(t, err)
 	log.Printf("loginResponse: %v", loginResponse)
-	assert.Equal(t, user.Login, loginResponse.GetUser().GetLogin())
-	assert.Equal(t, user.Password, loginResponse.GetUser().GetPassword())
 	assert.NotNil(t, loginResponse.GetToken().GetUserId())
 	assert.NotNil(t, loginResponse.GetToken().GetToken())
 
+	// access token is verified with public key of the server
+	signingKeys, err := authClient.GetSigningKeys(ctx, &pb.GetSigningKeysRequest{})
+	require.NoError(t, err)
+	require.Len(t, signingKeys.GetKeys(), 1)
+	assert.Equal(t, "EdDSA", signingKeys.GetKeys()[0].GetAlg())
+	assert.Contains(t, signingKeys.GetJwks(), signingKeys.GetKeys()[0].GetKid())
+	publicKey, err := x509.ParsePKIXPublicKey(signingKeys.GetKeys()[0].GetPublicKey())
+	require.NoError(t, err)
+	parsed, err := jwt.Parse(loginResponse.GetToken().GetToken(), func(token *jwt.Token) (interface{}, error) {
+		return publicKey, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, signingKeys.GetKeys()[0].GetKid(), parsed.Header["kid"])
+
+	// rotate refresh token
+	refreshToken := loginResponse.GetToken().GetRefreshToken()
+	assert.NotEmpty(t, refreshToken)
+	refreshResponse, err := authClient.Refresh(ctx, &pb.RefreshRequest{RefreshToken: refreshToken})
+	assert.NoError(t, err)
+	assert.Equal(t, loginResponse.GetToken().GetUserId(), refreshResponse.GetToken().GetUserId())
+	assert.NotEqual(t, refreshToken, refreshResponse.GetToken().GetRefreshToken())
+
+	// reuse of the rotated token revokes the whole family
+	_, err = authClient.Refresh(ctx, &pb.RefreshRequest{RefreshToken: refreshToken})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = authClient.Refresh(ctx, &pb.RefreshRequest{RefreshToken: refreshResponse.GetToken().GetRefreshToken()})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = authClient.Refresh(ctx, &pb.RefreshRequest{RefreshToken: "invalid_refresh_token"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// access tokens of the session are revoked with the family
+	reusedCtx := metadata.AppendToOutgoingContext(ctx, "authorization", "bearer "+loginResponse.GetToken().GetToken())
+	_, err = gophkeeperClient.GetData(reusedCtx, &pb.GetDataRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	loginResponse, err = authClient.Login(ctx, &pb.LoginRequest{User: user})
+	require.NoError(t, err)
+
 	// add jwt token for authorization
 	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "bearer "+loginResponse.GetToken().GetToken())
 
@@ -83,6 +244,7 @@ func TestGophkeeperServer_Positive_Negative(t *testing.T) {
 	textData := &pb.AddDataRequest{Data: &pb.Data{
 		DataType:   pb.DataType_TEXT_TYPE,
 		DataBinary: textSecret,
+		Metadata:   &pb.Metadata{Title: "note", Tags: []string{"work"}, Fields: map[string]string{"site": "example.com"}},
 	}}
 	_, err = gophkeeperClient.AddData(ctx, textData)
 	assert.NoError(t, err)
@@ -131,8 +293,12 @@ func TestGophkeeperServer_Positive_Negative(t *testing.T) {
 			assert.Equal(t, credentialsSecret, secret.GetDataBinary())
 		case pb.DataType_TEXT_TYPE:
 			assert.Equal(t, textSecret, secret.GetDataBinary())
+			assert.Equal(t, "note", secret.GetMetadata().GetTitle())
+			assert.Equal(t, []string{"work"}, secret.GetMetadata().GetTags())
+			assert.Equal(t, "example.com", secret.GetMetadata().GetFields()["site"])
 		case pb.DataType_BINARY_TYPE:
 			assert.Equal(t, binarySecret, secret.GetDataBinary())
+			assert.Nil(t, secret.GetMetadata())
 		case pb.DataType_CARD_TYPE:
 			assert.Equal(t, cardSecret, secret.GetDataBinary())
 		default:
@@ -140,9 +306,277 @@ func TestGophkeeperServer_Positive_Negative(t *testing.T) {
 		}
 	}
 
-	// Delete one secret from storage
+	// vault for user registered without vault
+	_, err = authClient.GetVault(ctx, &pb.GetVaultRequest{})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	vault, vaultKey, err := secure.NewVault(user.Password)
+	require.NoError(t, err)
+	_, err = authClient.SetVault(ctx, &pb.SetVaultRequest{Vault: &pb.Vault{
+		Salt:       vault.Salt,
+		KdfTime:    vault.KdfTime,
+		KdfMemory:  vault.KdfMemory,
+		KdfThreads: vault.KdfThreads,
+		WrappedKey: vault.WrappedKey,
+	}})
+	assert.NoError(t, err)
+
+	_, err = authClient.SetVault(ctx, &pb.SetVaultRequest{Vault: &pb.Vault{
+		Salt:       vault.Salt,
+		KdfTime:    vault.KdfTime,
+		KdfMemory:  vault.KdfMemory,
+		KdfThreads: vault.KdfThreads,
+		WrappedKey: vault.WrappedKey,
+	}})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	getVaultResponse, err := authClient.GetVault(ctx, &pb.GetVaultRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, vault.WrappedKey, getVaultResponse.GetVault().GetWrappedKey())
+
+	// client encrypted data is stored as opaque blob
+	opaque, err := secure.EncryptWithKey(vaultKey, textSecret, nil)
+	require.NoError(t, err)
+	_, err = gophkeeperClient.AddData(ctx, &pb.AddDataRequest{Data: &pb.Data{
+		DataType:        pb.DataType_TEXT_TYPE,
+		DataBinary:      opaque,
+		ClientEncrypted: true,
+	}})
+	assert.NoError(t, err)
+
+	getDataResponse, err = gophkeeperClient.GetData(ctx, &pb.GetDataRequest{})
+	assert.NoError(t, err)
+	clientEncrypted := 0
+	for _, secret := range getDataResponse.Data {
+		if secret.GetClientEncrypted() {
+			clientEncrypted++
+			decrypted, err := secure.DecryptWithKey(vaultKey, secret.GetDataBinary(), nil)
+			assert.NoError(t, err)
+			assert.Equal(t, textSecret, decrypted)
+		}
+	}
+	assert.Equal(t, 1, clientEncrypted)
+
+	// update keeps ID of the record and rejects stale revision
+	var edited *pb.Data
+	for _, secret := range getDataResponse.Data {
+		if secret.GetClientEncrypted() {
+			edited = secret
+		}
+	}
+	require.NotNil(t, edited)
+	assert.Equal(t, int64(1), edited.GetRevision())
+	assert.NotZero(t, edited.GetUpdatedAt())
+	updatedSecret, err := secure.EncryptWithKey(vaultKey, []byte("updated"), nil)
+	require.NoError(t, err)
+	updateRequest := &pb.UpdateDataRequest{Data: &pb.Data{
+		DataId:          edited.GetDataId(),
+		DataType:        pb.DataType_TEXT_TYPE,
+		DataBinary:      updatedSecret,
+		ClientEncrypted: true,
+	}, Revision: 1}
+	updateResponse, err := gophkeeperClient.UpdateData(ctx, updateRequest)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), updateResponse.GetRevision())
+	_, err = gophkeeperClient.UpdateData(ctx, updateRequest)
+	assert.Equal(t, codes.Aborted, status.Code(err))
+	_, err = gophkeeperClient.UpdateData(ctx, &pb.UpdateDataRequest{Data: &pb.Data{
+		DataId: edited.GetDataId(), DataType: pb.DataType_CARD_TYPE, DataBinary: updatedSecret}, Revision: 2})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = gophkeeperClient.UpdateData(ctx, &pb.UpdateDataRequest{Data: &pb.Data{
+		DataId: uuid.NewString(), DataBinary: updatedSecret}, Revision: 1})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = gophkeeperClient.UpdateData(ctx, &pb.UpdateDataRequest{Data: &pb.Data{DataId: "invalid"}, Revision: 1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = gophkeeperClient.AddData(ctx, &pb.AddDataRequest{Data: updateRequest.GetData()})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	_, err = gophkeeperClient.DeleteData(ctx, &pb.DeleteDataRequest{DataId: edited.GetDataId(), Revision: 1})
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	// replaced revision is kept in history and can be restored
+	revisionsResponse, err := gophkeeperClient.ListRevisions(ctx, &pb.ListRevisionsRequest{DataId: edited.GetDataId()})
+	require.NoError(t, err)
+	if assert.Len(t, revisionsResponse.GetRevisions(), 1) {
+		assert.Equal(t, int64(1), revisionsResponse.GetRevisions()[0].GetRevision())
+		decrypted, err := secure.DecryptWithKey(vaultKey, revisionsResponse.GetRevisions()[0].GetDataBinary(), nil)
+		assert.NoError(t, err)
+		assert.Equal(t, textSecret, decrypted)
+	}
+	restoreResponse, err := gophkeeperClient.RestoreRevision(ctx, &pb.RestoreRevisionRequest{DataId: edited.GetDataId(), Revision: 1})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), restoreResponse.GetData().GetRevision())
+	assert.Equal(t, edited.GetDataBinary(), restoreResponse.GetData().GetDataBinary())
+	_, err = gophkeeperClient.RestoreRevision(ctx, &pb.RestoreRevisionRequest{DataId: edited.GetDataId(), Revision: 3})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = gophkeeperClient.RestoreRevision(ctx, &pb.RestoreRevisionRequest{DataId: edited.GetDataId()})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = gophkeeperClient.ListRevisions(ctx, &pb.ListRevisionsRequest{DataId: "invalid"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// records of another user are not found
+	otherUser := &pb.User{Login: "otherServerUser", Password: "password"}
+	_, err = authClient.Register(context.Background(), &pb.RegisterRequest{User: otherUser})
+	require.NoError(t, err)
+	otherLogin, err := authClient.Login(context.Background(), &pb.LoginRequest{User: otherUser})
+	require.NoError(t, err)
+	otherUserCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "bearer "+otherLogin.GetToken().GetToken())
+	otherDataResponse, err := gophkeeperClient.GetData(otherUserCtx, &pb.GetDataRequest{})
+	assert.NoError(t, err)
+	assert.Empty(t, otherDataResponse.GetData())
+	otherUpdate := &pb.UpdateDataRequest{Data: updateRequest.GetData(), Revision: 3}
+	_, err = gophkeeperClient.UpdateData(otherUserCtx, otherUpdate)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = gophkeeperClient.ListRevisions(otherUserCtx, &pb.ListRevisionsRequest{DataId: edited.GetDataId()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = gophkeeperClient.DeleteData(otherUserCtx, &pb.DeleteDataRequest{DataId: edited.GetDataId()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = gophkeeperClient.AddData(otherUserCtx, &pb.AddDataRequest{Data: updateRequest.GetData()})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	_, err = gophkeeperClient.UpdateData(ctx, otherUpdate)
+	assert.NoError(t, err)
+
+	// sync returns changes after the cursor of the client
+	syncResponse, err := gophkeeperClient.Sync(otherUserCtx, &pb.SyncRequest{})
+	require.NoError(t, err)
+	assert.True(t, syncResponse.GetFull())
+	assert.Empty(t, syncResponse.GetData())
+	firstID, secondID := uuid.NewString(), uuid.NewString()
+	for _, id := range []string{firstID, secondID} {
+		_, err = gophkeeperClient.AddData(otherUserCtx, &pb.AddDataRequest{Data: &pb.Data{
+			DataId: id, DataType: pb.DataType_TEXT_TYPE, DataBinary: textSecret}})
+		require.NoError(t, err)
+	}
+	syncResponse, err = gophkeeperClient.Sync(otherUserCtx, &pb.SyncRequest{Cursor: syncResponse.GetCursor()})
+	require.NoError(t, err)
+	assert.False(t, syncResponse.GetFull())
+	assert.Len(t, syncResponse.GetData(), 2)
+	_, err = gophkeeperClient.DeleteData(otherUserCtx, &pb.DeleteDataRequest{DataId: firstID})
+	require.NoError(t, err)
+	syncResponse, err = gophkeeperClient.Sync(otherUserCtx, &pb.SyncRequest{Cursor: syncResponse.GetCursor()})
+	require.NoError(t, err)
+	assert.Empty(t, syncResponse.GetData())
+	if assert.Len(t, syncResponse.GetTombstones(), 1) {
+		assert.Equal(t, firstID, syncResponse.GetTombstones()[0].GetDataId())
+	}
+	syncResponse, err = gophkeeperClient.Sync(otherUserCtx, &pb.SyncRequest{Cursor: syncResponse.GetCursor() + 100})
+	require.NoError(t, err)
+	assert.True(t, syncResponse.GetFull())
+	if assert.Len(t, syncResponse.GetData(), 1) {
+		assert.Equal(t, secondID, syncResponse.GetData()[0].GetDataId())
+	}
+	assert.Empty(t, syncResponse.GetTombstones())
+	_, err = gophkeeperClient.Sync(otherUserCtx, &pb.SyncRequest{Cursor: -1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// watch pushes changes of the user after the cursor
+	watchCtx, cancelWatch := context.WithTimeout(otherUserCtx, 10*time.Second)
+	watchStream, err := gophkeeperClient.Watch(watchCtx, &pb.WatchRequest{Cursor: syncResponse.GetCursor()})
+	require.NoError(t, err)
+	_, err = gophkeeperClient.AddData(otherUserCtx, &pb.AddDataRequest{Data: &pb.Data{
+		DataId: firstID, DataType: pb.DataType_TEXT_TYPE, DataBinary: textSecret}})
+	require.NoError(t, err)
+	event, err := watchStream.Recv()
+	require.NoError(t, err)
+	assert.Greater(t, event.GetCursor(), syncResponse.GetCursor())
+	_, err = gophkeeperClient.DeleteData(otherUserCtx, &pb.DeleteDataRequest{DataId: secondID})
+	require.NoError(t, err)
+	for !event.GetDeleted() {
+		event, err = watchStream.Recv()
+		require.NoError(t, err)
+	}
+	assert.Equal(t, secondID, event.GetDataId())
+	assert.Equal(t, pb.DataType_TEXT_TYPE, event.GetDataType())
+	cancelWatch()
+
+	invalidWatch, err := gophkeeperClient.Watch(otherUserCtx, &pb.WatchRequest{Cursor: -1})
+	require.NoError(t, err)
+	_, err = invalidWatch.Recv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	anonymousWatch, err := gophkeeperClient.Watch(context.Background(), &pb.WatchRequest{})
+	require.NoError(t, err)
+	_, err = anonymousWatch.Recv()
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// personal access token is restricted to its scope
+	tokenKey, wrappedKey, err := secure.WrapVaultKeyForToken(vaultKey)
+	require.NoError(t, err)
+	_, err = authClient.CreateAccessToken(ctx, &pb.CreateAccessTokenRequest{Name: "ci", DataTypes: []string{"unknown"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = authClient.CreateAccessToken(ctx, &pb.CreateAccessTokenRequest{Name: "ci", DataIds: []string{uuid.NewString()}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	createTokenResponse, err := authClient.CreateAccessToken(ctx, &pb.CreateAccessTokenRequest{
+		Name:       "ci",
+		ReadOnly:   true,
+		DataTypes:  []string{"text"},
+		ExpiresIn:  3600,
+		WrappedKey: wrappedKey,
+	})
+	require.NoError(t, err)
+	assert.NotEmpty(t, createTokenResponse.GetToken())
+
+	tokenCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "bearer "+createTokenResponse.GetToken())
+	tokenDataResponse, err := gophkeeperClient.GetData(tokenCtx, &pb.GetDataRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, tokenDataResponse.GetData(), 2)
+	for _, secret := range tokenDataResponse.GetData() {
+		assert.Equal(t, pb.DataType_TEXT_TYPE, secret.GetDataType())
+	}
+	tokenVaultResponse, err := authClient.GetVault(tokenCtx, &pb.GetVaultRequest{})
+	assert.NoError(t, err)
+	unwrapped, err := secure.UnwrapVaultKeyWithToken(tokenKey, tokenVaultResponse.GetVault().GetWrappedKey())
+	assert.NoError(t, err)
+	assert.Equal(t, vaultKey, unwrapped)
+
+	_, err = gophkeeperClient.AddData(tokenCtx, textData)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = gophkeeperClient.DeleteData(tokenCtx, &pb.DeleteDataRequest{DataId: tokenDataResponse.GetData()[0].GetDataId()})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = gophkeeperClient.PurgeData(tokenCtx, &pb.PurgeDataRequest{DataId: tokenDataResponse.GetData()[0].GetDataId()})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	updateRequest.Revision = 2
+	_, err = gophkeeperClient.UpdateData(tokenCtx, updateRequest)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = authClient.ListSessions(tokenCtx, &pb.ListSessionsRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	listTokensResponse, err := authClient.ListAccessTokens(ctx, &pb.ListAccessTokensRequest{})
+	assert.NoError(t, err)
+	if assert.Len(t, listTokensResponse.GetAccessTokens(), 1) {
+		assert.Equal(t, "ci", listTokensResponse.GetAccessTokens()[0].GetName())
+		assert.Equal(t, []string{"text"}, listTokensResponse.GetAccessTokens()[0].GetDataTypes())
+		assert.NotZero(t, listTokensResponse.GetAccessTokens()[0].GetLastUsedAt())
+	}
+	_, err = authClient.RevokeAccessToken(ctx, &pb.RevokeAccessTokenRequest{Id: createTokenResponse.GetAccessToken().GetId()})
+	assert.NoError(t, err)
+	_, err = gophkeeperClient.GetData(tokenCtx, &pb.GetDataRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// Delete one secret from storage, deleted secret is kept in trash until it is restored or purged
 	secret := getDataResponse.Data[0]
 	_, err = gophkeeperClient.DeleteData(ctx, &pb.DeleteDataRequest{DataId: secret.DataId})
+	require.NoError(t, err)
+	trashResponse, err := gophkeeperClient.ListTrash(ctx, &pb.ListTrashRequest{})
+	require.NoError(t, err)
+	if assert.Len(t, trashResponse.GetData(), 1) {
+		assert.Equal(t, secret.GetDataId(), trashResponse.GetData()[0].GetDataId())
+		assert.NotZero(t, trashResponse.GetData()[0].GetDeletedAt())
+	}
+	_, err = gophkeeperClient.AddData(ctx, &pb.AddDataRequest{Data: secret})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	restoreDataResponse, err := gophkeeperClient.RestoreData(ctx, &pb.RestoreDataRequest{DataId: secret.GetDataId()})
+	require.NoError(t, err)
+	assert.Equal(t, secret.GetDataId(), restoreDataResponse.GetData().GetDataId())
+	assert.Zero(t, restoreDataResponse.GetData().GetDeletedAt())
+	_, err = gophkeeperClient.PurgeData(ctx, &pb.PurgeDataRequest{DataId: secret.GetDataId()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = gophkeeperClient.DeleteData(ctx, &pb.DeleteDataRequest{DataId: secret.GetDataId()})
+	require.NoError(t, err)
+	_, err = gophkeeperClient.PurgeData(ctx, &pb.PurgeDataRequest{DataId: secret.GetDataId()})
+	assert.NoError(t, err)
+	_, err = gophkeeperClient.RestoreData(ctx, &pb.RestoreDataRequest{DataId: secret.GetDataId()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = gophkeeperClient.RestoreData(ctx, &pb.RestoreDataRequest{DataId: "invalid"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
 
 	// negative tests for authClient
 	_, err = authClient.Register(ctx, nil)
@@ -163,7 +597,9 @@ func TestGophkeeperServer_Positive_Negative(t *testing.T) {
 	log.Printf("err : %v", err.Error())
 
 	_, err = gophkeeperClient.DeleteData(ctx, &pb.DeleteDataRequest{DataId: "invalid_dataid"})
-	assert.NotNil(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = gophkeeperClient.DeleteData(ctx, &pb.DeleteDataRequest{DataId: uuid.NewString()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
 	log.Printf("err : %v", err.Error())
 
 	// reset metadata and context to get authorization error
@@ -177,4 +613,532 @@ func TestGophkeeperServer_Positive_Negative(t *testing.T) {
 	_, err = gophkeeperClient.GetData(ctx, &pb.GetDataRequest{})
 	assert.NotNil(t, err)
 	log.Printf("err : %v", err.Error())
+
+	// Logout revokes access token and refresh tokens of the session
+	first, err := authClient.Login(context.Background(), &pb.LoginRequest{User: user})
+	require.NoError(t, err)
+	second, err := authClient.Login(context.Background(), &pb.LoginRequest{User: user,
+		Client: &pb.ClientInfo{DeviceName: "laptop", ClientVersion: "1.0.0"}})
+	require.NoError(t, err)
+	third, err := authClient.Login(context.Background(), &pb.LoginRequest{User: user,
+		Client: &pb.ClientInfo{DeviceName: "phone", ClientVersion: "1.0.0"}})
+	require.NoError(t, err)
+
+	firstCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "bearer "+first.GetToken().GetToken())
+	firstWatchCtx, cancelFirstWatch := context.WithTimeout(firstCtx, 10*time.Second)
+	defer cancelFirstWatch()
+	firstWatch, err := gophkeeperClient.Watch(firstWatchCtx, &pb.WatchRequest{})
+	require.NoError(t, err)
+	_, err = authClient.Logout(firstCtx, &pb.LogoutRequest{RefreshToken: first.GetToken().GetRefreshToken()})
+	assert.NoError(t, err)
+	// open stream of the signed out session is closed
+	for err == nil {
+		_, err = firstWatch.Recv()
+	}
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = gophkeeperClient.GetData(firstCtx, &pb.GetDataRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = authClient.Refresh(context.Background(), &pb.RefreshRequest{RefreshToken: first.GetToken().GetRefreshToken()})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// other sessions are still valid
+	secondCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "bearer "+second.GetToken().GetToken())
+	_, err = gophkeeperClient.GetData(secondCtx, &pb.GetDataRequest{})
+	assert.NoError(t, err)
+
+	// signed out session is not listed
+	sessionsResponse, err := authClient.ListSessions(secondCtx, &pb.ListSessionsRequest{})
+	require.NoError(t, err)
+	sessions := make(map[string]*pb.Session)
+	for _, session := range sessionsResponse.GetSessions() {
+		sessions[session.GetDeviceName()] = session
+	}
+	assert.True(t, sessions["laptop"].GetCurrent())
+	assert.Equal(t, "1.0.0", sessions["laptop"].GetClientVersion())
+	assert.Equal(t, "127.0.0.1", sessions["laptop"].GetAddress())
+	assert.False(t, sessions["phone"].GetCurrent())
+	assert.Len(t, sessionsResponse.GetSessions(), 3)
+
+	// revoke session of another device
+	_, err = authClient.RevokeSession(secondCtx, &pb.RevokeSessionRequest{SessionId: "invalid"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	_, err = authClient.RevokeSession(secondCtx, &pb.RevokeSessionRequest{SessionId: uuid.NewString()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = authClient.RevokeSession(secondCtx, &pb.RevokeSessionRequest{SessionId: sessions["phone"].GetId()})
+	assert.NoError(t, err)
+	phoneCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "bearer "+third.GetToken().GetToken())
+	_, err = gophkeeperClient.GetData(phoneCtx, &pb.GetDataRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = gophkeeperClient.GetData(secondCtx, &pb.GetDataRequest{})
+	assert.NoError(t, err)
+
+	// log out all devices
+	_, err = authClient.Logout(secondCtx, &pb.LogoutRequest{AllDevices: true})
+	assert.NoError(t, err)
+	thirdCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "bearer "+third.GetToken().GetToken())
+	_, err = gophkeeperClient.GetData(thirdCtx, &pb.GetDataRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = authClient.Refresh(context.Background(), &pb.RefreshRequest{RefreshToken: third.GetToken().GetRefreshToken()})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// new login after logout is valid
+	fourth, err := authClient.Login(context.Background(), &pb.LoginRequest{User: user})
+	require.NoError(t, err)
+	fourthCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "bearer "+fourth.GetToken().GetToken())
+	_, err = gophkeeperClient.GetData(fourthCtx, &pb.GetDataRequest{})
+	assert.NoError(t, err)
+
+	// enable two-factor authentication
+	enableResponse, err := authClient.EnableTwoFactor(fourthCtx, &pb.EnableTwoFactorRequest{})
+	require.NoError(t, err)
+	assert.Contains(t, enableResponse.GetProvisioningUri(), enableResponse.GetSecret())
+	assert.Len(t, enableResponse.GetRecoveryCodes(), recoveryCodesCount)
+	_, err = authClient.ConfirmTwoFactor(fourthCtx, &pb.ConfirmTwoFactorRequest{Code: "000000"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	code, err := auth.GenerateTOTPCode(enableResponse.GetSecret(), time.Now())
+	require.NoError(t, err)
+	_, err = authClient.ConfirmTwoFactor(fourthCtx, &pb.ConfirmTwoFactorRequest{Code: code})
+	assert.NoError(t, err)
+	_, err = authClient.EnableTwoFactor(fourthCtx, &pb.EnableTwoFactorRequest{})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	// login requires second factor, challenge is not an access token
+	challengeResponse, err := authClient.Login(context.Background(), &pb.LoginRequest{User: user})
+	require.NoError(t, err)
+	assert.True(t, challengeResponse.GetTwoFactorRequired())
+	assert.Nil(t, challengeResponse.GetToken())
+	challengeCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "bearer "+challengeResponse.GetChallenge())
+	_, err = gophkeeperClient.GetData(challengeCtx, &pb.GetDataRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// code of the confirmation cannot be used again
+	_, err = authClient.VerifyTwoFactor(context.Background(), &pb.VerifyTwoFactorRequest{
+		Challenge: challengeResponse.GetChallenge(), Code: code})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	nextCode, err := auth.GenerateTOTPCode(enableResponse.GetSecret(), time.Now().Add(auth.TOTPPeriod*time.Second))
+	require.NoError(t, err)
+	verifyResponse, err := authClient.VerifyTwoFactor(context.Background(), &pb.VerifyTwoFactorRequest{
+		Challenge: challengeResponse.GetChallenge(), Code: nextCode})
+	require.NoError(t, err)
+	verifyCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "bearer "+verifyResponse.GetToken().GetToken())
+	_, err = gophkeeperClient.GetData(verifyCtx, &pb.GetDataRequest{})
+	assert.NoError(t, err)
+
+	// challenge is used once
+	_, err = authClient.VerifyTwoFactor(context.Background(), &pb.VerifyTwoFactorRequest{
+		Challenge: challengeResponse.GetChallenge(), Code: enableResponse.GetRecoveryCodes()[0]})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// login with recovery code, every recovery code is used once
+	challengeResponse, err = authClient.Login(context.Background(), &pb.LoginRequest{User: user})
+	require.NoError(t, err)
+	_, err = authClient.VerifyTwoFactor(context.Background(), &pb.VerifyTwoFactorRequest{
+		Challenge: challengeResponse.GetChallenge(), Code: enableResponse.GetRecoveryCodes()[0]})
+	assert.NoError(t, err)
+	_, err = authClient.DisableTwoFactor(verifyCtx, &pb.DisableTwoFactorRequest{Code: enableResponse.GetRecoveryCodes()[0]})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// disable two-factor authentication
+	_, err = authClient.DisableTwoFactor(verifyCtx, &pb.DisableTwoFactorRequest{Code: enableResponse.GetRecoveryCodes()[1]})
+	assert.NoError(t, err)
+	loginResponse, err = authClient.Login(context.Background(), &pb.LoginRequest{User: user})
+	require.NoError(t, err)
+	assert.False(t, loginResponse.GetTwoFactorRequired())
+	assert.NotEmpty(t, loginResponse.GetToken().GetToken())
+
+	// change password revokes other sessions
+	otherSession, err := authClient.Login(context.Background(), &pb.LoginRequest{User: user})
+	require.NoError(t, err)
+	loginCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "bearer "+loginResponse.GetToken().GetToken())
+	_, err = authClient.ChangePassword(loginCtx, &pb.ChangePasswordRequest{OldPassword: "invalid", NewPassword: "new_password"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	changeResponse, err := authClient.ChangePassword(loginCtx, &pb.ChangePasswordRequest{
+		OldPassword: user.Password, NewPassword: "new_password"})
+	require.NoError(t, err)
+
+	otherCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "bearer "+otherSession.GetToken().GetToken())
+	_, err = gophkeeperClient.GetData(otherCtx, &pb.GetDataRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = authClient.Refresh(context.Background(), &pb.RefreshRequest{RefreshToken: otherSession.GetToken().GetRefreshToken()})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	changeCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "bearer "+changeResponse.GetToken().GetToken())
+	_, err = gophkeeperClient.GetData(changeCtx, &pb.GetDataRequest{})
+	assert.NoError(t, err)
+
+	_, err = authClient.Login(context.Background(), &pb.LoginRequest{User: user})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	user.Password = "new_password"
+	_, err = authClient.Login(context.Background(), &pb.LoginRequest{User: user})
+	assert.NoError(t, err)
+
+	// delete account with all private data
+	_, err = authClient.DeleteAccount(changeCtx, &pb.DeleteAccountRequest{Password: "invalid"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = authClient.DeleteAccount(changeCtx, &pb.DeleteAccountRequest{Password: user.Password})
+	assert.NoError(t, err)
+	_, err = gophkeeperClient.GetData(changeCtx, &pb.GetDataRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = authClient.Login(context.Background(), &pb.LoginRequest{User: user})
+	assert.Error(t, err)
+
+	// unknown user and invalid password are not distinguished
+	_, unknownErr := authClient.Login(context.Background(), &pb.LoginRequest{User: &pb.User{Login: "unknownUser", Password: "password"}})
+	assert.Equal(t, codes.Unauthenticated, status.Code(unknownErr))
+	_, err = authClient.Register(context.Background(), &pb.RegisterRequest{User: &pb.User{Login: "lockedUser", Password: "password"}})
+	require.NoError(t, err)
+	lockedResponse, err := authClient.Login(context.Background(), &pb.LoginRequest{User: &pb.User{Login: "lockedUser", Password: "password"}})
+	require.NoError(t, err)
+	_, invalidErr := authClient.Login(context.Background(), &pb.LoginRequest{User: &pb.User{Login: "lockedUser", Password: "invalid"}})
+	assert.Equal(t, unknownErr.Error(), invalidErr.Error())
+
+	// login is locked after failed attempts, even with valid password. Password checks of the signed-in user
+	// are failed attempts as well, so the password cannot be guessed with access token.
+	cfg, err := config.ReadConfig()
+	require.NoError(t, err)
+	lockedCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "bearer "+lockedResponse.GetToken().GetToken())
+	for i := 1; i < cfg.LoginAttempts; i++ {
+		_, err = authClient.ChangePassword(lockedCtx, &pb.ChangePasswordRequest{OldPassword: "invalid", NewPassword: "new_password"})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	}
+	_, err = authClient.Login(context.Background(), &pb.LoginRequest{User: &pb.User{Login: "lockedUser", Password: "password"}})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	_, err = authClient.DeleteAccount(lockedCtx, &pb.DeleteAccountRequest{Password: "password"})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// SRP login, the password is not sent to the server
+	salt, verifier, err := auth.NewSRPVerifier("srpUser", "password")
+	require.NoError(t, err)
+	_, err = authClient.Register(context.Background(), &pb.RegisterRequest{
+		User: &pb.User{Login: "srpUser"},
+		Srp:  &pb.SRPVerifier{Salt: salt, Verifier: verifier},
+	})
+	require.NoError(t, err)
+
+	proof, srpClient := startSRP(t, authClient, "srpUser", "password")
+	finishResponse, err := authClient.LoginFinish(context.Background(), &pb.LoginFinishRequest{Login: "srpUser", Proof: proof})
+	require.NoError(t, err)
+	assert.True(t, srpClient.VerifyServer(finishResponse.GetServerProof()))
+	assert.NotEmpty(t, finishResponse.GetToken().GetToken())
+
+	// every handshake is used once
+	_, err = authClient.LoginFinish(context.Background(), &pb.LoginFinishRequest{Login: "srpUser", Proof: proof})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	invalidProof, _ := startSRP(t, authClient, "srpUser", "invalid")
+	_, err = authClient.LoginFinish(context.Background(), &pb.LoginFinishRequest{Login: "srpUser", Proof: invalidProof})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// password login of SRP user is rejected
+	_, err = authClient.Login(context.Background(), &pb.LoginRequest{User: &pb.User{Login: "srpUser", Password: "password"}})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// unknown user gets the same salt on every handshake
+	firstStart, err := authClient.LoginStart(context.Background(), &pb.LoginStartRequest{Login: "unknownUser"})
+	require.NoError(t, err)
+	secondStart, err := authClient.LoginStart(context.Background(), &pb.LoginStartRequest{Login: "unknownUser"})
+	require.NoError(t, err)
+	assert.Equal(t, firstStart.GetSalt(), secondStart.GetSalt())
+	assert.NotEqual(t, firstStart.GetServerPublic(), secondStart.GetServerPublic())
+
+	// change password with SRP proof
+	srpCtx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "bearer "+finishResponse.GetToken().GetToken())
+	proof, _ = startSRP(t, authClient, "srpUser", "password")
+	salt, verifier, err = auth.NewSRPVerifier("srpUser", "new_password")
+	require.NoError(t, err)
+	changeResponse, err = authClient.ChangePassword(srpCtx, &pb.ChangePasswordRequest{
+		Proof: proof,
+		Srp:   &pb.SRPVerifier{Salt: salt, Verifier: verifier},
+	})
+	require.NoError(t, err)
+	proof, _ = startSRP(t, authClient, "srpUser", "new_password")
+	_, err = authClient.LoginFinish(context.Background(), &pb.LoginFinishRequest{Login: "srpUser", Proof: proof})
+	assert.NoError(t, err)
+
+	// legacy user is upgraded to SRP by password login
+	_, err = authClient.Register(context.Background(), &pb.RegisterRequest{User: &pb.User{Login: "legacyUser", Password: "password"}})
+	require.NoError(t, err)
+	// legacy user gets the same handshake as unknown user
+	legacyStart, err := authClient.LoginStart(context.Background(), &pb.LoginStartRequest{Login: "legacyUser"})
+	require.NoError(t, err)
+	assert.NotEmpty(t, legacyStart.GetSalt())
+	assert.NotEmpty(t, legacyStart.GetServerPublic())
+	legacyResponse, err := authClient.Login(context.Background(), &pb.LoginRequest{
+		User: &pb.User{Login: "legacyUser", Password: "password"},
+	})
+	require.NoError(t, err)
+	assert.True(t, legacyResponse.GetUpgradeRequired())
+	salt, verifier, err = auth.NewSRPVerifier("legacyUser", "password")
+	require.NoError(t, err)
+	legacyResponse, err = authClient.Login(context.Background(), &pb.LoginRequest{
+		User: &pb.User{Login: "legacyUser", Password: "password"},
+		Srp:  &pb.SRPVerifier{Salt: salt, Verifier: verifier},
+	})
+	require.NoError(t, err)
+	assert.False(t, legacyResponse.GetUpgradeRequired())
+	proof, _ = startSRP(t, authClient, "legacyUser", "password")
+	_, err = authClient.LoginFinish(context.Background(), &pb.LoginFinishRequest{Login: "legacyUser", Proof: proof})
+	assert.NoError(t, err)
+
+	// delete account with SRP proof
+	changeCtx = metadata.AppendToOutgoingContext(context.Background(), "authorization", "bearer "+changeResponse.GetToken().GetToken())
+	proof, _ = startSRP(t, authClient, "srpUser", "new_password")
+	_, err = authClient.DeleteAccount(changeCtx, &pb.DeleteAccountRequest{Proof: proof})
+	assert.NoError(t, err)
+}
+
+// startSRP starts SRP handshake and returns proof of the password.
+func startSRP(t *testing.T, authClient pb.AuthClient, login string, password string) (*pb.SRPProof, *auth.SRPClient) {
+	response, err := authClient.LoginStart(context.Background(), &pb.LoginStartRequest{Login: login})
+	require.NoError(t, err)
+	srpClient, err := auth.NewSRPClient(login, password)
+	require.NoError(t, err)
+	proof, err := srpClient.Proof(response.GetSalt(), response.GetServerPublic())
+	require.NoError(t, err)
+	return &pb.SRPProof{
+		HandshakeId:  response.GetHandshakeId(),
+		ClientPublic: srpClient.PublicKey(),
+		Proof:        proof,
+	}, srpClient
+}
+
+// writeCertificate creates self-signed certificate and saves it with the private key in PEM format.
+// Returns paths to the files.
+func writeCertificate(t *testing.T, template *x509.Certificate) (string, string, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	require.NoError(t, err)
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600)
+	require.NoError(t, err)
+	return certFile, keyFile, certificate
+}
+
+func TestGophkeeperServer_ClientCertificate(t *testing.T) {
+	if testhelpers.IsGithubActions() {
+		// skip testcontainers for github actions
+		return
+	}
+
+	// run docker with postgres
+	storageContainer := testhelpers.NewTestDatabase(t)
+	dsn := storageContainer.ConnectionString(t)
+
+	caCertFile, caKeyFile, _ := writeCertificate(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Gophkeeper device CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	})
+	serverCertFile, serverKeyFile, serverCert := writeCertificate(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+
+	t.Setenv("DATABASE_DSN", dsn)
+	t.Setenv("SERVER_ADDRESS", "localhost:3203")
+	t.Setenv("ENABLE_MIGRATION", "true")
+	t.Setenv("ENABLE_TLS", "true")
+	t.Setenv("DEV_MODE", "true")
+	t.Setenv("TLS_CERT_FILE", serverCertFile)
+	t.Setenv("TLS_KEY_FILE", serverKeyFile)
+	t.Setenv("TLS_CA_FILE", caCertFile)
+	t.Setenv("TLS_CA_KEY_FILE", caKeyFile)
+	t.Setenv("STREAM_RECHECK_INTERVAL", "100ms")
+
+	// start grpc server
+	go startGrpcServer(t)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(serverCert)
+	dial := func(certificates ...tls.Certificate) pb.GophkeeperClient {
+		transportCredentials := credentials.NewTLS(&tls.Config{RootCAs: roots, Certificates: certificates, MinVersion: tls.VersionTLS12})
+		conn, err := grpc.Dial("localhost:3203", grpc.WithTransportCredentials(transportCredentials))
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			assert.NoError(t, conn.Close())
+		})
+		return pb.NewGophkeeperClient(conn)
+	}
+
+	transportCredentials := credentials.NewTLS(&tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12})
+	conn, err := grpc.Dial("localhost:3203", grpc.WithTransportCredentials(transportCredentials))
+	require.NoError(t, err)
+	defer func(conn *grpc.ClientConn) {
+		assert.NoError(t, conn.Close())
+	}(conn)
+	authClient := pb.NewAuthClient(conn)
+
+	user := &pb.User{Login: "deviceUser", Password: "password"}
+	_, err = authClient.Register(context.Background(), &pb.RegisterRequest{User: user})
+	require.NoError(t, err)
+	loginResponse, err := authClient.Login(context.Background(), &pb.LoginRequest{User: user})
+	require.NoError(t, err)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "bearer "+loginResponse.GetToken().GetToken())
+
+	// enrollment of the device requires access token and valid csr
+	keyPEM, csrPEM, err := auth.NewDeviceKey("laptop")
+	require.NoError(t, err)
+	_, err = authClient.RegisterDevice(context.Background(), &pb.RegisterDeviceRequest{Name: "laptop", Csr: csrPEM})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = authClient.RegisterDevice(ctx, &pb.RegisterDeviceRequest{Name: "laptop", Csr: keyPEM})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	deviceResponse, err := authClient.RegisterDevice(ctx, &pb.RegisterDeviceRequest{Name: "laptop", Csr: csrPEM})
+	require.NoError(t, err)
+	assert.NotEmpty(t, deviceResponse.GetDeviceId())
+	assert.NotEmpty(t, deviceResponse.GetCaCertificate())
+
+	// client certificate replaces access token
+	deviceCertificate, err := tls.X509KeyPair(deviceResponse.GetCertificate(), keyPEM)
+	require.NoError(t, err)
+	deviceClient := dial(deviceCertificate)
+	_, err = deviceClient.GetData(context.Background(), &pb.GetDataRequest{})
+	assert.NoError(t, err)
+
+	// connection without client certificate still requires access token
+	_, err = dial().GetData(context.Background(), &pb.GetDataRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// revoked certificate is rejected and open stream of the device is closed
+	watchCtx, cancelWatch := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancelWatch()
+	deviceWatch, err := deviceClient.Watch(watchCtx, &pb.WatchRequest{})
+	require.NoError(t, err)
+	_, err = authClient.RevokeDevice(ctx, &pb.RevokeDeviceRequest{DeviceId: uuid.NewString()})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	_, err = authClient.RevokeDevice(ctx, &pb.RevokeDeviceRequest{DeviceId: deviceResponse.GetDeviceId()})
+	assert.NoError(t, err)
+	_, err = deviceClient.GetData(context.Background(), &pb.GetDataRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = deviceWatch.Recv()
+	for err == nil {
+		_, err = deviceWatch.Recv()
+	}
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestKeyRotation_Run(t *testing.T) {
+	if testhelpers.IsGithubActions() {
+		// skip testcontainers for github actions
+		return
+	}
+
+	// run docker with postgres
+	storageContainer := testhelpers.NewTestDatabase(t)
+	defer storageContainer.Close(t)
+	dsn := storageContainer.ConnectionString(t)
+
+	ctx := context.Background()
+	pool, err := postgres.ConnectDB(ctx, dsn)
+	require.NoError(t, err)
+	conn, err := postgres.ConnectDBForMigration(dsn)
+	require.NoError(t, err)
+	require.NoError(t, postgres.RunMigrations(conn))
+
+	dbStorage := postgres.NewDBStorage(pool)
+	defer dbStorage.ReleaseStorage()
+	svc := service.NewService(dbStorage)
+
+	user := models.User{ID: uuid.NewString(), Login: "rotationUser", Password: "password"}
+	require.NoError(t, svc.RegisterUser(ctx, user))
+
+	oldKey := secure.NewStaticKeyProvider("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	newKey := secure.NewStaticKeyProvider("ffeeddccbbaa99887766554433221100ffeeddccbbaa99887766554433221100")
+
+	// store legacy data and user key with the old master key
+	require.NoError(t, secure.SetKeyProvider(oldKey))
+	var legacy models.Data
+	for i := 0; i < 3; i++ {
+		legacy = models.Data{ID: uuid.NewString(), UserID: user.ID}
+		legacy.DataBinary, err = secure.Encrypt([]byte("secret"), secure.RecordAdditionalData(legacy.ID, user.ID))
+		require.NoError(t, err)
+		require.NoError(t, svc.AddData(ctx, legacy))
+	}
+	// previous revision of legacy data is kept in history
+	legacy.DataBinary, err = secure.Encrypt([]byte("new secret"), secure.RecordAdditionalData(legacy.ID, user.ID))
+	require.NoError(t, err)
+	_, err = svc.UpdateData(ctx, legacy, 1, 10)
+	require.NoError(t, err)
+
+	userKey, err := loadUserKey(ctx, *svc, user.ID)
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		data, err := secure.EncryptPrivateData(&pb.Data{DataBinary: []byte("secret")}, user.ID, userKey)
+		require.NoError(t, err)
+		require.NoError(t, svc.AddData(ctx, data))
+	}
+	stored, err := svc.GetDataByUserID(ctx, user.ID)
+	require.NoError(t, err)
+
+	// rotate: new key is active, old key is retired
+	require.NoError(t, secure.SetKeyProvider(newKey, oldKey))
+
+	progress, err := NewKeyRotation(*svc, 2).Run(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, RotationProgress{Keys: 1, Rewrapped: 1, Total: 5, Processed: 5, Migrated: 3, Skipped: 2,
+		Revisions: 1, MigratedRevisions: 1}, progress)
+
+	// records encrypted with the user key are not re-encrypted
+	rotated, err := svc.GetDataByUserID(ctx, user.ID)
+	require.NoError(t, err)
+	unchanged := 0
+	for _, data := range rotated {
+		for _, v := range stored {
+			if v.ID == data.ID && string(v.DataBinary) == string(data.DataBinary) {
+				unchanged++
+			}
+		}
+	}
+	assert.Equal(t, 2, unchanged)
+
+	// second run resumes and skips rotated user keys and records
+	progress, err = NewKeyRotation(*svc, 2).Run(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, RotationProgress{Keys: 1, Total: 5, Processed: 5, Skipped: 7, Revisions: 1}, progress)
+
+	// data is available without retired key
+	require.NoError(t, secure.SetKeyProvider(newKey))
+	assert.NoError(t, verifyMasterKey(ctx, svc))
+
+	// revision in history is encrypted with the user key and readable without retired key
+	revisions, err := svc.GetDataRevisions(ctx, user.ID, legacy.ID)
+	require.NoError(t, err)
+	require.Len(t, revisions, 1)
+	version, err := secure.GetEnvelopeVersion(revisions[0].DataBinary)
+	require.NoError(t, err)
+	assert.Equal(t, secure.UserKeyEnvelopeVersion, version)
+	userKey, err = loadUserKey(ctx, *svc, user.ID)
+	require.NoError(t, err)
+	decrypted, err := secure.DecryptPrivateData(revisions[0], userKey)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("secret"), decrypted.GetDataBinary())
+
+	// another master key cannot unwrap user key and server refuses to start
+	require.NoError(t, secure.SetKeyProvider(oldKey))
+	assert.Error(t, verifyMasterKey(ctx, svc))
+
+	// deleted user key makes private data unreadable (crypto-shredding)
+	require.NoError(t, secure.SetKeyProvider(newKey))
+	require.NoError(t, svc.DeleteUser(ctx, user.ID))
+	_, err = svc.GetUserKey(ctx, user.ID)
+	assert.ErrorIs(t, err, storage.ErrorUserKeyNotFound)
+	_, err = svc.GetDataByUserID(ctx, user.ID)
+	assert.ErrorIs(t, err, storage.ErrorPrivateDataNotFound)
 }
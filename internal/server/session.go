@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+	pb "github.com/vstebletsov89/go-developer-course-gophkeeper/internal/proto"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/service/auth"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// ListSessions returns active sessions of the current user. Sessions without refresh for lifetime
+// of the refresh token are expired and not returned.
+func (a *AuthServer) ListSessions(ctx context.Context, request *pb.ListSessionsRequest) (*pb.ListSessionsResponse, error) {
+	var response pb.ListSessionsResponse
+	userID := auth.ExtractUserIDFromContext(ctx)
+
+	sessions, err := a.service.GetSessions(ctx, userID, time.Now().Add(-a.refreshTokenTTL))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	currentSessionID := ""
+	if claims := auth.ExtractClaimsFromContext(ctx); claims != nil {
+		currentSessionID = claims.SessionID
+	}
+
+	response.Sessions = make([]*pb.Session, 0, len(sessions))
+	for _, session := range sessions {
+		response.Sessions = append(response.Sessions, &pb.Session{
+			Id:            session.ID,
+			DeviceName:    session.DeviceName,
+			ClientVersion: session.ClientVersion,
+			Address:       session.Address,
+			CreatedAt:     session.CreatedAt.Unix(),
+			LastSeenAt:    session.LastSeenAt.Unix(),
+			Current:       session.ID == currentSessionID,
+		})
+	}
+
+	log.Debug().Msg("Server (ListSessions): done")
+	return &response, nil
+}
+
+// RevokeSession signs out session of the current user. Access and refresh tokens of the session are rejected.
+func (a *AuthServer) RevokeSession(ctx context.Context, request *pb.RevokeSessionRequest) (*pb.RevokeSessionResponse, error) {
+	var response pb.RevokeSessionResponse
+	userID := auth.ExtractUserIDFromContext(ctx)
+
+	if _, err := uuid.Parse(request.GetSessionId()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid session id")
+	}
+
+	err := a.service.RevokeSession(ctx, userID, request.GetSessionId())
+	if errors.Is(err, storage.ErrorSessionNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.Debug().Msg("Server (RevokeSession): done")
+	return &response, nil
+}
+
+// startSession registers new session of the client and issues the first tokens of the session.
+func (a *AuthServer) startSession(ctx context.Context, userID string, client *pb.ClientInfo) (*pb.Token, error) {
+	session := models.Session{
+		ID:            uuid.NewString(),
+		UserID:        userID,
+		DeviceName:    client.GetDeviceName(),
+		ClientVersion: client.GetClientVersion(),
+		Address:       peerHost(ctx),
+		CreatedAt:     time.Now(),
+	}
+	err := a.service.SaveSession(ctx, session)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	// session starts new family of refresh tokens
+	return a.issueTokens(ctx, userID, session.ID)
+}
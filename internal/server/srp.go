@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+	pb "github.com/vstebletsov89/go-developer-course-gophkeeper/internal/proto"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/secure"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/service/auth"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+const (
+	// srpHandshakeTTL defines time between LoginStart and LoginFinish requests.
+	srpHandshakeTTL = time.Minute
+	// srpDummySaltPurpose defines purpose of the key for salts of unknown users.
+	srpDummySaltPurpose = "srp dummy salt"
+)
+
+// errorLegacyLoginDisabled is returned for password login when only SRP login is allowed.
+var errorLegacyLoginDisabled = status.Error(codes.FailedPrecondition, "password login is disabled, use SRP login")
+
+// LoginStart starts SRP login of the user. Salt of the verifier and public ephemeral key of the server are returned,
+// ephemeral secret of the server is kept until LoginFinish. Unknown user and legacy user without SRP verifier get
+// the same response as existing one, so login of legacy users is not disclosed before authentication.
+func (a *AuthServer) LoginStart(ctx context.Context, request *pb.LoginStartRequest) (*pb.LoginStartResponse, error) {
+	var response pb.LoginStartResponse
+
+	if err := a.checkAttempts(ctx, loginAttemptKey(request.GetLogin()), peerAttemptKey(ctx)); err != nil {
+		return nil, err
+	}
+
+	userDB, err := a.service.GetUserByLogin(ctx, request.GetLogin())
+	if err != nil && !errors.Is(err, storage.ErrorUserNotFound) {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if err != nil || len(userDB.SRPVerifier) == 0 {
+		key, err := secure.DeriveKey(srpDummySaltPurpose)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		server, err := auth.DummySRPServer()
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		response.HandshakeId = uuid.NewString()
+		response.Salt = auth.DummySRPSalt(key, request.GetLogin())
+		response.ServerPublic = server.PublicKey()
+
+		log.Debug().Msg("Server (LoginStart): done")
+		return &response, nil
+	}
+
+	server, err := auth.NewSRPServer(userDB.SRPVerifier)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	handshake := models.SRPHandshake{
+		ID:        uuid.NewString(),
+		UserID:    userDB.ID,
+		Secret:    server.SecretKey(),
+		ExpiresAt: time.Now().Add(srpHandshakeTTL),
+	}
+	if err := a.service.SaveSRPHandshake(ctx, handshake); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	response.HandshakeId = handshake.ID
+	response.Salt = userDB.SRPSalt
+	response.ServerPublic = server.PublicKey()
+
+	log.Debug().Msg("Server (LoginStart): done")
+	return &response, nil
+}
+
+// LoginFinish completes SRP login with proof of the password. JWT token and proof of the server return
+// in case of success, the password itself is never sent to the server.
+func (a *AuthServer) LoginFinish(ctx context.Context, request *pb.LoginFinishRequest) (*pb.LoginFinishResponse, error) {
+	var response pb.LoginFinishResponse
+
+	loginKey := loginAttemptKey(request.GetLogin())
+	peerKey := peerAttemptKey(ctx)
+	if err := a.checkAttempts(ctx, loginKey, peerKey); err != nil {
+		return nil, err
+	}
+
+	userDB, err := a.service.GetUserByLogin(ctx, request.GetLogin())
+	if errors.Is(err, storage.ErrorUserNotFound) {
+		// handshake of unknown user was not saved
+		return nil, a.addFailure(ctx, errorIncorrectCredentials, loginKey, peerKey)
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	response.ServerProof, err = a.verifySRPProof(ctx, userDB, request.GetProof())
+	if status.Code(err) == codes.Unauthenticated {
+		return nil, a.addFailure(ctx, err, loginKey, peerKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// failures of the address are not reset, otherwise own account could be used to continue brute force
+	if err := a.service.DeleteLoginAttempts(ctx, loginKey); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	login, err := a.completeLogin(ctx, userDB.ID, request.GetClient())
+	if err != nil {
+		return nil, err
+	}
+	response.Token = login.GetToken()
+	response.Vault = login.GetVault()
+	response.TwoFactorRequired = login.GetTwoFactorRequired()
+	response.Challenge = login.GetChallenge()
+
+	log.Debug().Msg("Server (LoginFinish): done")
+	return &response, nil
+}
+
+// verifySRPProof checks proof of the password from SRP handshake of the user and returns proof of the server.
+// Every handshake is used once.
+func (a *AuthServer) verifySRPProof(ctx context.Context, user models.User, proof *pb.SRPProof) ([]byte, error) {
+	if _, err := uuid.Parse(proof.GetHandshakeId()); err != nil {
+		return nil, errorIncorrectCredentials
+	}
+
+	handshake, err := a.service.UseSRPHandshake(ctx, proof.GetHandshakeId())
+	if errors.Is(err, storage.ErrorSRPHandshakeNotFound) {
+		return nil, errorIncorrectCredentials
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if handshake.UserID != user.ID || time.Now().After(handshake.ExpiresAt) || len(user.SRPVerifier) == 0 {
+		return nil, errorIncorrectCredentials
+	}
+
+	server := auth.RestoreSRPServer(user.SRPVerifier, handshake.Secret)
+	serverProof, err := server.VerifyProof(user.Login, user.SRPSalt, proof.GetClientPublic(), proof.GetProof())
+	if err != nil {
+		return nil, errorIncorrectCredentials
+	}
+	return serverProof, nil
+}
+
+// setCredentials sets SRP verifier of the user. Password is hashed with bcrypt in legacy mode only.
+func (a *AuthServer) setCredentials(user *models.User, password string, verifier *pb.SRPVerifier) error {
+	if verifier != nil {
+		if len(verifier.GetSalt()) == 0 || len(verifier.GetVerifier()) == 0 {
+			return status.Error(codes.InvalidArgument, "invalid SRP verifier")
+		}
+		user.Password = ""
+		user.SRPSalt = verifier.GetSalt()
+		user.SRPVerifier = verifier.GetVerifier()
+		return nil
+	}
+
+	if !a.legacyLogin {
+		return status.Error(codes.InvalidArgument, "SRP verifier is required")
+	}
+	if password == "" {
+		return status.Error(codes.InvalidArgument, "password is required")
+	}
+	encryptedPassword, err := auth.EncryptPassword(password)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	user.Password = encryptedPassword
+	user.SRPSalt = nil
+	user.SRPVerifier = nil
+	return nil
+}
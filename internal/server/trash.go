@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+	pb "github.com/vstebletsov89/go-developer-course-gophkeeper/internal/proto"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/service"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/service/auth"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// trashPurgeInterval defines interval between runs of the background purge of the trash.
+const trashPurgeInterval = time.Hour
+
+// ListTrash returns private data of the user in trash, the latest deleted data first.
+func (g *GophkeeperServer) ListTrash(ctx context.Context, request *pb.ListTrashRequest) (*pb.ListTrashResponse, error) {
+	userID := auth.ExtractUserIDFromContext(ctx)
+
+	var response pb.ListTrashResponse
+	data, err := g.service.GetTrashByUserID(ctx, userID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	response.Data, err = g.decryptData(ctx, userID, data)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug().Msgf("Server (ListTrash): done (%d records)", len(response.Data))
+	return &response, nil
+}
+
+// RestoreData moves private data of the user from trash back. Restored data is loaded by other clients on sync.
+func (g *GophkeeperServer) RestoreData(ctx context.Context, request *pb.RestoreDataRequest) (*pb.RestoreDataResponse, error) {
+	userID := auth.ExtractUserIDFromContext(ctx)
+
+	var response pb.RestoreDataResponse
+	if _, err := uuid.Parse(request.GetDataId()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid data ID")
+	}
+
+	if scope := auth.ExtractScopeFromContext(ctx); scope != nil {
+		if err := g.checkTrashScope(ctx, scope, request.GetDataId()); err != nil {
+			return nil, err
+		}
+	}
+
+	restored, err := g.service.RestoreData(ctx, userID, request.GetDataId())
+	if errors.Is(err, storage.ErrorPrivateDataNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	data, err := g.decryptData(ctx, userID, []models.Data{restored})
+	if err != nil {
+		return nil, err
+	}
+	response.Data = data[0]
+
+	log.Debug().Msg("Server (RestoreData): done")
+	return &response, nil
+}
+
+// PurgeData permanently deletes private data of the user from trash.
+func (g *GophkeeperServer) PurgeData(ctx context.Context, request *pb.PurgeDataRequest) (*pb.PurgeDataResponse, error) {
+	userID := auth.ExtractUserIDFromContext(ctx)
+
+	var response pb.PurgeDataResponse
+	if _, err := uuid.Parse(request.GetDataId()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, "invalid data ID")
+	}
+
+	if scope := auth.ExtractScopeFromContext(ctx); scope != nil {
+		if err := g.checkTrashScope(ctx, scope, request.GetDataId()); err != nil {
+			return nil, err
+		}
+	}
+
+	err := g.service.PurgeData(ctx, userID, request.GetDataId())
+	if errors.Is(err, storage.ErrorPrivateDataNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.Debug().Msg("Server (PurgeData): done")
+	return &response, nil
+}
+
+// checkTrashScope checks that private data in trash can be changed with personal access token.
+func (g *GophkeeperServer) checkTrashScope(ctx context.Context, scope *auth.AccessScope, dataID string) error {
+	if scope.ReadOnly {
+		return errorScopeDenied
+	}
+
+	trash, err := g.service.GetTrashByUserID(ctx, auth.ExtractUserIDFromContext(ctx))
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	for _, data := range trash {
+		if data.ID != dataID {
+			continue
+		}
+		if !scope.AllowsData(data.ID, data.DataType) {
+			return errorScopeDenied
+		}
+		return nil
+	}
+	return status.Error(codes.NotFound, storage.ErrorPrivateDataNotFound.Error())
+}
+
+// TrashPurge represents a job which permanently deletes private data kept in trash longer than retention period.
+type TrashPurge struct {
+	service   service.Service
+	retention time.Duration
+}
+
+// NewTrashPurge returns an instance of TrashPurge.
+func NewTrashPurge(service service.Service, retention time.Duration) *TrashPurge {
+	return &TrashPurge{service: service, retention: retention}
+}
+
+// Purge permanently deletes private data moved to trash before retention period. Returns number of deleted records.
+func (p *TrashPurge) Purge(ctx context.Context) (int64, error) {
+	return p.service.PurgeTrash(ctx, time.Now().Add(-p.retention))
+}
+
+// Run purges the trash on start and then periodically until the context is done. Trash is kept forever
+// with zero retention period.
+func (p *TrashPurge) Run(ctx context.Context) {
+	if p.retention <= 0 {
+		log.Info().Msg("Trash purge is disabled")
+		return
+	}
+
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for {
+		purged, err := p.Purge(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Error().Msgf("Trash purge error: %v", err)
+		}
+		if purged != 0 {
+			log.Info().Msgf("Trash purged: %d records", purged)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
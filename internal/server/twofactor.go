@@ -0,0 +1,263 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"github.com/rs/zerolog/log"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+	pb "github.com/vstebletsov89/go-developer-course-gophkeeper/internal/proto"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/secure"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/service/auth"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// recoveryCodesCount defines number of recovery codes issued on enrollment of the second factor.
+const recoveryCodesCount = 10
+
+// EnableTwoFactor starts enrollment of the second factor. Secret is returned once and must be confirmed
+// with TOTP code (ConfirmTwoFactor), recovery codes are shown to the user only here.
+func (a *AuthServer) EnableTwoFactor(ctx context.Context, request *pb.EnableTwoFactorRequest) (*pb.EnableTwoFactorResponse, error) {
+	var response pb.EnableTwoFactorResponse
+	userID := auth.ExtractUserIDFromContext(ctx)
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	recoveryCodes, err := auth.GenerateRecoveryCodes(recoveryCodesCount)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	userKey, err := loadUserKey(ctx, a.service, userID)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	encryptedSecret, err := secure.EncryptWithUserKey(userKey, []byte(secret), twoFactorAdditionalData(userID))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	hashes := make([]string, 0, len(recoveryCodes))
+	for _, code := range recoveryCodes {
+		hashes = append(hashes, auth.HashRecoveryCode(code))
+	}
+
+	err = a.service.SaveTwoFactor(ctx, models.TwoFactor{
+		UserID:        userID,
+		Secret:        encryptedSecret,
+		RecoveryCodes: hashes,
+	})
+	if errors.Is(err, storage.ErrorTwoFactorAlreadyEnabled) {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	response.Secret = secret
+	response.ProvisioningUri = auth.TOTPProvisioningURI(secret, userID)
+	response.RecoveryCodes = recoveryCodes
+
+	log.Debug().Msg("Server (EnableTwoFactor): done")
+	return &response, nil
+}
+
+// ConfirmTwoFactor enables second factor after verification of the first TOTP code from authenticator app.
+func (a *AuthServer) ConfirmTwoFactor(ctx context.Context, request *pb.ConfirmTwoFactorRequest) (*pb.ConfirmTwoFactorResponse, error) {
+	var response pb.ConfirmTwoFactorResponse
+	userID := auth.ExtractUserIDFromContext(ctx)
+
+	twoFactor, err := a.getTwoFactor(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if twoFactor.Enabled {
+		return nil, status.Error(codes.AlreadyExists, storage.ErrorTwoFactorAlreadyEnabled.Error())
+	}
+
+	secret, err := a.decryptTwoFactorSecret(ctx, twoFactor)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := auth.ValidateTOTPCode(secret, request.GetCode(), time.Now())
+	if !ok {
+		return nil, status.Error(codes.InvalidArgument, "invalid two-factor code")
+	}
+
+	err = a.service.EnableTwoFactor(ctx, userID, step)
+	if errors.Is(err, storage.ErrorTwoFactorNotFound) {
+		// enrollment was replaced or confirmed by concurrent request
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.Debug().Msg("Server (ConfirmTwoFactor): done")
+	return &response, nil
+}
+
+// DisableTwoFactor removes second factor of the user. Enabled second factor requires TOTP or recovery code,
+// pending enrollment is cancelled without code.
+func (a *AuthServer) DisableTwoFactor(ctx context.Context, request *pb.DisableTwoFactorRequest) (*pb.DisableTwoFactorResponse, error) {
+	var response pb.DisableTwoFactorResponse
+	userID := auth.ExtractUserIDFromContext(ctx)
+
+	twoFactor, err := a.getTwoFactor(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if twoFactor.Enabled {
+		if err := a.verifyTwoFactorCode(ctx, twoFactor, request.GetCode()); err != nil {
+			return nil, err
+		}
+	}
+
+	err = a.service.DeleteTwoFactor(ctx, userID)
+	if err != nil && !errors.Is(err, storage.ErrorTwoFactorNotFound) {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	log.Debug().Msg("Server (DisableTwoFactor): done")
+	return &response, nil
+}
+
+// VerifyTwoFactor completes login of the user with second factor. Challenge from Login is exchanged
+// for access and refresh tokens, every challenge can be used once.
+func (a *AuthServer) VerifyTwoFactor(ctx context.Context, request *pb.VerifyTwoFactorRequest) (*pb.VerifyTwoFactorResponse, error) {
+	var response pb.VerifyTwoFactorResponse
+
+	claims, err := a.jwt.ValidateChallenge(request.GetChallenge())
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid challenge")
+	}
+	userID := claims.Subject
+
+	revoked, err := a.service.IsTokenRevoked(ctx, claims.ID, userID, claims.SessionID, claims.IssuedAt.Time)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if revoked {
+		return nil, status.Error(codes.Unauthenticated, "challenge already used")
+	}
+
+	// codes of the second factor are guessed easier than passwords
+	attemptKey := twoFactorAttemptKey(userID)
+	if err := a.checkAttempts(ctx, attemptKey); err != nil {
+		return nil, err
+	}
+
+	twoFactor, err := a.getTwoFactor(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !twoFactor.Enabled {
+		return nil, status.Error(codes.FailedPrecondition, "two-factor authentication is not enabled")
+	}
+	if err := a.verifyTwoFactorCode(ctx, twoFactor, request.GetCode()); err != nil {
+		if status.Code(err) == codes.Unauthenticated {
+			return nil, a.addFailure(ctx, err, attemptKey)
+		}
+		return nil, err
+	}
+	if err := a.service.DeleteLoginAttempts(ctx, attemptKey); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	err = a.service.RevokeToken(ctx, models.RevokedToken{
+		ID:        claims.ID,
+		UserID:    userID,
+		ExpiresAt: claims.ExpiresAt.Time,
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	response.Token, err = a.startSession(ctx, userID, request.GetClient())
+	if err != nil {
+		return nil, err
+	}
+	response.Vault, err = a.loginVault(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debug().Msg("Server (VerifyTwoFactor): done")
+	return &response, nil
+}
+
+func (a *AuthServer) getTwoFactor(ctx context.Context, userID string) (models.TwoFactor, error) {
+	twoFactor, err := a.service.GetTwoFactor(ctx, userID)
+	if errors.Is(err, storage.ErrorTwoFactorNotFound) {
+		return models.TwoFactor{}, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if err != nil {
+		return models.TwoFactor{}, status.Error(codes.Internal, err.Error())
+	}
+	return twoFactor, nil
+}
+
+// isTwoFactorEnabled checks that login of the user requires second factor.
+func (a *AuthServer) isTwoFactorEnabled(ctx context.Context, userID string) (bool, error) {
+	twoFactor, err := a.service.GetTwoFactor(ctx, userID)
+	if errors.Is(err, storage.ErrorTwoFactorNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, status.Error(codes.Internal, err.Error())
+	}
+	return twoFactor.Enabled, nil
+}
+
+// verifyTwoFactorCode checks TOTP code or recovery code of the user. Used codes are rejected.
+func (a *AuthServer) verifyTwoFactorCode(ctx context.Context, twoFactor models.TwoFactor, code string) error {
+	if auth.IsRecoveryCode(code) {
+		err := a.service.UseRecoveryCode(ctx, twoFactor.UserID, auth.HashRecoveryCode(code))
+		if errors.Is(err, storage.ErrorTwoFactorCodeUsed) {
+			return status.Error(codes.Unauthenticated, "invalid two-factor code")
+		}
+		if err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		return nil
+	}
+
+	secret, err := a.decryptTwoFactorSecret(ctx, twoFactor)
+	if err != nil {
+		return err
+	}
+	step, ok := auth.ValidateTOTPCode(secret, code, time.Now())
+	if !ok {
+		return status.Error(codes.Unauthenticated, "invalid two-factor code")
+	}
+
+	err = a.service.UseTwoFactorStep(ctx, twoFactor.UserID, step)
+	if errors.Is(err, storage.ErrorTwoFactorCodeUsed) {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	return nil
+}
+
+func (a *AuthServer) decryptTwoFactorSecret(ctx context.Context, twoFactor models.TwoFactor) (string, error) {
+	userKey, err := loadUserKey(ctx, a.service, twoFactor.UserID)
+	if err != nil {
+		return "", status.Error(codes.Internal, err.Error())
+	}
+	secret, err := secure.DecryptWithUserKey(userKey, twoFactor.Secret, twoFactorAdditionalData(twoFactor.UserID))
+	if err != nil {
+		return "", status.Error(codes.Internal, err.Error())
+	}
+	return string(secret), nil
+}
+
+// twoFactorAdditionalData binds encrypted TOTP secret to the user.
+func twoFactorAdditionalData(userID string) []byte {
+	return []byte("totp|" + userID)
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+	pb "github.com/vstebletsov89/go-developer-course-gophkeeper/internal/proto"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/service"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/service/auth"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// changeEventsBuffer defines number of notifications buffered between the database listener and streams.
+	changeEventsBuffer = 64
+	// listenRetryDelay defines delay before the database listener is restarted after an error.
+	listenRetryDelay = 5 * time.Second
+)
+
+// ChangeHub delivers notifications about changed private data to grpc streams of the users.
+// Notifications are received from the database, so changes made through any server instance are delivered.
+type ChangeHub struct {
+	mu          sync.Mutex
+	subscribers map[string]map[chan models.ChangeEvent]struct{}
+	done        chan struct{}
+	closeOnce   sync.Once
+}
+
+// NewChangeHub returns an instance of ChangeHub.
+func NewChangeHub() *ChangeHub {
+	return &ChangeHub{
+		subscribers: make(map[string]map[chan models.ChangeEvent]struct{}),
+		done:        make(chan struct{}),
+	}
+}
+
+// Run listens for notifications about changed private data until the context is done. Listener is restarted
+// after errors, streams are notified after every start of the listener because notifications could be missed.
+func (h *ChangeHub) Run(ctx context.Context, svc service.Service) {
+	events := make(chan models.ChangeEvent, changeEventsBuffer)
+	go func() {
+		for {
+			select {
+			case event := <-events:
+				h.Publish(event)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	for {
+		err := svc.ListenDataChanges(ctx, events)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Error().Msgf("Data changes listener error: %v", err)
+
+		select {
+		case <-time.After(listenRetryDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Subscribe returns channel for notifications of the user and function to cancel subscription.
+// Channel keeps the last notification only: slow stream skips notifications, because the client
+// loads all changes after its cursor with sync anyway.
+func (h *ChangeHub) Subscribe(userID string) (<-chan models.ChangeEvent, func()) {
+	events := make(chan models.ChangeEvent, 1)
+
+	h.mu.Lock()
+	if h.subscribers[userID] == nil {
+		h.subscribers[userID] = make(map[chan models.ChangeEvent]struct{})
+	}
+	h.subscribers[userID][events] = struct{}{}
+	h.mu.Unlock()
+
+	return events, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.subscribers[userID], events)
+		if len(h.subscribers[userID]) == 0 {
+			delete(h.subscribers, userID)
+		}
+	}
+}
+
+// Publish sends notification to the streams of the user. Notification without user (listener started)
+// is sent to all streams.
+func (h *ChangeHub) Publish(event models.ChangeEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for userID, subscribers := range h.subscribers {
+		if event.UserID != "" && event.UserID != userID {
+			continue
+		}
+		for events := range subscribers {
+			// previous notification is replaced if the stream has not sent it yet
+			select {
+			case <-events:
+			default:
+			}
+			events <- event
+		}
+	}
+}
+
+// Done returns channel which is closed on shutdown of the server.
+func (h *ChangeHub) Done() <-chan struct{} {
+	return h.done
+}
+
+// Close stops all streams, so graceful shutdown of the server does not wait for them.
+func (h *ChangeHub) Close() {
+	h.closeOnce.Do(func() {
+		close(h.done)
+	})
+}
+
+// Watch sends notifications about changed private data of the user until the client cancels the stream.
+// Notification is sent at once if data was changed after the cursor of the client. Stream of access token
+// is stopped when the token expires, the client continues with refreshed token. Stream of revoked credentials
+// is stopped by the interceptor.
+func (g *GophkeeperServer) Watch(request *pb.WatchRequest, stream pb.Gophkeeper_WatchServer) error {
+	ctx := stream.Context()
+	userID := auth.ExtractUserIDFromContext(ctx)
+
+	if request.GetCursor() < 0 {
+		return status.Error(codes.InvalidArgument, "invalid cursor")
+	}
+
+	// subscription is started before the cursor is loaded, so changes in between are not missed
+	events, unsubscribe := g.changes.Subscribe(userID)
+	defer unsubscribe()
+
+	cursor, err := g.service.GetDataCursor(ctx, userID)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if cursor != request.GetCursor() {
+		if err := stream.Send(&pb.ChangeEvent{Cursor: cursor}); err != nil {
+			return err
+		}
+	}
+
+	var expired <-chan time.Time
+	if claims := auth.ExtractClaimsFromContext(ctx); claims != nil && claims.ExpiresAt != nil {
+		timer := time.NewTimer(time.Until(claims.ExpiresAt.Time))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	scope := auth.ExtractScopeFromContext(ctx)
+	log.Debug().Msg("Server (Watch): started")
+	for {
+		select {
+		case event := <-events:
+			if event.DataID != "" && scope != nil && !scope.AllowsData(event.DataID, event.DataType) {
+				continue
+			}
+			err := stream.Send(&pb.ChangeEvent{
+				Cursor:   event.Cursor,
+				DataId:   event.DataID,
+				DataType: pb.DataType(event.DataType),
+				Deleted:  event.Deleted,
+			})
+			if err != nil {
+				return err
+			}
+		case <-expired:
+			return status.Error(codes.Unauthenticated, "invalid authorization token: token is expired")
+		case <-g.changes.Done():
+			return status.Error(codes.Unavailable, "server is shutting down")
+		case <-ctx.Done():
+			log.Debug().Msg("Server (Watch): done")
+			return nil
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/rand"
+)
+
+const (
+	// PersonalTokenPrefix defines prefix of personal access tokens. It separates them from jwt access tokens.
+	PersonalTokenPrefix = "gpk_"
+	// personalTokenSize defines size of the random part of personal access token.
+	personalTokenSize = 32
+	// personalTokenKeySeparator separates key of the vault from personal access token.
+	// Key part is kept by the client and never sent to the server.
+	personalTokenKeySeparator = "."
+)
+
+// ErrorInvalidPersonalToken defines an error for personal access token with invalid format.
+var ErrorInvalidPersonalToken = errors.New("invalid personal access token")
+
+// ScopeCtx defines context name for scope of personal access token.
+const ScopeCtx UserContextType = "ScopeCtx"
+
+// AccessScope represents restrictions of personal access token.
+// Empty lists of records and types mean that all private data of the user is available.
+// Wrapped key is the vault key wrapped with the key of the token (nil for legacy users without vault).
+type AccessScope struct {
+	ReadOnly   bool
+	DataIDs    []string
+	DataTypes  []models.DataType
+	WrappedKey []byte
+}
+
+// NewAccessScope returns scope of personal access token.
+func NewAccessScope(token models.AccessToken) *AccessScope {
+	return &AccessScope{
+		ReadOnly:   token.ReadOnly,
+		DataIDs:    token.DataIDs,
+		DataTypes:  token.DataTypes,
+		WrappedKey: token.WrappedKey,
+	}
+}
+
+// AllowsData checks that private data is available with the scope.
+func (s *AccessScope) AllowsData(id string, dataType models.DataType) bool {
+	return s.AllowsType(dataType) && (len(s.DataIDs) == 0 || contains(s.DataIDs, id))
+}
+
+// AllowsType checks that private data of the type is available with the scope.
+func (s *AccessScope) AllowsType(dataType models.DataType) bool {
+	if len(s.DataTypes) == 0 {
+		return true
+	}
+	for _, t := range s.DataTypes {
+		if t == dataType {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// ExtractScopeFromContext extracts scope of personal access token from context.
+// Nil scope means full access of the user (jwt token or client certificate).
+func ExtractScopeFromContext(ctx context.Context) *AccessScope {
+	scope, ok := ctx.Value(ScopeCtx).(*AccessScope)
+	if ok {
+		return scope
+	}
+	return nil
+}
+
+// GeneratePersonalToken generates random personal access token.
+func GeneratePersonalToken() (string, error) {
+	b, err := rand.Bytes(personalTokenSize)
+	if err != nil {
+		return "", err
+	}
+	return PersonalTokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// IsPersonalToken checks that token is personal access token (not jwt).
+func IsPersonalToken(token string) bool {
+	return strings.HasPrefix(token, PersonalTokenPrefix)
+}
+
+// HashPersonalToken returns hash of personal access token. Only hashes are stored on the server.
+func HashPersonalToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// JoinPersonalToken returns personal access token with the key of the vault which is shown to the user.
+func JoinPersonalToken(token string, tokenKey []byte) string {
+	if len(tokenKey) == 0 {
+		return token
+	}
+	return token + personalTokenKeySeparator + base64.RawURLEncoding.EncodeToString(tokenKey)
+}
+
+// SplitPersonalToken splits personal access token of the user into the token which is sent to the server
+// and the key of the vault (nil if the token has no key).
+func SplitPersonalToken(value string) (string, []byte, error) {
+	if !IsPersonalToken(value) {
+		return "", nil, ErrorInvalidPersonalToken
+	}
+
+	token, encodedKey, found := strings.Cut(value, personalTokenKeySeparator)
+	if !found {
+		return token, nil, nil
+	}
+	tokenKey, err := base64.RawURLEncoding.DecodeString(encodedKey)
+	if err != nil || len(tokenKey) == 0 {
+		return "", nil, ErrorInvalidPersonalToken
+	}
+	return token, tokenKey, nil
+}
@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+)
+
+func TestGeneratePersonalToken(t *testing.T) {
+	token, err := GeneratePersonalToken()
+	require.NoError(t, err)
+	other, err := GeneratePersonalToken()
+	require.NoError(t, err)
+
+	assert.True(t, IsPersonalToken(token))
+	assert.NotEqual(t, token, other)
+	assert.NotEqual(t, HashPersonalToken(token), HashPersonalToken(other))
+	assert.False(t, IsPersonalToken("eyJhbGciOiJFZERTQSJ9.e30.signature"))
+}
+
+func TestAccessScope_AllowsData(t *testing.T) {
+	tests := []struct {
+		name     string
+		scope    AccessScope
+		id       string
+		dataType models.DataType
+		want     bool
+	}{
+		{
+			name:     "positive test (no restrictions)",
+			scope:    AccessScope{},
+			id:       "id1",
+			dataType: models.CardType,
+			want:     true,
+		},
+		{
+			name:     "positive test (allowed type)",
+			scope:    AccessScope{DataTypes: []models.DataType{models.TextType, models.CardType}},
+			id:       "id1",
+			dataType: models.CardType,
+			want:     true,
+		},
+		{
+			name:     "negative test (another type)",
+			scope:    AccessScope{DataTypes: []models.DataType{models.TextType}},
+			id:       "id1",
+			dataType: models.CardType,
+			want:     false,
+		},
+		{
+			name:     "positive test (allowed record)",
+			scope:    AccessScope{DataIDs: []string{"id1", "id2"}},
+			id:       "id2",
+			dataType: models.CardType,
+			want:     true,
+		},
+		{
+			name:     "negative test (another record)",
+			scope:    AccessScope{DataIDs: []string{"id1"}},
+			id:       "id2",
+			dataType: models.CardType,
+			want:     false,
+		},
+		{
+			name:     "negative test (allowed record of another type)",
+			scope:    AccessScope{DataIDs: []string{"id1"}, DataTypes: []models.DataType{models.TextType}},
+			id:       "id1",
+			dataType: models.CardType,
+			want:     false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.scope.AllowsData(tt.id, tt.dataType))
+		})
+	}
+}
+
+func TestExtractScopeFromContext(t *testing.T) {
+	assert.Nil(t, ExtractScopeFromContext(context.Background()))
+
+	scope := NewAccessScope(models.AccessToken{ReadOnly: true, DataIDs: []string{"id1"}})
+	got := ExtractScopeFromContext(context.WithValue(context.Background(), ScopeCtx, scope))
+	assert.Equal(t, scope, got)
+	assert.True(t, got.ReadOnly)
+}
+
+func TestSplitPersonalToken(t *testing.T) {
+	token, err := GeneratePersonalToken()
+	require.NoError(t, err)
+	tokenKey := []byte("0123456789abcdef0123456789abcdef")
+
+	tests := []struct {
+		name    string
+		value   string
+		token   string
+		key     []byte
+		wantErr bool
+	}{
+		{
+			name:  "positive test (token with key)",
+			value: JoinPersonalToken(token, tokenKey),
+			token: token,
+			key:   tokenKey,
+		},
+		{
+			name:  "positive test (token without key)",
+			value: JoinPersonalToken(token, nil),
+			token: token,
+		},
+		{
+			name:    "negative test (jwt token)",
+			value:   "eyJhbGciOiJFZERTQSJ9.e30.signature",
+			wantErr: true,
+		},
+		{
+			name:    "negative test (invalid key)",
+			value:   token + ".!invalid",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotToken, gotKey, err := SplitPersonalToken(tt.value)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrorInvalidPersonalToken)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.token, gotToken)
+			assert.Equal(t, tt.key, gotKey)
+		})
+	}
+}
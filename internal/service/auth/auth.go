@@ -3,11 +3,14 @@ package auth
 
 import (
 	"context"
+	"crypto"
 	"fmt"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
 	"golang.org/x/crypto/bcrypt"
+	"sort"
 	"time"
 )
 
@@ -17,46 +20,149 @@ type UserContextType string
 const (
 	// UserCtx defines user context name.
 	UserCtx UserContextType = "UserCtx"
+	// ClaimsCtx defines context name for claims of the access token.
+	ClaimsCtx UserContextType = "ClaimsCtx"
+)
+
+const (
+	// challengeAudience defines audience of the token which is issued after password check
+	// when second factor is required. Challenge cannot be used as access token.
+	challengeAudience = "two-factor"
+	// challengeTTL defines lifetime of the challenge token.
+	challengeTTL = 5 * time.Minute
 )
 
 // JWTManager represents a structure for jwt manager.
+// Tokens are signed with the active key and verified with any of the verification keys (key rotation).
 type JWTManager struct {
-	secretKey string
+	method           jwt.SigningMethod
+	signingKey       interface{}
+	keyID            string
+	verificationKeys map[string]verificationKey
+	tokenTTL         time.Duration
+}
+
+type verificationKey struct {
+	method jwt.SigningMethod
+	key    interface{}
 }
 
 // UserClaims custom claims for jwt.
 type UserClaims struct {
 	jwt.RegisteredClaims
+	// SessionID is ID of the login session (family of refresh tokens), empty for challenge.
+	SessionID string `json:"sid,omitempty"`
 }
 
 // JWT interface is the interface that must be implemented by JWTManager.
 type JWT interface {
-	GenerateToken(user string) (string, error)
+	GenerateToken(user string, session string) (string, error)
 	ValidateToken(token string) (*UserClaims, error)
+	GenerateChallenge(user string) (string, error)
+	ValidateChallenge(token string) (*UserClaims, error)
+	VerificationKeys() []VerificationKey
+}
+
+// NewJWTManager return an instance of JWTManager with HS256 shared secret.
+// Access tokens are short-lived and renewed with refresh tokens.
+func NewJWTManager(secretKey string, tokenTTL time.Duration) *JWTManager {
+	return &JWTManager{
+		method:     jwt.SigningMethodHS256,
+		signingKey: []byte(secretKey),
+		verificationKeys: map[string]verificationKey{
+			// tokens signed with shared secret have no key ID
+			"": {method: jwt.SigningMethodHS256, key: []byte(secretKey)},
+		},
+		tokenTTL: tokenTTL,
+	}
+}
+
+// NewSignedJWTManager return an instance of JWTManager with Ed25519 or RSA signing key.
+// Additional public keys are used only for verification of tokens signed with previous keys.
+func NewSignedJWTManager(signingKey crypto.Signer, previousKeys []crypto.PublicKey, tokenTTL time.Duration) (*JWTManager, error) {
+	method, err := signingMethod(signingKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	keyID, err := KeyID(signingKey.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	j := &JWTManager{
+		method:           method,
+		signingKey:       signingKey,
+		keyID:            keyID,
+		verificationKeys: make(map[string]verificationKey),
+		tokenTTL:         tokenTTL,
+	}
+	for _, publicKey := range append([]crypto.PublicKey{signingKey.Public()}, previousKeys...) {
+		method, err := signingMethod(publicKey)
+		if err != nil {
+			return nil, err
+		}
+		id, err := KeyID(publicKey)
+		if err != nil {
+			return nil, err
+		}
+		j.verificationKeys[id] = verificationKey{method: method, key: publicKey}
+	}
+	return j, nil
 }
 
-// NewJWTManager return an instance of JWTManager.
-func NewJWTManager(secretKey string) *JWTManager {
-	return &JWTManager{secretKey: secretKey}
+// VerificationKeys returns public keys for verification of tokens (empty for shared secret).
+// Active signing key goes first.
+func (j *JWTManager) VerificationKeys() []VerificationKey {
+	keys := make([]VerificationKey, 0, len(j.verificationKeys))
+	if j.keyID == "" {
+		return keys
+	}
+
+	keys = append(keys, j.publicKey(j.keyID))
+	for id := range j.verificationKeys {
+		if id != j.keyID {
+			keys = append(keys, j.publicKey(id))
+		}
+	}
+	sort.Slice(keys[1:], func(a, b int) bool { return keys[a+1].ID < keys[b+1].ID })
+	return keys
+}
+
+func (j *JWTManager) publicKey(id string) VerificationKey {
+	v := j.verificationKeys[id]
+	return VerificationKey{ID: id, Algorithm: v.method.Alg(), PublicKey: v.key}
 }
 
 // check that JWTManager implements all required methods.
 var _ JWT = (*JWTManager)(nil)
 
-// GenerateToken generates jwt token.
-func (j *JWTManager) GenerateToken(user string) (string, error) {
+// GenerateToken generates jwt token of the session. Token has unique ID (jti) to be revoked before expiration.
+func (j *JWTManager) GenerateToken(user string, session string) (string, error) {
+	return j.generate(user, session, j.tokenTTL, nil)
+}
+
+// GenerateChallenge generates short-lived jwt token for verification of the second factor.
+func (j *JWTManager) GenerateChallenge(user string) (string, error) {
+	return j.generate(user, "", challengeTTL, jwt.ClaimStrings{challengeAudience})
+}
+
+func (j *JWTManager) generate(user string, session string, ttl time.Duration, audience jwt.ClaimStrings) (string, error) {
+	now := time.Now()
 	claims := UserClaims{RegisteredClaims: jwt.RegisteredClaims{
 		Issuer:    "Gophkeeper",
-		Subject:   "authorization",
-		Audience:  nil,
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-		NotBefore: jwt.NewNumericDate(time.Now()),
-		IssuedAt:  jwt.NewNumericDate(time.Now()),
-		ID:        user,
-	}}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	genToken, err := token.SignedString([]byte(j.secretKey))
+		Subject:   user,
+		Audience:  audience,
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		NotBefore: jwt.NewNumericDate(now),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ID:        uuid.NewString(),
+	}, SessionID: session}
+
+	token := jwt.NewWithClaims(j.method, claims)
+	if j.keyID != "" {
+		token.Header["kid"] = j.keyID
+	}
+	genToken, err := token.SignedString(j.signingKey)
 	if err != nil {
 		return "", err
 	}
@@ -65,18 +171,48 @@ func (j *JWTManager) GenerateToken(user string) (string, error) {
 	return genToken, nil
 }
 
-// ValidateToken verifies that jwt token is valid.
+// ValidateToken verifies that jwt token is valid access token.
 func (j *JWTManager) ValidateToken(accessToken string) (*UserClaims, error) {
+	claims, err := j.parse(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	if len(claims.Audience) != 0 {
+		return nil, fmt.Errorf("invalid token: unexpected audience")
+	}
+
+	log.Debug().Msg("ValidateToken: OK")
+	return claims, nil
+}
+
+// ValidateChallenge verifies that jwt token is valid challenge for the second factor.
+func (j *JWTManager) ValidateChallenge(challenge string) (*UserClaims, error) {
+	claims, err := j.parse(challenge)
+	if err != nil {
+		return nil, err
+	}
+	if !claims.VerifyAudience(challengeAudience, true) {
+		return nil, fmt.Errorf("invalid challenge: unexpected audience")
+	}
+	return claims, nil
+}
+
+func (j *JWTManager) parse(signedToken string) (*UserClaims, error) {
 	token, err := jwt.ParseWithClaims(
-		accessToken,
+		signedToken,
 		&UserClaims{},
 		func(token *jwt.Token) (interface{}, error) {
-			_, ok := token.Method.(*jwt.SigningMethodHMAC)
+			keyID, _ := token.Header["kid"].(string)
+			key, ok := j.verificationKeys[keyID]
 			if !ok {
-				return nil, fmt.Errorf("unexpected token signing method")
+				return nil, fmt.Errorf("unknown token signing key")
 			}
 
-			return []byte(j.secretKey), nil
+			// algorithm is defined by the key, not by the token
+			if token.Method.Alg() != key.method.Alg() {
+				return nil, fmt.Errorf("unexpected token signing method")
+			}
+			return key.key, nil
 		},
 	)
 
@@ -88,8 +224,6 @@ func (j *JWTManager) ValidateToken(accessToken string) (*UserClaims, error) {
 	if !ok {
 		return nil, fmt.Errorf("invalid token claims")
 	}
-
-	log.Debug().Msg("ValidateToken: OK")
 	return claims, nil
 }
 
@@ -118,6 +252,15 @@ func IsUserAuthorized(user *models.User, userDB *models.User) (bool, error) {
 	return true, nil
 }
 
+// ExtractClaimsFromContext extracts claims of the access token from context.
+func ExtractClaimsFromContext(ctx context.Context) *UserClaims {
+	claims, ok := ctx.Value(ClaimsCtx).(*UserClaims)
+	if ok {
+		return claims
+	}
+	return nil
+}
+
 // ExtractUserIDFromContext extracts userID from context.
 func ExtractUserIDFromContext(ctx context.Context) string {
 	// try to get userID from context
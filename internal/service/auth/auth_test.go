@@ -2,10 +2,12 @@ package auth
 
 import (
 	"context"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/stretchr/testify/assert"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func TestEncryptPassword(t *testing.T) {
@@ -107,9 +109,11 @@ func TestIsUserAuthorized(t *testing.T) {
 func TestJWTManager_GenerateToken(t *testing.T) {
 	type fields struct {
 		secretKey string
+		tokenTTL  time.Duration
 	}
 	type args struct {
-		user string
+		user    string
+		session string
 	}
 	tests := []struct {
 		name    string
@@ -119,17 +123,15 @@ func TestJWTManager_GenerateToken(t *testing.T) {
 	}{
 		{
 			name:    "positive test",
-			fields:  fields{secretKey: "some_secret_key"},
-			args:    args{user: "user"},
+			fields:  fields{secretKey: "some_secret_key", tokenTTL: time.Minute},
+			args:    args{user: "user", session: "session"},
 			wantErr: false,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			J := &JWTManager{
-				secretKey: tt.fields.secretKey,
-			}
-			got, err := J.GenerateToken(tt.args.user)
+			J := NewJWTManager(tt.fields.secretKey, tt.fields.tokenTTL)
+			got, err := J.GenerateToken(tt.args.user, tt.args.session)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GenerateToken() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -142,6 +144,7 @@ func TestJWTManager_GenerateToken(t *testing.T) {
 func TestJWTManager_ValidateToken(t *testing.T) {
 	type fields struct {
 		secretKey string
+		tokenTTL  time.Duration
 	}
 	tests := []struct {
 		name    string
@@ -151,25 +154,33 @@ func TestJWTManager_ValidateToken(t *testing.T) {
 	}{
 		{
 			name:    "positive test",
-			fields:  fields{secretKey: "some_key"},
+			fields:  fields{secretKey: "some_key", tokenTTL: time.Minute},
 			user:    "user",
 			wantErr: false,
 		},
+		{
+			name:    "negative test (expired token)",
+			fields:  fields{secretKey: "some_key", tokenTTL: -time.Minute},
+			user:    "user",
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			J := &JWTManager{
-				secretKey: tt.fields.secretKey,
-			}
-			token, err := J.GenerateToken(tt.user)
+			J := NewJWTManager(tt.fields.secretKey, tt.fields.tokenTTL)
+			token, err := J.GenerateToken(tt.user, "session")
 			assert.NoError(t, err)
 
-			_, err = J.ValidateToken(token)
+			claims, err := J.ValidateToken(token)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("ValidateToken() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			assert.NoError(t, err)
+			if !tt.wantErr {
+				assert.Equal(t, tt.user, claims.Subject)
+				assert.Equal(t, "session", claims.SessionID)
+				assert.NotEmpty(t, claims.ID)
+			}
 		})
 	}
 }
@@ -177,6 +188,7 @@ func TestJWTManager_ValidateToken(t *testing.T) {
 func TestNewJWTManager(t *testing.T) {
 	type args struct {
 		secretKey string
+		tokenTTL  time.Duration
 	}
 	tests := []struct {
 		name string
@@ -185,13 +197,20 @@ func TestNewJWTManager(t *testing.T) {
 	}{
 		{
 			name: "positive test",
-			args: args{secretKey: "some_secret_key"},
-			want: &JWTManager{secretKey: "some_secret_key"},
+			args: args{secretKey: "some_secret_key", tokenTTL: time.Minute},
+			want: &JWTManager{
+				method:     jwt.SigningMethodHS256,
+				signingKey: []byte("some_secret_key"),
+				verificationKeys: map[string]verificationKey{
+					"": {method: jwt.SigningMethodHS256, key: []byte("some_secret_key")},
+				},
+				tokenTTL: time.Minute,
+			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := NewJWTManager(tt.args.secretKey); !reflect.DeepEqual(got, tt.want) {
+			if got := NewJWTManager(tt.args.secretKey, tt.args.tokenTTL); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("NewJWTManager() = %v, want %v", got, tt.want)
 			}
 		})
@@ -229,3 +248,23 @@ func TestExtractUserIDFromContext(t *testing.T) {
 		})
 	}
 }
+
+func TestJWTManager_ValidateChallenge(t *testing.T) {
+	J := NewJWTManager("some_key", time.Minute)
+
+	challenge, err := J.GenerateChallenge("user")
+	assert.NoError(t, err)
+	token, err := J.GenerateToken("user", "session")
+	assert.NoError(t, err)
+
+	claims, err := J.ValidateChallenge(challenge)
+	assert.NoError(t, err)
+	assert.Equal(t, "user", claims.Subject)
+	assert.Empty(t, claims.SessionID)
+
+	// challenge and access token are not interchangeable
+	_, err = J.ValidateToken(challenge)
+	assert.Error(t, err)
+	_, err = J.ValidateChallenge(token)
+	assert.Error(t, err)
+}
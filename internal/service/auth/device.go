@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/rand"
+)
+
+// deviceOrganization defines organization of device certificates, it separates them from other certificates of the CA.
+const deviceOrganization = "Gophkeeper device"
+
+// serialNumberSize defines size of random serial number of the certificate in bytes.
+const serialNumberSize = 16
+
+// ErrorInvalidCSR defines an error for certificate signing request with invalid format or signature.
+var ErrorInvalidCSR = errors.New("invalid certificate signing request")
+
+// DeviceCA represents a certificate authority which issues client certificates of the user devices (mTLS).
+type DeviceCA struct {
+	certificate *x509.Certificate
+	key         crypto.Signer
+}
+
+// LoadDeviceCA reads PEM encoded certificate and private key of the CA.
+func LoadDeviceCA(certFile string, keyFile string) (*DeviceCA, error) {
+	block, err := readPEM(certFile)
+	if err != nil {
+		return nil, err
+	}
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse CA certificate %s: %w", certFile, err)
+	}
+	if !certificate.IsCA {
+		return nil, fmt.Errorf("certificate %s is not a CA", certFile)
+	}
+
+	key, err := loadPrivateKey(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return NewDeviceCA(certificate, key), nil
+}
+
+// NewDeviceCA returns an instance of DeviceCA.
+func NewDeviceCA(certificate *x509.Certificate, key crypto.Signer) *DeviceCA {
+	return &DeviceCA{certificate: certificate, key: key}
+}
+
+// CertificatePEM returns PEM encoded certificate of the CA.
+func (ca *DeviceCA) CertificatePEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.certificate.Raw})
+}
+
+// Pool returns pool with the CA certificate for verification of client certificates.
+func (ca *DeviceCA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.certificate)
+	return pool
+}
+
+// SignCSR issues client certificate of the user device. Subject of the request is ignored:
+// common name of the certificate is the user ID and serial number is random.
+func (ca *DeviceCA) SignCSR(csrPEM []byte, userID string, ttl time.Duration) (*x509.Certificate, []byte, error) {
+	block, _ := pem.Decode(csrPEM)
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, nil, ErrorInvalidCSR
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrorInvalidCSR, err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrorInvalidCSR, err)
+	}
+
+	serial, err := rand.Bytes(serialNumberSize)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: new(big.Int).SetBytes(serial),
+		Subject: pkix.Name{
+			CommonName:   userID,
+			Organization: []string{deviceOrganization},
+		},
+		NotBefore:   now.Add(-time.Minute),
+		NotAfter:    now.Add(ttl),
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(cryptorand.Reader, template, ca.certificate, csr.PublicKey, ca.key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certificate, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), nil
+}
+
+// DeviceUserID returns user ID of the device certificate issued by SignCSR.
+func DeviceUserID(certificate *x509.Certificate) (string, bool) {
+	if len(certificate.Subject.Organization) != 1 || certificate.Subject.Organization[0] != deviceOrganization {
+		return "", false
+	}
+	return certificate.Subject.CommonName, certificate.Subject.CommonName != ""
+}
+
+// CertificateFingerprint returns SHA-256 fingerprint of DER encoded certificate.
+func CertificateFingerprint(der []byte) string {
+	hash := sha256.Sum256(der)
+	return hex.EncodeToString(hash[:])
+}
+
+// NewDeviceKey generates private key of the device (ECDSA P-256) and certificate signing request for it.
+// Both are PEM encoded, private key never leaves the device.
+func NewDeviceKey(name string) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	csr, err := x509.CreateCertificateRequest(cryptorand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: name},
+	}, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}), nil
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCA returns self-signed CA certificate with the private key.
+func newTestCA(t *testing.T, isCA bool) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Gophkeeper test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	certificate, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return certificate, key
+}
+
+func TestLoadDeviceCA(t *testing.T) {
+	caCert, caKey := newTestCA(t, true)
+	notCACert, notCAKey := newTestCA(t, false)
+
+	writeCertificate := func(certificate *x509.Certificate) string {
+		path := filepath.Join(t.TempDir(), "ca.pem")
+		err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw}), 0600)
+		require.NoError(t, err)
+		return path
+	}
+
+	tests := []struct {
+		name     string
+		certFile string
+		keyFile  string
+		wantErr  bool
+	}{
+		{
+			name:     "positive test",
+			certFile: writeCertificate(caCert),
+			keyFile:  writeKey(t, caKey),
+		},
+		{
+			name:     "negative test (not a CA)",
+			certFile: writeCertificate(notCACert),
+			keyFile:  writeKey(t, notCAKey),
+			wantErr:  true,
+		},
+		{
+			name:     "negative test (missing key)",
+			certFile: writeCertificate(caCert),
+			keyFile:  filepath.Join(t.TempDir(), "missing.pem"),
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ca, err := LoadDeviceCA(tt.certFile, tt.keyFile)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NotEmpty(t, ca.CertificatePEM())
+		})
+	}
+}
+
+func TestDeviceCA_SignCSR(t *testing.T) {
+	caCert, caKey := newTestCA(t, true)
+	ca := NewDeviceCA(caCert, caKey)
+
+	_, csr, err := NewDeviceKey("laptop")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		csr     []byte
+		wantErr error
+	}{
+		{
+			name: "positive test",
+			csr:  csr,
+		},
+		{
+			name:    "negative test (not a csr)",
+			csr:     ca.CertificatePEM(),
+			wantErr: ErrorInvalidCSR,
+		},
+		{
+			name:    "negative test (corrupted csr)",
+			csr:     pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: []byte("corrupted")}),
+			wantErr: ErrorInvalidCSR,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certificate, certificatePEM, err := ca.SignCSR(tt.csr, "user-id", time.Hour)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.NotEmpty(t, certificatePEM)
+
+			// certificate is accepted for client authentication only
+			_, err = certificate.Verify(x509.VerifyOptions{
+				Roots:     ca.Pool(),
+				KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+			})
+			assert.NoError(t, err)
+			_, err = certificate.Verify(x509.VerifyOptions{
+				Roots:     ca.Pool(),
+				KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			})
+			assert.Error(t, err)
+
+			userID, ok := DeviceUserID(certificate)
+			assert.True(t, ok)
+			assert.Equal(t, "user-id", userID)
+			assert.Len(t, CertificateFingerprint(certificate.Raw), 64)
+		})
+	}
+
+	// certificate of the CA does not identify a user
+	_, ok := DeviceUserID(caCert)
+	assert.False(t, ok)
+}
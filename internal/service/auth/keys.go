@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// minRSAKeySize defines minimal size of RSA signing key in bits.
+const minRSAKeySize = 2048
+
+// ErrorUnsupportedKey defines an error for signing key which is neither Ed25519 nor RSA.
+var ErrorUnsupportedKey = errors.New("signing key must be Ed25519 or RSA (at least 2048 bits)")
+
+// VerificationKey represents a public key which is used to verify signature of jwt tokens.
+type VerificationKey struct {
+	ID        string
+	Algorithm string
+	PublicKey crypto.PublicKey
+}
+
+// LoadSigningKey reads PEM encoded private key (PKCS #8 or PKCS #1 for RSA) from the file.
+func LoadSigningKey(path string) (crypto.Signer, error) {
+	signer, err := loadPrivateKey(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := signingMethod(signer.Public()); err != nil {
+		return nil, err
+	}
+	return signer, nil
+}
+
+// loadPrivateKey reads PEM encoded private key of any type (PKCS #8, PKCS #1 or SEC 1) from the file.
+func loadPrivateKey(path string) (crypto.Signer, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var key interface{}
+	key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			if key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+				return nil, fmt.Errorf("cannot parse private key %s: %w", path, err)
+			}
+		}
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, ErrorUnsupportedKey
+	}
+	return signer, nil
+}
+
+// LoadVerificationKey reads PEM encoded public key (PKIX) from the file. Private key is accepted as well.
+func LoadVerificationKey(path string) (crypto.PublicKey, error) {
+	block, err := readPEM(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		signer, signerErr := LoadSigningKey(path)
+		if signerErr != nil {
+			return nil, fmt.Errorf("cannot parse verification key %s: %w", path, err)
+		}
+		key = signer.Public()
+	}
+
+	if _, err := signingMethod(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func readPEM(path string) (*pem.Block, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("file %s does not contain PEM encoded key", path)
+	}
+	return block, nil
+}
+
+// KeyID returns ID of the public key (kid). It is derived from the key, so it is the same on all servers.
+func KeyID(publicKey crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(hash[:12]), nil
+}
+
+// signingMethod returns jwt signing method for the public key.
+func signingMethod(publicKey crypto.PublicKey) (jwt.SigningMethod, error) {
+	switch key := publicKey.(type) {
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA, nil
+	case *rsa.PublicKey:
+		if key.N.BitLen() < minRSAKeySize {
+			return nil, ErrorUnsupportedKey
+		}
+		return jwt.SigningMethodRS256, nil
+	}
+	return nil, ErrorUnsupportedKey
+}
+
+// jwk represents public key in JSON Web Key format (RFC 7517).
+type jwk struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+// JWKS returns verification keys as JSON Web Key Set, so other services are able to validate tokens
+// without access to the signing key.
+func JWKS(keys []VerificationKey) ([]byte, error) {
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{Keys: make([]jwk, 0, len(keys))}
+
+	for _, v := range keys {
+		key := jwk{KeyID: v.ID, Algorithm: v.Algorithm, Use: "sig"}
+		switch publicKey := v.PublicKey.(type) {
+		case ed25519.PublicKey:
+			key.KeyType = "OKP"
+			key.Curve = "Ed25519"
+			key.X = base64.RawURLEncoding.EncodeToString(publicKey)
+		case *rsa.PublicKey:
+			key.KeyType = "RSA"
+			key.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+			key.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+		default:
+			return nil, ErrorUnsupportedKey
+		}
+		set.Keys = append(set.Keys, key)
+	}
+	return json.Marshal(set)
+}
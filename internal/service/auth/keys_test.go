@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeKey saves private key in PEM format (PKCS #8) and returns path to the file.
+func writeKey(t *testing.T, key crypto.Signer) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "key.pem")
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	require.NoError(t, err)
+	return path
+}
+
+// writePublicKey saves public key in PEM format (PKIX) and returns path to the file.
+func writePublicKey(t *testing.T, key crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "key.pub")
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600)
+	require.NoError(t, err)
+	return path
+}
+
+func TestNewSignedJWTManager(t *testing.T) {
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	weakKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		key     crypto.Signer
+		alg     string
+		wantErr bool
+	}{
+		{
+			name: "positive test (Ed25519)",
+			key:  edKey,
+			alg:  "EdDSA",
+		},
+		{
+			name: "positive test (RSA)",
+			key:  rsaKey,
+			alg:  "RS256",
+		},
+		{
+			name:    "negative test (weak RSA key)",
+			key:     weakKey,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			J, err := NewSignedJWTManager(tt.key, nil, time.Minute)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrorUnsupportedKey)
+				return
+			}
+			require.NoError(t, err)
+
+			token, err := J.GenerateToken("user", "session")
+			require.NoError(t, err)
+			claims, err := J.ValidateToken(token)
+			require.NoError(t, err)
+			assert.Equal(t, "user", claims.Subject)
+
+			keys := J.VerificationKeys()
+			require.Len(t, keys, 1)
+			assert.Equal(t, tt.alg, keys[0].Algorithm)
+			assert.Equal(t, tt.key.Public(), keys[0].PublicKey)
+		})
+	}
+}
+
+func TestJWTManager_KeyRotation(t *testing.T) {
+	_, oldKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	newKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	oldManager, err := NewSignedJWTManager(oldKey, nil, time.Minute)
+	require.NoError(t, err)
+	oldToken, err := oldManager.GenerateToken("user", "session")
+	require.NoError(t, err)
+
+	// old key is used only for verification after rotation
+	signingKey, err := LoadSigningKey(writeKey(t, newKey))
+	require.NoError(t, err)
+	previousKey, err := LoadVerificationKey(writePublicKey(t, oldKey.Public()))
+	require.NoError(t, err)
+	newManager, err := NewSignedJWTManager(signingKey, []crypto.PublicKey{previousKey}, time.Minute)
+	require.NoError(t, err)
+
+	_, err = newManager.ValidateToken(oldToken)
+	assert.NoError(t, err)
+	newToken, err := newManager.GenerateToken("user", "session")
+	require.NoError(t, err)
+	_, err = oldManager.ValidateToken(newToken)
+	assert.Error(t, err)
+
+	keys := newManager.VerificationKeys()
+	require.Len(t, keys, 2)
+	assert.Equal(t, "RS256", keys[0].Algorithm)
+	assert.Equal(t, "EdDSA", keys[1].Algorithm)
+
+	jwks, err := JWKS(keys)
+	require.NoError(t, err)
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	require.NoError(t, json.Unmarshal(jwks, &set))
+	require.Len(t, set.Keys, 2)
+	assert.Equal(t, "RSA", set.Keys[0]["kty"])
+	assert.Equal(t, keys[0].ID, set.Keys[0]["kid"])
+	assert.Equal(t, "OKP", set.Keys[1]["kty"])
+
+	// tokens signed with shared secret or another algorithm are rejected
+	hmacToken, err := NewJWTManager("secret_key", time.Minute).GenerateToken("user", "session")
+	require.NoError(t, err)
+	_, err = newManager.ValidateToken(hmacToken)
+	assert.Error(t, err)
+	_, err = NewJWTManager("secret_key", time.Minute).ValidateToken(newToken)
+	assert.Error(t, err)
+}
+
+func TestLoadSigningKey(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	invalid := filepath.Join(t.TempDir(), "invalid.pem")
+	require.NoError(t, os.WriteFile(invalid, []byte("invalid"), 0600))
+
+	tests := []struct {
+		name    string
+		path    string
+		wantErr bool
+	}{
+		{
+			name: "positive test",
+			path: writeKey(t, key),
+		},
+		{
+			name:    "negative test (public key)",
+			path:    writePublicKey(t, key.Public()),
+			wantErr: true,
+		},
+		{
+			name:    "negative test (not PEM)",
+			path:    invalid,
+			wantErr: true,
+		},
+		{
+			name:    "negative test (no file)",
+			path:    filepath.Join(t.TempDir(), "unknown.pem"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadSigningKey(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadSigningKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !tt.wantErr {
+				assert.Equal(t, key.Public(), got.Public())
+			}
+		})
+	}
+}
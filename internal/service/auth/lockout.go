@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// LockoutPolicy defines exponential backoff for failed login attempts.
+// First FreeAttempts failures are not delayed, every next failure doubles the delay up to MaxDelay (lockout).
+type LockoutPolicy struct {
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	// ResetAfter defines period without failures after which counter is reset.
+	ResetAfter time.Duration
+}
+
+// NewLockoutPolicy returns lockout policy with one second base delay and one day reset period.
+func NewLockoutPolicy(freeAttempts int, maxDelay time.Duration) LockoutPolicy {
+	return LockoutPolicy{
+		FreeAttempts: freeAttempts,
+		BaseDelay:    time.Second,
+		MaxDelay:     maxDelay,
+		ResetAfter:   24 * time.Hour,
+	}
+}
+
+// LockedUntil returns time until which next attempt is rejected (zero time if attempt is allowed).
+func (p LockoutPolicy) LockedUntil(attempt models.LoginAttempt) time.Time {
+	if attempt.Failures < p.FreeAttempts || attempt.LastFailureAt.Before(time.Now().Add(-p.ResetAfter)) {
+		return time.Time{}
+	}
+
+	delay := p.MaxDelay
+	if shift := attempt.Failures - p.FreeAttempts; shift < 32 && p.BaseDelay<<shift < p.MaxDelay {
+		delay = p.BaseDelay << shift
+	}
+	return attempt.LastFailureAt.Add(delay)
+}
+
+// dummyPasswordHash is compared with the password of unknown user, so response time does not reveal
+// whether the user exists.
+var (
+	dummyPasswordHash     []byte    //nolint:gochecknoglobals
+	dummyPasswordHashOnce sync.Once //nolint:gochecknoglobals
+)
+
+// CompareDummyPassword spends the same time as the check of the password of existing user.
+func CompareDummyPassword(password string) {
+	dummyPasswordHashOnce.Do(func() {
+		dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("gophkeeper dummy password"), bcrypt.DefaultCost)
+	})
+	_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+}
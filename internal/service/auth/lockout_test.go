@@ -0,0 +1,56 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+)
+
+func TestLockoutPolicy_LockedUntil(t *testing.T) {
+	policy := NewLockoutPolicy(3, time.Minute)
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		attempt models.LoginAttempt
+		want    time.Time
+	}{
+		{
+			name:    "positive test (no failures)",
+			attempt: models.LoginAttempt{},
+			want:    time.Time{},
+		},
+		{
+			name:    "positive test (free attempts)",
+			attempt: models.LoginAttempt{Failures: 2, LastFailureAt: now},
+			want:    time.Time{},
+		},
+		{
+			name:    "positive test (base delay)",
+			attempt: models.LoginAttempt{Failures: 3, LastFailureAt: now},
+			want:    now.Add(time.Second),
+		},
+		{
+			name:    "positive test (exponential backoff)",
+			attempt: models.LoginAttempt{Failures: 6, LastFailureAt: now},
+			want:    now.Add(8 * time.Second),
+		},
+		{
+			name:    "positive test (lockout)",
+			attempt: models.LoginAttempt{Failures: 100, LastFailureAt: now},
+			want:    now.Add(time.Minute),
+		},
+		{
+			name:    "positive test (stale failures)",
+			attempt: models.LoginAttempt{Failures: 100, LastFailureAt: now.Add(-25 * time.Hour)},
+			want:    time.Time{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, policy.LockedUntil(tt.attempt))
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/rand"
+)
+
+// refreshTokenSize defines size of the random refresh token.
+const refreshTokenSize = 32
+
+// GenerateRefreshToken generates random opaque refresh token.
+func GenerateRefreshToken() (string, error) {
+	b, err := rand.Bytes(refreshTokenSize)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashRefreshToken returns hash of the refresh token. Only hashes are stored on the server.
+func HashRefreshToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
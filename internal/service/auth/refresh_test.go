@@ -0,0 +1,19 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateRefreshToken(t *testing.T) {
+	first, err := GenerateRefreshToken()
+	assert.NoError(t, err)
+	second, err := GenerateRefreshToken()
+	assert.NoError(t, err)
+
+	assert.NotEqual(t, first, second)
+	assert.Equal(t, HashRefreshToken(first), HashRefreshToken(first))
+	assert.NotEqual(t, HashRefreshToken(first), HashRefreshToken(second))
+	assert.NotContains(t, HashRefreshToken(first), first)
+}
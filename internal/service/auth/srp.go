@@ -0,0 +1,265 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"math/big"
+
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/rand"
+	"golang.org/x/crypto/argon2"
+)
+
+// SRP-6a (RFC 5054) with 2048-bit group and SHA-256. Private key x of the client is derived
+// from the password with Argon2id, so leaked verifiers are as expensive to attack as password hashes.
+const (
+	// srpSaltSize defines size of the salt of SRP verifier.
+	srpSaltSize = 16
+	// srpSecretSize defines size of the random ephemeral secret of the client and the server.
+	srpSecretSize = 32
+	// Argon2id parameters for derivation of the private key x (RFC 9106).
+	srpKdfTime    uint32 = 3
+	srpKdfMemory  uint32 = 64 * 1024
+	srpKdfThreads uint8  = 4
+)
+
+// srpPrime defines modulus N of 2048-bit group from RFC 5054 (appendix A), generator is 2.
+const srpPrime = "AC6BDB41324A9A9BF166DE5E1389582FAF72B6651987EE07FC3192943DB56050A37329CBB4A099ED8193E0757767A13D" +
+	"D52312AB4B03310DCD7F48A9DA04FD50E8083969EDB767B0CF6095179A163AB3661A05FBD5FAAAE82918A9962F0B93B855F97993EC975E" +
+	"EAA80D740ADBF4FF747359D041D5C33EA71D281E446B14773BCA97B43A23FB801676BD207A436C6481F1D2B9078717461A5B9D32E688F877" +
+	"48544523B524B0D57D5EA77A2775D2ECFA032CFBDBF52FB3786160279004E57AE6AF874E7303CE53299CCC041C7BC308D82A5698F3A8D0C3" +
+	"8271AE35F8E9DBFBB694B5C803D89F7AE435DE236D525F54759B65E372FCD68EF20FA7111F9E4AFF73"
+
+var (
+	srpN = mustParseHex(srpPrime)
+	srpG = big.NewInt(2)
+	// srpK defines multiplier parameter k = H(N | PAD(g)).
+	srpK = new(big.Int).SetBytes(srpHash(srpN.Bytes(), srpPad(srpG)))
+)
+
+// ErrorInvalidSRPProof defines an error for SRP handshake with invalid proof or public key.
+var ErrorInvalidSRPProof = errors.New("invalid SRP proof")
+
+func mustParseHex(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("invalid SRP prime")
+	}
+	return n
+}
+
+// srpHash returns SHA-256 hash of concatenated values.
+func srpHash(values ...[]byte) []byte {
+	hash := sha256.New()
+	for _, v := range values {
+		hash.Write(v)
+	}
+	return hash.Sum(nil)
+}
+
+// srpPad returns value padded with zeros to the length of N.
+func srpPad(n *big.Int) []byte {
+	return n.FillBytes(make([]byte, (srpN.BitLen()+7)/8))
+}
+
+// srpX derives private key x of the client from login, password and salt.
+func srpX(login string, password string, salt []byte) *big.Int {
+	key := argon2.IDKey([]byte(login+":"+password), salt, srpKdfTime, srpKdfMemory, srpKdfThreads, sha256.Size)
+	return new(big.Int).SetBytes(srpHash(salt, key))
+}
+
+// srpU returns scrambling parameter u = H(PAD(A) | PAD(B)).
+func srpU(clientPublic *big.Int, serverPublic *big.Int) *big.Int {
+	return new(big.Int).SetBytes(srpHash(srpPad(clientPublic), srpPad(serverPublic)))
+}
+
+// srpClientProof returns proof of the client M1 = H(H(N) xor H(g) | H(I) | s | A | B | K).
+func srpClientProof(login string, salt []byte, clientPublic *big.Int, serverPublic *big.Int, key []byte) []byte {
+	hashN := srpHash(srpN.Bytes())
+	hashG := srpHash(srpG.Bytes())
+	for i := range hashN {
+		hashN[i] ^= hashG[i]
+	}
+	return srpHash(hashN, srpHash([]byte(login)), salt, clientPublic.Bytes(), serverPublic.Bytes(), key)
+}
+
+// srpServerProof returns proof of the server M2 = H(A | M1 | K).
+func srpServerProof(clientPublic *big.Int, clientProof []byte, key []byte) []byte {
+	return srpHash(clientPublic.Bytes(), clientProof, key)
+}
+
+// srpRandom returns random ephemeral secret.
+func srpRandom() (*big.Int, error) {
+	b, err := rand.Bytes(srpSecretSize)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// isValidSRPPublic checks that public key of the other side is not zero modulo N.
+func isValidSRPPublic(n *big.Int) bool {
+	return new(big.Int).Mod(n, srpN).Sign() != 0
+}
+
+// NewSRPVerifier generates random salt and verifier v = g^x of the password. Verifier is stored
+// on the server instead of password hash, the password itself never leaves the client.
+func NewSRPVerifier(login string, password string) ([]byte, []byte, error) {
+	salt, err := rand.Bytes(srpSaltSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	verifier := new(big.Int).Exp(srpG, srpX(login, password, salt), srpN)
+	return salt, verifier.Bytes(), nil
+}
+
+// SRPClient represents client side of SRP handshake.
+type SRPClient struct {
+	login    string
+	password string
+	secret   *big.Int
+	public   *big.Int
+	proof    []byte
+	key      []byte
+}
+
+// NewSRPClient returns client side of SRP handshake with random ephemeral key.
+func NewSRPClient(login string, password string) (*SRPClient, error) {
+	secret, err := srpRandom()
+	if err != nil {
+		return nil, err
+	}
+	return &SRPClient{
+		login:    login,
+		password: password,
+		secret:   secret,
+		public:   new(big.Int).Exp(srpG, secret, srpN),
+	}, nil
+}
+
+// PublicKey returns public ephemeral key A of the client.
+func (c *SRPClient) PublicKey() []byte {
+	return c.public.Bytes()
+}
+
+// Proof computes session key and returns proof of the client M1 for salt and public key B of the server.
+func (c *SRPClient) Proof(salt []byte, serverPublicKey []byte) ([]byte, error) {
+	serverPublic := new(big.Int).SetBytes(serverPublicKey)
+	if !isValidSRPPublic(serverPublic) {
+		return nil, ErrorInvalidSRPProof
+	}
+	u := srpU(c.public, serverPublic)
+	if u.Sign() == 0 {
+		return nil, ErrorInvalidSRPProof
+	}
+
+	// S = (B - k * g^x) ^ (a + u * x)
+	x := srpX(c.login, c.password, salt)
+	base := new(big.Int).Exp(srpG, x, srpN)
+	base.Mul(base, srpK)
+	base.Sub(serverPublic, base)
+	base.Mod(base, srpN)
+	exponent := new(big.Int).Mul(u, x)
+	exponent.Add(exponent, c.secret)
+	sessionSecret := new(big.Int).Exp(base, exponent, srpN)
+
+	c.key = srpHash(srpPad(sessionSecret))
+	c.proof = srpClientProof(c.login, salt, c.public, serverPublic, c.key)
+	return c.proof, nil
+}
+
+// VerifyServer checks proof of the server M2, so the client knows that the server has the verifier.
+func (c *SRPClient) VerifyServer(serverProof []byte) bool {
+	if c.proof == nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(srpServerProof(c.public, c.proof, c.key), serverProof) == 1
+}
+
+// SRPServer represents server side of SRP handshake. Ephemeral secret is kept between requests of the handshake.
+type SRPServer struct {
+	verifier *big.Int
+	secret   *big.Int
+	public   *big.Int
+}
+
+// NewSRPServer returns server side of SRP handshake with random ephemeral key.
+func NewSRPServer(verifier []byte) (*SRPServer, error) {
+	secret, err := srpRandom()
+	if err != nil {
+		return nil, err
+	}
+	return RestoreSRPServer(verifier, secret.Bytes()), nil
+}
+
+// RestoreSRPServer returns server side of SRP handshake with saved ephemeral secret.
+func RestoreSRPServer(verifier []byte, secret []byte) *SRPServer {
+	s := &SRPServer{
+		verifier: new(big.Int).SetBytes(verifier),
+		secret:   new(big.Int).SetBytes(secret),
+	}
+
+	// B = k * v + g^b
+	s.public = new(big.Int).Mul(srpK, s.verifier)
+	s.public.Add(s.public, new(big.Int).Exp(srpG, s.secret, srpN))
+	s.public.Mod(s.public, srpN)
+	return s
+}
+
+// PublicKey returns public ephemeral key B of the server.
+func (s *SRPServer) PublicKey() []byte {
+	return s.public.Bytes()
+}
+
+// SecretKey returns ephemeral secret b of the server, it must be kept on the server until the proof is checked.
+func (s *SRPServer) SecretKey() []byte {
+	return s.secret.Bytes()
+}
+
+// VerifyProof checks proof of the client M1 and returns proof of the server M2.
+func (s *SRPServer) VerifyProof(login string, salt []byte, clientPublicKey []byte, clientProof []byte) ([]byte, error) {
+	clientPublic := new(big.Int).SetBytes(clientPublicKey)
+	if !isValidSRPPublic(clientPublic) {
+		return nil, ErrorInvalidSRPProof
+	}
+	u := srpU(clientPublic, s.public)
+	if u.Sign() == 0 {
+		return nil, ErrorInvalidSRPProof
+	}
+
+	// S = (A * v^u) ^ b
+	base := new(big.Int).Exp(s.verifier, u, srpN)
+	base.Mul(base, clientPublic)
+	base.Mod(base, srpN)
+	sessionSecret := new(big.Int).Exp(base, s.secret, srpN)
+
+	key := srpHash(srpPad(sessionSecret))
+	expected := srpClientProof(login, salt, clientPublic, s.public, key)
+	if subtle.ConstantTimeCompare(expected, clientProof) != 1 {
+		return nil, ErrorInvalidSRPProof
+	}
+	return srpServerProof(clientPublic, clientProof, key), nil
+}
+
+// DummySRPServer returns public key of SRP handshake for unknown user. It is not distinguished
+// from the key of existing user, so the handshake does not reveal registered logins.
+func DummySRPServer() (*SRPServer, error) {
+	secret, err := srpRandom()
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := srpRandom()
+	if err != nil {
+		return nil, err
+	}
+	return RestoreSRPServer(new(big.Int).Exp(srpG, verifier, srpN).Bytes(), secret.Bytes()), nil
+}
+
+// DummySRPSalt returns salt of SRP verifier for unknown user. Salt is the same for the login
+// on every request, so repeated handshakes do not reveal that the user does not exist.
+func DummySRPSalt(key []byte, login string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(login))
+	return mac.Sum(nil)[:srpSaltSize]
+}
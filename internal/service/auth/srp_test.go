@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSRP_Handshake(t *testing.T) {
+	salt, verifier, err := NewSRPVerifier("user", "password")
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		login    string
+		password string
+		wantErr  bool
+	}{
+		{
+			name:     "positive test",
+			login:    "user",
+			password: "password",
+			wantErr:  false,
+		},
+		{
+			name:     "negative test (invalid password)",
+			login:    "user",
+			password: "invalid",
+			wantErr:  true,
+		},
+		{
+			name:     "negative test (another login)",
+			login:    "another",
+			password: "password",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewSRPClient(tt.login, tt.password)
+			require.NoError(t, err)
+			server, err := NewSRPServer(verifier)
+			require.NoError(t, err)
+
+			clientProof, err := client.Proof(salt, server.PublicKey())
+			require.NoError(t, err)
+
+			// ephemeral secret of the server is restored in the second request of the handshake
+			restored := RestoreSRPServer(verifier, server.SecretKey())
+			assert.Equal(t, server.PublicKey(), restored.PublicKey())
+
+			serverProof, err := restored.VerifyProof("user", salt, client.PublicKey(), clientProof)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrorInvalidSRPProof)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, client.VerifyServer(serverProof))
+			assert.False(t, client.VerifyServer(clientProof))
+		})
+	}
+}
+
+func TestSRP_InvalidPublicKey(t *testing.T) {
+	_, verifier, err := NewSRPVerifier("user", "password")
+	require.NoError(t, err)
+	server, err := NewSRPServer(verifier)
+	require.NoError(t, err)
+	client, err := NewSRPClient("user", "password")
+	require.NoError(t, err)
+
+	// public key A = 0 (mod N) makes session key independent of the password
+	_, err = server.VerifyProof("user", nil, srpN.Bytes(), []byte("proof"))
+	assert.ErrorIs(t, err, ErrorInvalidSRPProof)
+	_, err = server.VerifyProof("user", nil, nil, []byte("proof"))
+	assert.ErrorIs(t, err, ErrorInvalidSRPProof)
+	_, err = client.Proof(nil, srpN.Bytes())
+	assert.ErrorIs(t, err, ErrorInvalidSRPProof)
+	assert.False(t, client.VerifyServer(nil))
+}
+
+func TestDummySRPSalt(t *testing.T) {
+	key := []byte("key")
+	assert.Equal(t, DummySRPSalt(key, "user"), DummySRPSalt(key, "user"))
+	assert.Len(t, DummySRPSalt(key, "user"), srpSaltSize)
+	assert.NotEqual(t, DummySRPSalt(key, "user"), DummySRPSalt(key, "another"))
+	assert.NotEqual(t, DummySRPSalt(key, "user"), DummySRPSalt([]byte("another key"), "user"))
+
+	server, err := DummySRPServer()
+	require.NoError(t, err)
+	assert.NotEmpty(t, server.PublicKey())
+}
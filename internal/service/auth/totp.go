@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec // HMAC-SHA1 is required by RFC 6238 for compatibility with authenticator apps
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/rand"
+)
+
+// TOTP parameters (RFC 6238 defaults supported by authenticator apps).
+const (
+	TOTPIssuer   = "Gophkeeper"
+	TOTPDigits   = 6
+	TOTPPeriod   = 30
+	totpSkew     = 1
+	totpKeySize  = 20
+	recoverySize = 10
+)
+
+// recoveryAlphabet defines alphabet of recovery codes (without similar symbols).
+const recoveryAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret generates random secret for authenticator app (base32 encoded).
+func GenerateTOTPSecret() (string, error) {
+	key, err := rand.Bytes(totpKeySize)
+	if err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(key), nil
+}
+
+// TOTPProvisioningURI returns otpauth URI of the secret (usually shown as QR code).
+func TOTPProvisioningURI(secret string, account string) string {
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", TOTPIssuer)
+	values.Set("algorithm", "SHA1")
+	values.Set("digits", fmt.Sprint(TOTPDigits))
+	values.Set("period", fmt.Sprint(TOTPPeriod))
+
+	label := url.PathEscape(TOTPIssuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + values.Encode()
+}
+
+// TOTPStep returns time step for the specified time.
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / TOTPPeriod
+}
+
+// GenerateTOTPCode returns one-time code of the secret for the specified time.
+func GenerateTOTPCode(secret string, t time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return totpCode(key, TOTPStep(t)), nil
+}
+
+// ValidateTOTPCode checks one-time code for the specified time with one step skew
+// and returns matched time step. Step is used to reject replay of the code.
+func ValidateTOTPCode(secret string, code string, t time.Time) (int64, bool) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil || len(code) != TOTPDigits {
+		return 0, false
+	}
+
+	current := TOTPStep(t)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// GenerateRecoveryCodes generates one-time recovery codes for login without authenticator app.
+func GenerateRecoveryCodes(count int) ([]string, error) {
+	codes := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		code, err := rand.StringFrom(recoveryAlphabet, recoverySize)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code[:recoverySize/2]+"-"+code[recoverySize/2:])
+	}
+	return codes, nil
+}
+
+// IsRecoveryCode checks that code has format of the recovery code.
+func IsRecoveryCode(code string) bool {
+	return len(code) == recoverySize+1 && strings.Count(code, "-") == 1
+}
+
+// HashRecoveryCode returns hash of the recovery code. Only hashes are stored on the server.
+func HashRecoveryCode(code string) string {
+	hash := sha256.Sum256([]byte(strings.ToLower(code)))
+	return hex.EncodeToString(hash[:])
+}
+
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	return totpEncoding.DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+}
+
+// totpCode implements HOTP (RFC 4226) with time step as a counter.
+func totpCode(key []byte, step int64) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	// dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%modulo)
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// rfcSecret is a test secret of RFC 6238 ("12345678901234567890").
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestGenerateTOTPCode(t *testing.T) {
+	// RFC 6238 test vectors (SHA1), last 6 digits
+	tests := []struct {
+		name string
+		time int64
+		want string
+	}{
+		{name: "time 59", time: 59, want: "287082"},
+		{name: "time 1111111109", time: 1111111109, want: "081804"},
+		{name: "time 1111111111", time: 1111111111, want: "050471"},
+		{name: "time 1234567890", time: 1234567890, want: "005924"},
+		{name: "time 2000000000", time: 2000000000, want: "279037"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GenerateTOTPCode(rfcSecret, time.Unix(tt.time, 0))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := GenerateTOTPCode("invalid secret!", time.Now())
+	assert.Error(t, err)
+}
+
+func TestValidateTOTPCode(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	assert.NoError(t, err)
+
+	now := time.Now()
+	code, err := GenerateTOTPCode(secret, now)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name string
+		code string
+		time time.Time
+		want bool
+	}{
+		{
+			name: "positive test",
+			code: code,
+			time: now,
+			want: true,
+		},
+		{
+			name: "positive test (previous step)",
+			code: code,
+			time: now.Add(TOTPPeriod * time.Second),
+			want: true,
+		},
+		{
+			name: "negative test (expired code)",
+			code: code,
+			time: now.Add(3 * TOTPPeriod * time.Second),
+			want: false,
+		},
+		{
+			name: "negative test (invalid code)",
+			code: "12345",
+			time: now,
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := ValidateTOTPCode(secret, tt.code, tt.time)
+			assert.Equal(t, tt.want, ok)
+			if tt.want {
+				assert.Equal(t, TOTPStep(now), step)
+			}
+		})
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := TOTPProvisioningURI(rfcSecret, "user")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Gophkeeper:user?"))
+	assert.Contains(t, uri, "secret="+rfcSecret)
+	assert.Contains(t, uri, "issuer=Gophkeeper")
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(8)
+	assert.NoError(t, err)
+	assert.Len(t, codes, 8)
+
+	for _, code := range codes {
+		assert.True(t, IsRecoveryCode(code))
+		assert.Equal(t, HashRecoveryCode(code), HashRecoveryCode(strings.ToUpper(code)))
+	}
+	assert.NotEqual(t, codes[0], codes[1])
+	assert.False(t, IsRecoveryCode("123456"))
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/storage"
+	"time"
 )
 
 // Service represents service layer for grpc server.
@@ -29,6 +30,206 @@ func (s *Service) GetUserByLogin(ctx context.Context, login string) (models.User
 	return s.storage.GetUserByLogin(ctx, login)
 }
 
+// GetUserByID is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) GetUserByID(ctx context.Context, userID string) (models.User, error) {
+	return s.storage.GetUserByID(ctx, userID)
+}
+
+// UpdateUserPassword is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) UpdateUserPassword(ctx context.Context, user models.User, vault *models.Vault) error {
+	return s.storage.UpdateUserPassword(ctx, user, vault)
+}
+
+// DeleteUser is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) DeleteUser(ctx context.Context, userID string) error {
+	return s.storage.DeleteUser(ctx, userID)
+}
+
+// SaveUserKey is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) SaveUserKey(ctx context.Context, userKey models.UserKey) error {
+	return s.storage.SaveUserKey(ctx, userKey)
+}
+
+// GetUserKey is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) GetUserKey(ctx context.Context, userID string) (models.UserKey, error) {
+	return s.storage.GetUserKey(ctx, userID)
+}
+
+// GetUserKeyBatch is a wrapper for storage layer. It is used in key rotation.
+func (s *Service) GetUserKeyBatch(ctx context.Context, afterUserID string, limit int) ([]models.UserKey, error) {
+	return s.storage.GetUserKeyBatch(ctx, afterUserID, limit)
+}
+
+// UpdateUserKey is a wrapper for storage layer. It is used in key rotation.
+func (s *Service) UpdateUserKey(ctx context.Context, userKey models.UserKey, previous []byte) error {
+	return s.storage.UpdateUserKey(ctx, userKey, previous)
+}
+
+// SaveRefreshToken is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) SaveRefreshToken(ctx context.Context, token models.RefreshToken) error {
+	return s.storage.SaveRefreshToken(ctx, token)
+}
+
+// GetRefreshToken is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) GetRefreshToken(ctx context.Context, tokenHash string) (models.RefreshToken, error) {
+	return s.storage.GetRefreshToken(ctx, tokenHash)
+}
+
+// UseRefreshToken is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) UseRefreshToken(ctx context.Context, tokenHash string) error {
+	return s.storage.UseRefreshToken(ctx, tokenHash)
+}
+
+// RevokeRefreshTokenFamily is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	return s.storage.RevokeRefreshTokenFamily(ctx, familyID)
+}
+
+// RevokeToken is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) RevokeToken(ctx context.Context, token models.RevokedToken) error {
+	return s.storage.RevokeToken(ctx, token)
+}
+
+// RevokeUserTokens is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) RevokeUserTokens(ctx context.Context, userID string, before time.Time) error {
+	return s.storage.RevokeUserTokens(ctx, userID, before)
+}
+
+// SaveSession is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) SaveSession(ctx context.Context, session models.Session) error {
+	return s.storage.SaveSession(ctx, session)
+}
+
+// GetSession is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) GetSession(ctx context.Context, userID string, sessionID string) (models.Session, error) {
+	return s.storage.GetSession(ctx, userID, sessionID)
+}
+
+// GetSessions is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) GetSessions(ctx context.Context, userID string, seenAfter time.Time) ([]models.Session, error) {
+	return s.storage.GetSessions(ctx, userID, seenAfter)
+}
+
+// TouchSession is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) TouchSession(ctx context.Context, sessionID string, seenAt time.Time) error {
+	return s.storage.TouchSession(ctx, sessionID, seenAt)
+}
+
+// RevokeSession is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) RevokeSession(ctx context.Context, userID string, sessionID string) error {
+	return s.storage.RevokeSession(ctx, userID, sessionID)
+}
+
+// IsTokenRevoked is a wrapper for storage layer. It is used in grpc interceptors.
+func (s *Service) IsTokenRevoked(ctx context.Context, tokenID string, userID string, sessionID string, issuedAt time.Time) (bool, error) {
+	return s.storage.IsTokenRevoked(ctx, tokenID, userID, sessionID, issuedAt)
+}
+
+// SaveTwoFactor is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) SaveTwoFactor(ctx context.Context, twoFactor models.TwoFactor) error {
+	return s.storage.SaveTwoFactor(ctx, twoFactor)
+}
+
+// GetTwoFactor is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) GetTwoFactor(ctx context.Context, userID string) (models.TwoFactor, error) {
+	return s.storage.GetTwoFactor(ctx, userID)
+}
+
+// EnableTwoFactor is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) EnableTwoFactor(ctx context.Context, userID string, step int64) error {
+	return s.storage.EnableTwoFactor(ctx, userID, step)
+}
+
+// UseTwoFactorStep is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) UseTwoFactorStep(ctx context.Context, userID string, step int64) error {
+	return s.storage.UseTwoFactorStep(ctx, userID, step)
+}
+
+// UseRecoveryCode is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) UseRecoveryCode(ctx context.Context, userID string, codeHash string) error {
+	return s.storage.UseRecoveryCode(ctx, userID, codeHash)
+}
+
+// DeleteTwoFactor is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) DeleteTwoFactor(ctx context.Context, userID string) error {
+	return s.storage.DeleteTwoFactor(ctx, userID)
+}
+
+// GetLoginAttempts is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) GetLoginAttempts(ctx context.Context, keys []string) ([]models.LoginAttempt, error) {
+	return s.storage.GetLoginAttempts(ctx, keys)
+}
+
+// AddLoginFailure is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) AddLoginFailure(ctx context.Context, key string, failedAt time.Time, resetBefore time.Time) error {
+	return s.storage.AddLoginFailure(ctx, key, failedAt, resetBefore)
+}
+
+// DeleteLoginAttempts is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) DeleteLoginAttempts(ctx context.Context, key string) error {
+	return s.storage.DeleteLoginAttempts(ctx, key)
+}
+
+// SaveSRPHandshake is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) SaveSRPHandshake(ctx context.Context, handshake models.SRPHandshake) error {
+	return s.storage.SaveSRPHandshake(ctx, handshake)
+}
+
+// UseSRPHandshake is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) UseSRPHandshake(ctx context.Context, handshakeID string) (models.SRPHandshake, error) {
+	return s.storage.UseSRPHandshake(ctx, handshakeID)
+}
+
+// SaveAccessToken is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) SaveAccessToken(ctx context.Context, token models.AccessToken) error {
+	return s.storage.SaveAccessToken(ctx, token)
+}
+
+// GetAccessToken is a wrapper for storage layer. It is used in grpc interceptors.
+func (s *Service) GetAccessToken(ctx context.Context, tokenHash string) (models.AccessToken, error) {
+	return s.storage.GetAccessToken(ctx, tokenHash)
+}
+
+// GetAccessTokens is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) GetAccessTokens(ctx context.Context, userID string) ([]models.AccessToken, error) {
+	return s.storage.GetAccessTokens(ctx, userID)
+}
+
+// TouchAccessToken is a wrapper for storage layer. It is used in grpc interceptors.
+func (s *Service) TouchAccessToken(ctx context.Context, tokenID string, usedAt time.Time) error {
+	return s.storage.TouchAccessToken(ctx, tokenID, usedAt)
+}
+
+// RevokeAccessToken is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) RevokeAccessToken(ctx context.Context, userID string, tokenID string) error {
+	return s.storage.RevokeAccessToken(ctx, userID, tokenID)
+}
+
+// SaveDevice is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) SaveDevice(ctx context.Context, device models.Device) error {
+	return s.storage.SaveDevice(ctx, device)
+}
+
+// GetDeviceByFingerprint is a wrapper for storage layer. It is used in grpc interceptors.
+func (s *Service) GetDeviceByFingerprint(ctx context.Context, fingerprint string) (models.Device, error) {
+	return s.storage.GetDeviceByFingerprint(ctx, fingerprint)
+}
+
+// RevokeDevice is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) RevokeDevice(ctx context.Context, userID string, deviceID string) error {
+	return s.storage.RevokeDevice(ctx, userID, deviceID)
+}
+
+// SaveVault is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) SaveVault(ctx context.Context, vault models.Vault) error {
+	return s.storage.SaveVault(ctx, vault)
+}
+
+// GetVault is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) GetVault(ctx context.Context, userID string) (models.Vault, error) {
+	return s.storage.GetVault(ctx, userID)
+}
+
 // AddData is a wrapper for storage layer. It is used in grpc server methods.
 func (s *Service) AddData(ctx context.Context, data models.Data) error {
 	return s.storage.AddData(ctx, data)
@@ -39,7 +240,92 @@ func (s *Service) GetDataByUserID(ctx context.Context, userID string) ([]models.
 	return s.storage.GetDataByUserID(ctx, userID)
 }
 
+// GetDataSample is a wrapper for storage layer. It is used to verify master key on startup.
+func (s *Service) GetDataSample(ctx context.Context, limit int) ([]models.Data, error) {
+	return s.storage.GetDataSample(ctx, limit)
+}
+
+// CountData is a wrapper for storage layer. It is used for key rotation.
+func (s *Service) CountData(ctx context.Context) (int64, error) {
+	return s.storage.CountData(ctx)
+}
+
+// GetDataBatch is a wrapper for storage layer. It is used for key rotation.
+func (s *Service) GetDataBatch(ctx context.Context, afterID string, limit int) ([]models.Data, error) {
+	return s.storage.GetDataBatch(ctx, afterID, limit)
+}
+
+// GetDataByID is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) GetDataByID(ctx context.Context, userID string, dataID string) (models.Data, error) {
+	return s.storage.GetDataByID(ctx, userID, dataID)
+}
+
+// UpdateDataBinary is a wrapper for storage layer. It is used for key rotation.
+func (s *Service) UpdateDataBinary(ctx context.Context, data models.Data, previous []byte) error {
+	return s.storage.UpdateDataBinary(ctx, data, previous)
+}
+
+// GetDataHistoryBatch is a wrapper for storage layer. It is used for key rotation.
+func (s *Service) GetDataHistoryBatch(ctx context.Context, afterID string, afterRevision int64, limit int) ([]models.Data, error) {
+	return s.storage.GetDataHistoryBatch(ctx, afterID, afterRevision, limit)
+}
+
+// UpdateDataHistoryBinary is a wrapper for storage layer. It is used for key rotation.
+func (s *Service) UpdateDataHistoryBinary(ctx context.Context, data models.Data, previous []byte) error {
+	return s.storage.UpdateDataHistoryBinary(ctx, data, previous)
+}
+
+// UpdateData is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) UpdateData(ctx context.Context, data models.Data, revision int64, keep int) (int64, error) {
+	return s.storage.UpdateData(ctx, data, revision, keep)
+}
+
+// GetDataRevisions is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) GetDataRevisions(ctx context.Context, userID string, dataID string) ([]models.Data, error) {
+	return s.storage.GetDataRevisions(ctx, userID, dataID)
+}
+
+// RestoreDataRevision is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) RestoreDataRevision(ctx context.Context, userID string, dataID string, revision int64, keep int) (models.Data, error) {
+	return s.storage.RestoreDataRevision(ctx, userID, dataID, revision, keep)
+}
+
+// GetDataChanges is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) GetDataChanges(ctx context.Context, userID string, cursor int64) (models.DataChanges, error) {
+	return s.storage.GetDataChanges(ctx, userID, cursor)
+}
+
+// GetDataCursor is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) GetDataCursor(ctx context.Context, userID string) (int64, error) {
+	return s.storage.GetDataCursor(ctx, userID)
+}
+
+// ListenDataChanges is a wrapper for storage layer. It is used for notifications of grpc streams.
+func (s *Service) ListenDataChanges(ctx context.Context, events chan<- models.ChangeEvent) error {
+	return s.storage.ListenDataChanges(ctx, events)
+}
+
 // DeleteDataByDataID is a wrapper for storage layer. It is used in grpc server methods.
-func (s *Service) DeleteDataByDataID(ctx context.Context, dataID string) error {
-	return s.storage.DeleteDataByDataID(ctx, dataID)
+func (s *Service) DeleteDataByDataID(ctx context.Context, userID string, dataID string, revision int64) error {
+	return s.storage.DeleteDataByDataID(ctx, userID, dataID, revision)
+}
+
+// GetTrashByUserID is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) GetTrashByUserID(ctx context.Context, userID string) ([]models.Data, error) {
+	return s.storage.GetTrashByUserID(ctx, userID)
+}
+
+// RestoreData is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) RestoreData(ctx context.Context, userID string, dataID string) (models.Data, error) {
+	return s.storage.RestoreData(ctx, userID, dataID)
+}
+
+// PurgeData is a wrapper for storage layer. It is used in grpc server methods.
+func (s *Service) PurgeData(ctx context.Context, userID string, dataID string) error {
+	return s.storage.PurgeData(ctx, userID, dataID)
+}
+
+// PurgeTrash is a wrapper for storage layer. It is used for background purge of the trash.
+func (s *Service) PurgeTrash(ctx context.Context, before time.Time) (int64, error) {
+	return s.storage.PurgeTrash(ctx, before)
 }
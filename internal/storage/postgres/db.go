@@ -3,6 +3,7 @@ package postgres
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"github.com/georgysavva/scany/v2/pgxscan"
 	"github.com/jackc/pgx/v5"
@@ -11,8 +12,12 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/storage"
+	"time"
 )
 
+// dataChangesChannel defines notification channel for changes of private data.
+const dataChangesChannel = "data_changes"
+
 // DBStorage implements Storage interface.
 type DBStorage struct {
 	db *pgxpool.Pool
@@ -29,12 +34,14 @@ func NewDBStorage(pool *pgxpool.Pool) *DBStorage {
 // RegisterUser adds a new unique user to the storage.
 func (d *DBStorage) RegisterUser(ctx context.Context, user models.User) error {
 	err := d.db.QueryRow(ctx,
-		`INSERT INTO users (id, login, password)
-			 VALUES ($1, $2, $3) ON CONFLICT
+		`INSERT INTO users (id, login, password, srp_salt, srp_verifier)
+			 VALUES ($1, $2, $3, $4, $5) ON CONFLICT
 			 DO NOTHING RETURNING login`,
 		user.ID,
 		user.Login,
 		user.Password,
+		user.SRPSalt,
+		user.SRPVerifier,
 	).Scan(&user.Login)
 
 	if errors.Is(err, pgx.ErrNoRows) {
@@ -47,14 +54,14 @@ func (d *DBStorage) RegisterUser(ctx context.Context, user models.User) error {
 		return err
 	}
 
-	log.Debug().Msgf("User registered %+v", user)
+	log.Debug().Msgf("User registered %s", user.ID)
 	return nil
 }
 
 // GetUserByLogin gets current user data from storage.
 func (d *DBStorage) GetUserByLogin(ctx context.Context, login string) (models.User, error) {
 	var users []models.User
-	err := pgxscan.Select(ctx, d.db, &users, "SELECT id, login, password FROM users WHERE login=$1",
+	err := pgxscan.Select(ctx, d.db, &users, "SELECT id, login, password, srp_salt, srp_verifier FROM users WHERE login=$1",
 		login)
 	if err != nil {
 		log.Error().Msgf("GetUserByLogin error %s", err)
@@ -70,63 +77,1554 @@ func (d *DBStorage) GetUserByLogin(ctx context.Context, login string) (models.Us
 	return users[0], nil
 }
 
-// AddData adds private data to storage.
-func (d *DBStorage) AddData(ctx context.Context, data models.Data) error {
-	log.Debug().Msgf("AddData (postgres): %v", data)
+// GetUserByID gets user data from storage by ID.
+func (d *DBStorage) GetUserByID(ctx context.Context, userID string) (models.User, error) {
+	var users []models.User
+	err := pgxscan.Select(ctx, d.db, &users, "SELECT id, login, password, srp_salt, srp_verifier FROM users WHERE id=$1",
+		userID)
+	if err != nil {
+		log.Error().Msgf("GetUserByID error %s", err)
+		return models.User{}, err
+	}
+
+	if len(users) == 0 {
+		log.Error().Msg("User doesn't exist")
+		return models.User{}, storage.ErrorUserNotFound
+	}
+
+	log.Debug().Msg("User loaded")
+	return users[0], nil
+}
+
+// UpdateUserPassword replaces hashed password and SRP verifier of the user. Vault wrapped with the new password
+// is replaced in the same transaction, so the vault is always unlocked by the current password.
+func (d *DBStorage) UpdateUserPassword(ctx context.Context, user models.User, vault *models.Vault) error {
+	err := pgx.BeginFunc(ctx, d.db, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `UPDATE users SET password = $2, srp_salt = $3, srp_verifier = $4 WHERE id = $1`,
+			user.ID, user.Password, user.SRPSalt, user.SRPVerifier)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return storage.ErrorUserNotFound
+		}
+
+		if vault == nil {
+			return nil
+		}
+		_, err = tx.Exec(ctx,
+			`INSERT INTO vaults (user_id, salt, kdf_time, kdf_memory, kdf_threads, wrapped_key)
+				 VALUES ($1, $2, $3, $4, $5, $6)
+				 ON CONFLICT (user_id) DO UPDATE SET salt = EXCLUDED.salt, kdf_time = EXCLUDED.kdf_time,
+				 kdf_memory = EXCLUDED.kdf_memory, kdf_threads = EXCLUDED.kdf_threads, wrapped_key = EXCLUDED.wrapped_key`,
+			user.ID,
+			vault.Salt,
+			vault.KdfTime,
+			vault.KdfMemory,
+			vault.KdfThreads,
+			vault.WrappedKey,
+		)
+		return err
+	})
+	if err != nil {
+		log.Error().Msgf("UpdateUserPassword error %s", err)
+		return err
+	}
+
+	log.Info().Msg("User password updated")
+	return nil
+}
+
+// DeleteUser deletes the user from storage. Wrapped data encryption key is destroyed first,
+// so private data of the user cannot be decrypted anymore (crypto-shredding).
+func (d *DBStorage) DeleteUser(ctx context.Context, userID string) error {
+	var deleted int64
+	err := pgx.BeginFunc(ctx, d.db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `DELETE FROM user_keys WHERE user_id = $1`, userID)
+		if err != nil {
+			return err
+		}
+
+		tag, err := tx.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID)
+		if err != nil {
+			return err
+		}
+		deleted = tag.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		log.Error().Msgf("DeleteUser error %s", err)
+		return err
+	}
+
+	if deleted == 0 {
+		log.Error().Msg("User doesn't exist")
+		return storage.ErrorUserNotFound
+	}
+
+	log.Info().Msg("User deleted")
+	return nil
+}
+
+// SaveUserKey adds wrapped data encryption key of the user. Existing key is not overwritten.
+func (d *DBStorage) SaveUserKey(ctx context.Context, userKey models.UserKey) error {
+	tag, err := d.db.Exec(ctx,
+		`INSERT INTO user_keys (user_id, wrapped_key) VALUES ($1, $2) ON CONFLICT DO NOTHING`,
+		userKey.UserID,
+		userKey.WrappedKey,
+	)
+	if err != nil {
+		log.Error().Msgf("SaveUserKey error %s", err)
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		log.Error().Msg("User key already exist")
+		return storage.ErrorUserKeyAlreadyExist
+	}
+
+	log.Debug().Msg("User key saved")
+	return nil
+}
+
+// GetUserKey gets wrapped data encryption key of the user.
+func (d *DBStorage) GetUserKey(ctx context.Context, userID string) (models.UserKey, error) {
+	var keys []models.UserKey
+	err := pgxscan.Select(ctx, d.db, &keys,
+		"SELECT user_id, wrapped_key FROM user_keys WHERE user_id=$1",
+		userID)
+	if err != nil {
+		log.Error().Msgf("GetUserKey error %s", err)
+		return models.UserKey{}, err
+	}
+
+	if len(keys) == 0 {
+		log.Debug().Msg("User key doesn't exist")
+		return models.UserKey{}, storage.ErrorUserKeyNotFound
+	}
+
+	log.Debug().Msg("User key loaded")
+	return keys[0], nil
+}
+
+// GetUserKeyBatch gets user keys following the specified user ID (keyset pagination).
+func (d *DBStorage) GetUserKeyBatch(ctx context.Context, afterUserID string, limit int) ([]models.UserKey, error) {
+	var keys []models.UserKey
+	err := pgxscan.Select(ctx, d.db, &keys,
+		"SELECT user_id, wrapped_key FROM user_keys WHERE user_id > $1 ORDER BY user_id LIMIT $2",
+		afterUserID, limit)
+	if err != nil {
+		log.Error().Msgf("GetUserKeyBatch error %s", err)
+		return nil, err
+	}
+
+	log.Debug().Msgf("User key batch loaded: %d", len(keys))
+	return keys, nil
+}
+
+// UpdateUserKey replaces wrapped data encryption key in storage.
+// Key is not updated (ErrorUserKeyNotFound) if it was changed or deleted after previous key was read.
+func (d *DBStorage) UpdateUserKey(ctx context.Context, userKey models.UserKey, previous []byte) error {
+	tag, err := d.db.Exec(ctx,
+		`UPDATE user_keys SET wrapped_key = $2 WHERE user_id = $1 AND wrapped_key = $3`,
+		userKey.UserID,
+		userKey.WrappedKey,
+		previous,
+	)
+	if err != nil {
+		log.Error().Msgf("UpdateUserKey error %s", err)
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return storage.ErrorUserKeyNotFound
+	}
+
+	log.Debug().Msg("User key updated")
+	return nil
+}
+
+// SaveRefreshToken adds hashed refresh token to storage.
+func (d *DBStorage) SaveRefreshToken(ctx context.Context, token models.RefreshToken) error {
 	_, err := d.db.Exec(ctx,
-		`INSERT INTO data (id, user_id, data_type, data_binary) 
-			 VALUES ($1, $2, $3, $4) ON CONFLICT(id) 
-			 DO UPDATE SET data_type = EXCLUDED.data_type,
-			               data_binary = EXCLUDED.data_binary`,
-		data.ID,
-		data.UserID,
-		data.DataType,
-		data.DataBinary,
+		`INSERT INTO refresh_tokens (token_hash, user_id, family_id, expires_at)
+			 VALUES ($1, $2, $3, $4)`,
+		token.TokenHash,
+		token.UserID,
+		token.FamilyID,
+		token.ExpiresAt,
 	)
+	if err != nil {
+		log.Error().Msgf("SaveRefreshToken error %s", err)
+		return err
+	}
+
+	log.Debug().Msg("Refresh token saved")
+	return nil
+}
 
+// GetRefreshToken gets refresh token by hash from storage.
+func (d *DBStorage) GetRefreshToken(ctx context.Context, tokenHash string) (models.RefreshToken, error) {
+	var tokens []models.RefreshToken
+	err := pgxscan.Select(ctx, d.db, &tokens,
+		"SELECT token_hash, user_id, family_id, expires_at, used, revoked FROM refresh_tokens WHERE token_hash=$1",
+		tokenHash)
 	if err != nil {
-		log.Error().Msgf("AddData error %s", err)
+		log.Error().Msgf("GetRefreshToken error %s", err)
+		return models.RefreshToken{}, err
+	}
+
+	if len(tokens) == 0 {
+		log.Error().Msg("Refresh token doesn't exist")
+		return models.RefreshToken{}, storage.ErrorRefreshTokenNotFound
+	}
+
+	log.Debug().Msg("Refresh token loaded")
+	return tokens[0], nil
+}
+
+// UseRefreshToken marks refresh token as used. Token can be used only once (ErrorRefreshTokenNotFound otherwise).
+func (d *DBStorage) UseRefreshToken(ctx context.Context, tokenHash string) error {
+	tag, err := d.db.Exec(ctx,
+		`UPDATE refresh_tokens SET used = true WHERE token_hash = $1 AND used = false AND revoked = false`,
+		tokenHash,
+	)
+	if err != nil {
+		log.Error().Msgf("UseRefreshToken error %s", err)
 		return err
 	}
 
-	log.Debug().Msg("DataBinary added")
+	if tag.RowsAffected() == 0 {
+		return storage.ErrorRefreshTokenNotFound
+	}
+
+	log.Debug().Msg("Refresh token used")
 	return nil
 }
 
-// GetDataByUserID gets all related user data from storage.
-func (d *DBStorage) GetDataByUserID(ctx context.Context, userID string) ([]models.Data, error) {
-	var data []models.Data
-	err := pgxscan.Select(ctx, d.db, &data,
-		"SELECT id, user_id, data_type, data_binary FROM data WHERE user_id=$1",
+// RevokeRefreshTokenFamily revokes all refresh tokens of the family in storage.
+func (d *DBStorage) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	_, err := d.db.Exec(ctx,
+		`UPDATE refresh_tokens SET revoked = true WHERE family_id = $1`,
+		familyID,
+	)
+	if err != nil {
+		log.Error().Msgf("RevokeRefreshTokenFamily error %s", err)
+		return err
+	}
+
+	log.Info().Msg("Refresh token family revoked")
+	return nil
+}
+
+// SaveSession adds login session of the user to storage.
+func (d *DBStorage) SaveSession(ctx context.Context, session models.Session) error {
+	_, err := d.db.Exec(ctx,
+		`INSERT INTO sessions (id, user_id, device_name, client_version, address, created_at, last_seen_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, $6)`,
+		session.ID,
+		session.UserID,
+		session.DeviceName,
+		session.ClientVersion,
+		session.Address,
+		session.CreatedAt,
+	)
+	if err != nil {
+		log.Error().Msgf("SaveSession error %s", err)
+		return err
+	}
+
+	log.Debug().Msg("Session saved")
+	return nil
+}
+
+// GetSession gets active session of the user by ID from storage.
+func (d *DBStorage) GetSession(ctx context.Context, userID string, sessionID string) (models.Session, error) {
+	var sessions []models.Session
+	err := pgxscan.Select(ctx, d.db, &sessions,
+		`SELECT id, user_id, device_name, client_version, address, created_at, last_seen_at, revoked
+			 FROM sessions WHERE id = $1 AND user_id = $2 AND revoked = false`,
+		sessionID,
 		userID)
 	if err != nil {
-		log.Error().Msgf("GetDataByUserID error %s", err)
-		return nil, err
+		log.Error().Msgf("GetSession error %s", err)
+		return models.Session{}, err
 	}
 
-	if len(data) == 0 {
-		log.Error().Msg("Data doesn't exist")
-		return nil, storage.ErrorPrivateDataNotFound
+	if len(sessions) == 0 {
+		log.Error().Msg("Session doesn't exist")
+		return models.Session{}, storage.ErrorSessionNotFound
 	}
 
-	log.Debug().Msg("Data loaded")
-	return data, nil
+	log.Debug().Msg("Session loaded")
+	return sessions[0], nil
+}
+
+// GetSessions gets active sessions of the user which were seen after the specified time (most recent first).
+func (d *DBStorage) GetSessions(ctx context.Context, userID string, seenAfter time.Time) ([]models.Session, error) {
+	var sessions []models.Session
+	err := pgxscan.Select(ctx, d.db, &sessions,
+		`SELECT id, user_id, device_name, client_version, address, created_at, last_seen_at, revoked
+			 FROM sessions WHERE user_id = $1 AND revoked = false AND last_seen_at > $2
+			 ORDER BY last_seen_at DESC`,
+		userID,
+		seenAfter)
+	if err != nil {
+		log.Error().Msgf("GetSessions error %s", err)
+		return nil, err
+	}
+
+	log.Debug().Msgf("Sessions loaded: %d", len(sessions))
+	return sessions, nil
 }
 
-// DeleteDataByDataID deletes private data from storage.
-func (d *DBStorage) DeleteDataByDataID(ctx context.Context, id string) error {
+// TouchSession updates last seen time of the session in storage.
+func (d *DBStorage) TouchSession(ctx context.Context, sessionID string, seenAt time.Time) error {
 	_, err := d.db.Exec(ctx,
-		`DELETE from data WHERE id = $1`,
-		id)
+		`UPDATE sessions SET last_seen_at = $2 WHERE id = $1 AND last_seen_at < $2`,
+		sessionID,
+		seenAt,
+	)
+	if err != nil {
+		log.Error().Msgf("TouchSession error %s", err)
+		return err
+	}
+
+	log.Debug().Msg("Session updated")
+	return nil
+}
+
+// RevokeSession revokes session of the user with all refresh tokens of the session in storage.
+func (d *DBStorage) RevokeSession(ctx context.Context, userID string, sessionID string) error {
+	err := pgx.BeginFunc(ctx, d.db, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx,
+			`UPDATE sessions SET revoked = true WHERE id = $1 AND user_id = $2 AND revoked = false`,
+			sessionID,
+			userID,
+		)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return storage.ErrorSessionNotFound
+		}
+
+		_, err = tx.Exec(ctx, `UPDATE refresh_tokens SET revoked = true WHERE family_id = $1`, sessionID)
+		return err
+	})
+	if err != nil {
+		log.Error().Msgf("RevokeSession error %s", err)
+		return err
+	}
+
+	log.Info().Msg("Session revoked")
+	return nil
+}
+
+// RevokeToken adds access token to the revocation list. Expired tokens are removed from the list.
+func (d *DBStorage) RevokeToken(ctx context.Context, token models.RevokedToken) error {
+	err := pgx.BeginFunc(ctx, d.db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `DELETE FROM revoked_tokens WHERE expires_at < now()`)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx,
+			`INSERT INTO revoked_tokens (id, user_id, expires_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`,
+			token.ID,
+			token.UserID,
+			token.ExpiresAt,
+		)
+		return err
+	})
+	if err != nil {
+		log.Error().Msgf("RevokeToken error %s", err)
+		return err
+	}
+
+	log.Info().Msg("Access token revoked")
+	return nil
+}
+
+// RevokeUserTokens revokes all tokens of the user issued before the specified time (log out all devices).
+// Revocation time is truncated to seconds as issue time of the token, tokens of the revoked sessions
+// issued within the same second are revoked with the sessions.
+func (d *DBStorage) RevokeUserTokens(ctx context.Context, userID string, before time.Time) error {
+	err := pgx.BeginFunc(ctx, d.db, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `UPDATE users SET tokens_valid_after = $2 WHERE id = $1`,
+			userID, before.Truncate(time.Second))
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return storage.ErrorUserNotFound
+		}
+
+		_, err = tx.Exec(ctx, `UPDATE refresh_tokens SET revoked = true WHERE user_id = $1`, userID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx, `UPDATE sessions SET revoked = true WHERE user_id = $1 AND created_at < $2`, userID, before)
+		return err
+	})
+	if err != nil {
+		log.Error().Msgf("RevokeUserTokens error %s", err)
+		return err
+	}
+
+	log.Info().Msg("User tokens revoked")
+	return nil
+}
+
+// IsTokenRevoked checks that access token is in the revocation list, belongs to revoked session
+// or issued before revocation time of the user. Tokens of the deleted user are revoked as well.
+func (d *DBStorage) IsTokenRevoked(ctx context.Context, tokenID string, userID string, sessionID string, issuedAt time.Time) (bool, error) {
+	var revoked bool
+	err := d.db.QueryRow(ctx,
+		`SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE id = $1)
+			 OR EXISTS(SELECT 1 FROM sessions WHERE id = NULLIF($4, '')::uuid AND revoked = true)
+			 OR NOT EXISTS(SELECT 1 FROM users WHERE id = $2 AND (tokens_valid_after IS NULL OR tokens_valid_after <= $3))`,
+		tokenID,
+		userID,
+		issuedAt,
+		sessionID,
+	).Scan(&revoked)
+	if err != nil {
+		log.Error().Msgf("IsTokenRevoked error %s", err)
+		return false, err
+	}
+	return revoked, nil
+}
+
+// SaveTwoFactor adds pending second factor of the user. Pending second factor is replaced (new enrollment),
+// enabled second factor is not overwritten.
+func (d *DBStorage) SaveTwoFactor(ctx context.Context, twoFactor models.TwoFactor) error {
+	tag, err := d.db.Exec(ctx,
+		`INSERT INTO two_factor (user_id, secret, enabled, last_used_step, recovery_codes)
+			 VALUES ($1, $2, false, 0, $3)
+			 ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, recovery_codes = EXCLUDED.recovery_codes
+			 WHERE two_factor.enabled = false`,
+		twoFactor.UserID,
+		twoFactor.Secret,
+		twoFactor.RecoveryCodes,
+	)
+	if err != nil {
+		log.Error().Msgf("SaveTwoFactor error %s", err)
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		log.Error().Msg("Two-factor authentication already enabled")
+		return storage.ErrorTwoFactorAlreadyEnabled
+	}
+
+	log.Debug().Msg("Two-factor authentication saved")
+	return nil
+}
+
+// GetTwoFactor gets second factor of the user.
+func (d *DBStorage) GetTwoFactor(ctx context.Context, userID string) (models.TwoFactor, error) {
+	var factors []models.TwoFactor
+	err := pgxscan.Select(ctx, d.db, &factors,
+		"SELECT user_id, secret, enabled, last_used_step, recovery_codes FROM two_factor WHERE user_id=$1",
+		userID)
+	if err != nil {
+		log.Error().Msgf("GetTwoFactor error %s", err)
+		return models.TwoFactor{}, err
+	}
+
+	if len(factors) == 0 {
+		log.Debug().Msg("Two-factor authentication doesn't exist")
+		return models.TwoFactor{}, storage.ErrorTwoFactorNotFound
+	}
+
+	log.Debug().Msg("Two-factor authentication loaded")
+	return factors[0], nil
+}
+
+// EnableTwoFactor enables pending second factor of the user. TOTP step of the confirmation code is marked as used.
+func (d *DBStorage) EnableTwoFactor(ctx context.Context, userID string, step int64) error {
+	tag, err := d.db.Exec(ctx,
+		`UPDATE two_factor SET enabled = true, last_used_step = $2 WHERE user_id = $1 AND enabled = false`,
+		userID,
+		step,
+	)
+	if err != nil {
+		log.Error().Msgf("EnableTwoFactor error %s", err)
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return storage.ErrorTwoFactorNotFound
+	}
 
+	log.Info().Msg("Two-factor authentication enabled")
+	return nil
+}
+
+// UseTwoFactorStep marks TOTP step as used. Every code can be used once (ErrorTwoFactorCodeUsed otherwise).
+func (d *DBStorage) UseTwoFactorStep(ctx context.Context, userID string, step int64) error {
+	tag, err := d.db.Exec(ctx,
+		`UPDATE two_factor SET last_used_step = $2 WHERE user_id = $1 AND enabled = true AND last_used_step < $2`,
+		userID,
+		step,
+	)
+	if err != nil {
+		log.Error().Msgf("UseTwoFactorStep error %s", err)
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return storage.ErrorTwoFactorCodeUsed
+	}
+
+	log.Debug().Msg("Two-factor step used")
+	return nil
+}
+
+// UseRecoveryCode removes hashed recovery code of the user. Every code can be used once (ErrorTwoFactorCodeUsed otherwise).
+func (d *DBStorage) UseRecoveryCode(ctx context.Context, userID string, codeHash string) error {
+	tag, err := d.db.Exec(ctx,
+		`UPDATE two_factor SET recovery_codes = array_remove(recovery_codes, $2)
+			 WHERE user_id = $1 AND enabled = true AND $2 = ANY(recovery_codes)`,
+		userID,
+		codeHash,
+	)
+	if err != nil {
+		log.Error().Msgf("UseRecoveryCode error %s", err)
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return storage.ErrorTwoFactorCodeUsed
+	}
+
+	log.Info().Msg("Recovery code used")
+	return nil
+}
+
+// DeleteTwoFactor deletes second factor of the user.
+func (d *DBStorage) DeleteTwoFactor(ctx context.Context, userID string) error {
+	tag, err := d.db.Exec(ctx, `DELETE FROM two_factor WHERE user_id = $1`, userID)
+	if err != nil {
+		log.Error().Msgf("DeleteTwoFactor error %s", err)
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return storage.ErrorTwoFactorNotFound
+	}
+
+	log.Info().Msg("Two-factor authentication deleted")
+	return nil
+}
+
+// GetLoginAttempts gets failed login attempts for the specified keys. Keys without failures are not returned.
+func (d *DBStorage) GetLoginAttempts(ctx context.Context, keys []string) ([]models.LoginAttempt, error) {
+	var attempts []models.LoginAttempt
+	err := pgxscan.Select(ctx, d.db, &attempts,
+		"SELECT key, failures, last_failure_at FROM login_attempts WHERE key = ANY($1)",
+		keys)
 	if err != nil {
+		log.Error().Msgf("GetLoginAttempts error %s", err)
+		return nil, err
+	}
+	return attempts, nil
+}
+
+// AddLoginFailure increments failed login attempts of the key. Stale attempts (failed before resetBefore)
+// are removed first, so the counter of the key starts again.
+func (d *DBStorage) AddLoginFailure(ctx context.Context, key string, failedAt time.Time, resetBefore time.Time) error {
+	err := pgx.BeginFunc(ctx, d.db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `DELETE FROM login_attempts WHERE last_failure_at < $1`, resetBefore)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx,
+			`INSERT INTO login_attempts (key, failures, last_failure_at) VALUES ($1, 1, $2)
+				 ON CONFLICT (key) DO UPDATE SET failures = login_attempts.failures + 1,
+				 last_failure_at = GREATEST(login_attempts.last_failure_at, EXCLUDED.last_failure_at)`,
+			key,
+			failedAt,
+		)
+		return err
+	})
+	if err != nil {
+		log.Error().Msgf("AddLoginFailure error %s", err)
+		return err
+	}
+
+	log.Debug().Msg("Login failure added")
+	return nil
+}
+
+// DeleteLoginAttempts resets failed login attempts of the key.
+func (d *DBStorage) DeleteLoginAttempts(ctx context.Context, key string) error {
+	_, err := d.db.Exec(ctx, `DELETE FROM login_attempts WHERE key = $1`, key)
+	if err != nil {
+		log.Error().Msgf("DeleteLoginAttempts error %s", err)
+		return err
+	}
+
+	log.Debug().Msg("Login attempts deleted")
+	return nil
+}
+
+// SaveSRPHandshake adds started SRP login to storage. Expired handshakes are removed.
+func (d *DBStorage) SaveSRPHandshake(ctx context.Context, handshake models.SRPHandshake) error {
+	_, err := d.db.Exec(ctx, `DELETE FROM srp_handshakes WHERE expires_at < $1`, time.Now())
+	if err != nil {
+		log.Error().Msgf("SaveSRPHandshake error %s", err)
+		return err
+	}
+
+	_, err = d.db.Exec(ctx,
+		`INSERT INTO srp_handshakes (id, user_id, secret, expires_at) VALUES ($1, $2, $3, $4)`,
+		handshake.ID,
+		handshake.UserID,
+		handshake.Secret,
+		handshake.ExpiresAt,
+	)
+	if err != nil {
+		log.Error().Msgf("SaveSRPHandshake error %s", err)
 		return err
 	}
 
-	log.Info().Msg("DataBinary deleted")
+	log.Debug().Msg("SRP handshake saved")
 	return nil
 }
 
+// UseSRPHandshake deletes started SRP login from storage and returns it. Every handshake can be used once.
+func (d *DBStorage) UseSRPHandshake(ctx context.Context, handshakeID string) (models.SRPHandshake, error) {
+	var handshakes []models.SRPHandshake
+	err := pgxscan.Select(ctx, d.db, &handshakes,
+		`DELETE FROM srp_handshakes WHERE id = $1 RETURNING id, user_id, secret, expires_at`,
+		handshakeID)
+	if err != nil {
+		log.Error().Msgf("UseSRPHandshake error %s", err)
+		return models.SRPHandshake{}, err
+	}
+
+	if len(handshakes) == 0 {
+		log.Error().Msg("SRP handshake doesn't exist")
+		return models.SRPHandshake{}, storage.ErrorSRPHandshakeNotFound
+	}
+
+	log.Debug().Msg("SRP handshake used")
+	return handshakes[0], nil
+}
+
+// accessTokenColumns defines columns of personal access token.
+const accessTokenColumns = `id, user_id, name, token_hash, read_only, data_ids, data_types, wrapped_key, expires_at, created_at, last_used_at, revoked`
+
+// SaveAccessToken adds hashed personal access token of the user to storage.
+func (d *DBStorage) SaveAccessToken(ctx context.Context, token models.AccessToken) error {
+	_, err := d.db.Exec(ctx,
+		`INSERT INTO access_tokens (id, user_id, name, token_hash, read_only, data_ids, data_types, wrapped_key, expires_at, created_at)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		token.ID,
+		token.UserID,
+		token.Name,
+		token.TokenHash,
+		token.ReadOnly,
+		token.DataIDs,
+		token.DataTypes,
+		token.WrappedKey,
+		token.ExpiresAt,
+		token.CreatedAt,
+	)
+	if err != nil {
+		log.Error().Msgf("SaveAccessToken error %s", err)
+		return err
+	}
+
+	log.Info().Msg("Access token saved")
+	return nil
+}
+
+// GetAccessToken gets personal access token by hash from storage. Revoked tokens are not returned.
+func (d *DBStorage) GetAccessToken(ctx context.Context, tokenHash string) (models.AccessToken, error) {
+	var tokens []models.AccessToken
+	err := pgxscan.Select(ctx, d.db, &tokens,
+		`SELECT `+accessTokenColumns+` FROM access_tokens WHERE token_hash = $1 AND revoked = false`,
+		tokenHash)
+	if err != nil {
+		log.Error().Msgf("GetAccessToken error %s", err)
+		return models.AccessToken{}, err
+	}
+
+	if len(tokens) == 0 {
+		log.Error().Msg("Access token doesn't exist")
+		return models.AccessToken{}, storage.ErrorAccessTokenNotFound
+	}
+
+	log.Debug().Msg("Access token loaded")
+	return tokens[0], nil
+}
+
+// GetAccessTokens gets personal access tokens of the user from storage. Revoked tokens are not returned.
+func (d *DBStorage) GetAccessTokens(ctx context.Context, userID string) ([]models.AccessToken, error) {
+	var tokens []models.AccessToken
+	err := pgxscan.Select(ctx, d.db, &tokens,
+		`SELECT `+accessTokenColumns+` FROM access_tokens WHERE user_id = $1 AND revoked = false ORDER BY created_at`,
+		userID)
+	if err != nil {
+		log.Error().Msgf("GetAccessTokens error %s", err)
+		return nil, err
+	}
+
+	log.Debug().Msgf("Access tokens loaded: %d", len(tokens))
+	return tokens, nil
+}
+
+// TouchAccessToken updates last used time of personal access token in storage.
+// Time is updated once per minute to avoid write on every request.
+func (d *DBStorage) TouchAccessToken(ctx context.Context, tokenID string, usedAt time.Time) error {
+	_, err := d.db.Exec(ctx,
+		`UPDATE access_tokens SET last_used_at = $2
+			 WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < $2::timestamptz - interval '1 minute')`,
+		tokenID,
+		usedAt,
+	)
+	if err != nil {
+		log.Error().Msgf("TouchAccessToken error %s", err)
+		return err
+	}
+
+	log.Debug().Msg("Access token updated")
+	return nil
+}
+
+// RevokeAccessToken revokes personal access token of the user in storage.
+func (d *DBStorage) RevokeAccessToken(ctx context.Context, userID string, tokenID string) error {
+	tag, err := d.db.Exec(ctx,
+		`UPDATE access_tokens SET revoked = true WHERE id = $1 AND user_id = $2 AND revoked = false`,
+		tokenID,
+		userID,
+	)
+	if err != nil {
+		log.Error().Msgf("RevokeAccessToken error %s", err)
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return storage.ErrorAccessTokenNotFound
+	}
+
+	log.Info().Msg("Access token revoked")
+	return nil
+}
+
+// SaveDevice adds device of the user to storage.
+func (d *DBStorage) SaveDevice(ctx context.Context, device models.Device) error {
+	_, err := d.db.Exec(ctx,
+		`INSERT INTO devices (id, user_id, name, fingerprint, expires_at) VALUES ($1, $2, $3, $4, $5)`,
+		device.ID,
+		device.UserID,
+		device.Name,
+		device.Fingerprint,
+		device.ExpiresAt,
+	)
+	if err != nil {
+		log.Error().Msgf("SaveDevice error %s", err)
+		return err
+	}
+
+	log.Info().Msg("Device saved")
+	return nil
+}
+
+// GetDeviceByFingerprint gets device by fingerprint of the client certificate.
+func (d *DBStorage) GetDeviceByFingerprint(ctx context.Context, fingerprint string) (models.Device, error) {
+	var devices []models.Device
+	err := pgxscan.Select(ctx, d.db, &devices,
+		"SELECT id, user_id, name, fingerprint, expires_at, revoked FROM devices WHERE fingerprint=$1",
+		fingerprint)
+	if err != nil {
+		log.Error().Msgf("GetDeviceByFingerprint error %s", err)
+		return models.Device{}, err
+	}
+
+	if len(devices) == 0 {
+		log.Error().Msg("Device doesn't exist")
+		return models.Device{}, storage.ErrorDeviceNotFound
+	}
+
+	log.Debug().Msg("Device loaded")
+	return devices[0], nil
+}
+
+// RevokeDevice revokes client certificate of the user device in storage.
+func (d *DBStorage) RevokeDevice(ctx context.Context, userID string, deviceID string) error {
+	tag, err := d.db.Exec(ctx,
+		`UPDATE devices SET revoked = true WHERE id = $1 AND user_id = $2`,
+		deviceID,
+		userID,
+	)
+	if err != nil {
+		log.Error().Msgf("RevokeDevice error %s", err)
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return storage.ErrorDeviceNotFound
+	}
+
+	log.Info().Msg("Device revoked")
+	return nil
+}
+
+// SaveVault adds parameters of client side encryption for the user. Existing vault is not overwritten.
+func (d *DBStorage) SaveVault(ctx context.Context, vault models.Vault) error {
+	tag, err := d.db.Exec(ctx,
+		`INSERT INTO vaults (user_id, salt, kdf_time, kdf_memory, kdf_threads, wrapped_key)
+			 VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT DO NOTHING`,
+		vault.UserID,
+		vault.Salt,
+		vault.KdfTime,
+		vault.KdfMemory,
+		vault.KdfThreads,
+		vault.WrappedKey,
+	)
+	if err != nil {
+		log.Error().Msgf("SaveVault error %s", err)
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		log.Error().Msg("Vault already exist")
+		return storage.ErrorVaultAlreadyExist
+	}
+
+	log.Debug().Msg("Vault saved")
+	return nil
+}
+
+// GetVault gets parameters of client side encryption for the user.
+func (d *DBStorage) GetVault(ctx context.Context, userID string) (models.Vault, error) {
+	var vaults []models.Vault
+	err := pgxscan.Select(ctx, d.db, &vaults,
+		"SELECT user_id, salt, kdf_time, kdf_memory, kdf_threads, wrapped_key FROM vaults WHERE user_id=$1",
+		userID)
+	if err != nil {
+		log.Error().Msgf("GetVault error %s", err)
+		return models.Vault{}, err
+	}
+
+	if len(vaults) == 0 {
+		log.Debug().Msg("Vault doesn't exist")
+		return models.Vault{}, storage.ErrorVaultNotFound
+	}
+
+	log.Debug().Msg("Vault loaded")
+	return vaults[0], nil
+}
+
+// AddData adds private data to storage. Existing data is not overwritten (UpdateData is used to change it),
+// data in trash is not replaced (RestoreData is used to get it back).
+func (d *DBStorage) AddData(ctx context.Context, data models.Data) error {
+	log.Debug().Msgf("AddData (postgres): %v", data)
+	err := pgx.BeginFunc(ctx, d.db, func(tx pgx.Tx) error {
+		changeSeq, err := nextChangeSeq(ctx, tx, data.UserID)
+		if err != nil {
+			return err
+		}
+
+		tag, err := tx.Exec(ctx,
+			`INSERT INTO data (id, user_id, data_type, data_binary, metadata_binary, client_encrypted, change_seq) 
+				 VALUES ($1, $2, $3, $4, $5, $6, $7) ON CONFLICT(id) DO NOTHING`,
+			data.ID,
+			data.UserID,
+			data.DataType,
+			data.DataBinary,
+			data.MetadataBinary,
+			data.ClientEncrypted,
+			changeSeq,
+		)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return storage.ErrorPrivateDataAlreadyExist
+		}
+
+		// data is restored with the same ID
+		_, err = tx.Exec(ctx, `DELETE FROM data_tombstones WHERE user_id = $1 AND data_id = $2`, data.UserID, data.ID)
+		if err != nil {
+			return err
+		}
+
+		return notifyDataChange(ctx, tx, models.ChangeEvent{
+			UserID:   data.UserID,
+			DataID:   data.ID,
+			DataType: data.DataType,
+			Cursor:   changeSeq,
+		})
+	})
+
+	if err != nil {
+		log.Error().Msgf("AddData error %s", err)
+		return err
+	}
+
+	log.Debug().Msg("DataBinary added")
+	return nil
+}
+
+// GetDataByUserID gets all related user data from storage. Data in trash is not returned.
+func (d *DBStorage) GetDataByUserID(ctx context.Context, userID string) ([]models.Data, error) {
+	var data []models.Data
+	err := pgxscan.Select(ctx, d.db, &data,
+		`SELECT id, user_id, data_type, data_binary, metadata_binary, client_encrypted, revision, updated_at
+			 FROM data WHERE user_id=$1 AND deleted_at IS NULL`,
+		userID)
+	if err != nil {
+		log.Error().Msgf("GetDataByUserID error %s", err)
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		log.Error().Msg("Data doesn't exist")
+		return nil, storage.ErrorPrivateDataNotFound
+	}
+
+	log.Debug().Msg("Data loaded")
+	return data, nil
+}
+
+// GetDataSample gets the latest private data records of all users from storage.
+func (d *DBStorage) GetDataSample(ctx context.Context, limit int) ([]models.Data, error) {
+	var data []models.Data
+	err := pgxscan.Select(ctx, d.db, &data,
+		"SELECT id, user_id, data_type, data_binary, client_encrypted FROM data ORDER BY created_at DESC LIMIT $1",
+		limit)
+	if err != nil {
+		log.Error().Msgf("GetDataSample error %s", err)
+		return nil, err
+	}
+
+	log.Debug().Msgf("Data sample loaded: %d", len(data))
+	return data, nil
+}
+
+// CountData gets total number of private data records from storage.
+func (d *DBStorage) CountData(ctx context.Context) (int64, error) {
+	var count int64
+	err := d.db.QueryRow(ctx, "SELECT count(*) FROM data").Scan(&count)
+	if err != nil {
+		log.Error().Msgf("CountData error %s", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// GetDataBatch gets private data records of all users following the specified ID (keyset pagination).
+func (d *DBStorage) GetDataBatch(ctx context.Context, afterID string, limit int) ([]models.Data, error) {
+	var data []models.Data
+	err := pgxscan.Select(ctx, d.db, &data,
+		"SELECT id, user_id, data_type, data_binary, client_encrypted FROM data WHERE id > $1 ORDER BY id LIMIT $2",
+		afterID, limit)
+	if err != nil {
+		log.Error().Msgf("GetDataBatch error %s", err)
+		return nil, err
+	}
+
+	log.Debug().Msgf("Data batch loaded: %d", len(data))
+	return data, nil
+}
+
+// GetDataByID gets private data of the user from storage. Data of another user is not found.
+func (d *DBStorage) GetDataByID(ctx context.Context, userID string, dataID string) (models.Data, error) {
+	var data []models.Data
+	err := pgxscan.Select(ctx, d.db, &data,
+		`SELECT id, user_id, data_type, data_binary, metadata_binary, client_encrypted, revision, updated_at
+			 FROM data WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL`,
+		dataID, userID)
+	if err != nil {
+		log.Error().Msgf("GetDataByID error %s", err)
+		return models.Data{}, err
+	}
+
+	if len(data) == 0 {
+		log.Debug().Msg("Data doesn't exist")
+		return models.Data{}, storage.ErrorPrivateDataNotFound
+	}
+
+	log.Debug().Msg("Data loaded")
+	return data[0], nil
+}
+
+// UpdateDataBinary replaces encrypted binary of private data of the user in storage.
+// Data is not updated (ErrorPrivateDataNotFound) if it was changed or deleted after previous binary was read.
+func (d *DBStorage) UpdateDataBinary(ctx context.Context, data models.Data, previous []byte) error {
+	tag, err := d.db.Exec(ctx,
+		`UPDATE data SET data_binary = $3 WHERE id = $1 AND user_id = $2 AND data_binary = $4`,
+		data.ID,
+		data.UserID,
+		data.DataBinary,
+		previous,
+	)
+	if err != nil {
+		log.Error().Msgf("UpdateDataBinary error %s", err)
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return storage.ErrorPrivateDataNotFound
+	}
+
+	log.Debug().Msg("DataBinary updated")
+	return nil
+}
+
+// UpdateData replaces private data of the user in storage and returns new revision. Data is not updated
+// (ErrorRevisionMismatch) if it was changed after the expected revision. Type of the data cannot be changed.
+// Replaced revision is kept in history with the latest revisions of the data (keep). History is removed when
+// legacy data is replaced with client encrypted data, so data readable by the server is not kept.
+func (d *DBStorage) UpdateData(ctx context.Context, data models.Data, revision int64, keep int) (int64, error) {
+	var updated int64
+	err := pgx.BeginFunc(ctx, d.db, func(tx pgx.Tx) error {
+		// user is locked first like in other changes of private data
+		changeSeq, err := nextChangeSeq(ctx, tx, data.UserID)
+		if errors.Is(err, storage.ErrorUserNotFound) {
+			return storage.ErrorPrivateDataNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		var current []models.Data
+		err = pgxscan.Select(ctx, tx, &current,
+			`SELECT data_type, client_encrypted, revision FROM data
+				 WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL FOR UPDATE`,
+			data.ID, data.UserID)
+		if err != nil {
+			return err
+		}
+		if len(current) == 0 {
+			return storage.ErrorPrivateDataNotFound
+		}
+		if current[0].DataType != data.DataType {
+			return storage.ErrorInvalidDataType
+		}
+		if current[0].Revision != revision {
+			return storage.ErrorRevisionMismatch
+		}
+		if !current[0].ClientEncrypted && data.ClientEncrypted {
+			// data is migrated to client side encryption
+			keep = 0
+		}
+		if err := saveDataHistory(ctx, tx, data.ID, keep); err != nil {
+			return err
+		}
+
+		err = tx.QueryRow(ctx,
+			`UPDATE data SET data_binary = $2, metadata_binary = $3, client_encrypted = $4, revision = revision + 1,
+				 updated_at = now(), change_seq = $5 WHERE id = $1 RETURNING revision`,
+			data.ID,
+			data.DataBinary,
+			data.MetadataBinary,
+			data.ClientEncrypted,
+			changeSeq,
+		).Scan(&updated)
+		if err != nil {
+			return err
+		}
+
+		return notifyDataChange(ctx, tx, models.ChangeEvent{
+			UserID:   data.UserID,
+			DataID:   data.ID,
+			DataType: data.DataType,
+			Cursor:   changeSeq,
+		})
+	})
+	if err != nil {
+		log.Error().Msgf("UpdateData error %s", err)
+		return 0, err
+	}
+
+	log.Debug().Msgf("Data updated to revision %d", updated)
+	return updated, nil
+}
+
+// DeleteDataByDataID moves private data of the user to trash. Data of another user is not found.
+// Data is not deleted (ErrorRevisionMismatch) if it was changed after the expected revision, zero revision
+// deletes data of any revision. Tombstone of the data is saved, so other clients of the user remove it on sync.
+func (d *DBStorage) DeleteDataByDataID(ctx context.Context, userID string, dataID string, revision int64) error {
+	err := pgx.BeginFunc(ctx, d.db, func(tx pgx.Tx) error {
+		changeSeq, err := nextChangeSeq(ctx, tx, userID)
+		if errors.Is(err, storage.ErrorUserNotFound) {
+			return storage.ErrorPrivateDataNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		var current []models.Data
+		err = pgxscan.Select(ctx, tx, &current,
+			`SELECT data_type, client_encrypted, revision FROM data
+				 WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL FOR UPDATE`,
+			dataID, userID)
+		if err != nil {
+			return err
+		}
+		if len(current) == 0 {
+			return storage.ErrorPrivateDataNotFound
+		}
+		if revision != 0 && current[0].Revision != revision {
+			return storage.ErrorRevisionMismatch
+		}
+		dataType := current[0].DataType
+
+		_, err = tx.Exec(ctx, `UPDATE data SET deleted_at = now(), change_seq = $2 WHERE id = $1`, dataID, changeSeq)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx,
+			`INSERT INTO data_tombstones (user_id, data_id, data_type, change_seq) VALUES ($1, $2, $3, $4)
+				 ON CONFLICT (user_id, data_id) DO UPDATE SET data_type = EXCLUDED.data_type,
+				 change_seq = EXCLUDED.change_seq, deleted_at = now()`,
+			userID, dataID, dataType, changeSeq)
+		if err != nil {
+			return err
+		}
+
+		return notifyDataChange(ctx, tx, models.ChangeEvent{
+			UserID:   userID,
+			DataID:   dataID,
+			DataType: dataType,
+			Cursor:   changeSeq,
+			Deleted:  true,
+		})
+	})
+
+	if err != nil {
+		log.Error().Msgf("DeleteDataByDataID error %s", err)
+		return err
+	}
+
+	log.Info().Msg("DataBinary moved to trash")
+	return nil
+}
+
+// GetTrashByUserID gets private data of the user in trash, the latest deleted data first.
+func (d *DBStorage) GetTrashByUserID(ctx context.Context, userID string) ([]models.Data, error) {
+	var data []models.Data
+	err := pgxscan.Select(ctx, d.db, &data,
+		`SELECT id, user_id, data_type, data_binary, metadata_binary, client_encrypted, revision, updated_at,
+			 deleted_at FROM data WHERE user_id = $1 AND deleted_at IS NOT NULL ORDER BY deleted_at DESC`,
+		userID)
+	if err != nil {
+		log.Error().Msgf("GetTrashByUserID error %s", err)
+		return nil, err
+	}
+
+	log.Debug().Msgf("Trash loaded: %d records", len(data))
+	return data, nil
+}
+
+// RestoreData moves private data of the user from trash back and returns restored data. Tombstone of the data
+// is removed, so other clients of the user load it on sync. Data which is not in trash is not found.
+func (d *DBStorage) RestoreData(ctx context.Context, userID string, dataID string) (models.Data, error) {
+	var restored []models.Data
+	err := pgx.BeginFunc(ctx, d.db, func(tx pgx.Tx) error {
+		changeSeq, err := nextChangeSeq(ctx, tx, userID)
+		if errors.Is(err, storage.ErrorUserNotFound) {
+			return storage.ErrorPrivateDataNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		err = pgxscan.Select(ctx, tx, &restored,
+			`UPDATE data SET deleted_at = NULL, change_seq = $3
+				 WHERE id = $1 AND user_id = $2 AND deleted_at IS NOT NULL
+				 RETURNING id, user_id, data_type, data_binary, metadata_binary, client_encrypted, revision, updated_at`,
+			dataID, userID, changeSeq)
+		if err != nil {
+			return err
+		}
+		if len(restored) == 0 {
+			return storage.ErrorPrivateDataNotFound
+		}
+
+		_, err = tx.Exec(ctx, `DELETE FROM data_tombstones WHERE user_id = $1 AND data_id = $2`, userID, dataID)
+		if err != nil {
+			return err
+		}
+
+		return notifyDataChange(ctx, tx, models.ChangeEvent{
+			UserID:   userID,
+			DataID:   dataID,
+			DataType: restored[0].DataType,
+			Cursor:   changeSeq,
+		})
+	})
+	if err != nil {
+		log.Error().Msgf("RestoreData error %s", err)
+		return models.Data{}, err
+	}
+
+	log.Debug().Msg("Data restored from trash")
+	return restored[0], nil
+}
+
+// PurgeData permanently deletes private data of the user from trash with history and tombstone of the data.
+// Data which is not in trash is not found.
+func (d *DBStorage) PurgeData(ctx context.Context, userID string, dataID string) error {
+	err := pgx.BeginFunc(ctx, d.db, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx,
+			`DELETE FROM data WHERE id = $1 AND user_id = $2 AND deleted_at IS NOT NULL`,
+			dataID, userID)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return storage.ErrorPrivateDataNotFound
+		}
+
+		// clients with cursor before the removed tombstone load all data on the next sync
+		_, err = tx.Exec(ctx,
+			`WITH tombstone AS (DELETE FROM data_tombstones WHERE user_id = $1 AND data_id = $2 RETURNING change_seq)
+			 UPDATE users SET tombstones_purged_seq = GREATEST(tombstones_purged_seq, tombstone.change_seq)
+			 FROM tombstone WHERE users.id = $1`,
+			userID, dataID)
+		return err
+	})
+	if err != nil {
+		log.Error().Msgf("PurgeData error %s", err)
+		return err
+	}
+
+	log.Debug().Msg("Data purged from trash")
+	return nil
+}
+
+// PurgeTrash permanently deletes private data of all users moved to trash before the specified time
+// with history and tombstones of the data. Returns number of deleted records.
+func (d *DBStorage) PurgeTrash(ctx context.Context, before time.Time) (int64, error) {
+	var purged int64
+	// clients with cursor before the removed tombstones load all data on the next sync
+	err := d.db.QueryRow(ctx,
+		`WITH purged AS (DELETE FROM data WHERE deleted_at < $1 RETURNING id, user_id),
+		 tombstones AS (DELETE FROM data_tombstones USING purged
+			 WHERE data_tombstones.user_id = purged.user_id AND data_tombstones.data_id = purged.id
+			 RETURNING data_tombstones.user_id, data_tombstones.change_seq),
+		 watermarks AS (UPDATE users SET tombstones_purged_seq = GREATEST(tombstones_purged_seq, removed.seq)
+			 FROM (SELECT user_id, max(change_seq) AS seq FROM tombstones GROUP BY user_id) AS removed
+			 WHERE users.id = removed.user_id)
+		 SELECT count(*) FROM purged`,
+		before).Scan(&purged)
+	if err != nil {
+		log.Error().Msgf("PurgeTrash error %s", err)
+		return 0, err
+	}
+
+	log.Debug().Msgf("Trash purged: %d records", purged)
+	return purged, nil
+}
+
+// GetDataChanges gets private data and tombstones of the user changed after the cursor. Changes and cursor
+// are read from the same snapshot, so changes committed later are returned on the next sync. Tombstones
+// are removed when data is purged from trash, all data is returned for the cursor before the removed tombstones.
+func (d *DBStorage) GetDataChanges(ctx context.Context, userID string, cursor int64) (models.DataChanges, error) {
+	var changes models.DataChanges
+	err := pgx.BeginTxFunc(ctx, d.db, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly},
+		func(tx pgx.Tx) error {
+			var purgedSeq int64
+			err := tx.QueryRow(ctx, `SELECT data_version, tombstones_purged_seq FROM users WHERE id = $1`,
+				userID).Scan(&changes.Cursor, &purgedSeq)
+			if errors.Is(err, pgx.ErrNoRows) {
+				return storage.ErrorUserNotFound
+			}
+			if err != nil {
+				return err
+			}
+			if cursor < purgedSeq {
+				// deletions after the cursor cannot be sent anymore
+				cursor = 0
+				changes.Full = true
+			}
+
+			err = pgxscan.Select(ctx, tx, &changes.Data,
+				`SELECT id, user_id, data_type, data_binary, metadata_binary, client_encrypted, revision, updated_at
+					 FROM data WHERE user_id = $1 AND change_seq > $2 AND deleted_at IS NULL ORDER BY change_seq`,
+				userID, cursor)
+			if err != nil || changes.Full {
+				return err
+			}
+
+			return pgxscan.Select(ctx, tx, &changes.Tombstones,
+				`SELECT data_id, user_id, data_type, deleted_at
+					 FROM data_tombstones WHERE user_id = $1 AND change_seq > $2 ORDER BY change_seq`,
+				userID, cursor)
+		})
+	if err != nil {
+		log.Error().Msgf("GetDataChanges error %s", err)
+		return models.DataChanges{}, err
+	}
+
+	log.Debug().Msgf("Data changes loaded: %d records, %d tombstones", len(changes.Data), len(changes.Tombstones))
+	return changes, nil
+}
+
+// GetDataCursor gets current cursor (last change) of private data of the user without loading the changes.
+func (d *DBStorage) GetDataCursor(ctx context.Context, userID string) (int64, error) {
+	var cursor int64
+	err := d.db.QueryRow(ctx, `SELECT data_version FROM users WHERE id = $1`, userID).Scan(&cursor)
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = storage.ErrorUserNotFound
+	}
+	if err != nil {
+		log.Error().Msgf("GetDataCursor error %s", err)
+		return 0, err
+	}
+	return cursor, nil
+}
+
+// GetDataRevisions gets previous revisions of private data of the user from history, the latest revision first.
+// Data of another user is not found.
+func (d *DBStorage) GetDataRevisions(ctx context.Context, userID string, dataID string) ([]models.Data, error) {
+	var revisions []models.Data
+	err := pgx.BeginTxFunc(ctx, d.db, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly},
+		func(tx pgx.Tx) error {
+			var exists bool
+			err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM data WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL)`,
+				dataID, userID).Scan(&exists)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return storage.ErrorPrivateDataNotFound
+			}
+
+			return pgxscan.Select(ctx, tx, &revisions,
+				`SELECT data_id AS id, user_id, data_type, data_binary, metadata_binary, client_encrypted, revision,
+					 updated_at FROM data_history WHERE data_id = $1 AND user_id = $2 ORDER BY revision DESC`,
+				dataID, userID)
+		})
+	if err != nil {
+		log.Error().Msgf("GetDataRevisions error %s", err)
+		return nil, err
+	}
+
+	log.Debug().Msgf("Data revisions loaded: %d", len(revisions))
+	return revisions, nil
+}
+
+// RestoreDataRevision replaces private data of the user with the revision from history and returns restored data
+// with new revision. Replaced revision is kept in history with the latest revisions of the data (keep).
+func (d *DBStorage) RestoreDataRevision(ctx context.Context, userID string, dataID string, revision int64, keep int) (models.Data, error) {
+	var restored []models.Data
+	err := pgx.BeginFunc(ctx, d.db, func(tx pgx.Tx) error {
+		changeSeq, err := nextChangeSeq(ctx, tx, userID)
+		if errors.Is(err, storage.ErrorUserNotFound) {
+			return storage.ErrorPrivateDataNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		var current []models.Data
+		err = pgxscan.Select(ctx, tx, &current,
+			`SELECT revision FROM data WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL FOR UPDATE`,
+			dataID, userID)
+		if err != nil {
+			return err
+		}
+		if len(current) == 0 {
+			return storage.ErrorPrivateDataNotFound
+		}
+
+		var previous []models.Data
+		err = pgxscan.Select(ctx, tx, &previous,
+			`SELECT data_type, data_binary, metadata_binary, client_encrypted FROM data_history
+				 WHERE data_id = $1 AND user_id = $2 AND revision = $3`,
+			dataID, userID, revision)
+		if err != nil {
+			return err
+		}
+		if len(previous) == 0 {
+			return storage.ErrorRevisionNotFound
+		}
+		if err := saveDataHistory(ctx, tx, dataID, keep); err != nil {
+			return err
+		}
+
+		err = pgxscan.Select(ctx, tx, &restored,
+			`UPDATE data SET data_binary = $2, metadata_binary = $3, client_encrypted = $4, revision = revision + 1,
+				 updated_at = now(), change_seq = $5 WHERE id = $1
+				 RETURNING id, user_id, data_type, data_binary, metadata_binary, client_encrypted, revision, updated_at`,
+			dataID,
+			previous[0].DataBinary,
+			previous[0].MetadataBinary,
+			previous[0].ClientEncrypted,
+			changeSeq,
+		)
+		if err != nil {
+			return err
+		}
+
+		return notifyDataChange(ctx, tx, models.ChangeEvent{
+			UserID:   userID,
+			DataID:   dataID,
+			DataType: restored[0].DataType,
+			Cursor:   changeSeq,
+		})
+	})
+	if err != nil {
+		log.Error().Msgf("RestoreDataRevision error %s", err)
+		return models.Data{}, err
+	}
+
+	log.Debug().Msgf("Data revision %d restored as revision %d", revision, restored[0].Revision)
+	return restored[0], nil
+}
+
+// GetDataHistoryBatch gets revisions from history of all users following the specified data ID and revision
+// (keyset pagination).
+func (d *DBStorage) GetDataHistoryBatch(ctx context.Context, afterID string, afterRevision int64, limit int) ([]models.Data, error) {
+	var revisions []models.Data
+	err := pgxscan.Select(ctx, d.db, &revisions,
+		`SELECT data_id AS id, user_id, data_type, data_binary, client_encrypted, revision FROM data_history
+			 WHERE (data_id, revision) > ($1, $2) ORDER BY data_id, revision LIMIT $3`,
+		afterID, afterRevision, limit)
+	if err != nil {
+		log.Error().Msgf("GetDataHistoryBatch error %s", err)
+		return nil, err
+	}
+
+	log.Debug().Msgf("Data history batch loaded: %d", len(revisions))
+	return revisions, nil
+}
+
+// UpdateDataHistoryBinary replaces encrypted binary of the revision in history. Revision is not updated
+// (ErrorRevisionNotFound) if it was removed from history or changed after previous binary was read.
+func (d *DBStorage) UpdateDataHistoryBinary(ctx context.Context, data models.Data, previous []byte) error {
+	tag, err := d.db.Exec(ctx,
+		`UPDATE data_history SET data_binary = $4
+			 WHERE data_id = $1 AND user_id = $2 AND revision = $3 AND data_binary = $5`,
+		data.ID,
+		data.UserID,
+		data.Revision,
+		data.DataBinary,
+		previous,
+	)
+	if err != nil {
+		log.Error().Msgf("UpdateDataHistoryBinary error %s", err)
+		return err
+	}
+
+	if tag.RowsAffected() == 0 {
+		return storage.ErrorRevisionNotFound
+	}
+
+	log.Debug().Msg("Data history binary updated")
+	return nil
+}
+
+// saveDataHistory keeps current revision of private data in history. Only the latest revisions (keep)
+// are left in history, history of the data is removed with zero keep.
+func saveDataHistory(ctx context.Context, tx pgx.Tx, dataID string, keep int) error {
+	if keep > 0 {
+		_, err := tx.Exec(ctx,
+			`INSERT INTO data_history
+				 (data_id, user_id, revision, data_type, data_binary, metadata_binary, client_encrypted, updated_at)
+				 SELECT id, user_id, revision, data_type, data_binary, metadata_binary, client_encrypted, updated_at
+				 FROM data WHERE id = $1`,
+			dataID)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := tx.Exec(ctx,
+		`DELETE FROM data_history WHERE data_id = $1 AND revision NOT IN
+			 (SELECT revision FROM data_history WHERE data_id = $1 ORDER BY revision DESC LIMIT $2)`,
+		dataID, keep)
+	return err
+}
+
+// nextChangeSeq increments sequence number of private data changes of the user. Row of the user is locked
+// until the end of transaction, so changes of the user are numbered in order of commit.
+func nextChangeSeq(ctx context.Context, tx pgx.Tx, userID string) (int64, error) {
+	var changeSeq int64
+	err := tx.QueryRow(ctx, `UPDATE users SET data_version = data_version + 1 WHERE id = $1 RETURNING data_version`,
+		userID).Scan(&changeSeq)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, storage.ErrorUserNotFound
+	}
+	return changeSeq, err
+}
+
+// notifyDataChange sends notification about changed private data to listeners of all server instances.
+// Notification is delivered on commit of the transaction only.
+func notifyDataChange(ctx context.Context, tx pgx.Tx, event models.ChangeEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `SELECT pg_notify($1, $2)`, dataChangesChannel, string(payload))
+	return err
+}
+
+// ListenDataChanges listens for notifications about changed private data with dedicated connection
+// and sends them to the channel until the context is done. Empty event is sent when listening starts.
+func (d *DBStorage) ListenDataChanges(ctx context.Context, events chan<- models.ChangeEvent) error {
+	pooled, err := d.db.Acquire(ctx)
+	if err != nil {
+		log.Error().Msgf("ListenDataChanges error %s", err)
+		return err
+	}
+	// connection in listen state is not returned to the pool
+	conn := pooled.Hijack()
+	defer func() {
+		if err := conn.Close(context.Background()); err != nil {
+			log.Error().Msgf("ListenDataChanges close error %s", err)
+		}
+	}()
+
+	_, err = conn.Exec(ctx, `LISTEN `+dataChangesChannel)
+	if err != nil {
+		log.Error().Msgf("ListenDataChanges error %s", err)
+		return err
+	}
+	log.Info().Msg("Listening for data changes")
+
+	// notifications sent before the listener started are lost, empty event marks that changes could be missed
+	select {
+	case events <- models.ChangeEvent{}:
+	case <-ctx.Done():
+		return nil
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			log.Error().Msgf("ListenDataChanges error %s", err)
+			return err
+		}
+
+		var event models.ChangeEvent
+		if err := json.Unmarshal([]byte(notification.Payload), &event); err != nil {
+			log.Error().Msgf("ListenDataChanges invalid payload %s", err)
+			continue
+		}
+
+		select {
+		case events <- event:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
 // ReleaseStorage closes database connection.
 func (d *DBStorage) ReleaseStorage() {
 	d.db.Close()
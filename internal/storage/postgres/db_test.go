@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/rs/zerolog"
@@ -171,6 +172,7 @@ func (sts *StorageTestSuite) TestDBStorage_GetUserByLogin() {
 }
 
 func (sts *StorageTestSuite) TestDBStorage_AddData() {
+	id := uuid.NewString()
 	tests := []struct {
 		name    string
 		id      string
@@ -179,10 +181,16 @@ func (sts *StorageTestSuite) TestDBStorage_AddData() {
 	}{
 		{
 			name:    "positive test",
-			id:      uuid.NewString(),
+			id:      id,
 			data:    models.NewText("description", "some text here"),
 			wantErr: false,
 		},
+		{
+			name:    "negative test (duplicate ID)",
+			id:      id,
+			data:    models.NewText("another description", "another text"),
+			wantErr: true,
+		},
 	}
 
 	user := models.User{
@@ -215,15 +223,19 @@ func (sts *StorageTestSuite) TestDBStorage_AddData() {
 				sts.T().Errorf("AddData() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if tt.wantErr {
+				assert.ErrorIs(sts.T(), err, storage.ErrorPrivateDataAlreadyExist)
+			}
 		})
 	}
 }
 
 func (sts *StorageTestSuite) TestDBStorage_GetDataByUserID() {
 	tests := []struct {
-		name    string
-		data    *models.Text
-		wantErr bool
+		name     string
+		data     *models.Text
+		revision int64
+		wantErr  bool
 	}{
 		{
 			name:    "positive test",
@@ -231,14 +243,16 @@ func (sts *StorageTestSuite) TestDBStorage_GetDataByUserID() {
 			wantErr: false,
 		},
 		{
-			name:    "positive test (update)",
-			data:    models.NewText("description updated", "text updated"),
-			wantErr: false,
+			name:     "positive test (update)",
+			data:     models.NewText("description updated", "text updated"),
+			revision: 1,
+			wantErr:  false,
 		},
 		{
-			name:    "negative test",
-			data:    models.NewText("test", "test"),
-			wantErr: true,
+			name:     "negative test",
+			data:     models.NewText("test", "test"),
+			revision: 2,
+			wantErr:  true,
 		},
 	}
 
@@ -262,13 +276,17 @@ func (sts *StorageTestSuite) TestDBStorage_GetDataByUserID() {
 			binary, err := json.Marshal(tt.data)
 			assert.NoError(sts.T(), err)
 
-			err = s.AddData(context.Background(),
-				models.Data{
-					ID:         id,
-					UserID:     user.ID,
-					DataType:   tt.data.GetType(),
-					DataBinary: binary,
-				})
+			record := models.Data{
+				ID:         id,
+				UserID:     user.ID,
+				DataType:   tt.data.GetType(),
+				DataBinary: binary,
+			}
+			if tt.revision == 0 {
+				err = s.AddData(context.Background(), record)
+			} else {
+				_, err = s.UpdateData(context.Background(), record, tt.revision, 10)
+			}
 			if err != nil {
 				sts.T().Errorf("AddData() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -299,105 +317,1744 @@ func (sts *StorageTestSuite) TestDBStorage_GetDataByUserID() {
 			assert.Equal(sts.T(), data[0].UserID, user.ID)
 			assert.Equal(sts.T(), data[0].DataType, tt.data.GetType())
 			assert.Equal(sts.T(), data[0].DataBinary, binary)
+			assert.Equal(sts.T(), tt.revision+1, data[0].Revision)
+			assert.False(sts.T(), data[0].UpdatedAt.IsZero())
 		})
 	}
 }
 
-func (sts *StorageTestSuite) TestDBStorage_DeleteDataByDataID() {
+func (sts *StorageTestSuite) TestDBStorage_UpdateData() {
+	user := models.User{
+		ID:       uuid.NewString(),
+		Login:    "login",
+		Password: "password",
+	}
+	err := sts.TestStorage.RegisterUser(context.Background(), user)
+	assert.NoError(sts.T(), err)
+
+	data := models.Data{
+		ID:         uuid.NewString(),
+		UserID:     user.ID,
+		DataType:   models.TextType,
+		DataBinary: []byte("binary"),
+	}
+	err = sts.TestStorage.AddData(context.Background(), data)
+	assert.NoError(sts.T(), err)
+
 	tests := []struct {
-		name    string
-		id      string
-		data    *models.Text
-		wantErr bool
+		name         string
+		data         models.Data
+		revision     int64
+		wantRevision int64
+		wantErr      error
 	}{
 		{
-			name:    "positive test",
-			id:      uuid.NewString(),
-			data:    models.NewText("description", "some text here"),
-			wantErr: false,
+			name:         "positive test",
+			data:         models.Data{ID: data.ID, UserID: user.ID, DataType: models.TextType, DataBinary: []byte("updated")},
+			revision:     1,
+			wantRevision: 2,
+		},
+		{
+			name:     "negative test (revision mismatch)",
+			data:     models.Data{ID: data.ID, UserID: user.ID, DataType: models.TextType, DataBinary: []byte("stale")},
+			revision: 1,
+			wantErr:  storage.ErrorRevisionMismatch,
+		},
+		{
+			name:     "negative test (another type)",
+			data:     models.Data{ID: data.ID, UserID: user.ID, DataType: models.CardType, DataBinary: []byte("card")},
+			revision: 2,
+			wantErr:  storage.ErrorInvalidDataType,
+		},
+		{
+			name:     "negative test (another user)",
+			data:     models.Data{ID: data.ID, UserID: uuid.NewString(), DataType: models.TextType, DataBinary: []byte("foreign")},
+			revision: 2,
+			wantErr:  storage.ErrorPrivateDataNotFound,
+		},
+		{
+			name:     "negative test (unknown data)",
+			data:     models.Data{ID: uuid.NewString(), UserID: user.ID, DataType: models.TextType, DataBinary: []byte("unknown")},
+			revision: 1,
+			wantErr:  storage.ErrorPrivateDataNotFound,
 		},
 	}
+	for _, tt := range tests {
+		sts.Run(tt.name, func() {
+			revision, err := sts.TestStorage.UpdateData(context.Background(), tt.data, tt.revision, 10)
+			if tt.wantErr != nil {
+				assert.ErrorIs(sts.T(), err, tt.wantErr)
+				return
+			}
+			assert.NoError(sts.T(), err)
+			assert.Equal(sts.T(), tt.wantRevision, revision)
+		})
+	}
+
+	stored, err := sts.TestStorage.GetDataByUserID(context.Background(), user.ID)
+	assert.NoError(sts.T(), err)
+	assert.Equal(sts.T(), []byte("updated"), stored[0].DataBinary)
+	assert.Equal(sts.T(), int64(2), stored[0].Revision)
+}
 
+func (sts *StorageTestSuite) TestDBStorage_DataRevisions() {
 	user := models.User{
 		ID:       uuid.NewString(),
 		Login:    "login",
 		Password: "password",
 	}
+	anotherUser := models.User{
+		ID:       uuid.NewString(),
+		Login:    "another",
+		Password: "password",
+	}
+	for _, u := range []models.User{user, anotherUser} {
+		err := sts.TestStorage.RegisterUser(context.Background(), u)
+		assert.NoError(sts.T(), err)
+	}
 
-	err := sts.TestStorage.RegisterUser(context.Background(), user)
-	if err != nil {
-		sts.T().Errorf("RegisterUser() error = %v", err)
-		return
+	data := models.Data{ID: uuid.NewString(), UserID: user.ID, DataType: models.TextType, DataBinary: []byte("first")}
+	err := sts.TestStorage.AddData(context.Background(), data)
+	assert.NoError(sts.T(), err)
+	revisions, err := sts.TestStorage.GetDataRevisions(context.Background(), user.ID, data.ID)
+	assert.NoError(sts.T(), err)
+	assert.Empty(sts.T(), revisions)
+
+	// only the latest revisions are kept in history, metadata is kept together with private data
+	for i, binary := range []string{"second", "third", "fourth"} {
+		data.DataBinary = []byte(binary)
+		data.MetadataBinary = []byte(binary + " metadata")
+		_, err = sts.TestStorage.UpdateData(context.Background(), data, int64(i+1), 2)
+		assert.NoError(sts.T(), err)
+	}
+	revisions, err = sts.TestStorage.GetDataRevisions(context.Background(), user.ID, data.ID)
+	assert.NoError(sts.T(), err)
+	if assert.Len(sts.T(), revisions, 2) {
+		assert.Equal(sts.T(), int64(3), revisions[0].Revision)
+		assert.Equal(sts.T(), []byte("third"), revisions[0].DataBinary)
+		assert.Equal(sts.T(), []byte("third metadata"), revisions[0].MetadataBinary)
+		assert.Equal(sts.T(), data.ID, revisions[0].ID)
+		assert.Equal(sts.T(), int64(2), revisions[1].Revision)
 	}
 
+	tests := []struct {
+		name     string
+		userID   string
+		id       string
+		revision int64
+		want     []byte
+		wantErr  error
+	}{
+		{
+			name:     "negative test (data of another user)",
+			userID:   anotherUser.ID,
+			id:       data.ID,
+			revision: 2,
+			wantErr:  storage.ErrorPrivateDataNotFound,
+		},
+		{
+			name:     "negative test (revision is not kept)",
+			userID:   user.ID,
+			id:       data.ID,
+			revision: 1,
+			wantErr:  storage.ErrorRevisionNotFound,
+		},
+		{
+			name:     "positive test",
+			userID:   user.ID,
+			id:       data.ID,
+			revision: 2,
+			want:     []byte("second"),
+		},
+	}
 	for _, tt := range tests {
 		sts.Run(tt.name, func() {
-			s := sts.TestStorage
+			restored, err := sts.TestStorage.RestoreDataRevision(context.Background(), tt.userID, tt.id, tt.revision, 2)
+			if tt.wantErr != nil {
+				assert.ErrorIs(sts.T(), err, tt.wantErr)
+				return
+			}
+			assert.NoError(sts.T(), err)
+			assert.Equal(sts.T(), tt.want, restored.DataBinary)
+			assert.Equal(sts.T(), append(tt.want, []byte(" metadata")...), restored.MetadataBinary)
+			assert.Equal(sts.T(), int64(5), restored.Revision)
+		})
+	}
 
-			binary, err := json.Marshal(tt.data)
+	// replaced revision is kept in history
+	revisions, err = sts.TestStorage.GetDataRevisions(context.Background(), user.ID, data.ID)
+	assert.NoError(sts.T(), err)
+	if assert.Len(sts.T(), revisions, 2) {
+		assert.Equal(sts.T(), int64(4), revisions[0].Revision)
+		assert.Equal(sts.T(), []byte("fourth"), revisions[0].DataBinary)
+	}
+	_, err = sts.TestStorage.GetDataRevisions(context.Background(), anotherUser.ID, data.ID)
+	assert.ErrorIs(sts.T(), err, storage.ErrorPrivateDataNotFound)
+
+	// history is removed when it is disabled
+	_, err = sts.TestStorage.UpdateData(context.Background(), data, 5, 0)
+	assert.NoError(sts.T(), err)
+	revisions, err = sts.TestStorage.GetDataRevisions(context.Background(), user.ID, data.ID)
+	assert.NoError(sts.T(), err)
+	assert.Empty(sts.T(), revisions)
+}
+
+func (sts *StorageTestSuite) TestDBStorage_DataRevisions_ClientEncrypted() {
+	user := models.User{
+		ID:       uuid.NewString(),
+		Login:    "login",
+		Password: "password",
+	}
+	err := sts.TestStorage.RegisterUser(context.Background(), user)
+	assert.NoError(sts.T(), err)
+
+	// legacy data readable by the server is kept in history
+	data := models.Data{ID: uuid.NewString(), UserID: user.ID, DataType: models.TextType, DataBinary: []byte("legacy")}
+	err = sts.TestStorage.AddData(context.Background(), data)
+	assert.NoError(sts.T(), err)
+	data.DataBinary = []byte("changed legacy")
+	_, err = sts.TestStorage.UpdateData(context.Background(), data, 1, 10)
+	assert.NoError(sts.T(), err)
+	revisions, err := sts.TestStorage.GetDataRevisions(context.Background(), user.ID, data.ID)
+	assert.NoError(sts.T(), err)
+	assert.Len(sts.T(), revisions, 1)
+
+	// no legacy revision is left after migration to client side encryption
+	data.DataBinary = []byte("client encrypted")
+	data.ClientEncrypted = true
+	_, err = sts.TestStorage.UpdateData(context.Background(), data, 2, 10)
+	assert.NoError(sts.T(), err)
+	revisions, err = sts.TestStorage.GetDataRevisions(context.Background(), user.ID, data.ID)
+	assert.NoError(sts.T(), err)
+	assert.Empty(sts.T(), revisions)
+	_, err = sts.TestStorage.RestoreDataRevision(context.Background(), user.ID, data.ID, 2, 10)
+	assert.ErrorIs(sts.T(), err, storage.ErrorRevisionNotFound)
+
+	// history of client encrypted data is kept
+	data.DataBinary = []byte("changed client encrypted")
+	_, err = sts.TestStorage.UpdateData(context.Background(), data, 3, 10)
+	assert.NoError(sts.T(), err)
+	revisions, err = sts.TestStorage.GetDataRevisions(context.Background(), user.ID, data.ID)
+	assert.NoError(sts.T(), err)
+	if assert.Len(sts.T(), revisions, 1) {
+		assert.True(sts.T(), revisions[0].ClientEncrypted)
+		assert.Equal(sts.T(), []byte("client encrypted"), revisions[0].DataBinary)
+	}
+}
+
+func (sts *StorageTestSuite) TestDBStorage_GetDataSample() {
+	tests := []struct {
+		name  string
+		limit int
+		want  int
+	}{
+		{
+			name:  "positive test (limited)",
+			limit: 2,
+			want:  2,
+		},
+		{
+			name:  "positive test (all records)",
+			limit: 10,
+			want:  3,
+		},
+	}
+
+	// empty storage returns empty sample
+	data, err := sts.TestStorage.GetDataSample(context.Background(), 10)
+	assert.NoError(sts.T(), err)
+	assert.Empty(sts.T(), data)
+
+	for i := 0; i < 3; i++ {
+		user := models.User{
+			ID:       uuid.NewString(),
+			Login:    uuid.NewString(),
+			Password: "password",
+		}
+		err := sts.TestStorage.RegisterUser(context.Background(), user)
+		assert.NoError(sts.T(), err)
+
+		err = sts.TestStorage.AddData(context.Background(),
+			models.Data{
+				ID:         uuid.NewString(),
+				UserID:     user.ID,
+				DataType:   models.TextType,
+				DataBinary: []byte("binary"),
+			})
+		assert.NoError(sts.T(), err)
+	}
+
+	for _, tt := range tests {
+		sts.Run(tt.name, func() {
+			data, err := sts.TestStorage.GetDataSample(context.Background(), tt.limit)
 			assert.NoError(sts.T(), err)
+			assert.Len(sts.T(), data, tt.want)
+		})
+	}
+}
 
-			err = s.AddData(context.Background(),
-				models.Data{
-					ID:         tt.id,
-					UserID:     user.ID,
-					DataType:   tt.data.GetType(),
-					DataBinary: binary,
-				})
+func (sts *StorageTestSuite) TestDBStorage_GetDataBatch() {
+	user := models.User{
+		ID:       uuid.NewString(),
+		Login:    "login",
+		Password: "password",
+	}
+	err := sts.TestStorage.RegisterUser(context.Background(), user)
+	assert.NoError(sts.T(), err)
+
+	for i := 0; i < 5; i++ {
+		err = sts.TestStorage.AddData(context.Background(),
+			models.Data{
+				ID:         uuid.NewString(),
+				UserID:     user.ID,
+				DataType:   models.TextType,
+				DataBinary: []byte("binary"),
+			})
+		assert.NoError(sts.T(), err)
+	}
+
+	count, err := sts.TestStorage.CountData(context.Background())
+	assert.NoError(sts.T(), err)
+	assert.Equal(sts.T(), int64(5), count)
+
+	// walk all records with keyset pagination
+	var ids []string
+	afterID := "00000000-0000-0000-0000-000000000000"
+	for {
+		batch, err := sts.TestStorage.GetDataBatch(context.Background(), afterID, 2)
+		assert.NoError(sts.T(), err)
+		if len(batch) == 0 {
+			break
+		}
+		assert.LessOrEqual(sts.T(), len(batch), 2)
+		for _, data := range batch {
+			assert.Greater(sts.T(), data.ID, afterID)
+			ids = append(ids, data.ID)
+		}
+		afterID = batch[len(batch)-1].ID
+	}
+	assert.Len(sts.T(), ids, 5)
+}
+
+func (sts *StorageTestSuite) TestDBStorage_UpdateDataBinary() {
+	user := models.User{
+		ID:       uuid.NewString(),
+		Login:    "login",
+		Password: "password",
+	}
+	err := sts.TestStorage.RegisterUser(context.Background(), user)
+	assert.NoError(sts.T(), err)
+
+	data := models.Data{
+		ID:         uuid.NewString(),
+		UserID:     user.ID,
+		DataType:   models.TextType,
+		DataBinary: []byte("binary"),
+	}
+	err = sts.TestStorage.AddData(context.Background(), data)
+	assert.NoError(sts.T(), err)
+
+	tests := []struct {
+		name     string
+		userID   string
+		binary   []byte
+		previous []byte
+		wantErr  bool
+	}{
+		{
+			name:     "positive test",
+			binary:   []byte("rotated binary"),
+			previous: []byte("binary"),
+			wantErr:  false,
+		},
+		{
+			name:     "negative test (changed concurrently)",
+			binary:   []byte("another binary"),
+			previous: []byte("binary"),
+			wantErr:  true,
+		},
+		{
+			name:     "negative test (another user)",
+			userID:   uuid.NewString(),
+			binary:   []byte("another binary"),
+			previous: []byte("rotated binary"),
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		sts.Run(tt.name, func() {
+			updated := data
+			updated.DataBinary = tt.binary
+			if tt.userID != "" {
+				updated.UserID = tt.userID
+			}
+			err := sts.TestStorage.UpdateDataBinary(context.Background(), updated, tt.previous)
 			if (err != nil) != tt.wantErr {
-				sts.T().Errorf("AddData() error = %v, wantErr %v", err, tt.wantErr)
+				sts.T().Errorf("UpdateDataBinary() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if tt.wantErr {
+				assert.ErrorIs(sts.T(), err, storage.ErrorPrivateDataNotFound)
+			}
+		})
+	}
+
+	stored, err := sts.TestStorage.GetDataByUserID(context.Background(), user.ID)
+	assert.NoError(sts.T(), err)
+	assert.Equal(sts.T(), []byte("rotated binary"), stored[0].DataBinary)
+}
+
+func (sts *StorageTestSuite) TestDBStorage_DataHistoryBatch() {
+	user := models.User{
+		ID:       uuid.NewString(),
+		Login:    "login",
+		Password: "password",
+	}
+	err := sts.TestStorage.RegisterUser(context.Background(), user)
+	assert.NoError(sts.T(), err)
+
+	// three revisions of each record are kept in history
+	for i := 0; i < 2; i++ {
+		data := models.Data{
+			ID:         uuid.NewString(),
+			UserID:     user.ID,
+			DataType:   models.TextType,
+			DataBinary: []byte("binary"),
+		}
+		err = sts.TestStorage.AddData(context.Background(), data)
+		assert.NoError(sts.T(), err)
+		for revision := int64(1); revision <= 3; revision++ {
+			_, err = sts.TestStorage.UpdateData(context.Background(), data, revision, 10)
+			assert.NoError(sts.T(), err)
+		}
+	}
+
+	// walk all revisions with keyset pagination
+	var revisions []models.Data
+	afterID := "00000000-0000-0000-0000-000000000000"
+	var afterRevision int64
+	for {
+		batch, err := sts.TestStorage.GetDataHistoryBatch(context.Background(), afterID, afterRevision, 2)
+		assert.NoError(sts.T(), err)
+		if len(batch) == 0 {
+			break
+		}
+		assert.LessOrEqual(sts.T(), len(batch), 2)
+		revisions = append(revisions, batch...)
+		afterID = batch[len(batch)-1].ID
+		afterRevision = batch[len(batch)-1].Revision
+	}
+	assert.Len(sts.T(), revisions, 6)
+
+	revision := revisions[0]
+	revision.DataBinary = []byte("rotated binary")
+	err = sts.TestStorage.UpdateDataHistoryBinary(context.Background(), revision, []byte("binary"))
+	assert.NoError(sts.T(), err)
+
+	// changed concurrently
+	err = sts.TestStorage.UpdateDataHistoryBinary(context.Background(), revision, []byte("binary"))
+	assert.ErrorIs(sts.T(), err, storage.ErrorRevisionNotFound)
+
+	// another user
+	another := revisions[1]
+	another.UserID = uuid.NewString()
+	err = sts.TestStorage.UpdateDataHistoryBinary(context.Background(), another, []byte("binary"))
+	assert.ErrorIs(sts.T(), err, storage.ErrorRevisionNotFound)
+
+	stored, err := sts.TestStorage.GetDataRevisions(context.Background(), user.ID, revision.ID)
+	assert.NoError(sts.T(), err)
+	for _, data := range stored {
+		if data.Revision == revision.Revision {
+			assert.Equal(sts.T(), []byte("rotated binary"), data.DataBinary)
+		} else {
+			assert.Equal(sts.T(), []byte("binary"), data.DataBinary)
+		}
+	}
+}
+
+func (sts *StorageTestSuite) TestDBStorage_SaveVault() {
+	user := models.User{
+		ID:       uuid.NewString(),
+		Login:    "login",
+		Password: "password",
+	}
+	err := sts.TestStorage.RegisterUser(context.Background(), user)
+	assert.NoError(sts.T(), err)
+
+	// user without vault
+	_, err = sts.TestStorage.GetVault(context.Background(), user.ID)
+	assert.ErrorIs(sts.T(), err, storage.ErrorVaultNotFound)
+
+	vault := models.Vault{
+		UserID:     user.ID,
+		Salt:       []byte("salt"),
+		KdfTime:    3,
+		KdfMemory:  64 * 1024,
+		KdfThreads: 4,
+		WrappedKey: []byte("wrapped key"),
+	}
 
-			err = s.DeleteDataByDataID(context.Background(), tt.id)
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{
+			name:    "positive test",
+			wantErr: false,
+		},
+		{
+			name:    "negative test (vault already exists)",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		sts.Run(tt.name, func() {
+			err := sts.TestStorage.SaveVault(context.Background(), vault)
 			if (err != nil) != tt.wantErr {
-				sts.T().Errorf("DeleteDataByDataID() error = %v, wantErr %v", err, tt.wantErr)
+				sts.T().Errorf("SaveVault() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if tt.wantErr {
+				assert.ErrorIs(sts.T(), err, storage.ErrorVaultAlreadyExist)
+			}
 		})
 	}
+
+	stored, err := sts.TestStorage.GetVault(context.Background(), user.ID)
+	assert.NoError(sts.T(), err)
+	assert.Equal(sts.T(), vault, stored)
 }
 
-func (sts *StorageTestSuite) TestDBStorage_NegativeAll() {
+func (sts *StorageTestSuite) TestDBStorage_SaveUserKey() {
+	user := models.User{
+		ID:       uuid.NewString(),
+		Login:    "login",
+		Password: "password",
+	}
+	err := sts.TestStorage.RegisterUser(context.Background(), user)
+	assert.NoError(sts.T(), err)
+
+	// user without key
+	_, err = sts.TestStorage.GetUserKey(context.Background(), user.ID)
+	assert.ErrorIs(sts.T(), err, storage.ErrorUserKeyNotFound)
+
+	userKey := models.UserKey{UserID: user.ID, WrappedKey: []byte("wrapped key")}
+
 	tests := []struct {
 		name    string
-		user    models.User
-		id      string
-		data    *models.Text
 		wantErr bool
 	}{
 		{
-			name: "negative tests for all methods",
-			user: models.User{
-				ID:       uuid.NewString(),
-				Login:    "login",
-				Password: "password",
-			},
-			id:      uuid.NewString(),
-			data:    models.NewText("description", "some text here"),
+			name:    "positive test",
+			wantErr: false,
+		},
+		{
+			name:    "negative test (user key already exists)",
 			wantErr: true,
 		},
 	}
-
-	sts.TestStorage.ReleaseStorage()
-
 	for _, tt := range tests {
 		sts.Run(tt.name, func() {
-			s := sts.TestStorage
+			err := sts.TestStorage.SaveUserKey(context.Background(), userKey)
+			if (err != nil) != tt.wantErr {
+				sts.T().Errorf("SaveUserKey() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				assert.ErrorIs(sts.T(), err, storage.ErrorUserKeyAlreadyExist)
+			}
+		})
+	}
 
-			binary, err := json.Marshal(tt.data)
-			assert.NoError(sts.T(), err)
+	stored, err := sts.TestStorage.GetUserKey(context.Background(), user.ID)
+	assert.NoError(sts.T(), err)
+	assert.Equal(sts.T(), userKey, stored)
 
-			err = s.RegisterUser(context.Background(), tt.user)
-			assert.NotNil(sts.T(), err)
+	// re-wrap key with compare-and-swap
+	rewrapped := models.UserKey{UserID: user.ID, WrappedKey: []byte("rewrapped key")}
+	err = sts.TestStorage.UpdateUserKey(context.Background(), rewrapped, userKey.WrappedKey)
+	assert.NoError(sts.T(), err)
+	err = sts.TestStorage.UpdateUserKey(context.Background(), rewrapped, userKey.WrappedKey)
+	assert.ErrorIs(sts.T(), err, storage.ErrorUserKeyNotFound)
 
-			_, err = s.GetUserByLogin(context.Background(), "login")
-			assert.NotNil(sts.T(), err)
+	batch, err := sts.TestStorage.GetUserKeyBatch(context.Background(), "00000000-0000-0000-0000-000000000000", 10)
+	assert.NoError(sts.T(), err)
+	assert.Equal(sts.T(), []models.UserKey{rewrapped}, batch)
+}
 
-			err = s.AddData(context.Background(),
-				models.Data{
-					ID:         tt.id,
-					UserID:     uuid.NewString(),
+func (sts *StorageTestSuite) TestDBStorage_DeleteUser() {
+	user := models.User{
+		ID:       uuid.NewString(),
+		Login:    "login",
+		Password: "password",
+	}
+	err := sts.TestStorage.RegisterUser(context.Background(), user)
+	assert.NoError(sts.T(), err)
+	err = sts.TestStorage.SaveUserKey(context.Background(), models.UserKey{UserID: user.ID, WrappedKey: []byte("key")})
+	assert.NoError(sts.T(), err)
+	err = sts.TestStorage.AddData(context.Background(), models.Data{
+		ID:         uuid.NewString(),
+		UserID:     user.ID,
+		DataType:   models.TextType,
+		DataBinary: []byte("binary"),
+	})
+	assert.NoError(sts.T(), err)
+	err = sts.TestStorage.SaveVault(context.Background(), models.Vault{UserID: user.ID, Salt: []byte("salt"), WrappedKey: []byte("key")})
+	assert.NoError(sts.T(), err)
+	err = sts.TestStorage.SaveRefreshToken(context.Background(), models.RefreshToken{
+		TokenHash: "hash", UserID: user.ID, FamilyID: uuid.NewString(), ExpiresAt: time.Now().Add(time.Hour),
+	})
+	assert.NoError(sts.T(), err)
+	err = sts.TestStorage.SaveTwoFactor(context.Background(), models.TwoFactor{UserID: user.ID, Secret: []byte("secret")})
+	assert.NoError(sts.T(), err)
+
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{
+			name:    "positive test",
+			wantErr: false,
+		},
+		{
+			name:    "negative test (user not found)",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		sts.Run(tt.name, func() {
+			err := sts.TestStorage.DeleteUser(context.Background(), user.ID)
+			if (err != nil) != tt.wantErr {
+				sts.T().Errorf("DeleteUser() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				assert.ErrorIs(sts.T(), err, storage.ErrorUserNotFound)
+			}
+		})
+	}
+
+	// all data of the user is deleted (ON DELETE CASCADE)
+	_, err = sts.TestStorage.GetUserKey(context.Background(), user.ID)
+	assert.ErrorIs(sts.T(), err, storage.ErrorUserKeyNotFound)
+	_, err = sts.TestStorage.GetDataByUserID(context.Background(), user.ID)
+	assert.ErrorIs(sts.T(), err, storage.ErrorPrivateDataNotFound)
+	_, err = sts.TestStorage.GetVault(context.Background(), user.ID)
+	assert.ErrorIs(sts.T(), err, storage.ErrorVaultNotFound)
+	_, err = sts.TestStorage.GetRefreshToken(context.Background(), "hash")
+	assert.ErrorIs(sts.T(), err, storage.ErrorRefreshTokenNotFound)
+	_, err = sts.TestStorage.GetTwoFactor(context.Background(), user.ID)
+	assert.ErrorIs(sts.T(), err, storage.ErrorTwoFactorNotFound)
+	_, err = sts.TestStorage.GetUserByID(context.Background(), user.ID)
+	assert.ErrorIs(sts.T(), err, storage.ErrorUserNotFound)
+
+	// access tokens of the deleted user are revoked
+	revoked, err := sts.TestStorage.IsTokenRevoked(context.Background(), uuid.NewString(), user.ID, "", time.Now())
+	assert.NoError(sts.T(), err)
+	assert.True(sts.T(), revoked)
+}
+
+func (sts *StorageTestSuite) TestDBStorage_UpdateUserPassword() {
+	user := models.User{
+		ID:       uuid.NewString(),
+		Login:    "login",
+		Password: "password",
+	}
+	err := sts.TestStorage.RegisterUser(context.Background(), user)
+	assert.NoError(sts.T(), err)
+	err = sts.TestStorage.SaveVault(context.Background(), models.Vault{
+		UserID: user.ID, Salt: []byte("salt"), KdfTime: 1, KdfMemory: 1, KdfThreads: 1, WrappedKey: []byte("key"),
+	})
+	assert.NoError(sts.T(), err)
+
+	newVault := models.Vault{
+		UserID: user.ID, Salt: []byte("new salt"), KdfTime: 3, KdfMemory: 2, KdfThreads: 4, WrappedKey: []byte("new key"),
+	}
+
+	tests := []struct {
+		name      string
+		user      models.User
+		vault     *models.Vault
+		wantVault models.Vault
+		wantErr   error
+	}{
+		{
+			name:      "positive test (password only)",
+			user:      models.User{ID: user.ID, Login: user.Login, Password: "new password"},
+			wantVault: models.Vault{UserID: user.ID, Salt: []byte("salt"), KdfTime: 1, KdfMemory: 1, KdfThreads: 1, WrappedKey: []byte("key")},
+		},
+		{
+			name:      "positive test (password and vault)",
+			user:      models.User{ID: user.ID, Login: user.Login, Password: "another password"},
+			vault:     &newVault,
+			wantVault: newVault,
+		},
+		{
+			name:      "positive test (SRP verifier)",
+			user:      models.User{ID: user.ID, Login: user.Login, SRPSalt: []byte("salt"), SRPVerifier: []byte("verifier")},
+			wantVault: newVault,
+		},
+		{
+			name:    "negative test (user not found)",
+			user:    models.User{ID: uuid.NewString(), Password: "password"},
+			vault:   &newVault,
+			wantErr: storage.ErrorUserNotFound,
+		},
+	}
+	for _, tt := range tests {
+		sts.Run(tt.name, func() {
+			err := sts.TestStorage.UpdateUserPassword(context.Background(), tt.user, tt.vault)
+			if tt.wantErr != nil {
+				assert.ErrorIs(sts.T(), err, tt.wantErr)
+				return
+			}
+			assert.NoError(sts.T(), err)
+
+			got, err := sts.TestStorage.GetUserByID(context.Background(), user.ID)
+			assert.NoError(sts.T(), err)
+			assert.Equal(sts.T(), tt.user, got)
+
+			vault, err := sts.TestStorage.GetVault(context.Background(), user.ID)
+			assert.NoError(sts.T(), err)
+			assert.Equal(sts.T(), tt.wantVault, vault)
+		})
+	}
+}
+
+func (sts *StorageTestSuite) TestDBStorage_UseRefreshToken() {
+	user := models.User{
+		ID:       uuid.NewString(),
+		Login:    "login",
+		Password: "password",
+	}
+	err := sts.TestStorage.RegisterUser(context.Background(), user)
+	assert.NoError(sts.T(), err)
+
+	token := models.RefreshToken{
+		TokenHash: "hash",
+		UserID:    user.ID,
+		FamilyID:  uuid.NewString(),
+		ExpiresAt: time.Now().Add(time.Hour).UTC().Truncate(time.Second),
+	}
+	err = sts.TestStorage.SaveRefreshToken(context.Background(), token)
+	assert.NoError(sts.T(), err)
+
+	stored, err := sts.TestStorage.GetRefreshToken(context.Background(), token.TokenHash)
+	assert.NoError(sts.T(), err)
+	assert.Equal(sts.T(), token.FamilyID, stored.FamilyID)
+	assert.True(sts.T(), token.ExpiresAt.Equal(stored.ExpiresAt))
+	assert.False(sts.T(), stored.Used)
+
+	_, err = sts.TestStorage.GetRefreshToken(context.Background(), "unknown")
+	assert.ErrorIs(sts.T(), err, storage.ErrorRefreshTokenNotFound)
+
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{
+			name:    "positive test",
+			wantErr: false,
+		},
+		{
+			name:    "negative test (token already used)",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		sts.Run(tt.name, func() {
+			err := sts.TestStorage.UseRefreshToken(context.Background(), token.TokenHash)
+			if (err != nil) != tt.wantErr {
+				sts.T().Errorf("UseRefreshToken() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				assert.ErrorIs(sts.T(), err, storage.ErrorRefreshTokenNotFound)
+			}
+		})
+	}
+
+	err = sts.TestStorage.RevokeRefreshTokenFamily(context.Background(), token.FamilyID)
+	assert.NoError(sts.T(), err)
+	stored, err = sts.TestStorage.GetRefreshToken(context.Background(), token.TokenHash)
+	assert.NoError(sts.T(), err)
+	assert.True(sts.T(), stored.Used)
+	assert.True(sts.T(), stored.Revoked)
+}
+
+func (sts *StorageTestSuite) TestDBStorage_IsTokenRevoked() {
+	user := models.User{
+		ID:       uuid.NewString(),
+		Login:    "login",
+		Password: "password",
+	}
+	err := sts.TestStorage.RegisterUser(context.Background(), user)
+	assert.NoError(sts.T(), err)
+
+	issuedAt := time.Now()
+	revokedID := uuid.NewString()
+	err = sts.TestStorage.RevokeToken(context.Background(), models.RevokedToken{
+		ID:        revokedID,
+		UserID:    user.ID,
+		ExpiresAt: issuedAt.Add(time.Hour),
+	})
+	assert.NoError(sts.T(), err)
+
+	activeSession := models.Session{ID: uuid.NewString(), UserID: user.ID, CreatedAt: issuedAt}
+	err = sts.TestStorage.SaveSession(context.Background(), activeSession)
+	assert.NoError(sts.T(), err)
+	revokedSession := models.Session{ID: uuid.NewString(), UserID: user.ID, CreatedAt: issuedAt}
+	err = sts.TestStorage.SaveSession(context.Background(), revokedSession)
+	assert.NoError(sts.T(), err)
+	err = sts.TestStorage.RevokeSession(context.Background(), user.ID, revokedSession.ID)
+	assert.NoError(sts.T(), err)
+
+	tests := []struct {
+		name      string
+		id        string
+		sessionID string
+		issuedAt  time.Time
+		before    time.Time
+		want      bool
+	}{
+		{
+			name:     "positive test (valid token)",
+			id:       uuid.NewString(),
+			issuedAt: issuedAt,
+			want:     false,
+		},
+		{
+			name:     "positive test (revoked token)",
+			id:       revokedID,
+			issuedAt: issuedAt,
+			want:     true,
+		},
+		{
+			name:      "positive test (token of active session)",
+			id:        uuid.NewString(),
+			sessionID: activeSession.ID,
+			issuedAt:  issuedAt,
+			want:      false,
+		},
+		{
+			name:      "positive test (token of revoked session)",
+			id:        uuid.NewString(),
+			sessionID: revokedSession.ID,
+			issuedAt:  issuedAt,
+			want:      true,
+		},
+		{
+			name:     "positive test (token issued before logout from all devices)",
+			id:       uuid.NewString(),
+			issuedAt: issuedAt,
+			before:   issuedAt.Add(time.Second),
+			want:     true,
+		},
+		{
+			name:     "positive test (token issued after logout from all devices)",
+			id:       uuid.NewString(),
+			issuedAt: issuedAt.Add(2 * time.Second),
+			want:     false,
+		},
+		{
+			name:     "positive test (token issued within the second of logout from all devices)",
+			id:       uuid.NewString(),
+			issuedAt: issuedAt.Add(3 * time.Second).Truncate(time.Second),
+			before:   issuedAt.Add(3 * time.Second).Truncate(time.Second).Add(500 * time.Millisecond),
+			want:     false,
+		},
+	}
+	for _, tt := range tests {
+		sts.Run(tt.name, func() {
+			if !tt.before.IsZero() {
+				err := sts.TestStorage.RevokeUserTokens(context.Background(), user.ID, tt.before)
+				assert.NoError(sts.T(), err)
+			}
+
+			got, err := sts.TestStorage.IsTokenRevoked(context.Background(), tt.id, user.ID, tt.sessionID, tt.issuedAt)
+			assert.NoError(sts.T(), err)
+			assert.Equal(sts.T(), tt.want, got)
+		})
+	}
+
+	// sessions are revoked with logout from all devices
+	_, err = sts.TestStorage.GetSession(context.Background(), user.ID, activeSession.ID)
+	assert.ErrorIs(sts.T(), err, storage.ErrorSessionNotFound)
+
+	err = sts.TestStorage.RevokeUserTokens(context.Background(), uuid.NewString(), issuedAt)
+	assert.ErrorIs(sts.T(), err, storage.ErrorUserNotFound)
+}
+
+func (sts *StorageTestSuite) TestDBStorage_Session() {
+	user := models.User{
+		ID:       uuid.NewString(),
+		Login:    "login",
+		Password: "password",
+	}
+	err := sts.TestStorage.RegisterUser(context.Background(), user)
+	assert.NoError(sts.T(), err)
+
+	now := time.Now().Truncate(time.Millisecond)
+	session := models.Session{
+		ID:            uuid.NewString(),
+		UserID:        user.ID,
+		DeviceName:    "laptop",
+		ClientVersion: "1.0.0",
+		Address:       "127.0.0.1",
+		CreatedAt:     now.Add(-time.Hour),
+	}
+	err = sts.TestStorage.SaveSession(context.Background(), session)
+	assert.NoError(sts.T(), err)
+	err = sts.TestStorage.SaveRefreshToken(context.Background(), models.RefreshToken{
+		TokenHash: "hash",
+		UserID:    user.ID,
+		FamilyID:  session.ID,
+		ExpiresAt: now.Add(time.Hour),
+	})
+	assert.NoError(sts.T(), err)
+
+	got, err := sts.TestStorage.GetSession(context.Background(), user.ID, session.ID)
+	assert.NoError(sts.T(), err)
+	assert.Equal(sts.T(), session.DeviceName, got.DeviceName)
+	assert.Equal(sts.T(), session.ClientVersion, got.ClientVersion)
+	assert.Equal(sts.T(), session.Address, got.Address)
+	assert.True(sts.T(), session.CreatedAt.Equal(got.LastSeenAt))
+
+	// session of another user is not available
+	_, err = sts.TestStorage.GetSession(context.Background(), uuid.NewString(), session.ID)
+	assert.ErrorIs(sts.T(), err, storage.ErrorSessionNotFound)
+
+	// sessions which were not seen recently are expired
+	sessions, err := sts.TestStorage.GetSessions(context.Background(), user.ID, now.Add(-time.Minute))
+	assert.NoError(sts.T(), err)
+	assert.Empty(sts.T(), sessions)
+
+	err = sts.TestStorage.TouchSession(context.Background(), session.ID, now)
+	assert.NoError(sts.T(), err)
+	// last seen time is not moved back by concurrent requests
+	err = sts.TestStorage.TouchSession(context.Background(), session.ID, now.Add(-time.Hour))
+	assert.NoError(sts.T(), err)
+	sessions, err = sts.TestStorage.GetSessions(context.Background(), user.ID, now.Add(-time.Minute))
+	assert.NoError(sts.T(), err)
+	if assert.Len(sts.T(), sessions, 1) {
+		assert.Equal(sts.T(), session.ID, sessions[0].ID)
+		assert.True(sts.T(), now.Equal(sessions[0].LastSeenAt))
+	}
+
+	// session of another user cannot be revoked
+	err = sts.TestStorage.RevokeSession(context.Background(), uuid.NewString(), session.ID)
+	assert.ErrorIs(sts.T(), err, storage.ErrorSessionNotFound)
+
+	err = sts.TestStorage.RevokeSession(context.Background(), user.ID, session.ID)
+	assert.NoError(sts.T(), err)
+	err = sts.TestStorage.RevokeSession(context.Background(), user.ID, session.ID)
+	assert.ErrorIs(sts.T(), err, storage.ErrorSessionNotFound)
+
+	sessions, err = sts.TestStorage.GetSessions(context.Background(), user.ID, now.Add(-time.Minute))
+	assert.NoError(sts.T(), err)
+	assert.Empty(sts.T(), sessions)
+	refreshToken, err := sts.TestStorage.GetRefreshToken(context.Background(), "hash")
+	assert.NoError(sts.T(), err)
+	assert.True(sts.T(), refreshToken.Revoked)
+}
+
+func (sts *StorageTestSuite) TestDBStorage_TwoFactor() {
+	user := models.User{
+		ID:       uuid.NewString(),
+		Login:    "login",
+		Password: "password",
+	}
+	err := sts.TestStorage.RegisterUser(context.Background(), user)
+	assert.NoError(sts.T(), err)
+
+	_, err = sts.TestStorage.GetTwoFactor(context.Background(), user.ID)
+	assert.ErrorIs(sts.T(), err, storage.ErrorTwoFactorNotFound)
+
+	// pending enrollment can be replaced
+	twoFactor := models.TwoFactor{UserID: user.ID, Secret: []byte("secret"), RecoveryCodes: []string{"hash1", "hash2"}}
+	err = sts.TestStorage.SaveTwoFactor(context.Background(), twoFactor)
+	assert.NoError(sts.T(), err)
+	twoFactor.Secret = []byte("new secret")
+	err = sts.TestStorage.SaveTwoFactor(context.Background(), twoFactor)
+	assert.NoError(sts.T(), err)
+
+	// codes of pending enrollment are not accepted
+	err = sts.TestStorage.UseTwoFactorStep(context.Background(), user.ID, 100)
+	assert.ErrorIs(sts.T(), err, storage.ErrorTwoFactorCodeUsed)
+
+	err = sts.TestStorage.EnableTwoFactor(context.Background(), user.ID, 100)
+	assert.NoError(sts.T(), err)
+	err = sts.TestStorage.EnableTwoFactor(context.Background(), user.ID, 100)
+	assert.ErrorIs(sts.T(), err, storage.ErrorTwoFactorNotFound)
+	err = sts.TestStorage.SaveTwoFactor(context.Background(), twoFactor)
+	assert.ErrorIs(sts.T(), err, storage.ErrorTwoFactorAlreadyEnabled)
+
+	got, err := sts.TestStorage.GetTwoFactor(context.Background(), user.ID)
+	assert.NoError(sts.T(), err)
+	assert.Equal(sts.T(), models.TwoFactor{
+		UserID:        user.ID,
+		Secret:        []byte("new secret"),
+		Enabled:       true,
+		LastUsedStep:  100,
+		RecoveryCodes: []string{"hash1", "hash2"},
+	}, got)
+
+	tests := []struct {
+		name    string
+		step    int64
+		code    string
+		wantErr error
+	}{
+		{
+			name:    "negative test (step of confirmation code)",
+			step:    100,
+			wantErr: storage.ErrorTwoFactorCodeUsed,
+		},
+		{
+			name: "positive test (next step)",
+			step: 101,
+		},
+		{
+			name:    "negative test (previous step)",
+			step:    99,
+			wantErr: storage.ErrorTwoFactorCodeUsed,
+		},
+		{
+			name: "positive test (recovery code)",
+			code: "hash1",
+		},
+		{
+			name:    "negative test (used recovery code)",
+			code:    "hash1",
+			wantErr: storage.ErrorTwoFactorCodeUsed,
+		},
+		{
+			name:    "negative test (unknown recovery code)",
+			code:    "hash3",
+			wantErr: storage.ErrorTwoFactorCodeUsed,
+		},
+	}
+	for _, tt := range tests {
+		sts.Run(tt.name, func() {
+			if tt.code != "" {
+				err = sts.TestStorage.UseRecoveryCode(context.Background(), user.ID, tt.code)
+			} else {
+				err = sts.TestStorage.UseTwoFactorStep(context.Background(), user.ID, tt.step)
+			}
+			if tt.wantErr != nil {
+				assert.ErrorIs(sts.T(), err, tt.wantErr)
+				return
+			}
+			assert.NoError(sts.T(), err)
+		})
+	}
+
+	got, err = sts.TestStorage.GetTwoFactor(context.Background(), user.ID)
+	assert.NoError(sts.T(), err)
+	assert.Equal(sts.T(), []string{"hash2"}, got.RecoveryCodes)
+
+	err = sts.TestStorage.DeleteTwoFactor(context.Background(), user.ID)
+	assert.NoError(sts.T(), err)
+	err = sts.TestStorage.DeleteTwoFactor(context.Background(), user.ID)
+	assert.ErrorIs(sts.T(), err, storage.ErrorTwoFactorNotFound)
+}
+
+func (sts *StorageTestSuite) TestDBStorage_Device() {
+	user := models.User{
+		ID:       uuid.NewString(),
+		Login:    "login",
+		Password: "password",
+	}
+	err := sts.TestStorage.RegisterUser(context.Background(), user)
+	assert.NoError(sts.T(), err)
+
+	_, err = sts.TestStorage.GetDeviceByFingerprint(context.Background(), "fingerprint")
+	assert.ErrorIs(sts.T(), err, storage.ErrorDeviceNotFound)
+
+	device := models.Device{
+		ID:          uuid.NewString(),
+		UserID:      user.ID,
+		Name:        "laptop",
+		Fingerprint: "fingerprint",
+		ExpiresAt:   time.Now().Add(time.Hour).Truncate(time.Millisecond),
+	}
+	err = sts.TestStorage.SaveDevice(context.Background(), device)
+	assert.NoError(sts.T(), err)
+
+	// fingerprint of the certificate is unique
+	duplicate := device
+	duplicate.ID = uuid.NewString()
+	err = sts.TestStorage.SaveDevice(context.Background(), duplicate)
+	assert.Error(sts.T(), err)
+
+	got, err := sts.TestStorage.GetDeviceByFingerprint(context.Background(), device.Fingerprint)
+	assert.NoError(sts.T(), err)
+	assert.Equal(sts.T(), device.ID, got.ID)
+	assert.Equal(sts.T(), device.UserID, got.UserID)
+	assert.True(sts.T(), device.ExpiresAt.Equal(got.ExpiresAt))
+	assert.False(sts.T(), got.Revoked)
+
+	// device of another user cannot be revoked
+	err = sts.TestStorage.RevokeDevice(context.Background(), uuid.NewString(), device.ID)
+	assert.ErrorIs(sts.T(), err, storage.ErrorDeviceNotFound)
+
+	err = sts.TestStorage.RevokeDevice(context.Background(), user.ID, device.ID)
+	assert.NoError(sts.T(), err)
+	got, err = sts.TestStorage.GetDeviceByFingerprint(context.Background(), device.Fingerprint)
+	assert.NoError(sts.T(), err)
+	assert.True(sts.T(), got.Revoked)
+
+	// devices are removed with the user
+	err = sts.TestStorage.DeleteUser(context.Background(), user.ID)
+	assert.NoError(sts.T(), err)
+	_, err = sts.TestStorage.GetDeviceByFingerprint(context.Background(), device.Fingerprint)
+	assert.ErrorIs(sts.T(), err, storage.ErrorDeviceNotFound)
+}
+
+func (sts *StorageTestSuite) TestDBStorage_AccessToken() {
+	user := models.User{
+		ID:       uuid.NewString(),
+		Login:    "login",
+		Password: "password",
+	}
+	err := sts.TestStorage.RegisterUser(context.Background(), user)
+	assert.NoError(sts.T(), err)
+
+	_, err = sts.TestStorage.GetAccessToken(context.Background(), "hash")
+	assert.ErrorIs(sts.T(), err, storage.ErrorAccessTokenNotFound)
+
+	now := time.Now().Truncate(time.Millisecond)
+	expiresAt := now.Add(time.Hour)
+	token := models.AccessToken{
+		ID:         uuid.NewString(),
+		UserID:     user.ID,
+		Name:       "ci",
+		TokenHash:  "hash",
+		ReadOnly:   true,
+		DataIDs:    []string{uuid.NewString()},
+		DataTypes:  []models.DataType{models.TextType, models.CardType},
+		WrappedKey: []byte("wrapped key"),
+		ExpiresAt:  &expiresAt,
+		CreatedAt:  now,
+	}
+	err = sts.TestStorage.SaveAccessToken(context.Background(), token)
+	assert.NoError(sts.T(), err)
+
+	// token without restrictions and expiration
+	unrestricted := models.AccessToken{
+		ID:        uuid.NewString(),
+		UserID:    user.ID,
+		Name:      "backup",
+		TokenHash: "another hash",
+		CreatedAt: now.Add(time.Second),
+	}
+	err = sts.TestStorage.SaveAccessToken(context.Background(), unrestricted)
+	assert.NoError(sts.T(), err)
+
+	got, err := sts.TestStorage.GetAccessToken(context.Background(), token.TokenHash)
+	assert.NoError(sts.T(), err)
+	assert.Equal(sts.T(), token.ID, got.ID)
+	assert.Equal(sts.T(), token.UserID, got.UserID)
+	assert.Equal(sts.T(), token.DataIDs, got.DataIDs)
+	assert.Equal(sts.T(), token.DataTypes, got.DataTypes)
+	assert.Equal(sts.T(), token.WrappedKey, got.WrappedKey)
+	assert.True(sts.T(), got.ReadOnly)
+	if assert.NotNil(sts.T(), got.ExpiresAt) {
+		assert.True(sts.T(), expiresAt.Equal(*got.ExpiresAt))
+	}
+	assert.Nil(sts.T(), got.LastUsedAt)
+
+	got, err = sts.TestStorage.GetAccessToken(context.Background(), unrestricted.TokenHash)
+	assert.NoError(sts.T(), err)
+	assert.Empty(sts.T(), got.DataIDs)
+	assert.Empty(sts.T(), got.DataTypes)
+	assert.Nil(sts.T(), got.ExpiresAt)
+
+	err = sts.TestStorage.TouchAccessToken(context.Background(), token.ID, now)
+	assert.NoError(sts.T(), err)
+	got, err = sts.TestStorage.GetAccessToken(context.Background(), token.TokenHash)
+	assert.NoError(sts.T(), err)
+	if assert.NotNil(sts.T(), got.LastUsedAt) {
+		assert.True(sts.T(), now.Equal(*got.LastUsedAt))
+	}
+
+	tokens, err := sts.TestStorage.GetAccessTokens(context.Background(), user.ID)
+	assert.NoError(sts.T(), err)
+	if assert.Len(sts.T(), tokens, 2) {
+		assert.Equal(sts.T(), token.ID, tokens[0].ID)
+		assert.Equal(sts.T(), unrestricted.ID, tokens[1].ID)
+	}
+
+	// token of another user cannot be revoked
+	err = sts.TestStorage.RevokeAccessToken(context.Background(), uuid.NewString(), token.ID)
+	assert.ErrorIs(sts.T(), err, storage.ErrorAccessTokenNotFound)
+
+	err = sts.TestStorage.RevokeAccessToken(context.Background(), user.ID, token.ID)
+	assert.NoError(sts.T(), err)
+	err = sts.TestStorage.RevokeAccessToken(context.Background(), user.ID, token.ID)
+	assert.ErrorIs(sts.T(), err, storage.ErrorAccessTokenNotFound)
+	_, err = sts.TestStorage.GetAccessToken(context.Background(), token.TokenHash)
+	assert.ErrorIs(sts.T(), err, storage.ErrorAccessTokenNotFound)
+
+	tokens, err = sts.TestStorage.GetAccessTokens(context.Background(), user.ID)
+	assert.NoError(sts.T(), err)
+	assert.Len(sts.T(), tokens, 1)
+}
+
+func (sts *StorageTestSuite) TestDBStorage_SRPHandshake() {
+	user := models.User{
+		ID:          uuid.NewString(),
+		Login:       "login",
+		SRPSalt:     []byte("salt"),
+		SRPVerifier: []byte("verifier"),
+	}
+	err := sts.TestStorage.RegisterUser(context.Background(), user)
+	assert.NoError(sts.T(), err)
+
+	got, err := sts.TestStorage.GetUserByLogin(context.Background(), user.Login)
+	assert.NoError(sts.T(), err)
+	assert.Equal(sts.T(), user, got)
+
+	handshake := models.SRPHandshake{
+		ID:        uuid.NewString(),
+		UserID:    user.ID,
+		Secret:    []byte("secret"),
+		ExpiresAt: time.Now().Add(time.Minute).Truncate(time.Millisecond),
+	}
+	err = sts.TestStorage.SaveSRPHandshake(context.Background(), handshake)
+	assert.NoError(sts.T(), err)
+
+	// expired handshake is removed by the next one
+	expired := models.SRPHandshake{
+		ID:        uuid.NewString(),
+		UserID:    user.ID,
+		Secret:    []byte("expired"),
+		ExpiresAt: time.Now().Add(-time.Minute),
+	}
+	err = sts.TestStorage.SaveSRPHandshake(context.Background(), expired)
+	assert.NoError(sts.T(), err)
+	err = sts.TestStorage.SaveSRPHandshake(context.Background(), models.SRPHandshake{
+		ID:        uuid.NewString(),
+		UserID:    user.ID,
+		Secret:    []byte("another secret"),
+		ExpiresAt: time.Now().Add(time.Minute),
+	})
+	assert.NoError(sts.T(), err)
+	_, err = sts.TestStorage.UseSRPHandshake(context.Background(), expired.ID)
+	assert.ErrorIs(sts.T(), err, storage.ErrorSRPHandshakeNotFound)
+
+	used, err := sts.TestStorage.UseSRPHandshake(context.Background(), handshake.ID)
+	assert.NoError(sts.T(), err)
+	assert.Equal(sts.T(), handshake.UserID, used.UserID)
+	assert.Equal(sts.T(), handshake.Secret, used.Secret)
+	assert.True(sts.T(), handshake.ExpiresAt.Equal(used.ExpiresAt))
+
+	// every handshake is used once
+	_, err = sts.TestStorage.UseSRPHandshake(context.Background(), handshake.ID)
+	assert.ErrorIs(sts.T(), err, storage.ErrorSRPHandshakeNotFound)
+}
+
+func (sts *StorageTestSuite) TestDBStorage_AddLoginFailure() {
+	now := time.Now().Truncate(time.Millisecond)
+
+	// stale attempts are removed by the next failure
+	err := sts.TestStorage.AddLoginFailure(context.Background(), "login:stale", now.Add(-48*time.Hour), now.Add(-72*time.Hour))
+	assert.NoError(sts.T(), err)
+
+	tests := []struct {
+		name     string
+		key      string
+		failedAt time.Time
+		want     models.LoginAttempt
+	}{
+		{
+			name:     "positive test (first failure)",
+			key:      "login:user",
+			failedAt: now,
+			want:     models.LoginAttempt{Key: "login:user", Failures: 1, LastFailureAt: now},
+		},
+		{
+			name:     "positive test (next failure)",
+			key:      "login:user",
+			failedAt: now.Add(time.Second),
+			want:     models.LoginAttempt{Key: "login:user", Failures: 2, LastFailureAt: now.Add(time.Second)},
+		},
+		{
+			name:     "positive test (concurrent failure with earlier time)",
+			key:      "login:user",
+			failedAt: now,
+			want:     models.LoginAttempt{Key: "login:user", Failures: 3, LastFailureAt: now.Add(time.Second)},
+		},
+		{
+			name:     "positive test (another key)",
+			key:      "peer:127.0.0.1",
+			failedAt: now,
+			want:     models.LoginAttempt{Key: "peer:127.0.0.1", Failures: 1, LastFailureAt: now},
+		},
+	}
+	for _, tt := range tests {
+		sts.Run(tt.name, func() {
+			err := sts.TestStorage.AddLoginFailure(context.Background(), tt.key, tt.failedAt, now.Add(-24*time.Hour))
+			assert.NoError(sts.T(), err)
+
+			got, err := sts.TestStorage.GetLoginAttempts(context.Background(), []string{tt.key})
+			assert.NoError(sts.T(), err)
+			if assert.Len(sts.T(), got, 1) {
+				assert.Equal(sts.T(), tt.want.Failures, got[0].Failures)
+				assert.True(sts.T(), tt.want.LastFailureAt.Equal(got[0].LastFailureAt))
+			}
+		})
+	}
+
+	got, err := sts.TestStorage.GetLoginAttempts(context.Background(), []string{"login:user", "peer:127.0.0.1", "login:stale"})
+	assert.NoError(sts.T(), err)
+	assert.Len(sts.T(), got, 2)
+
+	err = sts.TestStorage.DeleteLoginAttempts(context.Background(), "login:user")
+	assert.NoError(sts.T(), err)
+	got, err = sts.TestStorage.GetLoginAttempts(context.Background(), []string{"login:user"})
+	assert.NoError(sts.T(), err)
+	assert.Empty(sts.T(), got)
+}
+
+func (sts *StorageTestSuite) TestDBStorage_DeleteDataByDataID() {
+	user := models.User{
+		ID:       uuid.NewString(),
+		Login:    "login",
+		Password: "password",
+	}
+	anotherUser := models.User{
+		ID:       uuid.NewString(),
+		Login:    "another",
+		Password: "password",
+	}
+	for _, u := range []models.User{user, anotherUser} {
+		err := sts.TestStorage.RegisterUser(context.Background(), u)
+		assert.NoError(sts.T(), err)
+	}
+
+	binary, err := json.Marshal(models.NewText("description", "some text here"))
+	assert.NoError(sts.T(), err)
+	id := uuid.NewString()
+	err = sts.TestStorage.AddData(context.Background(),
+		models.Data{
+			ID:         id,
+			UserID:     user.ID,
+			DataType:   models.TextType,
+			DataBinary: binary,
+		})
+	assert.NoError(sts.T(), err)
+
+	anotherID := uuid.NewString()
+	err = sts.TestStorage.AddData(context.Background(),
+		models.Data{
+			ID:         anotherID,
+			UserID:     user.ID,
+			DataType:   models.TextType,
+			DataBinary: binary,
+		})
+	assert.NoError(sts.T(), err)
+
+	tests := []struct {
+		name     string
+		userID   string
+		id       string
+		revision int64
+		wantErr  error
+	}{
+		{
+			name:     "negative test (data of another user)",
+			userID:   anotherUser.ID,
+			id:       id,
+			revision: 0,
+			wantErr:  storage.ErrorPrivateDataNotFound,
+		},
+		{
+			name:     "negative test (revision mismatch)",
+			userID:   user.ID,
+			id:       id,
+			revision: 2,
+			wantErr:  storage.ErrorRevisionMismatch,
+		},
+		{
+			name:     "positive test",
+			userID:   user.ID,
+			id:       id,
+			revision: 1,
+			wantErr:  nil,
+		},
+		{
+			name:     "negative test (already deleted)",
+			userID:   user.ID,
+			id:       id,
+			revision: 0,
+			wantErr:  storage.ErrorPrivateDataNotFound,
+		},
+		{
+			name:     "positive test (any revision)",
+			userID:   user.ID,
+			id:       anotherID,
+			revision: 0,
+			wantErr:  nil,
+		},
+	}
+	for _, tt := range tests {
+		sts.Run(tt.name, func() {
+			err := sts.TestStorage.DeleteDataByDataID(context.Background(), tt.userID, tt.id, tt.revision)
+			if tt.wantErr != nil {
+				assert.ErrorIs(sts.T(), err, tt.wantErr)
+				return
+			}
+			assert.NoError(sts.T(), err)
+		})
+	}
+}
+
+func (sts *StorageTestSuite) TestDBStorage_Trash() {
+	user := models.User{
+		ID:       uuid.NewString(),
+		Login:    "login",
+		Password: "password",
+	}
+	anotherUser := models.User{
+		ID:       uuid.NewString(),
+		Login:    "another",
+		Password: "password",
+	}
+	for _, u := range []models.User{user, anotherUser} {
+		err := sts.TestStorage.RegisterUser(context.Background(), u)
+		assert.NoError(sts.T(), err)
+	}
+
+	text := models.Data{ID: uuid.NewString(), UserID: user.ID, DataType: models.TextType, DataBinary: []byte("text")}
+	card := models.Data{ID: uuid.NewString(), UserID: user.ID, DataType: models.CardType, DataBinary: []byte("card")}
+	for _, data := range []models.Data{text, card} {
+		err := sts.TestStorage.AddData(context.Background(), data)
+		assert.NoError(sts.T(), err)
+		err = sts.TestStorage.DeleteDataByDataID(context.Background(), user.ID, data.ID, 0)
+		assert.NoError(sts.T(), err)
+	}
+
+	// data in trash is not returned with data of the user
+	_, err := sts.TestStorage.GetDataByUserID(context.Background(), user.ID)
+	assert.ErrorIs(sts.T(), err, storage.ErrorPrivateDataNotFound)
+	_, err = sts.TestStorage.GetDataByID(context.Background(), user.ID, text.ID)
+	assert.ErrorIs(sts.T(), err, storage.ErrorPrivateDataNotFound)
+	_, err = sts.TestStorage.UpdateData(context.Background(), text, 1, 10)
+	assert.ErrorIs(sts.T(), err, storage.ErrorPrivateDataNotFound)
+	// data in trash is not replaced by data with the same ID
+	err = sts.TestStorage.AddData(context.Background(), models.Data{ID: text.ID, UserID: user.ID, DataType: models.TextType,
+		DataBinary: []byte("replaced")})
+	assert.ErrorIs(sts.T(), err, storage.ErrorPrivateDataAlreadyExist)
+	trash, err := sts.TestStorage.GetTrashByUserID(context.Background(), user.ID)
+	assert.NoError(sts.T(), err)
+	if assert.Len(sts.T(), trash, 2) {
+		assert.Equal(sts.T(), card.ID, trash[0].ID)
+		assert.False(sts.T(), trash[0].DeletedAt.IsZero())
+	}
+	trash, err = sts.TestStorage.GetTrashByUserID(context.Background(), anotherUser.ID)
+	assert.NoError(sts.T(), err)
+	assert.Empty(sts.T(), trash)
+
+	tests := []struct {
+		name    string
+		userID  string
+		id      string
+		wantErr error
+	}{
+		{
+			name:    "negative test (data of another user)",
+			userID:  anotherUser.ID,
+			id:      text.ID,
+			wantErr: storage.ErrorPrivateDataNotFound,
+		},
+		{
+			name:    "positive test",
+			userID:  user.ID,
+			id:      text.ID,
+			wantErr: nil,
+		},
+		{
+			name:    "negative test (data is not in trash)",
+			userID:  user.ID,
+			id:      text.ID,
+			wantErr: storage.ErrorPrivateDataNotFound,
+		},
+	}
+	for _, tt := range tests {
+		sts.Run(tt.name, func() {
+			restored, err := sts.TestStorage.RestoreData(context.Background(), tt.userID, tt.id)
+			if tt.wantErr != nil {
+				assert.ErrorIs(sts.T(), err, tt.wantErr)
+				return
+			}
+			assert.NoError(sts.T(), err)
+			assert.Equal(sts.T(), []byte("text"), restored.DataBinary)
+			assert.Equal(sts.T(), int64(1), restored.Revision)
+		})
+	}
+
+	// restored data is loaded on sync without tombstone
+	changes, err := sts.TestStorage.GetDataChanges(context.Background(), user.ID, 0)
+	assert.NoError(sts.T(), err)
+	if assert.Len(sts.T(), changes.Data, 1) {
+		assert.Equal(sts.T(), text.ID, changes.Data[0].ID)
+	}
+	if assert.Len(sts.T(), changes.Tombstones, 1) {
+		assert.Equal(sts.T(), card.ID, changes.Tombstones[0].DataID)
+	}
+
+	err = sts.TestStorage.PurgeData(context.Background(), user.ID, text.ID)
+	assert.ErrorIs(sts.T(), err, storage.ErrorPrivateDataNotFound)
+	err = sts.TestStorage.PurgeData(context.Background(), anotherUser.ID, card.ID)
+	assert.ErrorIs(sts.T(), err, storage.ErrorPrivateDataNotFound)
+	err = sts.TestStorage.PurgeData(context.Background(), user.ID, card.ID)
+	assert.NoError(sts.T(), err)
+	_, err = sts.TestStorage.RestoreData(context.Background(), user.ID, card.ID)
+	assert.ErrorIs(sts.T(), err, storage.ErrorPrivateDataNotFound)
+
+	// tombstone of purged data is removed, cursor before it leads to full sync
+	cursor := changes.Cursor
+	changes, err = sts.TestStorage.GetDataChanges(context.Background(), user.ID, 3)
+	assert.NoError(sts.T(), err)
+	assert.True(sts.T(), changes.Full)
+	assert.Len(sts.T(), changes.Data, 1)
+	assert.Empty(sts.T(), changes.Tombstones)
+	changes, err = sts.TestStorage.GetDataChanges(context.Background(), user.ID, cursor)
+	assert.NoError(sts.T(), err)
+	assert.False(sts.T(), changes.Full)
+	assert.Empty(sts.T(), changes.Data)
+	assert.Empty(sts.T(), changes.Tombstones)
+
+	// only data deleted before the time is purged
+	err = sts.TestStorage.DeleteDataByDataID(context.Background(), user.ID, text.ID, 0)
+	assert.NoError(sts.T(), err)
+	purged, err := sts.TestStorage.PurgeTrash(context.Background(), time.Now().Add(-time.Hour))
+	assert.NoError(sts.T(), err)
+	assert.Equal(sts.T(), int64(0), purged)
+	changes, err = sts.TestStorage.GetDataChanges(context.Background(), user.ID, cursor)
+	assert.NoError(sts.T(), err)
+	assert.False(sts.T(), changes.Full)
+	assert.Len(sts.T(), changes.Tombstones, 1)
+	purged, err = sts.TestStorage.PurgeTrash(context.Background(), time.Now().Add(time.Hour))
+	assert.NoError(sts.T(), err)
+	assert.Equal(sts.T(), int64(1), purged)
+	trash, err = sts.TestStorage.GetTrashByUserID(context.Background(), user.ID)
+	assert.NoError(sts.T(), err)
+	assert.Empty(sts.T(), trash)
+	changes, err = sts.TestStorage.GetDataChanges(context.Background(), user.ID, cursor)
+	assert.NoError(sts.T(), err)
+	assert.True(sts.T(), changes.Full)
+	assert.Empty(sts.T(), changes.Data)
+	assert.Empty(sts.T(), changes.Tombstones)
+	changes, err = sts.TestStorage.GetDataChanges(context.Background(), user.ID, changes.Cursor)
+	assert.NoError(sts.T(), err)
+	assert.False(sts.T(), changes.Full)
+}
+
+func (sts *StorageTestSuite) TestDBStorage_GetDataChanges() {
+	user := models.User{
+		ID:       uuid.NewString(),
+		Login:    "login",
+		Password: "password",
+	}
+	anotherUser := models.User{
+		ID:       uuid.NewString(),
+		Login:    "another",
+		Password: "password",
+	}
+	for _, u := range []models.User{user, anotherUser} {
+		err := sts.TestStorage.RegisterUser(context.Background(), u)
+		assert.NoError(sts.T(), err)
+	}
+
+	changes, err := sts.TestStorage.GetDataChanges(context.Background(), user.ID, 0)
+	assert.NoError(sts.T(), err)
+	assert.Empty(sts.T(), changes.Data)
+	assert.Equal(sts.T(), int64(0), changes.Cursor)
+
+	text := models.Data{ID: uuid.NewString(), UserID: user.ID, DataType: models.TextType, DataBinary: []byte("text")}
+	card := models.Data{ID: uuid.NewString(), UserID: user.ID, DataType: models.CardType, DataBinary: []byte("card")}
+	foreign := models.Data{ID: uuid.NewString(), UserID: anotherUser.ID, DataType: models.TextType, DataBinary: []byte("foreign")}
+	for _, data := range []models.Data{text, card, foreign} {
+		err = sts.TestStorage.AddData(context.Background(), data)
+		assert.NoError(sts.T(), err)
+	}
+	changes, err = sts.TestStorage.GetDataChanges(context.Background(), user.ID, 0)
+	assert.NoError(sts.T(), err)
+	assert.Len(sts.T(), changes.Data, 2)
+	assert.Equal(sts.T(), int64(2), changes.Cursor)
+	cursor := changes.Cursor
+
+	// failed changes do not move the cursor
+	err = sts.TestStorage.AddData(context.Background(), text)
+	assert.ErrorIs(sts.T(), err, storage.ErrorPrivateDataAlreadyExist)
+	err = sts.TestStorage.DeleteDataByDataID(context.Background(), user.ID, foreign.ID, 0)
+	assert.ErrorIs(sts.T(), err, storage.ErrorPrivateDataNotFound)
+
+	_, err = sts.TestStorage.UpdateData(context.Background(),
+		models.Data{ID: text.ID, UserID: user.ID, DataType: models.TextType, DataBinary: []byte("updated")}, 1, 10)
+	assert.NoError(sts.T(), err)
+	err = sts.TestStorage.DeleteDataByDataID(context.Background(), user.ID, card.ID, 0)
+	assert.NoError(sts.T(), err)
+
+	changes, err = sts.TestStorage.GetDataChanges(context.Background(), user.ID, cursor)
+	assert.NoError(sts.T(), err)
+	assert.Equal(sts.T(), int64(4), changes.Cursor)
+	if assert.Len(sts.T(), changes.Data, 1) {
+		assert.Equal(sts.T(), text.ID, changes.Data[0].ID)
+		assert.Equal(sts.T(), []byte("updated"), changes.Data[0].DataBinary)
+	}
+	if assert.Len(sts.T(), changes.Tombstones, 1) {
+		assert.Equal(sts.T(), card.ID, changes.Tombstones[0].DataID)
+		assert.Equal(sts.T(), models.CardType, changes.Tombstones[0].DataType)
+	}
+
+	changes, err = sts.TestStorage.GetDataChanges(context.Background(), user.ID, changes.Cursor)
+	assert.NoError(sts.T(), err)
+	assert.Empty(sts.T(), changes.Data)
+	assert.Empty(sts.T(), changes.Tombstones)
+
+	// data restored with the same ID has no tombstone
+	err = sts.TestStorage.AddData(context.Background(), card)
+	assert.NoError(sts.T(), err)
+	changes, err = sts.TestStorage.GetDataChanges(context.Background(), user.ID, cursor)
+	assert.NoError(sts.T(), err)
+	assert.Len(sts.T(), changes.Data, 2)
+	assert.Empty(sts.T(), changes.Tombstones)
+
+	_, err = sts.TestStorage.GetDataChanges(context.Background(), uuid.NewString(), 0)
+	assert.ErrorIs(sts.T(), err, storage.ErrorUserNotFound)
+
+	// current cursor is loaded without changes
+	current, err := sts.TestStorage.GetDataCursor(context.Background(), user.ID)
+	assert.NoError(sts.T(), err)
+	assert.Equal(sts.T(), changes.Cursor, current)
+	_, err = sts.TestStorage.GetDataCursor(context.Background(), uuid.NewString())
+	assert.ErrorIs(sts.T(), err, storage.ErrorUserNotFound)
+}
+
+func (sts *StorageTestSuite) TestDBStorage_ListenDataChanges() {
+	user := models.User{
+		ID:       uuid.NewString(),
+		Login:    "login",
+		Password: "password",
+	}
+	err := sts.TestStorage.RegisterUser(context.Background(), user)
+	assert.NoError(sts.T(), err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	events := make(chan models.ChangeEvent, 10)
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- sts.TestStorage.ListenDataChanges(ctx, events)
+	}()
+
+	// empty event is sent when listening starts
+	assert.Equal(sts.T(), models.ChangeEvent{}, <-events)
+
+	data := models.Data{ID: uuid.NewString(), UserID: user.ID, DataType: models.TextType, DataBinary: []byte("text")}
+	err = sts.TestStorage.AddData(context.Background(), data)
+	assert.NoError(sts.T(), err)
+	_, err = sts.TestStorage.UpdateData(context.Background(), data, 1, 10)
+	assert.NoError(sts.T(), err)
+	// failed changes are not notified
+	_, err = sts.TestStorage.UpdateData(context.Background(), data, 1, 10)
+	assert.ErrorIs(sts.T(), err, storage.ErrorRevisionMismatch)
+	err = sts.TestStorage.DeleteDataByDataID(context.Background(), user.ID, data.ID, 0)
+	assert.NoError(sts.T(), err)
+
+	want := []models.ChangeEvent{
+		{UserID: user.ID, DataID: data.ID, DataType: models.TextType, Cursor: 1},
+		{UserID: user.ID, DataID: data.ID, DataType: models.TextType, Cursor: 2},
+		{UserID: user.ID, DataID: data.ID, DataType: models.TextType, Cursor: 3, Deleted: true},
+	}
+	for _, event := range want {
+		assert.Equal(sts.T(), event, <-events)
+	}
+
+	cancel()
+	assert.NoError(sts.T(), <-listenErr)
+}
+
+func (sts *StorageTestSuite) TestDBStorage_GetDataByID() {
+	user := models.User{
+		ID:       uuid.NewString(),
+		Login:    "login",
+		Password: "password",
+	}
+	anotherUser := models.User{
+		ID:       uuid.NewString(),
+		Login:    "another",
+		Password: "password",
+	}
+	for _, u := range []models.User{user, anotherUser} {
+		err := sts.TestStorage.RegisterUser(context.Background(), u)
+		assert.NoError(sts.T(), err)
+	}
+
+	data := models.Data{
+		ID:         uuid.NewString(),
+		UserID:     user.ID,
+		DataType:   models.CardType,
+		DataBinary: []byte("binary"),
+	}
+	err := sts.TestStorage.AddData(context.Background(), data)
+	assert.NoError(sts.T(), err)
+
+	got, err := sts.TestStorage.GetDataByID(context.Background(), user.ID, data.ID)
+	assert.NoError(sts.T(), err)
+	assert.Equal(sts.T(), data.DataType, got.DataType)
+	assert.Equal(sts.T(), data.DataBinary, got.DataBinary)
+	assert.Equal(sts.T(), int64(1), got.Revision)
+
+	_, err = sts.TestStorage.GetDataByID(context.Background(), anotherUser.ID, data.ID)
+	assert.ErrorIs(sts.T(), err, storage.ErrorPrivateDataNotFound)
+	_, err = sts.TestStorage.GetDataByID(context.Background(), user.ID, uuid.NewString())
+	assert.ErrorIs(sts.T(), err, storage.ErrorPrivateDataNotFound)
+}
+
+func (sts *StorageTestSuite) TestDBStorage_NegativeAll() {
+	tests := []struct {
+		name    string
+		user    models.User
+		id      string
+		data    *models.Text
+		wantErr bool
+	}{
+		{
+			name: "negative tests for all methods",
+			user: models.User{
+				ID:       uuid.NewString(),
+				Login:    "login",
+				Password: "password",
+			},
+			id:      uuid.NewString(),
+			data:    models.NewText("description", "some text here"),
+			wantErr: true,
+		},
+	}
+
+	sts.TestStorage.ReleaseStorage()
+
+	for _, tt := range tests {
+		sts.Run(tt.name, func() {
+			s := sts.TestStorage
+
+			binary, err := json.Marshal(tt.data)
+			assert.NoError(sts.T(), err)
+
+			err = s.RegisterUser(context.Background(), tt.user)
+			assert.NotNil(sts.T(), err)
+
+			_, err = s.GetUserByLogin(context.Background(), "login")
+			assert.NotNil(sts.T(), err)
+
+			err = s.AddData(context.Background(),
+				models.Data{
+					ID:         tt.id,
+					UserID:     uuid.NewString(),
 					DataType:   tt.data.GetType(),
 					DataBinary: binary,
 				})
@@ -406,7 +2063,178 @@ func (sts *StorageTestSuite) TestDBStorage_NegativeAll() {
 			_, err = s.GetDataByUserID(context.Background(), tt.user.ID)
 			assert.NotNil(sts.T(), err)
 
-			err = s.DeleteDataByDataID(context.Background(), tt.id)
+			_, err = s.GetDataSample(context.Background(), 1)
+			assert.NotNil(sts.T(), err)
+
+			_, err = s.CountData(context.Background())
+			assert.NotNil(sts.T(), err)
+
+			_, err = s.GetDataBatch(context.Background(), tt.id, 1)
+			assert.NotNil(sts.T(), err)
+
+			err = s.UpdateDataBinary(context.Background(), models.Data{ID: tt.id}, binary)
+			assert.NotNil(sts.T(), err)
+
+			_, err = s.GetDataHistoryBatch(context.Background(), tt.id, 0, 1)
+			assert.NotNil(sts.T(), err)
+
+			err = s.UpdateDataHistoryBinary(context.Background(), models.Data{ID: tt.id}, binary)
+			assert.NotNil(sts.T(), err)
+
+			err = s.SaveVault(context.Background(), models.Vault{UserID: tt.user.ID})
+			assert.NotNil(sts.T(), err)
+
+			err = s.DeleteUser(context.Background(), tt.user.ID)
+			assert.NotNil(sts.T(), err)
+
+			_, err = s.GetUserByID(context.Background(), tt.user.ID)
+			assert.NotNil(sts.T(), err)
+
+			err = s.UpdateUserPassword(context.Background(), tt.user, nil)
+			assert.NotNil(sts.T(), err)
+
+			err = s.SaveRefreshToken(context.Background(), models.RefreshToken{UserID: tt.user.ID})
+			assert.NotNil(sts.T(), err)
+
+			_, err = s.GetRefreshToken(context.Background(), "hash")
+			assert.NotNil(sts.T(), err)
+
+			err = s.UseRefreshToken(context.Background(), "hash")
+			assert.NotNil(sts.T(), err)
+
+			err = s.RevokeRefreshTokenFamily(context.Background(), tt.id)
+			assert.NotNil(sts.T(), err)
+
+			err = s.RevokeToken(context.Background(), models.RevokedToken{ID: tt.id, UserID: tt.user.ID})
+			assert.NotNil(sts.T(), err)
+
+			err = s.RevokeUserTokens(context.Background(), tt.user.ID, time.Now())
+			assert.NotNil(sts.T(), err)
+
+			_, err = s.IsTokenRevoked(context.Background(), tt.id, tt.user.ID, "", time.Now())
+			assert.NotNil(sts.T(), err)
+
+			err = s.SaveUserKey(context.Background(), models.UserKey{UserID: tt.user.ID})
+			assert.NotNil(sts.T(), err)
+
+			_, err = s.GetUserKey(context.Background(), tt.user.ID)
+			assert.NotNil(sts.T(), err)
+
+			_, err = s.GetUserKeyBatch(context.Background(), tt.user.ID, 1)
+			assert.NotNil(sts.T(), err)
+
+			err = s.UpdateUserKey(context.Background(), models.UserKey{UserID: tt.user.ID}, binary)
+			assert.NotNil(sts.T(), err)
+
+			_, err = s.GetVault(context.Background(), tt.user.ID)
+			assert.NotNil(sts.T(), err)
+
+			err = s.SaveTwoFactor(context.Background(), models.TwoFactor{UserID: tt.user.ID})
+			assert.NotNil(sts.T(), err)
+
+			_, err = s.GetLoginAttempts(context.Background(), []string{tt.id})
+			assert.NotNil(sts.T(), err)
+
+			err = s.AddLoginFailure(context.Background(), tt.id, time.Now(), time.Now())
+			assert.NotNil(sts.T(), err)
+
+			err = s.DeleteLoginAttempts(context.Background(), tt.id)
+			assert.NotNil(sts.T(), err)
+
+			_, err = s.GetTwoFactor(context.Background(), tt.user.ID)
+			assert.NotNil(sts.T(), err)
+
+			err = s.EnableTwoFactor(context.Background(), tt.user.ID, 1)
+			assert.NotNil(sts.T(), err)
+
+			err = s.UseTwoFactorStep(context.Background(), tt.user.ID, 1)
+			assert.NotNil(sts.T(), err)
+
+			err = s.UseRecoveryCode(context.Background(), tt.user.ID, "hash")
+			assert.NotNil(sts.T(), err)
+
+			err = s.DeleteTwoFactor(context.Background(), tt.user.ID)
+			assert.NotNil(sts.T(), err)
+
+			err = s.SaveDevice(context.Background(), models.Device{ID: tt.id, UserID: tt.user.ID})
+			assert.NotNil(sts.T(), err)
+
+			err = s.SaveSession(context.Background(), models.Session{ID: tt.id, UserID: tt.user.ID})
+			assert.NotNil(sts.T(), err)
+
+			_, err = s.GetSession(context.Background(), tt.user.ID, tt.id)
+			assert.NotNil(sts.T(), err)
+
+			_, err = s.GetSessions(context.Background(), tt.user.ID, time.Now())
+			assert.NotNil(sts.T(), err)
+
+			err = s.TouchSession(context.Background(), tt.id, time.Now())
+			assert.NotNil(sts.T(), err)
+
+			err = s.RevokeSession(context.Background(), tt.user.ID, tt.id)
+			assert.NotNil(sts.T(), err)
+
+			_, err = s.GetDeviceByFingerprint(context.Background(), tt.id)
+			assert.NotNil(sts.T(), err)
+
+			err = s.RevokeDevice(context.Background(), tt.user.ID, tt.id)
+			assert.NotNil(sts.T(), err)
+
+			err = s.SaveAccessToken(context.Background(), models.AccessToken{ID: tt.id, UserID: tt.user.ID})
+			assert.NotNil(sts.T(), err)
+
+			_, err = s.GetAccessToken(context.Background(), "hash")
+			assert.NotNil(sts.T(), err)
+
+			_, err = s.GetAccessTokens(context.Background(), tt.user.ID)
+			assert.NotNil(sts.T(), err)
+
+			err = s.TouchAccessToken(context.Background(), tt.id, time.Now())
+			assert.NotNil(sts.T(), err)
+
+			err = s.RevokeAccessToken(context.Background(), tt.user.ID, tt.id)
+			assert.NotNil(sts.T(), err)
+
+			_, err = s.UpdateData(context.Background(), models.Data{ID: tt.id, UserID: tt.user.ID}, 1, 10)
+			assert.NotNil(sts.T(), err)
+
+			_, err = s.GetDataRevisions(context.Background(), tt.user.ID, tt.id)
+			assert.NotNil(sts.T(), err)
+
+			_, err = s.RestoreDataRevision(context.Background(), tt.user.ID, tt.id, 1, 10)
+			assert.NotNil(sts.T(), err)
+
+			err = s.DeleteDataByDataID(context.Background(), tt.user.ID, tt.id, 0)
+			assert.NotNil(sts.T(), err)
+
+			_, err = s.GetTrashByUserID(context.Background(), tt.user.ID)
+			assert.NotNil(sts.T(), err)
+
+			_, err = s.RestoreData(context.Background(), tt.user.ID, tt.id)
+			assert.NotNil(sts.T(), err)
+
+			err = s.PurgeData(context.Background(), tt.user.ID, tt.id)
+			assert.NotNil(sts.T(), err)
+
+			_, err = s.PurgeTrash(context.Background(), time.Now())
+			assert.NotNil(sts.T(), err)
+
+			_, err = s.GetDataByID(context.Background(), tt.user.ID, tt.id)
+			assert.NotNil(sts.T(), err)
+
+			_, err = s.GetDataChanges(context.Background(), tt.user.ID, 0)
+			assert.NotNil(sts.T(), err)
+
+			_, err = s.GetDataCursor(context.Background(), tt.user.ID)
+			assert.NotNil(sts.T(), err)
+
+			err = s.ListenDataChanges(context.Background(), make(chan models.ChangeEvent))
+			assert.NotNil(sts.T(), err)
+
+			err = s.SaveSRPHandshake(context.Background(), models.SRPHandshake{ID: tt.id, UserID: tt.user.ID})
+			assert.NotNil(sts.T(), err)
+
+			_, err = s.UseSRPHandshake(context.Background(), tt.id)
 			assert.NotNil(sts.T(), err)
 		})
 	}
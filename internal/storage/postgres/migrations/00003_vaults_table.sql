@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS "vaults"
+(
+    user_id     uuid    NOT NULL PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    salt        bytea   NOT NULL,
+    kdf_time    integer NOT NULL,
+    kdf_memory  integer NOT NULL,
+    kdf_threads integer NOT NULL,
+    wrapped_key bytea   NOT NULL
+);
+
+ALTER TABLE "data" ADD COLUMN IF NOT EXISTS client_encrypted boolean NOT NULL DEFAULT false;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "data" DROP COLUMN IF EXISTS client_encrypted;
+
+DROP TABLE IF EXISTS "vaults";
+-- +goose StatementEnd
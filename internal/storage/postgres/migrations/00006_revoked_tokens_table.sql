@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS "revoked_tokens"
+(
+    id         text        NOT NULL PRIMARY KEY,
+    user_id    uuid        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    expires_at timestamptz NOT NULL
+);
+
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS tokens_valid_after timestamptz;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "users" DROP COLUMN IF EXISTS tokens_valid_after;
+
+DROP TABLE IF EXISTS "revoked_tokens";
+-- +goose StatementEnd
\ No newline at end of file
@@ -0,0 +1,16 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS "two_factor"
+(
+    user_id        uuid     NOT NULL PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    secret         bytea    NOT NULL,
+    enabled        boolean  NOT NULL DEFAULT false,
+    last_used_step bigint   NOT NULL DEFAULT 0,
+    recovery_codes text[]   NOT NULL DEFAULT '{}'
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS "two_factor";
+-- +goose StatementEnd
\ No newline at end of file
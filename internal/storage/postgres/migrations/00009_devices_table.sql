@@ -0,0 +1,18 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS "devices"
+(
+    id          uuid        NOT NULL PRIMARY KEY,
+    user_id     uuid        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name        text        NOT NULL,
+    fingerprint text        NOT NULL UNIQUE,
+    expires_at  timestamptz NOT NULL,
+    revoked     boolean     NOT NULL DEFAULT false,
+    created_at  timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS "devices";
+-- +goose StatementEnd
\ No newline at end of file
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS "sessions"
+(
+    id             uuid        NOT NULL PRIMARY KEY,
+    user_id        uuid        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    device_name    text        NOT NULL DEFAULT '',
+    client_version text        NOT NULL DEFAULT '',
+    address        text        NOT NULL DEFAULT '',
+    created_at     timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at   timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    revoked        boolean     NOT NULL DEFAULT false
+);
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON "sessions" (user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS "sessions";
+-- +goose StatementEnd
\ No newline at end of file
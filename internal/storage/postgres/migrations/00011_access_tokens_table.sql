@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS "access_tokens"
+(
+    id           uuid        NOT NULL PRIMARY KEY,
+    user_id      uuid        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name         text        NOT NULL,
+    token_hash   text        NOT NULL UNIQUE,
+    read_only    boolean     NOT NULL DEFAULT true,
+    data_ids     text[],
+    data_types   integer[],
+    wrapped_key  bytea,
+    expires_at   timestamptz,
+    created_at   timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at timestamptz,
+    revoked      boolean     NOT NULL DEFAULT false
+);
+
+CREATE INDEX IF NOT EXISTS access_tokens_user_id_idx ON "access_tokens" (user_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS "access_tokens";
+-- +goose StatementEnd
\ No newline at end of file
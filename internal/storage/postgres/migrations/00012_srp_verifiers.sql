@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE "users"
+    ADD COLUMN IF NOT EXISTS srp_salt     bytea,
+    ADD COLUMN IF NOT EXISTS srp_verifier bytea;
+
+CREATE TABLE IF NOT EXISTS "srp_handshakes"
+(
+    id         uuid        NOT NULL PRIMARY KEY,
+    user_id    uuid        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    secret     bytea       NOT NULL,
+    expires_at timestamptz NOT NULL
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS "srp_handshakes";
+
+ALTER TABLE "users"
+    DROP COLUMN IF EXISTS srp_salt,
+    DROP COLUMN IF EXISTS srp_verifier;
+-- +goose StatementEnd
\ No newline at end of file
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE "data"
+    ADD COLUMN IF NOT EXISTS revision   bigint      NOT NULL DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS updated_at timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "data"
+    DROP COLUMN IF EXISTS revision,
+    DROP COLUMN IF EXISTS updated_at;
+-- +goose StatementEnd
\ No newline at end of file
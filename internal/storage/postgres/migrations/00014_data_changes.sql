@@ -0,0 +1,36 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS data_version bigint NOT NULL DEFAULT 0;
+ALTER TABLE "data" ADD COLUMN IF NOT EXISTS change_seq bigint NOT NULL DEFAULT 0;
+
+-- existing records are numbered in order of creation
+UPDATE data
+SET change_seq = numbered.seq
+FROM (SELECT id, row_number() OVER (PARTITION BY user_id ORDER BY created_at, id) AS seq FROM data) AS numbered
+WHERE data.id = numbered.id;
+
+UPDATE users
+SET data_version = (SELECT coalesce(max(change_seq), 0) FROM data WHERE data.user_id = users.id);
+
+CREATE INDEX IF NOT EXISTS data_user_id_change_seq_idx ON data (user_id, change_seq);
+
+CREATE TABLE IF NOT EXISTS "data_tombstones"
+(
+    user_id    uuid        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    data_id    uuid        NOT NULL,
+    data_type  integer     NOT NULL,
+    change_seq bigint      NOT NULL,
+    deleted_at timestamptz NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, data_id)
+);
+
+CREATE INDEX IF NOT EXISTS data_tombstones_user_id_change_seq_idx ON data_tombstones (user_id, change_seq);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS "data_tombstones";
+DROP INDEX IF EXISTS data_user_id_change_seq_idx;
+ALTER TABLE "data" DROP COLUMN IF EXISTS change_seq;
+ALTER TABLE "users" DROP COLUMN IF EXISTS data_version;
+-- +goose StatementEnd
\ No newline at end of file
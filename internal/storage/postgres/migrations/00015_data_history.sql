@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS "data_history"
+(
+    data_id          uuid        NOT NULL REFERENCES data (id) ON DELETE CASCADE,
+    user_id          uuid        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    revision         bigint      NOT NULL,
+    data_type        integer     NOT NULL,
+    data_binary      bytea       NOT NULL,
+    client_encrypted boolean     NOT NULL DEFAULT false,
+    updated_at       timestamptz NOT NULL,
+    PRIMARY KEY (data_id, revision)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS "data_history";
+-- +goose StatementEnd
\ No newline at end of file
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE "data" ADD COLUMN IF NOT EXISTS deleted_at timestamptz;
+
+CREATE INDEX IF NOT EXISTS data_deleted_at_idx ON data (deleted_at) WHERE deleted_at IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM data WHERE deleted_at IS NOT NULL;
+DROP INDEX IF EXISTS data_deleted_at_idx;
+ALTER TABLE "data" DROP COLUMN IF EXISTS deleted_at;
+-- +goose StatementEnd
\ No newline at end of file
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE "data" ADD COLUMN IF NOT EXISTS metadata_binary bytea;
+
+ALTER TABLE "data_history" ADD COLUMN IF NOT EXISTS metadata_binary bytea;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "data_history" DROP COLUMN IF EXISTS metadata_binary;
+
+ALTER TABLE "data" DROP COLUMN IF EXISTS metadata_binary;
+-- +goose StatementEnd
\ No newline at end of file
@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE "users" ADD COLUMN IF NOT EXISTS tombstones_purged_seq bigint NOT NULL DEFAULT 0;
+
+-- tombstones of purged data are removed, clients with older cursors load all data on the next sync
+UPDATE users
+SET tombstones_purged_seq = purged.seq
+FROM (SELECT user_id, max(change_seq) AS seq
+      FROM data_tombstones
+      WHERE NOT EXISTS (SELECT 1 FROM data WHERE data.id = data_tombstones.data_id)
+      GROUP BY user_id) AS purged
+WHERE users.id = purged.user_id;
+
+DELETE FROM data_tombstones WHERE NOT EXISTS (SELECT 1 FROM data WHERE data.id = data_tombstones.data_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE "users" DROP COLUMN IF EXISTS tombstones_purged_seq;
+-- +goose StatementEnd
@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"github.com/vstebletsov89/go-developer-course-gophkeeper/internal/models"
+	"time"
 )
 
 // ErrorUnauthorized defines an error for unauthorized user.
@@ -19,21 +20,187 @@ var ErrorUserNotFound = errors.New("user not found")
 // ErrorPrivateDataNotFound defines an error for unknown private data.
 var ErrorPrivateDataNotFound = errors.New("private data not found")
 
+// ErrorPrivateDataAlreadyExist defines an error for duplicate ID of private data.
+var ErrorPrivateDataAlreadyExist = errors.New("private data already exists")
+
+// ErrorRevisionMismatch defines an error for private data changed after the expected revision.
+var ErrorRevisionMismatch = errors.New("private data revision mismatch")
+
+// ErrorRevisionNotFound defines an error for revision of private data which is not kept in history.
+var ErrorRevisionNotFound = errors.New("private data revision not found")
+
 // ErrorInvalidDataType defines an error for invalid private data.
 var ErrorInvalidDataType = errors.New("private data has invalid type")
 
+// ErrorVaultAlreadyExist defines an error for duplicate vault of the user.
+var ErrorVaultAlreadyExist = errors.New("vault already exists")
+
+// ErrorVaultNotFound defines an error for user without vault (legacy user).
+var ErrorVaultNotFound = errors.New("vault not found")
+
+// ErrorUserKeyAlreadyExist defines an error for duplicate data encryption key of the user.
+var ErrorUserKeyAlreadyExist = errors.New("user key already exists")
+
+// ErrorUserKeyNotFound defines an error for user without data encryption key.
+var ErrorUserKeyNotFound = errors.New("user key not found")
+
+// ErrorRefreshTokenNotFound defines an error for unknown or already used refresh token.
+var ErrorRefreshTokenNotFound = errors.New("refresh token not found")
+
+// ErrorTwoFactorNotFound defines an error for user without second factor.
+var ErrorTwoFactorNotFound = errors.New("two-factor authentication not found")
+
+// ErrorTwoFactorAlreadyEnabled defines an error for repeated enrollment of the second factor.
+var ErrorTwoFactorAlreadyEnabled = errors.New("two-factor authentication already enabled")
+
+// ErrorTwoFactorCodeUsed defines an error for TOTP code or recovery code which was already used.
+var ErrorTwoFactorCodeUsed = errors.New("two-factor code already used")
+
+// ErrorSessionNotFound defines an error for unknown or revoked session of the user.
+var ErrorSessionNotFound = errors.New("session not found")
+
+// ErrorAccessTokenNotFound defines an error for unknown or revoked personal access token.
+var ErrorAccessTokenNotFound = errors.New("access token not found")
+
+// ErrorSRPHandshakeNotFound defines an error for unknown or already used SRP handshake.
+var ErrorSRPHandshakeNotFound = errors.New("SRP handshake not found")
+
+// ErrorDeviceNotFound defines an error for unknown device of the user.
+var ErrorDeviceNotFound = errors.New("device not found")
+
 // Storage is the interface that must be implemented by specific storage.
 type Storage interface {
 	// RegisterUser registers new user in the service.
 	RegisterUser(context.Context, models.User) error
 	// GetUserByLogin gets user data for authentication/authorization.
 	GetUserByLogin(context.Context, string) (models.User, error)
+	// GetUserByID gets user data by ID of the user.
+	GetUserByID(context.Context, string) (models.User, error)
+	// UpdateUserPassword replaces hashed password and SRP verifier of the user and vault wrapped with the new password.
+	UpdateUserPassword(context.Context, models.User, *models.Vault) error
+	// DeleteUser deletes the user with data encryption key and all private data.
+	DeleteUser(context.Context, string) error
+	// SaveUserKey saves wrapped data encryption key of the user.
+	SaveUserKey(context.Context, models.UserKey) error
+	// GetUserKey gets wrapped data encryption key of the user.
+	GetUserKey(context.Context, string) (models.UserKey, error)
+	// GetUserKeyBatch gets limited number of user keys ordered by user ID and following the specified ID.
+	GetUserKeyBatch(context.Context, string, int) ([]models.UserKey, error)
+	// UpdateUserKey replaces wrapped data encryption key if it was not changed concurrently.
+	UpdateUserKey(context.Context, models.UserKey, []byte) error
+	// SaveRefreshToken saves hashed refresh token of the user.
+	SaveRefreshToken(context.Context, models.RefreshToken) error
+	// GetRefreshToken gets refresh token by hash.
+	GetRefreshToken(context.Context, string) (models.RefreshToken, error)
+	// UseRefreshToken marks refresh token as used if it was not used or revoked before.
+	UseRefreshToken(context.Context, string) error
+	// RevokeRefreshTokenFamily revokes all refresh tokens of the family.
+	RevokeRefreshTokenFamily(context.Context, string) error
+	// SaveSession saves login session of the user.
+	SaveSession(context.Context, models.Session) error
+	// GetSession gets session of the user by ID.
+	GetSession(context.Context, string, string) (models.Session, error)
+	// GetSessions gets active sessions of the user which were seen after the specified time.
+	GetSessions(context.Context, string, time.Time) ([]models.Session, error)
+	// TouchSession updates last seen time of the session.
+	TouchSession(context.Context, string, time.Time) error
+	// RevokeSession revokes session of the user with all refresh tokens of the session.
+	RevokeSession(context.Context, string, string) error
+	// RevokeToken revokes access token by ID (jti).
+	RevokeToken(context.Context, models.RevokedToken) error
+	// RevokeUserTokens revokes all access and refresh tokens of the user issued before the specified time.
+	RevokeUserTokens(context.Context, string, time.Time) error
+	// IsTokenRevoked checks that access token (ID, user, session and issue time) is revoked.
+	IsTokenRevoked(context.Context, string, string, string, time.Time) (bool, error)
+	// SaveTwoFactor saves pending (not enabled) second factor of the user.
+	SaveTwoFactor(context.Context, models.TwoFactor) error
+	// GetTwoFactor gets second factor of the user.
+	GetTwoFactor(context.Context, string) (models.TwoFactor, error)
+	// EnableTwoFactor enables pending second factor of the user after confirmation with TOTP code of the specified step.
+	EnableTwoFactor(context.Context, string, int64) error
+	// UseTwoFactorStep marks TOTP step as used if it is later than the last used step.
+	UseTwoFactorStep(context.Context, string, int64) error
+	// UseRecoveryCode removes hashed recovery code of the user if it was not used before.
+	UseRecoveryCode(context.Context, string, string) error
+	// DeleteTwoFactor deletes second factor of the user.
+	DeleteTwoFactor(context.Context, string) error
+	// GetLoginAttempts gets failed login attempts for the specified keys (login and peer address).
+	GetLoginAttempts(context.Context, []string) ([]models.LoginAttempt, error)
+	// AddLoginFailure increments failed login attempts of the key. Attempts older than the specified time are reset.
+	AddLoginFailure(context.Context, string, time.Time, time.Time) error
+	// DeleteLoginAttempts resets failed login attempts of the key.
+	DeleteLoginAttempts(context.Context, string) error
+	// SaveSRPHandshake saves started SRP login with ephemeral secret of the server.
+	SaveSRPHandshake(context.Context, models.SRPHandshake) error
+	// UseSRPHandshake deletes started SRP login and returns it.
+	UseSRPHandshake(context.Context, string) (models.SRPHandshake, error)
+	// SaveAccessToken saves hashed personal access token of the user.
+	SaveAccessToken(context.Context, models.AccessToken) error
+	// GetAccessToken gets personal access token by hash.
+	GetAccessToken(context.Context, string) (models.AccessToken, error)
+	// GetAccessTokens gets personal access tokens of the user which are not revoked.
+	GetAccessTokens(context.Context, string) ([]models.AccessToken, error)
+	// TouchAccessToken updates last used time of personal access token.
+	TouchAccessToken(context.Context, string, time.Time) error
+	// RevokeAccessToken revokes personal access token of the user.
+	RevokeAccessToken(context.Context, string, string) error
+	// SaveDevice saves device of the user with fingerprint of the client certificate.
+	SaveDevice(context.Context, models.Device) error
+	// GetDeviceByFingerprint gets device by fingerprint of the client certificate.
+	GetDeviceByFingerprint(context.Context, string) (models.Device, error)
+	// RevokeDevice revokes client certificate of the user device.
+	RevokeDevice(context.Context, string, string) error
+	// SaveVault saves parameters of client side encryption for the user.
+	SaveVault(context.Context, models.Vault) error
+	// GetVault gets parameters of client side encryption for the user.
+	GetVault(context.Context, string) (models.Vault, error)
 	// AddData adds private data to the current storage.
 	AddData(context.Context, models.Data) error
 	// GetDataByUserID gets all private data for the current user.
 	GetDataByUserID(context.Context, string) ([]models.Data, error)
-	// DeleteDataByDataID deletes private data for the current user.
-	DeleteDataByDataID(context.Context, string) error
+	// GetDataSample gets limited number of private data records regardless of the user.
+	GetDataSample(context.Context, int) ([]models.Data, error)
+	// CountData gets total number of private data records.
+	CountData(context.Context) (int64, error)
+	// GetDataBatch gets limited number of private data records ordered by ID and following the specified ID.
+	GetDataBatch(context.Context, string, int) ([]models.Data, error)
+	// GetDataByID gets private data of the user by ID.
+	GetDataByID(context.Context, string, string) (models.Data, error)
+	// UpdateDataBinary replaces encrypted binary of private data of the user if it was not changed concurrently.
+	UpdateDataBinary(context.Context, models.Data, []byte) error
+	// UpdateData replaces private data of the user if it has expected revision and returns new revision.
+	// Replaced revision is kept in history with the specified number of the latest revisions, history is removed
+	// when legacy data is replaced with client encrypted data.
+	UpdateData(context.Context, models.Data, int64, int) (int64, error)
+	// GetDataRevisions gets previous revisions of private data of the user, the latest revision first.
+	GetDataRevisions(context.Context, string, string) ([]models.Data, error)
+	// RestoreDataRevision replaces private data of the user with the revision from history and returns restored data.
+	RestoreDataRevision(context.Context, string, string, int64, int) (models.Data, error)
+	// GetDataHistoryBatch gets limited number of revisions from history of all users ordered by data ID and revision
+	// and following the specified data ID and revision.
+	GetDataHistoryBatch(context.Context, string, int64, int) ([]models.Data, error)
+	// UpdateDataHistoryBinary replaces encrypted binary of the revision in history if it was not changed concurrently.
+	UpdateDataHistoryBinary(context.Context, models.Data, []byte) error
+	// DeleteDataByDataID moves private data of the user to trash if it has the expected revision (0 for any revision),
+	// tombstone of the data is kept for sync.
+	DeleteDataByDataID(context.Context, string, string, int64) error
+	// GetTrashByUserID gets private data of the user in trash.
+	GetTrashByUserID(context.Context, string) ([]models.Data, error)
+	// RestoreData moves private data of the user from trash back and returns restored data.
+	RestoreData(context.Context, string, string) (models.Data, error)
+	// PurgeData permanently deletes private data of the user from trash with tombstone of the data.
+	PurgeData(context.Context, string, string) error
+	// PurgeTrash permanently deletes private data of all users moved to trash before the specified time
+	// with tombstones of the data.
+	PurgeTrash(context.Context, time.Time) (int64, error)
+	// GetDataChanges gets private data and tombstones of the user changed after the cursor. All data is returned
+	// (full sync) for the cursor before tombstones removed by purge.
+	GetDataChanges(context.Context, string, int64) (models.DataChanges, error)
+	// GetDataCursor gets current cursor (last change) of private data of the user.
+	GetDataCursor(context.Context, string) (int64, error)
+	// ListenDataChanges sends notifications about changed private data of all users (from all server instances)
+	// to the channel until the context is done. Empty event is sent when listening starts.
+	ListenDataChanges(context.Context, chan<- models.ChangeEvent) error
 	// ReleaseStorage releases current storage.
 	ReleaseStorage()
 }